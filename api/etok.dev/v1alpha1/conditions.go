@@ -5,6 +5,10 @@ const (
 	RunCompleteCondition    = "Complete"
 	WorkspaceReadyCondition = "Ready"
 
+	// WorkspacePausedCondition reports whether the workspace is holding new
+	// runs
+	WorkspacePausedCondition = "Paused"
+
 	PodCreatedReason        = "PodCreated"
 	PodPendingReason        = "PodPending"
 	PodUnknownReason        = "PodUnknown"
@@ -13,10 +17,23 @@ const (
 	PodRunningReason        = "PodRunning"
 	RunQueuedReason         = "Queued"
 	RunUnqueuedReason       = "Unqueued"
+	RunThrottledReason      = "Throttled"
 	RunEnqueueTimeoutReason = "EnqueueTimeout"
 	QueueTimeoutReason      = "QueueTimeout"
 	RunPendingTimeoutReason = "PodPendingTimeout"
 	WorkspaceNotFoundReason = "WorkspaceNotFound"
+	SecretNotFoundReason    = "SecretNotFound"
+	ConfigMapNotFoundReason = "ConfigMapNotFound"
+	PreRunFailedReason      = "PreRunFailed"
+	PendingApprovalReason   = "PendingApproval"
+	ApprovalTimeoutReason   = "ApprovalTimeout"
+	RunTimeoutReason        = "RunTimeout"
+	RunPausedReason         = "WorkspacePaused"
+	StateLockedReason       = "StateLocked"
+
+	// Reasons for the workspace paused condition
+	PausedReason  = "Paused"
+	ResumedReason = "Resumed"
 
 	// Pending means whatever is being observed is reported to be progressing
 	// towards a non-failure state.
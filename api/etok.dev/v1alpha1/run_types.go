@@ -65,11 +65,37 @@ type RunSpec struct {
 	// The workspace of the run.
 	Workspace string `json:"workspace"`
 
+	// The terraform workspace to select prior to running the command. The
+	// terraform workspace is created if it doesn't exist.
+	TFWorkspace string `json:"tfWorkspace,omitempty"`
+
+	// Resource addresses targeted by the command, recorded for auditing. Each
+	// is passed to the command as a -target argument.
+	Targets []string `json:"targets,omitempty"`
+
+	// Name of a plan run whose saved plan is to be applied. Only applicable to
+	// the apply command.
+	Plan string `json:"plan,omitempty"`
+
+	// Print the saved plan in JSON once planned, delimited from the rest of
+	// the output so that the client can capture it. Only applicable to the
+	// plan command.
+	JSONPlan bool `json:"jsonPlan,omitempty"`
+
+	// Maximum time the run's pod may run for, e.g. "30m". Once exceeded the
+	// pod is deleted and the run is failed. No timeout is applied if empty.
+	Timeout string `json:"timeout,omitempty"`
+
 	//+kubebuilder:validation:Minimum=0
 
 	// Logging verbosity.
 	Verbosity int `json:"verbosity,omitempty"`
 
+	// Environment variables to set on the run's pod. They take precedence
+	// over the workspace's environment variables. Names prefixed with ETOK_
+	// are reserved for etok's own use and are not permitted.
+	EnvironmentVariables map[string]string `json:"environmentVariables,omitempty"`
+
 	// AttachSpec defines behaviour for clients attaching to the pod's TTY
 	AttachSpec `json:",inline"`
 }
@@ -98,10 +124,48 @@ func ApprovedAnnotationKey(runName string) string {
 	return fmt.Sprintf("%s/%s", ApprovedAnnotationKeyPrefix, runName)
 }
 
+// ReviewedAnnotationKey is the key set on a workspace's annotations by etok
+// approve to indicate that this run has been approved. Only necessary if the
+// workspace requires approval for the run's command. It is distinct from the
+// privileged command approval, which is given on behalf of the user launching
+// the run.
+func (r *Run) ReviewedAnnotationKey() string {
+	return ReviewedAnnotationKey(r.Name)
+}
+
+const ReviewedAnnotationKeyPrefix = "reviews.etok.dev"
+
+func ReviewedAnnotationKey(runName string) string {
+	return fmt.Sprintf("%s/%s", ReviewedAnnotationKeyPrefix, runName)
+}
+
 func GetRunFromApprovalAnnotationKey(key string) string {
 	return strings.Split(key, "/")[1]
 }
 
+// UserAnnotationKey is the key of the annotation recording the authenticated
+// user that created the run. The operator's webhook sets the annotation from
+// the identity the API server reports, so that it cannot be forged. An
+// annotation rather than a label is used because user names often contain
+// characters not permitted in label values.
+const UserAnnotationKey = "etok.dev/user"
+
+// User returns the authenticated user that created the run, or an empty string
+// if unknown.
+func (r *Run) User() string {
+	return r.Annotations[UserAnnotationKey]
+}
+
+// ReservedEnvironmentVariablePrefix is the prefix of the names of environment
+// variables that etok sets on a run's pod, which a run may not override.
+const ReservedEnvironmentVariablePrefix = "ETOK_"
+
+// IsReservedEnvironmentVariable determines whether the name of an environment
+// variable is reserved for etok's own use.
+func IsReservedEnvironmentVariable(name string) bool {
+	return strings.HasPrefix(name, ReservedEnvironmentVariablePrefix)
+}
+
 // Run's pod shares its name
 func (r *Run) PodName() string { return r.Name }
 
@@ -122,6 +186,32 @@ type RunStatus struct {
 
 	// Exit code of run pod's runner container
 	ExitCode *int `json:"exitCode,omitempty"`
+
+	// Details of the state lock held by someone else, should the run have
+	// failed to acquire it
+	LockInfo *LockInfo `json:"lockInfo,omitempty"`
+}
+
+// LockInfo describes a terraform state lock, as reported by terraform when it
+// fails to acquire the lock
+type LockInfo struct {
+	// Unique ID of the lock, with which it can be forcibly released
+	ID string `json:"id"`
+
+	// Path to the state file that is locked
+	Path string `json:"path,omitempty"`
+
+	// Terraform operation that acquired the lock
+	Operation string `json:"operation,omitempty"`
+
+	// User and host that acquired the lock
+	Who string `json:"who,omitempty"`
+
+	// Terraform version that acquired the lock
+	Version string `json:"version,omitempty"`
+
+	// Time at which the lock was acquired
+	Created string `json:"created,omitempty"`
 }
 
 func (r *Run) IsReconciled() bool {
@@ -148,6 +238,9 @@ const (
 	// Waiting: waiting to be added to workspace queue (only relevant to those
 	// runs with a command that needs to be queued, e.g. apply, sh, etc.)
 	RunPhaseWaiting RunPhase = "waiting"
+	// PendingApproval: waiting to be approved before being added to the
+	// workspace queue (only relevant to workspaces that require approval)
+	RunPhasePendingApproval RunPhase = "pendingApproval"
 	// Queued: run is currently in workspace queue backlog i.e. not first place
 	RunPhaseQueued RunPhase = "queued"
 	// Provisioning: run's pod is in the process of being created
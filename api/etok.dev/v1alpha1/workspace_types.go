@@ -2,6 +2,7 @@ package v1alpha1
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/leg100/etok/pkg/util/slice"
 	corev1 "k8s.io/api/core/v1"
@@ -19,7 +20,9 @@ func init() {
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:path=workspaces,scope=Namespaced,shortName={ws}
 // +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type=="Ready")].status"
 // +kubebuilder:printcolumn:name="Version",type="string",JSONPath=".spec.terraformVersion"
+// +kubebuilder:printcolumn:name="Backend",type="string",JSONPath=".spec.backend.type"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 // +kubebuilder:printcolumn:name="Active",type="string",JSONPath=".status.active"
 // +kubebuilder:printcolumn:name="Queue",type="string",JSONPath=".status.queue"
@@ -51,28 +54,197 @@ type WorkspaceSpec struct {
 	// Logging verbosity.
 	Verbosity int `json:"verbosity,omitempty"`
 
+	// +kubebuilder:validation:Enum={"TRACE","DEBUG","INFO","WARN","ERROR"}
+
+	// Terraform log level, set as TF_LOG on run pods.
+	TFLog string `json:"tfLog,omitempty"`
+
 	// List of commands that are deemed privileged. The client must set a
 	// specific annotation on the workspace to approve a run with a privileged
 	// command.
 	PrivilegedCommands []string `json:"privilegedCommands,omitempty"`
 
+	// Require apply and destroy runs to be approved, with etok approve, before
+	// they are queued. Runs not approved within an hour fail.
+	RequireApproval bool `json:"requireApproval,omitempty"`
+
+	// Hold new runs without starting their pods, e.g. for maintenance. Runs
+	// that have already started are unaffected. Held runs proceed once the
+	// workspace is no longer paused.
+	Paused bool `json:"paused,omitempty"`
+
 	// Any change to the default marker for the terraform version below must
 	// also be made to the dockerfile for the container image
 	// (/build/Dockerfile)
 
 	// +kubebuilder:default="0.14.3"
-	// +kubebuilder:validation:Pattern=`^[0-9]+\.[0-9]+\.[0-9]+$`
 
-	// Required version of Terraform on workspace pod
+	// Required version of Terraform on workspace pod. Either an exact version
+	// or a version constraint, e.g. `>= 1.3, < 1.5`, resolved to the highest
+	// matching release.
 	TerraformVersion string `json:"terraformVersion,omitempty"`
 
 	// Variables as inputs to module
 	Variables []*Variable `json:"variables,omitempty"`
 
+	// Config maps and secrets whose keys are made available to terraform as
+	// variables, in order of precedence: later sources override earlier
+	// sources. Variables set above override them all.
+	VariablesFrom []VariablesSource `json:"variablesFrom,omitempty"`
+
 	// +kubebuilder:validation:Pattern=`^[0-9a-z][0-9a-z\-_]{0,61}[0-9a-z]$`
 
-	// GCS bucket to which to backup state file
+	// Bucket to which to backup state file
 	BackupBucket string `json:"backupBucket,omitempty"`
+
+	// Customer-managed KMS key with which to encrypt backups: a Cloud KMS
+	// crypto key resource name for the gcs provider, or an AWS KMS key ID,
+	// ARN or alias for the s3 provider.
+	BackupKMSKey string `json:"backupKMSKey,omitempty"`
+
+	// +kubebuilder:validation:Enum={"gcs","s3"}
+	// +kubebuilder:default="gcs"
+
+	// Cloud storage provider of the backup bucket
+	BackupProvider string `json:"backupProvider,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+
+	// Number of versions of the state file to retain in the backup bucket.
+	// Older versions are pruned after each backup. Zero retains all versions.
+	BackupRetention int `json:"backupRetention,omitempty"`
+
+	// Back up the providers installed by terraform init, along with the lock
+	// file, to the backup bucket, and restore them to a cache that has yet to
+	// have providers installed, speeding up init on a new cache. The runs'
+	// service account requires access to the bucket. Requires a backup
+	// bucket.
+	CacheBackup bool `json:"cacheBackup,omitempty"`
+
+	// Terraform backend configuration
+	Backend BackendSpec `json:"backend,omitempty"`
+
+	// Compute resources required by the terraform containers
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Node selector for the workspace and run pods
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations for the workspace and run pods
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Affinity for the workspace and run pods. Note the pods of a workspace
+	// share its cache, so they should not be spread apart if the cache's
+	// access mode is ReadWriteOnce.
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+
+	// Seconds a deleted pod is given to exit before it is killed. Terraform
+	// killed mid-operation can leave state unwritten and locks held, so run
+	// pods with commands that mutate state, e.g. apply, default to 600
+	// seconds. Other pods default to the kubernetes default of 30 seconds.
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds,omitempty"`
+
+	// Pod-level security context for the workspace and run pods
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	// Security context for the containers of the workspace and run pods. If
+	// the root filesystem is read-only, writable volumes are mounted on the
+	// paths to which etok and terraform write.
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// Annotations to add to the workspace and run pods
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+
+	// Labels to add to the workspace and run pods. Etok's own labels take
+	// precedence.
+	PodLabels map[string]string `json:"podLabels,omitempty"`
+
+	// Terraform variable files, in order of precedence: later files override
+	// earlier files. Each is a key in the workspace's var files config map.
+	VarFiles []string `json:"varFiles,omitempty"`
+
+	// Additional arguments to pass to terraform init, e.g. -upgrade
+	InitArgs []string `json:"initArgs,omitempty"`
+
+	// Default arguments to pass to the apply, destroy, import, plan and refresh
+	// commands run in the workspace, e.g. -lock-timeout=5m. A default is
+	// dropped should a run pass the same flag.
+	TerraformArgs []string `json:"terraformArgs,omitempty"`
+
+	// Additional secrets whose keys are made available to terraform as
+	// environment variables, alongside those of the etok secret. Should a key
+	// exist in more than one secret, the last secret takes precedence.
+	SecretNames []string `json:"secretNames,omitempty"`
+
+	// Name of a secret containing a netrc file under the key .netrc. The file
+	// is mounted at /home/etok/.netrc in run pods, with HOME set to
+	// /home/etok, for authenticating to private module sources.
+	NetrcSecret string `json:"netrcSecret,omitempty"`
+
+	// Name of a secret containing a bundle of PEM-encoded CA certificates
+	// under the key ca.crt, for verifying endpoints with certificates issued
+	// by a private CA. The bundle is added to the system's CA certificates in
+	// the workspace and run pods, and trusted by the operator when backing up
+	// state.
+	CABundleSecret string `json:"caBundleSecret,omitempty"`
+
+	// Name of a config map containing a terraform CLI configuration file under
+	// the key .terraformrc. The file is mounted at ~/.terraformrc in run pods,
+	// for configuring provider mirrors, the plugin cache, credentials, etc.
+	TerraformRCConfigMap string `json:"terraformRCConfigMap,omitempty"`
+
+	// Shell script run in run pods before terraform, e.g. to fetch
+	// credentials. It runs with the same environment, secrets, mounts and
+	// configuration as terraform. Should it exit non-zero the run fails.
+	PreRunScript string `json:"preRunScript,omitempty"`
+
+	// Secret keys containing API tokens for private module registries, keyed
+	// by registry hostname. Each token is made available to terraform as a
+	// TF_TOKEN_<hostname> environment variable.
+	RegistryTokens map[string]corev1.SecretKeySelector `json:"registryTokens,omitempty"`
+
+	// Secrets for pulling images from a private registry, set on the
+	// workspace and run pods
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+
+	// Container image for the workspace and run pods, overriding the image
+	// configured on the operator. It must be based on the etok image.
+	Image string `json:"image,omitempty"`
+
+	// +kubebuilder:validation:Enum={"Always","IfNotPresent","Never"}
+
+	// Pull policy for the image of the workspace and run pods. Defaults to
+	// IfNotPresent.
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+
+	// Maximum number of non-mutating runs, e.g. plan, permitted to run
+	// simultaneously. Mutating runs, e.g. apply, are always serialized via the
+	// workspace queue. Zero imposes no limit.
+	MaxConcurrentRuns int `json:"maxConcurrentRuns,omitempty"`
+}
+
+// BackendSpec defines the terraform backend used by the workspace
+type BackendSpec struct {
+	// +kubebuilder:default="kubernetes"
+
+	// Type of backend. One of kubernetes, s3, azurerm, remote, http, or
+	// consul, unless the backend is declared with raw, in which case it must
+	// be the type of the backend declared.
+	Type string `json:"type,omitempty"`
+
+	// Backend configuration. Each key-value pair is passed to terraform init
+	// as backend configuration. Not applicable to the kubernetes backend,
+	// which etok configures itself.
+	Config map[string]string `json:"config,omitempty"`
+
+	// Terraform configuration declaring the backend, i.e. a terraform block
+	// containing a backend block, used verbatim rather than etok's declaration
+	// of the backend. Permits the use of backends that etok does not support.
+	Raw string `json:"raw,omitempty"`
 }
 
 // WorkspaceSpec defines the desired state of Workspace's cache storage
@@ -86,6 +258,22 @@ type WorkspaceCacheSpec struct {
 
 	// Size of cache's persistent volume claim.
 	Size string `json:"size,omitempty"`
+
+	// Access modes for the cache's persistent volume claim. Defaults to
+	// ReadWriteOnce.
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+
+	// +kubebuilder:validation:Enum=Filesystem;Block
+
+	// Volume mode for the cache's persistent volume claim, either Filesystem or
+	// Block. Nil leaves it to the cluster default.
+	VolumeMode *corev1.PersistentVolumeMode `json:"volumeMode,omitempty"`
+
+	// Use an empty dir volume for the cache rather than a persistent volume
+	// claim. Nothing is then shared between pods: each run pod installs
+	// terraform and runs terraform init afresh. The other cache settings are
+	// ignored.
+	Ephemeral bool `json:"ephemeral,omitempty"`
 }
 
 // WorkspaceStatus defines the observed state of Workspace
@@ -109,6 +297,18 @@ type WorkspaceStatus struct {
 	// has not been backed up.
 	BackupSerial *int `json:"backupSerial,omitempty"`
 
+	// GCS object generation of the backup from which the state was last
+	// restored, as requested via the restore generation annotation.
+	RestoredGeneration *int64 `json:"restoredGeneration,omitempty"`
+
+	// Version of Terraform resolved from the spec's terraform version, and
+	// installed on the workspace pod.
+	TerraformVersion string `json:"terraformVersion,omitempty"`
+
+	// Number of consecutive reconciles that have failed. Determines the delay
+	// before the next reconcile. Reset to zero upon a successful reconcile.
+	ReconcileAttempts int `json:"reconcileAttempts,omitempty"`
+
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
@@ -125,6 +325,20 @@ type Variable struct {
 	EnvironmentVariable bool `json:"environmentVariable,omitempty"`
 }
 
+// VariablesSource is a config map or secret, each key of which is a terraform
+// variable. The values are set as TF_VAR_<key> environment variables on run
+// pods, rather than passed on the command line, keeping them out of the
+// workspace spec and logs.
+type VariablesSource struct {
+	// +kubebuilder:validation:Enum={"ConfigMap","Secret"}
+
+	// Kind of the source: ConfigMap or Secret
+	Kind string `json:"kind"`
+
+	// Name of the config map or secret
+	Name string `json:"name"`
+}
+
 // Output outputs the values of Terraform output
 type Output struct {
 	// Attribute name in module
@@ -145,6 +359,33 @@ func (ws *Workspace) IsReconciled() bool {
 	return false
 }
 
+// ResolvedTerraformVersion returns the version of terraform to install,
+// defaulting to the spec's version until it has been resolved
+func (ws *Workspace) ResolvedTerraformVersion() string {
+	if ws.Status.TerraformVersion != "" {
+		return ws.Status.TerraformVersion
+	}
+	return ws.Spec.TerraformVersion
+}
+
+// BackendType returns the type of terraform backend in use, defaulting to the
+// kubernetes backend
+func (ws *Workspace) BackendType() string {
+	if ws.Spec.Backend.Type == "" {
+		return BackendKubernetes
+	}
+	return ws.Spec.Backend.Type
+}
+
+// BackupProviderType returns the workspace's backup provider, defaulting to GCS
+// if unspecified
+func (ws *Workspace) BackupProviderType() string {
+	if ws.Spec.BackupProvider == "" {
+		return BackupProviderGCS
+	}
+	return ws.Spec.BackupProvider
+}
+
 func (ws *Workspace) PodName() string {
 	return WorkspacePodName(ws.Name)
 }
@@ -165,6 +406,30 @@ func (ws *Workspace) BackupObjectName() string {
 	return fmt.Sprintf("%s/%s.yaml", ws.Namespace, ws.Name)
 }
 
+// BackupVersionPrefix returns the object name prefix shared by all versioned
+// backups of the workspace's state file.
+func (ws *Workspace) BackupVersionPrefix() string {
+	return fmt.Sprintf("%s/%s/", ws.Namespace, ws.Name)
+}
+
+// BackupVersionTimeFormat is the format of the time at which a versioned backup
+// is taken, as it appears in the backup's object name.
+const BackupVersionTimeFormat = "20060102T150405Z"
+
+// BackupVersionObjectName returns the object name to be used for the backup of
+// the workspace's state file with the given serial number, taken at the given
+// time. The time is included because a serial number is not unique: restoring
+// an older version of the state and then applying re-uses serial numbers.
+func (ws *Workspace) BackupVersionObjectName(serial int, taken time.Time) string {
+	return fmt.Sprintf("%s%d-%s.yaml", ws.BackupVersionPrefix(), serial, taken.UTC().Format(BackupVersionTimeFormat))
+}
+
+// CacheBackupObjectName returns the object name to be used for the backup of
+// the workspace's providers and lock file.
+func (ws *Workspace) CacheBackupObjectName() string {
+	return fmt.Sprintf("%s/%s.terraform.tar.gz", ws.Namespace, ws.Name)
+}
+
 func (ws *Workspace) BuiltinsConfigMapName() string {
 	return WorkspaceBuiltinsConfigMapName(ws.Name)
 }
@@ -173,10 +438,35 @@ func WorkspaceBuiltinsConfigMapName(name string) string {
 	return name + "-builtins"
 }
 
+// TFLogLevels are the log levels accepted by terraform's TF_LOG
+var TFLogLevels = []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR"}
+
+func (ws *Workspace) VarFilesConfigMapName() string {
+	return WorkspaceVarFilesConfigMapName(ws.Name)
+}
+
+func WorkspaceVarFilesConfigMapName(name string) string {
+	return name + "-varfiles"
+}
+
+// IsPrivilegedCommand determines whether the command is privileged on the
+// workspace, in which case a run with the command must be approved before it
+// is queued. If the workspace has no privileged commands then no command is
+// privileged.
 func (ws *Workspace) IsPrivilegedCommand(cmd string) bool {
 	return slice.ContainsString(ws.Spec.PrivilegedCommands, cmd)
 }
 
+// ApprovalCommands are the commands that require approval on a workspace
+// that requires approval
+var ApprovalCommands = []string{"apply", "destroy"}
+
+// RequiresApproval determines whether a run with the command must be approved
+// with etok approve before it is queued
+func (ws *Workspace) RequiresApproval(command string) bool {
+	return ws.Spec.RequireApproval && slice.ContainsString(ApprovalCommands, command)
+}
+
 func (ws *Workspace) IsRunApproved(run *Run) bool {
 	if annotations := ws.Annotations; annotations != nil {
 		status, exists := annotations[run.ApprovedAnnotationKey()]
@@ -187,10 +477,45 @@ func (ws *Workspace) IsRunApproved(run *Run) bool {
 	return false
 }
 
+// IsRunReviewed determines whether the run has been approved with etok approve
+func (ws *Workspace) IsRunReviewed(run *Run) bool {
+	return ws.Annotations[run.ReviewedAnnotationKey()] == "approved"
+}
+
 func WorkspacePodName(name string) string {
 	return "workspace-" + name
 }
 
+const (
+	// Terraform backend types
+	BackendKubernetes = "kubernetes"
+	BackendS3         = "s3"
+	BackendAzureRM    = "azurerm"
+	BackendRemote     = "remote"
+	BackendHTTP       = "http"
+	BackendConsul     = "consul"
+
+	// Backup providers
+	BackupProviderGCS = "gcs"
+	BackupProviderS3  = "s3"
+
+	// Kinds of variables source
+	VariablesSourceConfigMap = "ConfigMap"
+	VariablesSourceSecret    = "Secret"
+)
+
+// RestoreVersionAnnotationKey is the key to be set on a workspace's annotations
+// to restore the state file from the backup with the given serial number. The
+// operator removes the annotation once the restore has been attempted.
+const RestoreVersionAnnotationKey = "etok.dev/restore-version"
+
+// RestoreGenerationAnnotationKey is the key to be set on a workspace's
+// annotations to restore the state file from the given GCS object generation
+// of the latest backup, which requires the bucket to have object versioning
+// enabled. The operator removes the annotation once the generation has been
+// restored.
+const RestoreGenerationAnnotationKey = "etok.dev/restore-generation"
+
 type WorkspacePhase string
 
 const (
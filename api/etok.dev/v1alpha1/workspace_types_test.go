@@ -0,0 +1,56 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsPrivilegedCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		privileged []string
+		command    string
+		want       bool
+	}{
+		{
+			name:    "no privileged commands",
+			command: "apply",
+			want:    false,
+		},
+		{
+			name:       "empty list of privileged commands",
+			privileged: []string{},
+			command:    "apply",
+			want:       false,
+		},
+		{
+			name:       "privileged command",
+			privileged: []string{"apply", "destroy"},
+			command:    "apply",
+			want:       true,
+		},
+		{
+			name:       "unprivileged command",
+			privileged: []string{"apply", "destroy"},
+			command:    "plan",
+			want:       false,
+		},
+		{
+			name:       "subcommand",
+			privileged: []string{"state rm"},
+			command:    "state rm",
+			want:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := &Workspace{
+				ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"},
+				Spec:       WorkspaceSpec{PrivilegedCommands: tt.privileged},
+			}
+			assert.Equal(t, tt.want, ws.IsPrivilegedCommand(tt.command))
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package approve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/leg100/etok/cmd/flags"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/env"
+	"github.com/spf13/cobra"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultNamespace = "default"
+)
+
+var (
+	errRunNotFound         = errors.New("run not found")
+	errRunDone             = errors.New("run has already finished")
+	errApprovalNotRequired = errors.New("run does not require approval")
+	errNotAuthorised       = errors.New("you are not authorised")
+)
+
+type ApproveOptions struct {
+	*cmdutil.Factory
+
+	*client.Client
+
+	path        string
+	namespace   string
+	kubeContext string
+
+	// Name of run to approve
+	run string
+}
+
+func ApproveCmd(f *cmdutil.Factory) (*cobra.Command, *ApproveOptions) {
+	o := &ApproveOptions{
+		Factory:   f,
+		namespace: defaultNamespace,
+	}
+	cmd := &cobra.Command{
+		Use:   "approve <run>",
+		Short: "Approve a run",
+		Long:  "Approve a run awaiting approval, permitting it to proceed. Only runs on workspaces that require approval need approving, and approving requires permission to update the workspace.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			o.run = args[0]
+
+			etokenv, err := env.Read(o.path)
+			if err != nil {
+				// It's ok for envfile to not exist
+				if !os.IsNotExist(err) {
+					return err
+				}
+			} else if !flags.IsFlagPassed(cmd.Flags(), "namespace") {
+				o.namespace = etokenv.Namespace
+			}
+
+			o.Client, err = f.Create(o.kubeContext)
+			if err != nil {
+				return err
+			}
+
+			return o.Run(cmd.Context())
+		},
+	}
+
+	flags.AddPathFlag(cmd, &o.path)
+	flags.AddNamespaceFlag(cmd, &o.namespace)
+	flags.AddKubeContextFlag(cmd, &o.kubeContext)
+
+	return cmd, o
+}
+
+func (o *ApproveOptions) Run(ctx context.Context) error {
+	run, err := o.RunsClient(o.namespace).Get(ctx, o.run, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return fmt.Errorf("%w: %s/%s", errRunNotFound, o.namespace, o.run)
+	} else if err != nil {
+		return err
+	}
+
+	if run.IsDone() {
+		return fmt.Errorf("%w: %s/%s", errRunDone, o.namespace, o.run)
+	}
+
+	ws, err := o.WorkspacesClient(o.namespace).Get(ctx, run.Workspace, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !ws.RequiresApproval(run.Command) {
+		return fmt.Errorf("%w: %s/%s", errApprovalNotRequired, o.namespace, o.run)
+	}
+
+	if ws.IsRunReviewed(run) {
+		fmt.Fprintf(o.Out, "Run %s/%s is already approved\n", o.namespace, o.run)
+		return nil
+	}
+
+	// Approval is recorded on the workspace rather than the run, lest a user
+	// approve their own run upon creating it. The run controller watches
+	// workspaces and proceeds to queue the run.
+	if ws.Annotations == nil {
+		ws.Annotations = make(map[string]string)
+	}
+	ws.Annotations[run.ReviewedAnnotationKey()] = "approved"
+	if _, err := o.WorkspacesClient(o.namespace).Update(ctx, ws, metav1.UpdateOptions{}); err != nil {
+		if kerrors.IsForbidden(err) {
+			return fmt.Errorf("%w: approving a run requires permission to update the workspace", errNotAuthorised)
+		}
+		return fmt.Errorf("failed to approve run: %w", err)
+	}
+
+	fmt.Fprintf(o.Out, "Approved run %s/%s\n", o.namespace, o.run)
+	return nil
+}
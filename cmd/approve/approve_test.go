@@ -0,0 +1,119 @@
+package approve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/env"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestApprove(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		env        *env.Env
+		objs       []runtime.Object
+		err        error
+		out        string
+		assertions func(*testutil.T, *ApproveOptions)
+	}{
+		{
+			name: "approve run",
+			args: []string{"apply-1"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "default", testobj.WithRequireApproval()),
+				testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("default")),
+			},
+			out: "Approved run default/apply-1\n",
+			assertions: func(t *testutil.T, o *ApproveOptions) {
+				ws, err := o.WorkspacesClient("default").Get(context.Background(), "default", metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, "approved", ws.Annotations["reviews.etok.dev/apply-1"])
+			},
+		},
+		{
+			name: "approve run in namespace from environment file",
+			args: []string{"apply-1"},
+			env:  &env.Env{Namespace: "dev", Workspace: "networking"},
+			objs: []runtime.Object{
+				testobj.Workspace("dev", "networking", testobj.WithRequireApproval()),
+				testobj.Run("dev", "apply-1", "apply", testobj.WithWorkspace("networking")),
+			},
+			out: "Approved run dev/apply-1\n",
+		},
+		{
+			name: "already approved",
+			args: []string{"apply-1"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "default", testobj.WithRequireApproval(), testobj.WithReviews("apply-1")),
+				testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("default")),
+			},
+			out: "Run default/apply-1 is already approved\n",
+		},
+		{
+			name: "run not found",
+			args: []string{"apply-1"},
+			err:  errRunNotFound,
+		},
+		{
+			name: "run finished",
+			args: []string{"apply-1"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "default", testobj.WithRequireApproval()),
+				testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("default"), testobj.WithCondition(v1alpha1.RunFailedCondition)),
+			},
+			err: errRunDone,
+		},
+		{
+			name: "approval not required",
+			args: []string{"plan-1"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "default", testobj.WithRequireApproval()),
+				testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("default")),
+			},
+			err: errApprovalNotRequired,
+		},
+	}
+
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			path := t.NewTempDir().Chdir().Root()
+
+			// Write .terraform/environment
+			if tt.env != nil {
+				require.NoError(t, tt.env.Write(path))
+			}
+
+			out := new(bytes.Buffer)
+			f := cmdutil.NewFakeFactory(out, tt.objs...)
+
+			cmd, o := ApproveCmd(f)
+			cmd.SetOut(f.Out)
+			// Leave reporting errors to the test
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			cmd.SetArgs(tt.args)
+
+			err := cmd.ExecuteContext(context.Background())
+			if !assert.True(t, errors.Is(err, tt.err)) {
+				t.Errorf("no error in %v's chain matches %v", err, tt.err)
+			}
+
+			assert.Equal(t, tt.out, out.String())
+
+			if tt.assertions != nil {
+				tt.assertions(t, o)
+			}
+		})
+	}
+}
@@ -1,24 +1,66 @@
 package install
 
 import (
+	"strconv"
+	"strings"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 
+	"github.com/leg100/etok/pkg/controllers"
 	"github.com/leg100/etok/pkg/version"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/leg100/etok/pkg/labels"
 )
 
+const (
+	// Port on which the operator serves its health probe endpoints
+	healthProbePort = 8081
+	// Port on which the operator serves its metrics endpoint
+	metricsPort = 8080
+	// Port on which the operator serves its webhooks
+	webhookPort = 9443
+	// Path to which the webhook's certificate secret is mounted
+	webhookCertDir = "/webhook-certs"
+)
+
 type podTemplateOption func(*podTemplateConfig)
 
 type podTemplateConfig struct {
-	image       string
-	envVars     []corev1.EnvVar
-	annotations map[string]string
-	withSecret  bool
+	image            string
+	envVars          []corev1.EnvVar
+	annotations      map[string]string
+	withSecret       bool
+	replicas         int32
+	leaderElection   bool
+	imagePullSecrets []string
+	backupOnDelete   bool
+	autopilot        bool
+
+	// Delays before reconciling a workspace following a failed reconcile.
+	// Zero values leave the operator's defaults in place.
+	requeueBaseDelay time.Duration
+	requeueMaxDelay  time.Duration
+
+	// Threshold and timeout of the operator's backlog health check. The check
+	// is disabled if the threshold is zero, and a zero timeout leaves the
+	// operator's default in place.
+	backlogThreshold int
+	backlogTimeout   time.Duration
+
+	// Namespaces for the operator to watch. All namespaces are watched if
+	// empty.
+	watchNamespaces []string
+
+	// Format of the operator's log entries. The operator's default is used
+	// if empty.
+	logFormat string
+
+	// Toggle serving the workspace validation webhook
+	webhook bool
 }
 
 func WithImage(image string) podTemplateOption {
@@ -56,6 +98,68 @@ func WithSecret(secretPresent bool) podTemplateOption {
 	}
 }
 
+func WithReplicas(replicas int32) podTemplateOption {
+	return func(c *podTemplateConfig) {
+		c.replicas = replicas
+	}
+}
+
+func WithBackupOnDelete(enabled bool) podTemplateOption {
+	return func(c *podTemplateConfig) {
+		c.backupOnDelete = enabled
+	}
+}
+
+func WithAutopilot(enabled bool) podTemplateOption {
+	return func(c *podTemplateConfig) {
+		c.autopilot = enabled
+	}
+}
+
+func WithRequeueBackoff(base, max time.Duration) podTemplateOption {
+	return func(c *podTemplateConfig) {
+		c.requeueBaseDelay = base
+		c.requeueMaxDelay = max
+	}
+}
+
+func WithBacklogCheck(threshold int, timeout time.Duration) podTemplateOption {
+	return func(c *podTemplateConfig) {
+		c.backlogThreshold = threshold
+		c.backlogTimeout = timeout
+	}
+}
+
+func WithWatchNamespaces(namespaces []string) podTemplateOption {
+	return func(c *podTemplateConfig) {
+		c.watchNamespaces = namespaces
+	}
+}
+
+func WithLogFormat(format string) podTemplateOption {
+	return func(c *podTemplateConfig) {
+		c.logFormat = format
+	}
+}
+
+func WithWebhook(enabled bool) podTemplateOption {
+	return func(c *podTemplateConfig) {
+		c.webhook = enabled
+	}
+}
+
+func WithImagePullSecrets(secrets []string) podTemplateOption {
+	return func(c *podTemplateConfig) {
+		c.imagePullSecrets = secrets
+	}
+}
+
+func WithLeaderElection(enabled bool) podTemplateOption {
+	return func(c *podTemplateConfig) {
+		c.leaderElection = enabled
+	}
+}
+
 func deployment(namespace string, opts ...podTemplateOption) *appsv1.Deployment {
 	c := &podTemplateConfig{
 		image: version.Image,
@@ -107,7 +211,15 @@ func deployment(namespace string, opts ...podTemplateOption) *appsv1.Deployment
 									Value: c.image,
 								},
 							},
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          "metrics",
+									ContainerPort: metricsPort,
+								},
+							},
 							TerminationMessagePolicy: "FallbackToLogsOnError",
+							LivenessProbe:            httpProbe("/healthz"),
+							ReadinessProbe:           httpProbe("/readyz"),
 						},
 					},
 				},
@@ -124,6 +236,77 @@ func deployment(namespace string, opts ...podTemplateOption) *appsv1.Deployment
 	deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: selector}
 	deployment.Spec.Template.Labels = selector
 
+	if c.replicas > 0 {
+		deployment.Spec.Replicas = &c.replicas
+	}
+
+	if c.leaderElection {
+		deployment.Spec.Template.Spec.Containers[0].Args = append(deployment.Spec.Template.Spec.Containers[0].Args, "--enable-leader-election")
+	}
+
+	if c.backupOnDelete {
+		deployment.Spec.Template.Spec.Containers[0].Args = append(deployment.Spec.Template.Spec.Containers[0].Args, "--backup-on-delete")
+	}
+
+	if c.autopilot {
+		deployment.Spec.Template.Spec.Containers[0].Args = append(deployment.Spec.Template.Spec.Containers[0].Args, "--autopilot")
+	}
+
+	if c.requeueBaseDelay > 0 {
+		deployment.Spec.Template.Spec.Containers[0].Args = append(deployment.Spec.Template.Spec.Containers[0].Args, "--requeue-base-delay="+c.requeueBaseDelay.String())
+	}
+
+	if c.requeueMaxDelay > 0 {
+		deployment.Spec.Template.Spec.Containers[0].Args = append(deployment.Spec.Template.Spec.Containers[0].Args, "--requeue-max-delay="+c.requeueMaxDelay.String())
+	}
+
+	if c.backlogThreshold > 0 {
+		deployment.Spec.Template.Spec.Containers[0].Args = append(deployment.Spec.Template.Spec.Containers[0].Args, "--backlog-threshold="+strconv.Itoa(c.backlogThreshold))
+		if c.backlogTimeout > 0 {
+			deployment.Spec.Template.Spec.Containers[0].Args = append(deployment.Spec.Template.Spec.Containers[0].Args, "--backlog-timeout="+c.backlogTimeout.String())
+		}
+
+		// A large backlog is reported to external monitors via
+		// /healthz/backlog, but restarting the operator would not clear it
+		deployment.Spec.Template.Spec.Containers[0].LivenessProbe = httpProbe("/healthz?exclude=" + controllers.BacklogCheckName)
+	}
+
+	if len(c.watchNamespaces) > 0 {
+		deployment.Spec.Template.Spec.Containers[0].Args = append(deployment.Spec.Template.Spec.Containers[0].Args, "--watch-namespaces="+strings.Join(c.watchNamespaces, ","))
+	}
+
+	if c.logFormat != "" {
+		deployment.Spec.Template.Spec.Containers[0].Args = append(deployment.Spec.Template.Spec.Containers[0].Args, "--log-format="+c.logFormat)
+	}
+
+	if c.webhook {
+		deployment.Spec.Template.Spec.Containers[0].Args = append(deployment.Spec.Template.Spec.Containers[0].Args, "--webhook-cert-dir="+webhookCertDir)
+
+		deployment.Spec.Template.Spec.Containers[0].Ports = append(deployment.Spec.Template.Spec.Containers[0].Ports, corev1.ContainerPort{
+			Name:          "webhook",
+			ContainerPort: webhookPort,
+		})
+
+		deployment.Spec.Template.Spec.Volumes = append(deployment.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name: "webhook-certs",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: webhookCertSecretName,
+				},
+			},
+		})
+
+		deployment.Spec.Template.Spec.Containers[0].VolumeMounts = append(deployment.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "webhook-certs",
+			MountPath: webhookCertDir,
+			ReadOnly:  true,
+		})
+	}
+
+	for _, secret := range c.imagePullSecrets {
+		deployment.Spec.Template.Spec.ImagePullSecrets = append(deployment.Spec.Template.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: secret})
+	}
+
 	if c.withSecret {
 		deployment.Spec.Template.Spec.Volumes = append(deployment.Spec.Template.Spec.Volumes, corev1.Volume{
 			Name: "secrets",
@@ -149,14 +332,16 @@ func deployment(namespace string, opts ...podTemplateOption) *appsv1.Deployment
 	return deployment
 }
 
-func isAvailable(c appsv1.DeploymentCondition) bool {
-	// Make sure that the deployment has been available for at least 10 seconds.
-	// This is because the deployment can show as Ready momentarily before the pods fall into a CrashLoopBackOff.
-	// See podutils.IsPodAvailable upstream for similar logic with pods
-	if c.Type == appsv1.DeploymentAvailable && c.Status == corev1.ConditionTrue {
-		if !c.LastTransitionTime.IsZero() && c.LastTransitionTime.Add(10*time.Second).Before(time.Now()) {
-			return true
-		}
+// httpProbe probes the operator's health probe endpoint at the given path
+func httpProbe(path string) *corev1.Probe {
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromInt(healthProbePort),
+			},
+		},
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       10,
 	}
-	return false
 }
@@ -2,12 +2,14 @@ package install
 
 import (
 	"testing"
+	"time"
 
 	"github.com/leg100/etok/pkg/testutil"
 	"github.com/leg100/etok/pkg/version"
 	"github.com/stretchr/testify/assert"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 func TestDeployment(t *testing.T) {
@@ -24,6 +26,115 @@ func TestDeployment(t *testing.T) {
 				assert.Equal(t, "test-image", deploy.Spec.Template.Spec.Containers[0].Image)
 			},
 		},
+		{
+			name:      "with probes",
+			namespace: "default",
+			assertions: func(deploy *appsv1.Deployment) {
+				container := deploy.Spec.Template.Spec.Containers[0]
+				assert.Equal(t, "/healthz", container.LivenessProbe.HTTPGet.Path)
+				assert.Equal(t, intstr.FromInt(8081), container.LivenessProbe.HTTPGet.Port)
+				assert.Equal(t, "/readyz", container.ReadinessProbe.HTTPGet.Path)
+				assert.Equal(t, intstr.FromInt(8081), container.ReadinessProbe.HTTPGet.Port)
+			},
+		},
+		{
+			name:      "with leader election",
+			namespace: "default",
+			opts:      []podTemplateOption{WithReplicas(3), WithLeaderElection(true)},
+			assertions: func(deploy *appsv1.Deployment) {
+				assert.Equal(t, int32(3), *deploy.Spec.Replicas)
+				assert.Equal(t, []string{"operator", "--enable-leader-election"}, deploy.Spec.Template.Spec.Containers[0].Args)
+			},
+		},
+		{
+			name:      "without leader election",
+			namespace: "default",
+			opts:      []podTemplateOption{WithLeaderElection(false)},
+			assertions: func(deploy *appsv1.Deployment) {
+				assert.Equal(t, []string{"operator"}, deploy.Spec.Template.Spec.Containers[0].Args)
+			},
+		},
+		{
+			name:      "with watch namespaces",
+			namespace: "default",
+			opts:      []podTemplateOption{WithWatchNamespaces([]string{"dev", "prod"})},
+			assertions: func(deploy *appsv1.Deployment) {
+				assert.Equal(t, []string{"operator", "--watch-namespaces=dev,prod"}, deploy.Spec.Template.Spec.Containers[0].Args)
+			},
+		},
+		{
+			name:      "with log format",
+			namespace: "default",
+			opts:      []podTemplateOption{WithLogFormat("console")},
+			assertions: func(deploy *appsv1.Deployment) {
+				assert.Equal(t, []string{"operator", "--log-format=console"}, deploy.Spec.Template.Spec.Containers[0].Args)
+			},
+		},
+		{
+			name:      "with backup on delete",
+			namespace: "default",
+			opts:      []podTemplateOption{WithBackupOnDelete(true)},
+			assertions: func(deploy *appsv1.Deployment) {
+				assert.Equal(t, []string{"operator", "--backup-on-delete"}, deploy.Spec.Template.Spec.Containers[0].Args)
+			},
+		},
+		{
+			name:      "with autopilot",
+			namespace: "default",
+			opts:      []podTemplateOption{WithAutopilot(true)},
+			assertions: func(deploy *appsv1.Deployment) {
+				assert.Equal(t, []string{"operator", "--autopilot"}, deploy.Spec.Template.Spec.Containers[0].Args)
+			},
+		},
+		{
+			name:      "with requeue backoff",
+			namespace: "default",
+			opts:      []podTemplateOption{WithRequeueBackoff(2*time.Second, 10*time.Minute)},
+			assertions: func(deploy *appsv1.Deployment) {
+				assert.Equal(t, []string{"operator", "--requeue-base-delay=2s", "--requeue-max-delay=10m0s"}, deploy.Spec.Template.Spec.Containers[0].Args)
+			},
+		},
+		{
+			name:      "with backlog check",
+			namespace: "default",
+			opts:      []podTemplateOption{WithBacklogCheck(5, 30*time.Minute)},
+			assertions: func(deploy *appsv1.Deployment) {
+				assert.Equal(t, []string{"operator", "--backlog-threshold=5", "--backlog-timeout=30m0s"}, deploy.Spec.Template.Spec.Containers[0].Args)
+				assert.Equal(t, "/healthz?exclude=backlog", deploy.Spec.Template.Spec.Containers[0].LivenessProbe.HTTPGet.Path)
+			},
+		},
+		{
+			name:      "without backlog check",
+			namespace: "default",
+			assertions: func(deploy *appsv1.Deployment) {
+				assert.Equal(t, "/healthz", deploy.Spec.Template.Spec.Containers[0].LivenessProbe.HTTPGet.Path)
+			},
+		},
+		{
+			name:      "with webhook",
+			namespace: "default",
+			opts:      []podTemplateOption{WithWebhook(true)},
+			assertions: func(deploy *appsv1.Deployment) {
+				assert.Equal(t, []string{"operator", "--webhook-cert-dir=/webhook-certs"}, deploy.Spec.Template.Spec.Containers[0].Args)
+				assert.Contains(t, deploy.Spec.Template.Spec.Containers[0].Ports, corev1.ContainerPort{
+					Name:          "webhook",
+					ContainerPort: 9443,
+				})
+				assert.Contains(t, deploy.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "webhook-certs",
+					MountPath: "/webhook-certs",
+					ReadOnly:  true,
+				})
+			},
+		},
+		{
+			name:      "with image pull secrets",
+			namespace: "default",
+			opts:      []podTemplateOption{WithImagePullSecrets([]string{"registry-creds"})},
+			assertions: func(deploy *appsv1.Deployment) {
+				assert.Equal(t, []corev1.LocalObjectReference{{Name: "registry-creds"}}, deploy.Spec.Template.Spec.ImagePullSecrets)
+			},
+		},
 		{
 			name:      "with secret",
 			namespace: "default",
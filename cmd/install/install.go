@@ -2,6 +2,7 @@ package install
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -11,8 +12,11 @@ import (
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 
@@ -21,6 +25,7 @@ import (
 	"github.com/leg100/etok/cmd/flags"
 	cmdutil "github.com/leg100/etok/cmd/util"
 	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/k8s"
 	"github.com/leg100/etok/pkg/labels"
 	"github.com/leg100/etok/pkg/version"
 	"github.com/spf13/cobra"
@@ -33,6 +38,11 @@ const (
 )
 
 var (
+	errCRDDowngrade      = errors.New("refusing to downgrade CRD; use --force to override")
+	errUnknownCRDVersion = errors.New("unable to determine CRD version; use --force to override")
+
+	errReplicasWithoutLeaderElection = errors.New("more than one replica requires --enable-leader-election")
+
 	// The URL of the repo from which certain resources will be retrieved (CRDs,
 	// cluster role).
 	repoURL = "https://raw.githubusercontent.com/leg100/etok/v" + version.Version
@@ -43,12 +53,16 @@ var (
 		"config/crd/bases/etok.dev_workspaces.yaml",
 		"config/crd/bases/etok.dev_runs.yaml",
 	}
+	// Relative path to the operator's cluster role. Path relative to the root
+	// of the repo.
+	operatorClusterRolePath = "config/rbac/role.yaml"
 	// Relative paths to the cluster roles to be installed. Paths relative to
 	// the root of the repo.
 	clusterRolePaths = []string{
-		"config/rbac/role.yaml",
+		operatorClusterRolePath,
 		"config/rbac/user.yaml",
 		"config/rbac/admin.yaml",
+		"config/rbac/readonly.yaml",
 	}
 
 	// Interval between polling deployment status
@@ -65,6 +79,9 @@ type installOptions struct {
 	image       string
 	kubeContext string
 
+	// Secrets for pulling the image from a private registry
+	imagePullSecrets []string
+
 	// Path on local fs containing GCP service account key
 	secretFile string
 	// Annotations to add to the service account resource
@@ -73,6 +90,51 @@ type installOptions struct {
 	// Toggle only installing CRDs
 	crdsOnly bool
 
+	// Toggle only upgrading CRDs, refusing to downgrade them
+	upgradeCRDsOnly bool
+	// Permit downgrading CRDs
+	force bool
+
+	// Type of service exposing operator metrics. No service is created if
+	// empty.
+	metricsServiceType string
+
+	// Toggle leader election, permitting more than one operator replica
+	enableLeaderElection bool
+	// Number of operator replicas
+	replicas int32
+
+	// Toggle backing up state before a workspace is deleted
+	backupOnDelete bool
+
+	// Toggle defaults suited to GKE Autopilot clusters
+	autopilot bool
+
+	// Delays before reconciling a workspace following a failed reconcile
+	requeueBaseDelay time.Duration
+	requeueMaxDelay  time.Duration
+
+	// Number of workspaces with queued runs above which the operator's
+	// backlog health check fails, once exceeded for longer than the backlog
+	// timeout. The check is disabled if zero.
+	backlogThreshold int
+	backlogTimeout   time.Duration
+
+	// Namespaces for the operator to watch. All namespaces are watched if
+	// empty.
+	watchNamespaces []string
+
+	// Format of the operator's log entries. The operator's default is used if
+	// empty.
+	logFormat string
+
+	// Toggle installing the operator's admission webhooks
+	webhook bool
+
+	// Group bound to the read-only cluster role. The binding's existing
+	// subjects are retained if empty.
+	readonlyGroup string
+
 	// Toggle reading resources from local files rather than a URL
 	local bool
 
@@ -109,6 +171,7 @@ func InstallCmd(f *cmdutil.Factory) (*cobra.Command, *installOptions) {
 
 	cmd.Flags().StringVar(&o.name, "name", "etok-operator", "Name for kubernetes resources")
 	cmd.Flags().StringVar(&o.image, "image", version.Image, "Docker image used for both the operator and the runner")
+	cmd.Flags().StringSliceVar(&o.imagePullSecrets, "image-pull-secrets", []string{}, "Secrets for pulling the operator image from a private registry")
 
 	cmd.Flags().BoolVar(&o.local, "local", false, "Read resources from local files (default false)")
 	cmd.Flags().BoolVar(&o.dryRun, "dry-run", false, "Don't install resources just print out them in YAML format")
@@ -118,6 +181,21 @@ func InstallCmd(f *cmdutil.Factory) (*cobra.Command, *installOptions) {
 	cmd.Flags().StringVar(&o.secretFile, "secret-file", "", "Path on local filesystem to key file")
 	cmd.Flags().StringToStringVar(&o.serviceAccountAnnotations, "sa-annotations", map[string]string{}, "Annotations to add to the etok ServiceAccount. Add iam.gke.io/gcp-service-account=[GSA_NAME]@[PROJECT_NAME].iam.gserviceaccount.com for workload identity")
 	cmd.Flags().BoolVar(&o.crdsOnly, "crds-only", o.crdsOnly, "Only generate CRD resources. Useful for updating CRDs for an existing Etok install.")
+	cmd.Flags().BoolVar(&o.upgradeCRDsOnly, "upgrade-crds-only", o.upgradeCRDsOnly, "Only upgrade CRDs, reporting which CRDs would change and refusing to downgrade them.")
+	cmd.Flags().BoolVar(&o.force, "force", o.force, "Permit --upgrade-crds-only to downgrade CRDs")
+	cmd.Flags().BoolVar(&o.enableLeaderElection, "enable-leader-election", false, "Enable leader election for the operator, ensuring only one replica reconciles resources at any one time")
+	cmd.Flags().BoolVar(&o.backupOnDelete, "backup-on-delete", false, "Backup state of workspaces with a backup bucket before they are deleted")
+	cmd.Flags().BoolVar(&o.autopilot, "autopilot", false, "Use defaults suited to GKE Autopilot clusters (default true if the operator detects Autopilot)")
+	cmd.Flags().DurationVar(&o.requeueBaseDelay, "requeue-base-delay", 0, "Delay before reconciling a workspace again following a failed reconcile, doubling with each consecutive failure (default 1s)")
+	cmd.Flags().DurationVar(&o.requeueMaxDelay, "requeue-max-delay", 0, "Maximum delay before reconciling a workspace again following a failed reconcile (default 5m0s)")
+	cmd.Flags().IntVar(&o.backlogThreshold, "backlog-threshold", 0, "Number of workspaces with queued runs above which the operator's /healthz/backlog health check fails, once exceeded for longer than --backlog-timeout (default disabled)")
+	cmd.Flags().DurationVar(&o.backlogTimeout, "backlog-timeout", 0, "How long the number of workspaces with queued runs may exceed --backlog-threshold before the operator's /healthz/backlog health check fails (default 10m0s)")
+	cmd.Flags().StringVar(&o.logFormat, "log-format", "", "Format of the operator's log entries: json or console (default json)")
+	cmd.Flags().StringSliceVar(&o.watchNamespaces, "watch-namespaces", []string{}, "Restrict the operator to these namespaces, granting it permissions only within them (default all namespaces)")
+	cmd.Flags().BoolVar(&o.webhook, "webhook", true, "Install the operator's admission webhooks, which validate workspaces and record the user that creates each run. With the webhooks installed, workspaces and runs cannot be created or updated while the operator is unavailable.")
+	cmd.Flags().StringVar(&o.readonlyGroup, "readonly-group", "", "Bind this group to the etok-readonly ClusterRole, permitting its members to view workspaces, runs and their logs but not to run commands")
+	cmd.Flags().Int32Var(&o.replicas, "replicas", 1, "Number of operator replicas (more than one requires --enable-leader-election)")
+	cmd.Flags().StringVar(&o.metricsServiceType, "metrics-service-type", "", "Create a service of this type exposing the operator's metrics endpoint: ClusterIP, NodePort, or LoadBalancer (default no service)")
 
 	return cmd, o
 }
@@ -126,6 +204,26 @@ func (o *installOptions) install(ctx context.Context) error {
 	var deploy *appsv1.Deployment
 	var resources []runtimeclient.Object
 
+	if err := validateServiceAccountAnnotations(o.serviceAccountAnnotations); err != nil {
+		return err
+	}
+
+	if err := validateServiceType(o.metricsServiceType); err != nil {
+		return err
+	}
+
+	if err := validateLogFormat(o.logFormat); err != nil {
+		return err
+	}
+
+	if o.replicas > 1 && !o.enableLeaderElection {
+		return errReplicasWithoutLeaderElection
+	}
+
+	if o.upgradeCRDsOnly {
+		o.crdsOnly = true
+	}
+
 	for _, path := range crdPaths {
 		res, err := o.crd(path)
 		if err != nil {
@@ -140,19 +238,52 @@ func (o *installOptions) install(ctx context.Context) error {
 			if err != nil {
 				return err
 			}
+
+			if path == operatorClusterRolePath && len(o.watchNamespaces) > 0 {
+				// Grant the operator its permissions only within the
+				// namespaces it watches
+				for _, ns := range o.watchNamespaces {
+					resources = append(resources, operatorRole(ns, role.Rules))
+					resources = append(resources, operatorRoleBinding(ns, o.namespace))
+				}
+				continue
+			}
 			resources = append(resources, role)
 		}
 
-		resources = append(resources, operatorClusterRoleBinding(o.namespace))
+		if len(o.watchNamespaces) == 0 {
+			resources = append(resources, operatorClusterRoleBinding(o.namespace))
+		}
 		resources = append(resources, userClusterRoleBinding())
 		resources = append(resources, adminClusterRoleBinding())
+		resources = append(resources, readonlyClusterRoleBinding(o.readonlyGroup))
 		resources = append(resources, namespace(o.namespace))
 		resources = append(resources, serviceAccount(o.namespace, o.serviceAccountAnnotations))
 
 		secretPresent := o.secretFile != ""
-		deploy = deployment(o.namespace, WithSecret(secretPresent), WithImage(o.image))
+		deploy = deployment(o.namespace, WithSecret(secretPresent), WithImage(o.image), WithImagePullSecrets(o.imagePullSecrets), WithReplicas(o.replicas), WithLeaderElection(o.enableLeaderElection), WithBackupOnDelete(o.backupOnDelete), WithAutopilot(o.autopilot), WithRequeueBackoff(o.requeueBaseDelay, o.requeueMaxDelay), WithBacklogCheck(o.backlogThreshold, o.backlogTimeout), WithWatchNamespaces(o.watchNamespaces), WithLogFormat(o.logFormat), WithWebhook(o.webhook))
 		resources = append(resources, deploy)
 
+		if o.webhook {
+			certs, err := o.webhookCerts(ctx)
+			if err != nil {
+				return err
+			}
+			resources = append(resources, webhookService(o.namespace))
+			resources = append(resources, webhookCertSecret(o.namespace, certs))
+			resources = append(resources, validatingWebhookConfiguration(o.namespace, certs.caCert))
+			resources = append(resources, mutatingWebhookConfiguration(o.namespace, certs.caCert))
+		}
+
+		if o.enableLeaderElection {
+			resources = append(resources, leaderElectionRole(o.namespace))
+			resources = append(resources, leaderElectionRoleBinding(o.namespace))
+		}
+
+		if o.metricsServiceType != "" {
+			resources = append(resources, metricsService(o.namespace, corev1.ServiceType(o.metricsServiceType)))
+		}
+
 		if o.secretFile != "" {
 			key, err := ioutil.ReadFile(o.secretFile)
 			if err != nil {
@@ -169,6 +300,12 @@ func (o *installOptions) install(ctx context.Context) error {
 		labels.SetLabel(r, labels.OperatorComponent)
 	}
 
+	if o.upgradeCRDsOnly {
+		if err := o.checkCRDUpgrade(ctx, resources); err != nil {
+			return err
+		}
+	}
+
 	if o.dryRun {
 		// Print out YAML representation
 		var docs []string
@@ -189,6 +326,14 @@ func (o *installOptions) install(ctx context.Context) error {
 		return err
 	}
 
+	if !o.webhook && !o.crdsOnly {
+		// Remove the webhooks of a previous install, which would otherwise
+		// reject every request once the operator stops serving them
+		if err := o.deleteWebhookConfigurations(ctx); err != nil {
+			return err
+		}
+	}
+
 	if o.wait && !o.crdsOnly {
 		fmt.Fprintf(o.Out, "Waiting for Deployment to be ready\n")
 		if err := o.deploymentIsReady(ctx, deploy); err != nil {
@@ -223,6 +368,13 @@ func (o *installOptions) createOrUpdate(ctx context.Context, resources []runtime
 				updatedBinding := res.(*rbacv1.ClusterRoleBinding)
 				updatedBinding.Subjects = existingBinding.Subjects
 			}
+			if kind == "ClusterRoleBinding" && res.GetName() == "etok-readonly" && o.readonlyGroup == "" {
+				// Preserve any out-of-band changes to subjects unless a
+				// group has been specified
+				existingBinding := existing.(*rbacv1.ClusterRoleBinding)
+				updatedBinding := res.(*rbacv1.ClusterRoleBinding)
+				updatedBinding.Subjects = existingBinding.Subjects
+			}
 
 			res.SetResourceVersion(existing.GetResourceVersion())
 
@@ -237,6 +389,62 @@ func (o *installOptions) createOrUpdate(ctx context.Context, resources []runtime
 	return nil
 }
 
+// checkCRDUpgrade compares the CRDs to be installed against those already
+// installed, reporting which CRDs would change. It refuses to downgrade a CRD
+// unless forced to do so.
+func (o *installOptions) checkCRDUpgrade(ctx context.Context, resources []runtimeclient.Object) error {
+	for _, res := range resources {
+		wanted, ok := res.(*apiextv1.CustomResourceDefinition)
+		if !ok {
+			continue
+		}
+
+		var installed apiextv1.CustomResourceDefinition
+		err := o.RuntimeClient.Get(ctx, runtimeclient.ObjectKeyFromObject(wanted), &installed)
+		switch {
+		case kerrors.IsNotFound(err):
+			fmt.Fprintf(o.Out, "CRD %s not installed and would be created\n", wanted.Name)
+			continue
+		case err != nil:
+			return err
+		}
+
+		installedVersion := installed.Labels[labels.Version.Name]
+		wantedVersion := wanted.Labels[labels.Version.Name]
+
+		if err := checkCRDVersion(installedVersion, wantedVersion); err != nil {
+			if !o.force {
+				return fmt.Errorf("%s: %w", wanted.Name, err)
+			}
+			fmt.Fprintf(o.Out, "Warning: %s: %s\n", wanted.Name, err.Error())
+		}
+
+		if equality.Semantic.DeepEqual(installed.Spec.Versions, wanted.Spec.Versions) {
+			fmt.Fprintf(o.Out, "CRD %s unchanged (%s -> %s)\n", wanted.Name, installedVersion, wantedVersion)
+		} else {
+			fmt.Fprintf(o.Out, "CRD %s would change (%s -> %s)\n", wanted.Name, installedVersion, wantedVersion)
+		}
+	}
+	return nil
+}
+
+// checkCRDVersion returns an error if the wanted version of a CRD is older than
+// the installed version, or if either version cannot be determined.
+func checkCRDVersion(installed, wanted string) error {
+	installedVersion, err := utilversion.ParseSemantic(installed)
+	if err != nil {
+		return fmt.Errorf("installed version %q: %w", installed, errUnknownCRDVersion)
+	}
+	wantedVersion, err := utilversion.ParseSemantic(wanted)
+	if err != nil {
+		return fmt.Errorf("wanted version %q: %w", wanted, errUnknownCRDVersion)
+	}
+	if wantedVersion.LessThan(installedVersion) {
+		return fmt.Errorf("%s -> %s: %w", installed, wanted, errCRDDowngrade)
+	}
+	return nil
+}
+
 // DeploymentIsReady will poll the kubernetes API server to see if the velero
 // deployment is ready to service user requests.
 func (o *installOptions) deploymentIsReady(ctx context.Context, deploy *appsv1.Deployment) error {
@@ -246,10 +454,8 @@ func (o *installOptions) deploymentIsReady(ctx context.Context, deploy *appsv1.D
 			return false, err
 		}
 
-		for _, cond := range deploy.Status.Conditions {
-			if isAvailable(cond) {
-				readyObservations++
-			}
+		if k8s.IsDeploymentAvailable(deploy) {
+			readyObservations++
 		}
 		// Make sure we query the deployment enough times to see the state change, provided there is one.
 		if readyObservations > 4 {
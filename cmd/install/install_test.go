@@ -3,6 +3,7 @@ package install
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,14 +16,17 @@ import (
 
 	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	cmdutil "github.com/leg100/etok/cmd/util"
 	etokclient "github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/labels"
 	"github.com/leg100/etok/pkg/scheme"
 	"github.com/leg100/etok/pkg/testutil"
 	"github.com/stretchr/testify/assert"
@@ -34,11 +38,16 @@ import (
 )
 
 func TestInstall(t *testing.T) {
+	// Webhook certificates of an existing install
+	existingCerts, err := generateWebhookCerts("etok-webhook.etok.svc")
+	require.NoError(t, err)
+
 	tests := []struct {
 		name       string
 		args       []string
 		objs       []runtimeclient.Object
 		err        bool
+		version    string
 		assertions func(*testutil.T, runtimeclient.Client)
 	}{
 		{
@@ -52,12 +61,184 @@ func TestInstall(t *testing.T) {
 		{
 			name: "upgrade",
 			args: []string{"install", "--wait=false"},
-			objs: append(wantedResources(), wantedCRDs()...),
+			objs: append(wantedResources("etok", nil, true), wantedCRDs()...),
+		},
+		{
+			name:    "upgrade CRDs only",
+			args:    []string{"install", "--wait=false", "--upgrade-crds-only"},
+			objs:    installedCRDs("0.1.0"),
+			version: "0.2.0",
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				for _, res := range wantedCRDs() {
+					client.Get(context.Background(), runtimeclient.ObjectKeyFromObject(res), res)
+					assert.Equal(t, "0.2.0", res.GetLabels()["version"])
+				}
+			},
+		},
+		{
+			name:    "upgrade CRDs only refuses to downgrade",
+			args:    []string{"install", "--wait=false", "--upgrade-crds-only"},
+			objs:    installedCRDs("0.3.0"),
+			version: "0.2.0",
+			err:     true,
+		},
+		{
+			name:    "upgrade CRDs only forced to downgrade",
+			args:    []string{"install", "--wait=false", "--upgrade-crds-only", "--force"},
+			objs:    installedCRDs("0.3.0"),
+			version: "0.2.0",
+		},
+		{
+			name: "upgrade CRDs only with unknown version",
+			args: []string{"install", "--wait=false", "--upgrade-crds-only"},
+			objs: installedCRDs("0.1.0"),
+			err:  true,
 		},
 		{
 			name: "fresh local install",
 			args: []string{"install", "--local", "--wait=false"},
 		},
+		{
+			name: "fresh install into custom namespace",
+			args: []string{"install", "--wait=false", "--namespace", "etok-system"},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				// Operator's service account in the custom namespace should be
+				// bound to the operator's cluster role
+				var binding rbacv1.ClusterRoleBinding
+				client.Get(context.Background(), types.NamespacedName{Name: "etok"}, &binding)
+				assert.Equal(t, "etok-system", binding.Subjects[0].Namespace)
+
+				// Nothing should be installed into the default namespace
+				var ns corev1.Namespace
+				assert.True(t, kerrors.IsNotFound(client.Get(context.Background(), types.NamespacedName{Name: "etok"}, &ns)))
+			},
+		},
+		{
+			name: "fresh install watching namespaces",
+			args: []string{"install", "--wait=false", "--watch-namespaces", "dev,prod"},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				// Operator should not be granted cluster-wide permissions
+				var binding rbacv1.ClusterRoleBinding
+				assert.True(t, kerrors.IsNotFound(client.Get(context.Background(), types.NamespacedName{Name: "etok"}, &binding)))
+				var clusterRole rbacv1.ClusterRole
+				assert.True(t, kerrors.IsNotFound(client.Get(context.Background(), types.NamespacedName{Name: "etok"}, &clusterRole)))
+
+				// Operator's role in a watched namespace should have the
+				// rules of its cluster role, and be bound to its service
+				// account
+				var role rbacv1.Role
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Namespace: "prod", Name: "etok-operator"}, &role))
+				assert.NotEmpty(t, role.Rules)
+				var roleBinding rbacv1.RoleBinding
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Namespace: "prod", Name: "etok-operator"}, &roleBinding))
+				assert.Equal(t, "etok", roleBinding.Subjects[0].Namespace)
+
+				var deploy appsv1.Deployment
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Namespace: "etok", Name: "etok"}, &deploy))
+				assert.Contains(t, deploy.Spec.Template.Spec.Containers[0].Args, "--watch-namespaces=dev,prod")
+			},
+		},
+		{
+			name: "fresh install with readonly group",
+			args: []string{"install", "--wait=false", "--readonly-group", "auditors"},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				var role rbacv1.ClusterRole
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Name: "etok-readonly"}, &role))
+				for _, rule := range role.Rules {
+					assert.NotContains(t, rule.Verbs, "create")
+				}
+
+				var binding rbacv1.ClusterRoleBinding
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Name: "etok-readonly"}, &binding))
+				assert.Equal(t, []rbacv1.Subject{{Kind: "Group", Name: "auditors", APIGroup: "rbac.authorization.k8s.io"}}, binding.Subjects)
+			},
+		},
+		{
+			name: "upgrade retains readonly binding subjects",
+			args: []string{"install", "--wait=false"},
+			objs: func() []runtimeclient.Object {
+				resources := wantedResources("etok", nil, true)
+				for _, res := range resources {
+					if binding, ok := res.(*rbacv1.ClusterRoleBinding); ok && binding.Name == "etok-readonly" {
+						// Bound out-of-band
+						binding.Subjects = []rbacv1.Subject{{Kind: "User", Name: "alice", APIGroup: "rbac.authorization.k8s.io"}}
+					}
+				}
+				return resources
+			}(),
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				var binding rbacv1.ClusterRoleBinding
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Name: "etok-readonly"}, &binding))
+				assert.Equal(t, "alice", binding.Subjects[0].Name)
+			},
+		},
+		{
+			name: "fresh install with metrics service",
+			args: []string{"install", "--wait=false", "--metrics-service-type", "LoadBalancer"},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				var svc corev1.Service
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Namespace: "etok", Name: "etok-metrics"}, &svc))
+				assert.Equal(t, corev1.ServiceTypeLoadBalancer, svc.Spec.Type)
+				assert.Equal(t, int32(8080), svc.Spec.Ports[0].Port)
+			},
+		},
+		{
+			name: "fresh install without metrics service",
+			args: []string{"install", "--wait=false"},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				var svc corev1.Service
+				assert.True(t, kerrors.IsNotFound(client.Get(context.Background(), types.NamespacedName{Namespace: "etok", Name: "etok-metrics"}, &svc)))
+			},
+		},
+		{
+			name: "fresh install with invalid metrics service type",
+			args: []string{"install", "--wait=false", "--metrics-service-type", "ExternalName"},
+			err:  true,
+		},
+		{
+			name: "fresh install with console log format",
+			args: []string{"install", "--wait=false", "--log-format", "console"},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				var deploy appsv1.Deployment
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Namespace: "etok", Name: "etok"}, &deploy))
+				assert.Contains(t, deploy.Spec.Template.Spec.Containers[0].Args, "--log-format=console")
+			},
+		},
+		{
+			name: "fresh install with invalid log format",
+			args: []string{"install", "--wait=false", "--log-format", "logfmt"},
+			err:  true,
+		},
+		{
+			name: "fresh install with leader election",
+			args: []string{"install", "--wait=false", "--enable-leader-election", "--replicas", "2"},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				var role rbacv1.Role
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Namespace: "etok", Name: "etok-leader-election"}, &role))
+
+				var binding rbacv1.RoleBinding
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Namespace: "etok", Name: "etok-leader-election"}, &binding))
+				assert.Equal(t, "etok", binding.Subjects[0].Name)
+
+				var deploy appsv1.Deployment
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Namespace: "etok", Name: "etok"}, &deploy))
+				assert.Equal(t, int32(2), *deploy.Spec.Replicas)
+				assert.Contains(t, deploy.Spec.Template.Spec.Containers[0].Args, "--enable-leader-election")
+			},
+		},
+		{
+			name: "fresh install without leader election",
+			args: []string{"install", "--wait=false"},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				var role rbacv1.Role
+				assert.True(t, kerrors.IsNotFound(client.Get(context.Background(), types.NamespacedName{Namespace: "etok", Name: "etok-leader-election"}, &role)))
+			},
+		},
+		{
+			name: "fresh install with multiple replicas without leader election",
+			args: []string{"install", "--wait=false", "--replicas", "2"},
+			err:  true,
+		},
 		{
 			name: "fresh install with service account annotations",
 			args: []string{"install", "--wait=false", "--sa-annotations", "foo=bar,baz=haj"},
@@ -67,6 +248,81 @@ func TestInstall(t *testing.T) {
 				assert.Equal(t, map[string]string{"foo": "bar", "baz": "haj"}, sa.GetAnnotations())
 			},
 		},
+		{
+			name: "fresh install with workload identity annotation",
+			args: []string{"install", "--wait=false", "--sa-annotations", "iam.gke.io/gcp-service-account=etok-operator@my-project.iam.gserviceaccount.com"},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				var sa corev1.ServiceAccount
+				client.Get(context.Background(), types.NamespacedName{Namespace: "etok", Name: "etok"}, &sa)
+				assert.Equal(t, "etok-operator@my-project.iam.gserviceaccount.com", sa.GetAnnotations()["iam.gke.io/gcp-service-account"])
+			},
+		},
+		{
+			name: "fresh install with invalid workload identity annotation",
+			args: []string{"install", "--wait=false", "--sa-annotations", "iam.gke.io/gcp-service-account=etok-operator@my-project"},
+			err:  true,
+		},
+		{
+			name: "fresh install with webhook",
+			args: []string{"install", "--wait=false", "--namespace", "etok-system"},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				var secret corev1.Secret
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Namespace: "etok-system", Name: "etok-webhook-cert"}, &secret))
+				certs := &webhookCerts{caCert: secret.Data["ca.crt"], cert: secret.Data["tls.crt"], key: secret.Data["tls.key"]}
+				assert.True(t, certs.valid("etok-webhook.etok-system.svc"))
+
+				var config admissionregistrationv1.ValidatingWebhookConfiguration
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Name: "etok"}, &config))
+				assert.Equal(t, "etok-system", config.Webhooks[0].ClientConfig.Service.Namespace)
+				assert.Equal(t, secret.Data["ca.crt"], config.Webhooks[0].ClientConfig.CABundle)
+
+				var mutating admissionregistrationv1.MutatingWebhookConfiguration
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Name: "etok"}, &mutating))
+				assert.Equal(t, "/mutate-run-user", *mutating.Webhooks[0].ClientConfig.Service.Path)
+				assert.Equal(t, secret.Data["ca.crt"], mutating.Webhooks[0].ClientConfig.CABundle)
+
+				var d = deploy()
+				d.Namespace = "etok-system"
+				require.NoError(t, client.Get(context.Background(), runtimeclient.ObjectKeyFromObject(d), d))
+				assert.Contains(t, d.Spec.Template.Spec.Containers[0].Args, "--webhook-cert-dir=/webhook-certs")
+			},
+		},
+		{
+			name: "install without webhook",
+			args: []string{"install", "--wait=false", "--webhook=false"},
+			objs: []runtimeclient.Object{
+				validatingWebhookConfiguration("etok", existingCerts.caCert),
+				mutatingWebhookConfiguration("etok", existingCerts.caCert),
+			},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				// Webhooks of the previous install are removed
+				var config admissionregistrationv1.ValidatingWebhookConfiguration
+				assert.True(t, kerrors.IsNotFound(client.Get(context.Background(), types.NamespacedName{Name: "etok"}, &config)))
+				var mutating admissionregistrationv1.MutatingWebhookConfiguration
+				assert.True(t, kerrors.IsNotFound(client.Get(context.Background(), types.NamespacedName{Name: "etok"}, &mutating)))
+
+				var secret corev1.Secret
+				assert.True(t, kerrors.IsNotFound(client.Get(context.Background(), types.NamespacedName{Namespace: "etok", Name: "etok-webhook-cert"}, &secret)))
+
+				var d = deploy()
+				require.NoError(t, client.Get(context.Background(), runtimeclient.ObjectKeyFromObject(d), d))
+				assert.NotContains(t, d.Spec.Template.Spec.Containers[0].Args, "--webhook-cert-dir=/webhook-certs")
+			},
+		},
+		{
+			name: "upgrade retains webhook certificates",
+			args: []string{"install", "--wait=false"},
+			objs: []runtimeclient.Object{webhookCertSecret("etok", existingCerts)},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				var secret corev1.Secret
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Namespace: "etok", Name: "etok-webhook-cert"}, &secret))
+				assert.Equal(t, existingCerts.cert, secret.Data["tls.crt"])
+
+				var config admissionregistrationv1.ValidatingWebhookConfiguration
+				require.NoError(t, client.Get(context.Background(), types.NamespacedName{Name: "etok"}, &config))
+				assert.Equal(t, existingCerts.caCert, config.Webhooks[0].ClientConfig.CABundle)
+			},
+		},
 		{
 			name: "fresh install with custom image",
 			args: []string{"install", "--wait=false", "--image", "bugsbunny:v123"},
@@ -76,6 +332,15 @@ func TestInstall(t *testing.T) {
 				assert.Equal(t, "bugsbunny:v123", d.Spec.Template.Spec.Containers[0].Image)
 			},
 		},
+		{
+			name: "fresh install with image pull secret",
+			args: []string{"install", "--wait=false", "--image", "private.registry/etok:v123", "--image-pull-secrets", "registry-creds"},
+			assertions: func(t *testutil.T, client runtimeclient.Client) {
+				var d = deploy()
+				client.Get(context.Background(), runtimeclient.ObjectKeyFromObject(d), d)
+				assert.Equal(t, []corev1.LocalObjectReference{{Name: "registry-creds"}}, d.Spec.Template.Spec.ImagePullSecrets)
+			},
+		},
 	}
 	for _, tt := range tests {
 		testutil.Run(t, tt.name, func(t *testutil.T) {
@@ -103,7 +368,15 @@ func TestInstall(t *testing.T) {
 			// Override wait interval to ensure fast tests
 			t.Override(&interval, 10*time.Millisecond)
 
+			if tt.version != "" {
+				t.Override(&labels.Version, labels.Label{Name: "version", Value: tt.version})
+			}
+
 			t.CheckError(tt.err, cmd.ExecuteContext(context.Background()))
+			if tt.err {
+				// Nothing should have been installed
+				return
+			}
 
 			// get runtime client now that it's been created
 			client := opts.RuntimeClient
@@ -116,7 +389,7 @@ func TestInstall(t *testing.T) {
 			// assert non-CRD resources are present unless only CRDs are
 			// requested
 			if !opts.crdsOnly {
-				for _, res := range wantedResources() {
+				for _, res := range wantedResources(opts.namespace, opts.watchNamespaces, opts.webhook) {
 					assert.NoError(t, client.Get(context.Background(), runtimeclient.ObjectKeyFromObject(res), res))
 				}
 			}
@@ -128,6 +401,107 @@ func TestInstall(t *testing.T) {
 	}
 }
 
+func TestValidateServiceAccountAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		err         error
+	}{
+		{
+			name:        "no annotations",
+			annotations: map[string]string{},
+		},
+		{
+			name:        "unknown annotation",
+			annotations: map[string]string{"foo": "bar"},
+		},
+		{
+			name:        "valid gcp service account",
+			annotations: map[string]string{"iam.gke.io/gcp-service-account": "etok-operator@my-project.iam.gserviceaccount.com"},
+		},
+		{
+			name:        "valid gcp service account with domain-scoped project",
+			annotations: map[string]string{"iam.gke.io/gcp-service-account": "etok-operator@example.com:my-project.iam.gserviceaccount.com"},
+		},
+		{
+			name:        "gcp service account missing domain",
+			annotations: map[string]string{"iam.gke.io/gcp-service-account": "etok-operator@my-project"},
+			err:         errInvalidServiceAccountAnnotation,
+		},
+		{
+			name:        "gcp service account with typo in domain",
+			annotations: map[string]string{"iam.gke.io/gcp-service-account": "etok-operator@my-project.iam.gserviceacount.com"},
+			err:         errInvalidServiceAccountAnnotation,
+		},
+		{
+			name:        "valid aws role arn",
+			annotations: map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:role/etok"},
+		},
+		{
+			name:        "valid aws role arn with path",
+			annotations: map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:role/service/etok"},
+		},
+		{
+			name:        "aws role arn with short account id",
+			annotations: map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::12345:role/etok"},
+			err:         errInvalidServiceAccountAnnotation,
+		},
+		{
+			name:        "aws user arn",
+			annotations: map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:user/etok"},
+			err:         errInvalidServiceAccountAnnotation,
+		},
+	}
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			assert.True(t, errors.Is(validateServiceAccountAnnotations(tt.annotations), tt.err))
+		})
+	}
+}
+
+func TestCheckCRDVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed string
+		wanted    string
+		err       error
+	}{
+		{
+			name:      "upgrade",
+			installed: "0.1.0",
+			wanted:    "0.2.0",
+		},
+		{
+			name:      "same version",
+			installed: "0.2.0",
+			wanted:    "0.2.0",
+		},
+		{
+			name:      "downgrade",
+			installed: "0.2.0",
+			wanted:    "0.1.0",
+			err:       errCRDDowngrade,
+		},
+		{
+			name:      "unknown installed version",
+			installed: "",
+			wanted:    "0.2.0",
+			err:       errUnknownCRDVersion,
+		},
+		{
+			name:      "unknown wanted version",
+			installed: "0.2.0",
+			wanted:    "unknown",
+			err:       errUnknownCRDVersion,
+		},
+	}
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			assert.True(t, errors.Is(checkCRDVersion(tt.installed, tt.wanted), tt.err))
+		})
+	}
+}
+
 func TestInstallWait(t *testing.T) {
 	tests := []struct {
 		name string
@@ -177,13 +551,55 @@ func TestInstallDryRun(t *testing.T) {
 			Factory: &cmdutil.Factory{
 				IOStreams: cmdutil.IOStreams{Out: out},
 			},
-			dryRun: true,
-			local:  true,
+			dryRun:  true,
+			local:   true,
+			webhook: true,
+		}
+		require.NoError(t, opts.install(context.Background()))
+
+		docs := strings.Split(out.String(), "---\n")
+		assert.Equal(t, 17, len(docs))
+	})
+
+	testutil.Run(t, "custom namespace", func(t *testutil.T) {
+		t.Chdir("../../")
+
+		out := new(bytes.Buffer)
+		opts := &installOptions{
+			Factory: &cmdutil.Factory{
+				IOStreams: cmdutil.IOStreams{Out: out},
+			},
+			namespace: "etok-system",
+			dryRun:    true,
+			local:     true,
+			webhook:   true,
 		}
 		require.NoError(t, opts.install(context.Background()))
 
 		docs := strings.Split(out.String(), "---\n")
-		assert.Equal(t, 11, len(docs))
+		assert.Equal(t, 17, len(docs))
+
+		assert.Contains(t, out.String(), "name: etok-system\n")
+		assert.NotContains(t, out.String(), "namespace: etok\n")
+	})
+
+	testutil.Run(t, "with metrics service", func(t *testutil.T) {
+		t.Chdir("../../")
+
+		out := new(bytes.Buffer)
+		opts := &installOptions{
+			Factory: &cmdutil.Factory{
+				IOStreams: cmdutil.IOStreams{Out: out},
+			},
+			metricsServiceType: "NodePort",
+			dryRun:             true,
+			local:              true,
+			webhook:            true,
+		}
+		require.NoError(t, opts.install(context.Background()))
+
+		docs := strings.Split(out.String(), "---\n")
+		assert.Equal(t, 18, len(docs))
 	})
 }
 
@@ -202,17 +618,41 @@ func wantedCRDs() (resources []runtimeclient.Object) {
 	return
 }
 
-func wantedResources() (resources []runtimeclient.Object) {
-	resources = append(resources, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "etok"}})
-	resources = append(resources, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "etok", Name: "etok"}})
-	resources = append(resources, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "etok", Name: "etok"}})
-	resources = append(resources, &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "etok"}})
+func installedCRDs(version string) (resources []runtimeclient.Object) {
+	for _, crd := range wantedCRDs() {
+		crd.SetLabels(map[string]string{"version": version})
+		resources = append(resources, crd)
+	}
+	return
+}
+
+func wantedResources(namespace string, watchNamespaces []string, webhook bool) (resources []runtimeclient.Object) {
+	resources = append(resources, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})
+	resources = append(resources, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "etok"}})
+	resources = append(resources, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "etok"}})
+	if len(watchNamespaces) > 0 {
+		// Operator is granted permissions only within watched namespaces
+		for _, ns := range watchNamespaces {
+			resources = append(resources, &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "etok-operator"}})
+			resources = append(resources, &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "etok-operator"}})
+		}
+	} else {
+		resources = append(resources, &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "etok"}})
+		resources = append(resources, &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "etok"}})
+	}
 	resources = append(resources, &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "etok-user"}})
 	resources = append(resources, &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "etok-admin"}})
-	resources = append(resources, &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "etok"}})
 	resources = append(resources, &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "etok-user"}})
 	resources = append(resources, &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "etok-admin"}})
-	resources = append(resources, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "etok", Name: "etok"}})
+	resources = append(resources, &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "etok-readonly"}})
+	resources = append(resources, &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "etok-readonly"}})
+	resources = append(resources, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "etok"}})
+	if webhook {
+		resources = append(resources, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "etok-webhook"}})
+		resources = append(resources, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "etok-webhook-cert"}})
+		resources = append(resources, &admissionregistrationv1.ValidatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "etok"}})
+		resources = append(resources, &admissionregistrationv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "etok"}})
+	}
 	return
 }
 
@@ -1,9 +1,16 @@
 package install
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/leg100/etok/cmd/manager"
+	"github.com/leg100/etok/pkg/labels"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 func namespace(namespace string) *corev1.Namespace {
@@ -18,6 +25,104 @@ func namespace(namespace string) *corev1.Namespace {
 	}
 }
 
+var (
+	errInvalidServiceAccountAnnotation = errors.New("invalid service account annotation")
+
+	// Expected formats of the values of known IAM annotations
+	iamAnnotationFormats = map[string]struct {
+		re          *regexp.Regexp
+		description string
+	}{
+		// GKE workload identity
+		"iam.gke.io/gcp-service-account": {
+			re:          regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]@[a-z0-9.:-]+\.iam\.gserviceaccount\.com$`),
+			description: "[GSA_NAME]@[PROJECT_NAME].iam.gserviceaccount.com",
+		},
+		// EKS IAM roles for service accounts
+		"eks.amazonaws.com/role-arn": {
+			re:          regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:role/[\w+=,.@/-]+$`),
+			description: "arn:aws:iam::[ACCOUNT_ID]:role/[ROLE_NAME]",
+		},
+	}
+)
+
+// validateServiceAccountAnnotations checks the values of known IAM annotations
+// are correctly formatted. A malformed value would otherwise only surface once
+// a pod fails to authenticate.
+func validateServiceAccountAnnotations(annotations map[string]string) error {
+	for k, v := range annotations {
+		format, ok := iamAnnotationFormats[k]
+		if !ok {
+			continue
+		}
+		if !format.re.MatchString(v) {
+			return fmt.Errorf("%w: %s=%s: value must be in the format %s", errInvalidServiceAccountAnnotation, k, v, format.description)
+		}
+	}
+	return nil
+}
+
+var (
+	errInvalidServiceType = errors.New("invalid service type")
+
+	serviceTypes = []corev1.ServiceType{
+		corev1.ServiceTypeClusterIP,
+		corev1.ServiceTypeNodePort,
+		corev1.ServiceTypeLoadBalancer,
+	}
+)
+
+// validateServiceType checks the service type is one that can expose the
+// metrics endpoint. An empty type is valid and means no service is created.
+func validateServiceType(serviceType string) error {
+	if serviceType == "" {
+		return nil
+	}
+	for _, t := range serviceTypes {
+		if serviceType == string(t) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s: must be one of %v", errInvalidServiceType, serviceType, serviceTypes)
+}
+
+// validateLogFormat checks the operator supports the log format. An empty
+// format is valid and means the operator's default is used.
+func validateLogFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	return manager.ValidateLogFormat(format)
+}
+
+// metricsService exposes the operator's metrics endpoint
+func metricsService(namespace string, serviceType corev1.ServiceType) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etok-metrics",
+			Namespace: namespace,
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: corev1.SchemeGroupVersion.String(),
+		},
+		Spec: corev1.ServiceSpec{
+			Type: serviceType,
+			Selector: labels.MakeLabels(
+				labels.App,
+				labels.OperatorComponent,
+			),
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "metrics",
+					Port:       metricsPort,
+					TargetPort: intstr.FromInt(metricsPort),
+				},
+			},
+		},
+	}
+}
+
 func serviceAccount(namespace string, annotations map[string]string) *corev1.ServiceAccount {
 	return &corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
@@ -56,6 +161,108 @@ func operatorClusterRoleBinding(namespace string) *rbacv1.ClusterRoleBinding {
 	}
 }
 
+// operatorRole grants the operator the rules of its cluster role within a
+// namespace it watches
+func operatorRole(namespace string, rules []rbacv1.PolicyRule) *rbacv1.Role {
+	return &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etok-operator",
+			Namespace: namespace,
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Role",
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+		},
+		Rules: rules,
+	}
+}
+
+// operatorRoleBinding binds the operator's service account, in the namespace
+// in which the operator is installed, to its role in a namespace it watches
+func operatorRoleBinding(namespace, operatorNamespace string) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etok-operator",
+			Namespace: namespace,
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "RoleBinding",
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Namespace: operatorNamespace,
+				Name:      "etok",
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind:     "Role",
+			Name:     "etok-operator",
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	}
+}
+
+// leaderElectionRole permits the operator to elect a leader, using both config
+// maps and leases as locks, within its namespace
+func leaderElectionRole(namespace string) *rbacv1.Role {
+	verbs := []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+
+	return &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etok-leader-election",
+			Namespace: namespace,
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Role",
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				Verbs:     verbs,
+			},
+			{
+				APIGroups: []string{"coordination.k8s.io"},
+				Resources: []string{"leases"},
+				Verbs:     verbs,
+			},
+			{
+				APIGroups: []string{""},
+				Resources: []string{"events"},
+				Verbs:     []string{"create", "patch"},
+			},
+		},
+	}
+}
+
+func leaderElectionRoleBinding(namespace string) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etok-leader-election",
+			Namespace: namespace,
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "RoleBinding",
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Namespace: namespace,
+				Name:      "etok",
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind:     "Role",
+			Name:     "etok-leader-election",
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	}
+}
+
 func userClusterRoleBinding() *rbacv1.ClusterRoleBinding {
 	return &rbacv1.ClusterRoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
@@ -90,6 +297,37 @@ func adminClusterRoleBinding() *rbacv1.ClusterRoleBinding {
 	}
 }
 
+// readonlyClusterRoleBinding binds the read-only cluster role to a group. The
+// binding has no subjects if group is empty.
+func readonlyClusterRoleBinding(group string) *rbacv1.ClusterRoleBinding {
+	binding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "etok-readonly",
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ClusterRoleBinding",
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind:     "ClusterRole",
+			Name:     "etok-readonly",
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	}
+
+	if group != "" {
+		binding.Subjects = []rbacv1.Subject{
+			{
+				Kind:     "Group",
+				Name:     group,
+				APIGroup: "rbac.authorization.k8s.io",
+			},
+		}
+	}
+
+	return binding
+}
+
 func secret(namespace string, key []byte) *corev1.Secret {
 	secret := &corev1.Secret{
 		TypeMeta: metav1.TypeMeta{
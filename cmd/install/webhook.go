@@ -0,0 +1,313 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/controllers"
+	"github.com/leg100/etok/pkg/labels"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	webhookServiceName    = "etok-webhook"
+	webhookCertSecretName = "etok-webhook-cert"
+	// Name of both the validating and mutating webhook configurations
+	webhookConfigurationName = "etok"
+
+	// Validity of the webhook's self-signed certificates
+	webhookCertValidity = 10 * 365 * 24 * time.Hour
+)
+
+// webhookCerts is the PEM-encoded certificate authority, together with the
+// serving certificate and key it has signed.
+type webhookCerts struct {
+	caCert []byte
+	cert   []byte
+	key    []byte
+}
+
+// webhookCerts retrieves the webhook's existing certificates, so that
+// re-installing etok doesn't needlessly rotate them. Otherwise new
+// certificates are generated.
+func (o *installOptions) webhookCerts(ctx context.Context) (*webhookCerts, error) {
+	if !o.dryRun {
+		var secret corev1.Secret
+		err := o.RuntimeClient.Get(ctx, types.NamespacedName{Namespace: o.namespace, Name: webhookCertSecretName}, &secret)
+		switch {
+		case kerrors.IsNotFound(err):
+		case err != nil:
+			return nil, err
+		default:
+			certs := &webhookCerts{
+				caCert: secret.Data["ca.crt"],
+				cert:   secret.Data[corev1.TLSCertKey],
+				key:    secret.Data[corev1.TLSPrivateKeyKey],
+			}
+			if certs.valid(webhookServiceHost(o.namespace)) {
+				return certs, nil
+			}
+		}
+	}
+	return generateWebhookCerts(webhookServiceHost(o.namespace))
+}
+
+// valid determines whether the certificates are present, unexpired, and issued
+// for the given host
+func (c *webhookCerts) valid(host string) bool {
+	if len(c.caCert) == 0 || len(c.key) == 0 {
+		return false
+	}
+	block, _ := pem.Decode(c.cert)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	if time.Now().After(cert.NotAfter) {
+		return false
+	}
+	return cert.VerifyHostname(host) == nil
+}
+
+// generateWebhookCerts generates a self-signed certificate authority, and a
+// serving certificate for the given host signed by the authority.
+func generateWebhookCerts(host string) (*webhookCerts, error) {
+	now := time.Now()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "etok-webhook-ca"},
+		NotBefore:             now,
+		NotAfter:              now.Add(webhookCertValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create webhook CA certificate: %w", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    now,
+		NotAfter:     now.Add(webhookCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create webhook serving certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhookCerts{
+		caCert: encodePEM("CERTIFICATE", caDER),
+		cert:   encodePEM("CERTIFICATE", der),
+		key:    encodePEM("EC PRIVATE KEY", keyDER),
+	}, nil
+}
+
+func encodePEM(blockType string, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	pem.Encode(buf, &pem.Block{Type: blockType, Bytes: data})
+	return buf.Bytes()
+}
+
+// webhookServiceHost is the host name of the webhook service, for which the
+// serving certificate is issued
+func webhookServiceHost(namespace string) string {
+	return fmt.Sprintf("%s.%s.svc", webhookServiceName, namespace)
+}
+
+// webhookService exposes the operator's webhook server to the API server
+func webhookService(namespace string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      webhookServiceName,
+			Namespace: namespace,
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: corev1.SchemeGroupVersion.String(),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: labels.MakeLabels(
+				labels.App,
+				labels.OperatorComponent,
+			),
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "webhook",
+					Port:       443,
+					TargetPort: intstr.FromInt(webhookPort),
+				},
+			},
+		},
+	}
+}
+
+// webhookCertSecret holds the webhook's serving certificate and key, mounted
+// into the operator pod
+func webhookCertSecret(namespace string, certs *webhookCerts) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: corev1.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      webhookCertSecretName,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certs.cert,
+			corev1.TLSPrivateKeyKey: certs.key,
+			"ca.crt":                certs.caCert,
+		},
+	}
+}
+
+// validatingWebhookConfiguration registers the operator's workspace validation
+// webhook with the API server
+func validatingWebhookConfiguration(namespace string, caBundle []byte) *admissionregistrationv1.ValidatingWebhookConfiguration {
+	path := controllers.WorkspaceValidationPath
+	failurePolicy := admissionregistrationv1.Fail
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ValidatingWebhookConfiguration",
+			APIVersion: admissionregistrationv1.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: webhookConfigurationName,
+		},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{
+				Name: "workspaces.etok.dev",
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{
+					Service: &admissionregistrationv1.ServiceReference{
+						Namespace: namespace,
+						Name:      webhookServiceName,
+						Path:      &path,
+					},
+					CABundle: caBundle,
+				},
+				Rules: []admissionregistrationv1.RuleWithOperations{
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Create,
+							admissionregistrationv1.Update,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{v1alpha1.SchemeGroupVersion.Group},
+							APIVersions: []string{v1alpha1.SchemeGroupVersion.Version},
+							Resources:   []string{"workspaces"},
+						},
+					},
+				},
+				FailurePolicy:           &failurePolicy,
+				SideEffects:             &sideEffects,
+				AdmissionReviewVersions: []string{"v1"},
+			},
+		},
+	}
+}
+
+// mutatingWebhookConfiguration registers the operator's webhook recording the
+// user that created a run with the API server
+func mutatingWebhookConfiguration(namespace string, caBundle []byte) *admissionregistrationv1.MutatingWebhookConfiguration {
+	path := controllers.RunUserPath
+	failurePolicy := admissionregistrationv1.Fail
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "MutatingWebhookConfiguration",
+			APIVersion: admissionregistrationv1.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: webhookConfigurationName,
+		},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{
+				Name: "runs.etok.dev",
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{
+					Service: &admissionregistrationv1.ServiceReference{
+						Namespace: namespace,
+						Name:      webhookServiceName,
+						Path:      &path,
+					},
+					CABundle: caBundle,
+				},
+				Rules: []admissionregistrationv1.RuleWithOperations{
+					{
+						Operations: []admissionregistrationv1.OperationType{
+							admissionregistrationv1.Create,
+							admissionregistrationv1.Update,
+						},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{v1alpha1.SchemeGroupVersion.Group},
+							APIVersions: []string{v1alpha1.SchemeGroupVersion.Version},
+							Resources:   []string{"runs"},
+						},
+					},
+				},
+				FailurePolicy:           &failurePolicy,
+				SideEffects:             &sideEffects,
+				AdmissionReviewVersions: []string{"v1"},
+			},
+		},
+	}
+}
+
+// deleteWebhookConfigurations deletes the operator's webhook configurations,
+// should they exist
+func (o *installOptions) deleteWebhookConfigurations(ctx context.Context) error {
+	for _, config := range []runtimeclient.Object{
+		&admissionregistrationv1.ValidatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: webhookConfigurationName}},
+		&admissionregistrationv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: webhookConfigurationName}},
+	} {
+		if err := o.RuntimeClient.Delete(ctx, config); runtimeclient.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("unable to delete webhook configuration: %w", err)
+		}
+	}
+	return nil
+}
@@ -44,8 +44,6 @@ func AddToRoot(root *cobra.Command, f *cmdutil.Factory) {
 	for _, stateSubCmd := range []string{
 		"list",
 		"mv",
-		"pull",
-		"push",
 		"replace-provider",
 		"rm",
 		"show",
@@ -53,6 +51,20 @@ func AddToRoot(root *cobra.Command, f *cmdutil.Factory) {
 		state.AddCommand(launcherCommand(f, &launcherOptions{command: "state " + stateSubCmd}))
 	}
 
+	// Terraform state pull command, optionally writing state to a local file
+	pullOpts := &launcherOptions{command: "state pull"}
+	pull := launcherCommand(f, pullOpts)
+	pull.Flags().StringVarP(&pullOpts.outputFile, "output", "o", "", "Write state to file rather than stdout")
+	state.AddCommand(pull)
+
+	// Terraform state push command, uploading a local state file
+	push := launcherCommand(f, &launcherOptions{command: "state push"})
+	push.Use = "push [flags] -- [push args] <path>"
+	state.AddCommand(push)
+
+	// Restore state from a versioned backup
+	state.AddCommand(stateRestoreCmd(f))
+
 	// Shell command
 	shell := launcherCommand(f, &launcherOptions{command: "sh"})
 	shell.Short = "Run shell session in workspace"
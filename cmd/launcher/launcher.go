@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/fatih/color"
 	"github.com/leg100/etok/api/etok.dev/v1alpha1"
 	"github.com/leg100/etok/cmd/flags"
 	cmdutil "github.com/leg100/etok/cmd/util"
@@ -25,9 +26,11 @@ import (
 	"github.com/leg100/etok/pkg/logstreamer"
 	"github.com/leg100/etok/pkg/monitors"
 	"github.com/leg100/etok/pkg/util"
+	"github.com/leg100/etok/pkg/util/slice"
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
 
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -51,6 +54,16 @@ var (
 	errWorkspaceNotFound = errors.New("workspace not found")
 	errWorkspaceNotReady = errors.New("workspace not ready")
 	errReconcileTimeout  = errors.New("timed out waiting for run to be reconciled")
+	errStatePushPath     = errors.New("invalid state file path")
+	errDestroyApproval   = errors.New("destroy requires approval: either run with a TTY or pass --auto-approve")
+	errInvalidPlanRun    = errors.New("invalid plan run")
+	errUsePlanTargets    = errors.New("--target cannot be used with --use-plan: resources are targeted when planning")
+	errUsePlanEphemeral  = errors.New("--use-plan cannot be used with a workspace with an ephemeral cache: plans are not retained")
+	errInvalidLockRun    = errors.New("invalid lock run")
+	errReservedEnvVar    = errors.New("environment variables prefixed with " + v1alpha1.ReservedEnvironmentVariablePrefix + " are reserved")
+
+	// Commands that accept the --target flag
+	targetCommands = []string{"plan", "apply", "destroy"}
 )
 
 // launcherOptions deploys a new Run. It monitors not only its progress, but
@@ -89,10 +102,47 @@ type launcherOptions struct {
 	podTimeout time.Duration
 	// Timeout for resource to be reconciled (at least once)
 	reconcileTimeout time.Duration
+	// Timeout for run pod to finish running, after which it is killed
+	runTimeout time.Duration
 
 	// Disable TTY detection
 	disableTTY bool
 
+	// Prefix each line of logs with a timestamp
+	timestamps bool
+
+	// Disable colorized output
+	noColor bool
+
+	// Write logs to file rather than stdout
+	outputFile string
+
+	// Skip interactive approval (destroy only)
+	autoApprove bool
+
+	// Return exit code 2 if plan contains changes (plan only)
+	detailedExitCode bool
+
+	// Terraform workspace to select prior to running command
+	tfWorkspace string
+
+	// Resource addresses to target (plan, apply and destroy only)
+	targets []string
+
+	// Name of plan run whose saved plan is to be applied (apply only)
+	usePlan string
+
+	// Environment variables to set on the run's pod
+	environmentVariables map[string]string
+
+	// Print raw value of a single output (output only)
+	rawOutput string
+
+	// Print the plan in JSON, separately from the logs (plan only)
+	jsonPlan bool
+	// Write the plan in JSON to a file rather than stdout (plan only)
+	jsonFile string
+
 	// Recall if resources are created so that if error occurs they can be cleaned up
 	createdRun     bool
 	createdArchive bool
@@ -125,6 +175,17 @@ func launcherCommand(f *cmdutil.Factory, o *launcherOptions) *cobra.Command {
 				return err
 			}
 
+			// Unless specified, disable color when output isn't to a terminal
+			if !flags.IsFlagPassed(cmd.Flags(), "no-color") {
+				o.noColor = !term.IsTerminal(o.Out)
+			}
+
+			if o.command == "state push" {
+				if err := o.resolveStatePushPath(); err != nil {
+					return err
+				}
+			}
+
 			err = o.run(cmd.Context())
 			if err != nil {
 				// Cleanup resources upon error. An exit code error means the
@@ -149,10 +210,41 @@ func launcherCommand(f *cmdutil.Factory, o *launcherOptions) *cobra.Command {
 	flags.AddDisableResourceCleanupFlag(cmd, &o.disableResourceCleanup)
 
 	cmd.Flags().BoolVar(&o.disableTTY, "no-tty", false, "disable tty")
+	cmd.Flags().BoolVar(&o.noColor, "no-color", false, "Disable colorized output (default true if stdout is not a terminal)")
+	cmd.Flags().BoolVar(&o.timestamps, "timestamps", false, "Prefix each line of output with a timestamp (disables tty)")
 	cmd.Flags().DurationVar(&o.podTimeout, "pod-timeout", time.Hour, "timeout for pod to be ready and running")
 	cmd.Flags().DurationVar(&o.handshakeTimeout, "handshake-timeout", v1alpha1.DefaultHandshakeTimeout, "timeout waiting for handshake")
 
 	cmd.Flags().DurationVar(&o.reconcileTimeout, "reconcile-timeout", defaultReconcileTimeout, "timeout for resource to be reconciled")
+	cmd.Flags().DurationVar(&o.runTimeout, "run-timeout", 0, "timeout for run pod to finish running, after which the pod is killed and the run fails (default no timeout)")
+
+	cmd.Flags().StringToStringVar(&o.environmentVariables, "environment-variables", map[string]string{}, "Set environment variables for this run only (overrides workspace environment variables)")
+
+	if o.command != "init" {
+		cmd.Flags().StringVar(&o.tfWorkspace, "tf-workspace", "", "Select terraform workspace before running command, creating it if it doesn't exist")
+	}
+
+	if o.command == "destroy" {
+		cmd.Flags().BoolVar(&o.autoApprove, "auto-approve", false, "Skip interactive approval before destroying")
+	}
+
+	if slice.ContainsString(targetCommands, o.command) {
+		cmd.Flags().StringArrayVar(&o.targets, "target", []string{}, "Limit the operation to the resource address and its dependencies (repeatable)")
+	}
+
+	if o.command == "apply" {
+		cmd.Flags().StringVar(&o.usePlan, "use-plan", "", "Apply the plan saved by this plan run, rather than planning afresh")
+	}
+
+	if o.command == "output" {
+		cmd.Flags().StringVar(&o.rawOutput, "raw", "", "Print the raw value of the named output, for use in scripts")
+	}
+
+	if o.command == "plan" {
+		cmd.Flags().BoolVar(&o.detailedExitCode, "detailed-exitcode", false, "Return exit code 0 if plan has no changes, 1 if plan failed, or 2 if plan has changes")
+		cmd.Flags().BoolVar(&o.jsonPlan, "json", false, "Print the plan in JSON to stdout, and the logs to stderr")
+		cmd.Flags().StringVar(&o.jsonFile, "json-file", "", "Write the plan in JSON to this file (implies --json, with the logs printed to stdout)")
+	}
 
 	return cmd
 }
@@ -175,8 +267,121 @@ func (o *launcherOptions) lookupEnvFile(cmd *cobra.Command) error {
 	return nil
 }
 
+// resolveStatePushPath checks the state file to be pushed exists and is
+// within the root module, which is uploaded along with the state file, and
+// rewrites its path relative to the root module, which is the working directory
+// of the run.
+func (o *launcherOptions) resolveStatePushPath() error {
+	if len(o.args) == 0 {
+		return fmt.Errorf("%w: no path specified", errStatePushPath)
+	}
+	path := o.args[len(o.args)-1]
+	if path == "-" {
+		return fmt.Errorf("%w: reading state from stdin is unsupported", errStatePushPath)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("%w: %s", errStatePushPath, err.Error())
+	}
+
+	root, err := filepath.Abs(o.path)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: %s: must be within %s", errStatePushPath, path, root)
+	}
+
+	o.args[len(o.args)-1] = rel
+	return nil
+}
+
 func (o *launcherOptions) run(ctx context.Context) error {
-	isTTY := !o.disableTTY && term.IsTerminal(o.In)
+	// Output is written to a file rather than a TTY
+	if o.jsonFile != "" {
+		o.jsonPlan = true
+	}
+
+	isTTY := !o.disableTTY && !o.timestamps && o.outputFile == "" && o.rawOutput == "" && !o.jsonPlan && term.IsTerminal(o.In)
+
+	if o.command == "destroy" {
+		if o.autoApprove {
+			o.args = append(o.args, "-auto-approve")
+		} else if !isTTY {
+			// Terraform prompts for approval after showing what it intends
+			// to destroy, which is only possible with a TTY
+			return errDestroyApproval
+		}
+	}
+
+	if o.rawOutput != "" {
+		// Retrieve all outputs in JSON, from which the named output is parsed
+		o.args = append(o.args, "-json")
+	}
+
+	if o.detailedExitCode {
+		// The run's exit code is passed through faithfully, so there is
+		// nothing further to do other than to pass the flag to terraform
+		o.args = append(o.args, "-detailed-exitcode")
+	}
+
+	for name := range o.environmentVariables {
+		if v1alpha1.IsReservedEnvironmentVariable(name) {
+			return fmt.Errorf("%w: %s", errReservedEnvVar, name)
+		}
+	}
+
+	if o.usePlan != "" {
+		if len(o.targets) > 0 {
+			return errUsePlanTargets
+		}
+		if err := o.checkPlanRun(ctx); err != nil {
+			return err
+		}
+	}
+
+	if o.command == "force-unlock" {
+		if err := o.resolveLockID(ctx); err != nil {
+			return err
+		}
+	}
+
+	if len(o.targets) > 0 {
+		// Print to stderr so as not to mix with the command's output
+		fmt.Fprintln(o.ErrOut, "Warning: targeting resources can leave state inconsistent with the configuration; use only in exceptional circumstances")
+		for _, t := range o.targets {
+			o.args = append(o.args, "-target="+t)
+		}
+	}
+
+	if o.noColor {
+		// Disable etok's own colorized output
+		color.NoColor = true
+
+		if slice.ContainsString(noColorCommands, o.command) {
+			o.args = append([]string{"-no-color"}, o.args...)
+		}
+	}
+
+	// Reject privileged command before deploying anything if user is not
+	// permitted to approve it
+	if err := o.checkPrivileged(ctx); err != nil {
+		return err
+	}
+
+	// Fail fast rather than waiting for reconcile to time out if the operator
+	// is missing
+	if err := cmdutil.CheckOperator(ctx, o.KubeClient, o.ErrOut); err != nil {
+		return err
+	}
 
 	// Tar up local config and deploy k8s resources
 	run, err := o.deploy(ctx, isTTY)
@@ -217,15 +422,61 @@ func (o *launcherOptions) run(ctx context.Context) error {
 	// Watch the run for the container's exit code. Non-blocking.
 	exit := monitors.RunExitMonitor(ctx, o.EtokClient, o.namespace, o.runName)
 
+	// Outputs captured for parsing
+	var rawOutputs bytes.Buffer
+
 	// Connect to pod
 	if isTTY {
 		if err := o.AttachFunc(o.Out, *o.Config, o.namespace, o.runName, o.In.(*os.File), cmdutil.HandshakeString, globals.RunnerContainerName); err != nil {
 			return err
 		}
 	} else {
-		if err := logstreamer.Stream(ctx, o.GetLogsFunc, o.Out, o.PodsClient(o.namespace), o.runName, globals.RunnerContainerName); err != nil {
+		out := o.Out
+		if o.rawOutput != "" {
+			// Capture outputs so they can be parsed
+			out = &rawOutputs
+		} else if o.outputFile != "" {
+			f, err := os.Create(o.outputFile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+
+		// Divert the plan in JSON from the logs
+		var splitter *logstreamer.Splitter
+		if o.jsonPlan {
+			jsonOut := o.Out
+			if o.jsonFile != "" {
+				f, err := os.Create(o.jsonFile)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				jsonOut = f
+			} else if out == o.Out {
+				// Reserve stdout for the JSON
+				out = o.ErrOut
+			}
+			splitter = logstreamer.NewSplitter(out, jsonOut, logstreamer.JSONPlanSection)
+			out = splitter
+		}
+
+		var streamOpts []logstreamer.StreamOption
+		// Timestamps would corrupt output destined for parsing
+		if o.timestamps && o.rawOutput == "" && !o.jsonPlan {
+			streamOpts = append(streamOpts, logstreamer.WithTimestamps())
+		}
+		if err := logstreamer.Stream(ctx, o.GetLogsFunc, out, o.PodsClient(o.namespace), o.runName, globals.RunnerContainerName, streamOpts...); err != nil {
 			return err
 		}
+
+		if splitter != nil {
+			if err := splitter.Flush(); err != nil {
+				return err
+			}
+		}
 	}
 
 	// Await container's exit code
@@ -238,6 +489,10 @@ func (o *launcherOptions) run(ctx context.Context) error {
 		}
 	}
 
+	if o.rawOutput != "" {
+		return printRawOutput(o.Out, rawOutputs.Bytes(), o.rawOutput)
+	}
+
 	if UpdatesLockFile(o.command) {
 		// Some commands (e.g. terraform init) update the lock file,
 		// .terraform.lock.hcl, and it's recommended that this be committed to
@@ -301,6 +556,11 @@ func (o *launcherOptions) checkWorkspace(ctx context.Context, run *v1alpha1.Run)
 		}
 	}
 
+	// ...inform user that run awaits approval from someone else
+	if ws.RequiresApproval(o.command) {
+		fmt.Fprintf(o.Out, "Run %s requires approval: waiting for 'etok approve %s'\n", run.Name, run.Name)
+	}
+
 	return nil
 }
 
@@ -357,6 +617,42 @@ func (o *launcherOptions) cleanup() {
 	}
 }
 
+// checkPrivileged checks whether the user is permitted to run the command. A
+// privileged command requires the user to approve the run by updating the
+// workspace, so the user must be permitted to update the workspace.
+func (o *launcherOptions) checkPrivileged(ctx context.Context) error {
+	ws, err := o.WorkspacesClient(o.namespace).Get(ctx, o.workspace, metav1.GetOptions{})
+	if err != nil {
+		// Leave it to checkWorkspace to report missing workspace
+		return nil
+	}
+
+	if !ws.IsPrivilegedCommand(o.command) {
+		return nil
+	}
+
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: o.namespace,
+				Verb:      "update",
+				Group:     v1alpha1.SchemeGroupVersion.Group,
+				Resource:  "workspaces",
+				Name:      o.workspace,
+			},
+		},
+	}
+	review, err = o.KubeClient.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to check permission to run privileged command: %w", err)
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("%w: %s is a privileged command on workspace %s/%s and requires permission to update the workspace", errNotAuthorised, o.command, o.namespace, o.workspace)
+	}
+
+	return nil
+}
+
 func (o *launcherOptions) approveRun(ctx context.Context, ws *v1alpha1.Workspace, run *v1alpha1.Run) error {
 	klog.V(1).Infof("%s is a privileged command on workspace\n", o.command)
 	annotations := ws.GetAnnotations()
@@ -379,6 +675,68 @@ func (o *launcherOptions) approveRun(ctx context.Context, ws *v1alpha1.Workspace
 	return nil
 }
 
+// checkPlanRun checks the run whose saved plan is to be applied is a plan run
+// on the same workspace and terraform workspace, and that the workspace retains
+// plans
+func (o *launcherOptions) checkPlanRun(ctx context.Context) error {
+	// Leave it to checkWorkspace to report missing workspace
+	if ws, err := o.WorkspacesClient(o.namespace).Get(ctx, o.workspace, metav1.GetOptions{}); err == nil && ws.Spec.Cache.Ephemeral {
+		return errUsePlanEphemeral
+	}
+
+	plan, err := o.RunsClient(o.namespace).Get(ctx, o.usePlan, metav1.GetOptions{})
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return fmt.Errorf("%w: %s/%s not found", errInvalidPlanRun, o.namespace, o.usePlan)
+		}
+		return err
+	}
+	if plan.Command != "plan" {
+		return fmt.Errorf("%w: %s is a %s run", errInvalidPlanRun, klog.KObj(plan), plan.Command)
+	}
+	if plan.Workspace != o.workspace {
+		return fmt.Errorf("%w: %s ran on workspace %s", errInvalidPlanRun, klog.KObj(plan), plan.Workspace)
+	}
+	if plan.TFWorkspace != o.tfWorkspace {
+		return fmt.Errorf("%w: %s ran on terraform workspace %q", errInvalidPlanRun, klog.KObj(plan), plan.TFWorkspace)
+	}
+	return nil
+}
+
+// resolveLockID permits a state lock to be released by naming the run that
+// failed to acquire it, substituting the ID of the lock recorded by the run.
+// Otherwise the argument is passed through to terraform as a lock ID.
+func (o *launcherOptions) resolveLockID(ctx context.Context) error {
+	// The lock ID is terraform's last argument
+	if len(o.args) == 0 || strings.HasPrefix(o.args[len(o.args)-1], "-") {
+		return nil
+	}
+	name := o.args[len(o.args)-1]
+
+	run, err := o.RunsClient(o.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			// Not a run, so assume it's a lock ID
+			return nil
+		}
+		return err
+	}
+	if run.LockInfo == nil {
+		return fmt.Errorf("%w: %s did not fail to acquire the state lock", errInvalidLockRun, klog.KObj(run))
+	}
+	if run.Workspace != o.workspace {
+		return fmt.Errorf("%w: %s ran on workspace %s", errInvalidLockRun, klog.KObj(run), run.Workspace)
+	}
+	if run.TFWorkspace != o.tfWorkspace {
+		return fmt.Errorf("%w: %s ran on terraform workspace %q", errInvalidLockRun, klog.KObj(run), run.TFWorkspace)
+	}
+
+	fmt.Fprintf(o.Out, "Releasing state lock %s held by %s since %s\n", run.LockInfo.ID, run.LockInfo.Who, run.LockInfo.Created)
+
+	o.args[len(o.args)-1] = run.LockInfo.ID
+	return nil
+}
+
 func (o *launcherOptions) createRun(ctx context.Context, name, configMapName string, isTTY bool, relPathToRoot string) (*v1alpha1.Run, error) {
 	run := &v1alpha1.Run{}
 	run.SetNamespace(o.namespace)
@@ -397,12 +755,24 @@ func (o *launcherOptions) createRun(ctx context.Context, name, configMapName str
 
 	run.Command = o.command
 	run.Args = o.args
+	run.TFWorkspace = o.tfWorkspace
+	run.Targets = o.targets
+	run.Plan = o.usePlan
+	run.JSONPlan = o.jsonPlan
 	run.ConfigMap = configMapName
 	run.ConfigMapKey = v1alpha1.RunDefaultConfigMapKey
 	run.ConfigMapPath = relPathToRoot
 
 	run.Verbosity = o.Verbosity
 
+	if o.runTimeout > 0 {
+		run.Timeout = o.runTimeout.String()
+	}
+
+	if len(o.environmentVariables) > 0 {
+		run.EnvironmentVariables = o.environmentVariables
+	}
+
 	if o.status != nil {
 		// For testing purposes seed status
 		run.RunStatus = *o.status
@@ -5,12 +5,14 @@ import (
 	"context"
 	"errors"
 	"io"
+	"io/ioutil"
 	"testing"
 
 	"github.com/creack/pty"
 	"github.com/leg100/etok/api/etok.dev/v1alpha1"
 	cmdutil "github.com/leg100/etok/cmd/util"
 	"github.com/leg100/etok/pkg/archive"
+	"github.com/leg100/etok/pkg/client"
 	"github.com/leg100/etok/pkg/env"
 	etokerrors "github.com/leg100/etok/pkg/errors"
 	"github.com/leg100/etok/pkg/handlers"
@@ -19,9 +21,12 @@ import (
 	"github.com/leg100/etok/pkg/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	testclient "k8s.io/client-go/testing"
 )
 
 func TestLauncher(t *testing.T) {
@@ -39,6 +44,8 @@ func TestLauncher(t *testing.T) {
 		size int
 		// Mock exit code of runner container
 		code int32
+		// Write logs to file
+		outputFile string
 		// Override run status
 		overrideStatus   func(*v1alpha1.RunStatus)
 		factoryOverrides func(*cmdutil.Factory)
@@ -94,13 +101,116 @@ func TestLauncher(t *testing.T) {
 		},
 		{
 			name: "arbitrary terraform flag",
-			args: []string{"--", "-input", "false"},
+			args: []string{"--no-color=false", "--", "-input", "false"},
 			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
 			env:  &env.Env{Namespace: "default", Workspace: "default"},
 			assertions: func(o *launcherOptions) {
 				assert.Equal(t, []string{"-input", "false"}, o.args)
 			},
 		},
+		{
+			name: "import",
+			cmd:  "import",
+			args: []string{"--no-color=false", "aws_s3_bucket.foo", "my-bucket"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			env:  &env.Env{Namespace: "default", Workspace: "default"},
+			assertions: func(o *launcherOptions) {
+				run, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, "import", run.Command)
+				assert.Equal(t, []string{"aws_s3_bucket.foo", "my-bucket"}, run.Args)
+			},
+		},
+		{
+			name: "privileged import not authorised",
+			cmd:  "import",
+			args: []string{"aws_s3_bucket.foo", "my-bucket"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"), testobj.WithPrivilegedCommands("import"))},
+			err:  errNotAuthorised,
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("create", "selfsubjectaccessreviews", func(action testclient.Action) (bool, runtime.Object, error) {
+					return true, &authorizationv1.SelfSubjectAccessReview{}, nil
+				})
+			},
+		},
+		{
+			name: "privileged command not authorised",
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"), testobj.WithPrivilegedCommands("plan"))},
+			err:  errNotAuthorised,
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("create", "selfsubjectaccessreviews", func(action testclient.Action) (bool, runtime.Object, error) {
+					return true, &authorizationv1.SelfSubjectAccessReview{}, nil
+				})
+			},
+			assertions: func(o *launcherOptions) {
+				// Run should not have been created
+				_, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				assert.True(t, kerrors.IsNotFound(err))
+			},
+		},
+		{
+			name: "unprivileged command not checked",
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"), testobj.WithPrivilegedCommands("apply"))},
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("create", "selfsubjectaccessreviews", func(action testclient.Action) (bool, runtime.Object, error) {
+					return true, &authorizationv1.SelfSubjectAccessReview{}, nil
+				})
+			},
+		},
+		{
+			name: "operator not installed",
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			err:  cmdutil.ErrOperatorNotReady,
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("list", "deployments", func(action testclient.Action) (bool, runtime.Object, error) {
+					return true, &appsv1.DeploymentList{}, nil
+				})
+			},
+			assertions: func(o *launcherOptions) {
+				// Run should not have been created
+				_, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				assert.True(t, kerrors.IsNotFound(err))
+			},
+		},
+		{
+			name: "operator not ready",
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			err:  cmdutil.ErrOperatorNotReady,
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("list", "deployments", func(action testclient.Action) (bool, runtime.Object, error) {
+					return true, &appsv1.DeploymentList{Items: []appsv1.Deployment{{}}}, nil
+				})
+			},
+		},
+		{
+			name: "operator check skipped when forbidden",
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("list", "deployments", func(action testclient.Action) (bool, runtime.Object, error) {
+					return true, nil, kerrors.NewForbidden(appsv1.Resource("deployments"), "", errors.New("fake forbidden"))
+				})
+				f.ErrOut = new(bytes.Buffer)
+			},
+			assertions: func(o *launcherOptions) {
+				// Ordinary users cannot list deployments, so the user is not
+				// warned
+				assert.Empty(t, o.ErrOut.(*bytes.Buffer).String())
+			},
+		},
+		{
+			name: "operator check skipped when deployments cannot be listed",
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("list", "deployments", func(action testclient.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("fake error")
+				})
+				f.ErrOut = new(bytes.Buffer)
+			},
+			assertions: func(o *launcherOptions) {
+				// User is told the check could not be performed
+				assert.Contains(t, o.ErrOut.(*bytes.Buffer).String(), "Warning: unable to check the etok operator is installed and ready")
+			},
+		},
 		{
 			name: "context flag",
 			args: []string{"--context", "oz-cluster"},
@@ -136,6 +246,310 @@ func TestLauncher(t *testing.T) {
 			name: "workspace does not exist",
 			err:  errWorkspaceNotFound,
 		},
+		{
+			name: "destroy with auto approve",
+			cmd:  "destroy",
+			args: []string{"--auto-approve"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			assertions: func(o *launcherOptions) {
+				// Output is not to a terminal so color is disabled too
+				assert.Equal(t, []string{"-no-color", "-auto-approve"}, o.args)
+			},
+		},
+		{
+			name: "plan with detailed exit code and changes",
+			args: []string{"--detailed-exitcode"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			overrideStatus: func(status *v1alpha1.RunStatus) {
+				// Mock terraform reporting the plan has changes
+				var code = etokerrors.ExitCodePlanChanges
+				status.ExitCode = &code
+			},
+			err: etokerrors.NewExitError(etokerrors.ExitCodePlanChanges),
+			assertions: func(o *launcherOptions) {
+				assert.Equal(t, []string{"-no-color", "-detailed-exitcode"}, o.args)
+			},
+		},
+		{
+			name: "plan with detailed exit code and no changes",
+			args: []string{"--detailed-exitcode", "--no-color=false"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			assertions: func(o *launcherOptions) {
+				assert.Equal(t, []string{"-detailed-exitcode"}, o.args)
+			},
+		},
+		{
+			name: "tf workspace",
+			args: []string{"--tf-workspace", "staging"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			assertions: func(o *launcherOptions) {
+				run, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, "staging", run.TFWorkspace)
+			},
+		},
+		{
+			name: "targets",
+			args: []string{"--target", "module.db", "--target", "aws_instance.web[0]"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.ErrOut = new(bytes.Buffer)
+			},
+			assertions: func(o *launcherOptions) {
+				// Each target passed as a separate arg
+				assert.Equal(t, []string{"-target=module.db", "-target=aws_instance.web[0]"}, o.args[len(o.args)-2:])
+
+				run, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, []string{"module.db", "aws_instance.web[0]"}, run.Targets)
+
+				assert.Contains(t, o.ErrOut.(*bytes.Buffer).String(), "Warning: targeting resources can leave state inconsistent")
+				assert.NotContains(t, o.Out.(*bytes.Buffer).String(), "Warning")
+			},
+		},
+		{
+			name: "use plan",
+			cmd:  "apply",
+			args: []string{"--use-plan", "run-23456"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345")),
+				testobj.Run("default", "run-23456", "plan", testobj.WithWorkspace("default")),
+			},
+			assertions: func(o *launcherOptions) {
+				run, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, "run-23456", run.Plan)
+			},
+		},
+		{
+			name: "use plan not found",
+			cmd:  "apply",
+			args: []string{"--use-plan", "run-23456"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			err:  errInvalidPlanRun,
+		},
+		{
+			name: "use plan that is not a plan",
+			cmd:  "apply",
+			args: []string{"--use-plan", "run-23456"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345")),
+				testobj.Run("default", "run-23456", "apply", testobj.WithWorkspace("default")),
+			},
+			err: errInvalidPlanRun,
+		},
+		{
+			name: "use plan from another workspace",
+			cmd:  "apply",
+			args: []string{"--use-plan", "run-23456"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345")),
+				testobj.Run("default", "run-23456", "plan", testobj.WithWorkspace("other")),
+			},
+			err: errInvalidPlanRun,
+		},
+		{
+			name: "use plan with ephemeral cache",
+			cmd:  "apply",
+			args: []string{"--use-plan", "run-23456"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"), testobj.WithEphemeralCache()),
+				testobj.Run("default", "run-23456", "plan", testobj.WithWorkspace("default")),
+			},
+			err: errUsePlanEphemeral,
+		},
+		{
+			name: "reserved environment variables",
+			args: []string{"--environment-variables", "ETOK_COMMAND=destroy"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			err:  errReservedEnvVar,
+		},
+		{
+			name: "use plan with targets",
+			cmd:  "apply",
+			args: []string{"--use-plan", "run-23456", "--target", "module.db"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			err:  errUsePlanTargets,
+		},
+		{
+			name: "force unlock lock ID",
+			cmd:  "force-unlock",
+			args: []string{"1617191435237187"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			assertions: func(o *launcherOptions) {
+				run, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, []string{"1617191435237187"}, run.Args)
+			},
+		},
+		{
+			name: "force unlock lock recorded by run",
+			cmd:  "force-unlock",
+			args: []string{"run-23456"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345")),
+				testobj.Run("default", "run-23456", "apply", testobj.WithWorkspace("default"), testobj.WithLockInfo("1617191435237187", "alice@laptop")),
+			},
+			assertions: func(o *launcherOptions) {
+				run, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, []string{"1617191435237187"}, run.Args)
+
+				assert.Contains(t, o.Out.(*bytes.Buffer).String(), "Releasing state lock 1617191435237187 held by alice@laptop")
+			},
+		},
+		{
+			name: "force unlock run without lock info",
+			cmd:  "force-unlock",
+			args: []string{"run-23456"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345")),
+				testobj.Run("default", "run-23456", "apply", testobj.WithWorkspace("default")),
+			},
+			err: errInvalidLockRun,
+		},
+		{
+			name: "force unlock lock recorded by run on another workspace",
+			cmd:  "force-unlock",
+			args: []string{"run-23456"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345")),
+				testobj.Run("default", "run-23456", "apply", testobj.WithWorkspace("other"), testobj.WithLockInfo("1617191435237187", "alice@laptop")),
+			},
+			err: errInvalidLockRun,
+		},
+		{
+			name: "run timeout",
+			cmd:  "apply",
+			args: []string{"--run-timeout", "30m"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			assertions: func(o *launcherOptions) {
+				run, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, "30m0s", run.Timeout)
+			},
+		},
+		{
+			name: "apply requiring approval",
+			cmd:  "apply",
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"), testobj.WithRequireApproval())},
+			assertions: func(o *launcherOptions) {
+				assert.Contains(t, o.Out.(*bytes.Buffer).String(), "Run run-12345 requires approval: waiting for 'etok approve run-12345'")
+			},
+		},
+		{
+			name: "environment variables",
+			args: []string{"--environment-variables", "TF_VAR_region=eu-west-2,TF_LOG=DEBUG"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			assertions: func(o *launcherOptions) {
+				run, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, map[string]string{"TF_VAR_region": "eu-west-2", "TF_LOG": "DEBUG"}, run.EnvironmentVariables)
+			},
+		},
+		{
+			name: "raw output",
+			cmd:  "output",
+			args: []string{"--raw", "bucket"},
+			objs: []runtime.Object{testobj.Workspace("default", "default")},
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.GetLogsFunc = func(ctx context.Context, opts logstreamer.Options) (io.ReadCloser, error) {
+					return ioutil.NopCloser(bytes.NewBufferString(`{"bucket":{"sensitive":false,"type":"string","value":"my-bucket"}}`)), nil
+				}
+			},
+			assertions: func(o *launcherOptions) {
+				assert.Equal(t, []string{"-no-color", "-json"}, o.args)
+				assert.Equal(t, "my-bucket\n", o.Out.(*bytes.Buffer).String())
+			},
+		},
+		{
+			name: "raw output not found",
+			cmd:  "output",
+			args: []string{"--raw", "missing"},
+			objs: []runtime.Object{testobj.Workspace("default", "default")},
+			err:  errOutputNotFound,
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.GetLogsFunc = func(ctx context.Context, opts logstreamer.Options) (io.ReadCloser, error) {
+					return ioutil.NopCloser(bytes.NewBufferString(`{"bucket":{"sensitive":false,"type":"string","value":"my-bucket"}}`)), nil
+				}
+			},
+		},
+		{
+			name: "no color when output is not a terminal",
+			args: []string{"--", "-out=plan.out"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			assertions: func(o *launcherOptions) {
+				assert.Equal(t, []string{"-no-color", "-out=plan.out"}, o.args)
+			},
+		},
+		{
+			name: "color explicitly enabled",
+			args: []string{"--no-color=false"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			assertions: func(o *launcherOptions) {
+				assert.Empty(t, o.args)
+			},
+		},
+		{
+			name: "no color ignored for command not accepting it",
+			cmd:  "state list",
+			args: []string{"--no-color"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			assertions: func(o *launcherOptions) {
+				assert.Empty(t, o.args)
+			},
+		},
+		{
+			name: "destroy without approval",
+			cmd:  "destroy",
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			err:  errDestroyApproval,
+			assertions: func(o *launcherOptions) {
+				// No run should have been created
+				_, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				assert.True(t, kerrors.IsNotFound(err))
+			},
+		},
+		{
+			name:       "state pull to file",
+			cmd:        "state pull",
+			outputFile: "state.json",
+			objs:       []runtime.Object{testobj.Workspace("default", "default")},
+			assertions: func(o *launcherOptions) {
+				state, err := ioutil.ReadFile("state.json")
+				require.NoError(t, err)
+				assert.Equal(t, "fake logs", string(state))
+			},
+		},
+		{
+			name: "state push",
+			cmd:  "state push",
+			args: []string{"--", "-force", "test.bin"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			assertions: func(o *launcherOptions) {
+				assert.Equal(t, []string{"-force", "test.bin"}, o.args)
+			},
+		},
+		{
+			name: "state push without path",
+			cmd:  "state push",
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			err:  errStatePushPath,
+		},
+		{
+			name: "state push non-existent file",
+			cmd:  "state push",
+			args: []string{"--", "does-not-exist.tfstate"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			err:  errStatePushPath,
+		},
+		{
+			name: "state push from stdin",
+			cmd:  "state push",
+			args: []string{"--", "-"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			err:  errStatePushPath,
+		},
 		{
 			name: "cleanup resources upon error",
 			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
@@ -230,6 +644,65 @@ func TestLauncher(t *testing.T) {
 				assert.False(t, run.Handshake)
 			},
 		},
+		{
+			name: "timestamps",
+			args: []string{"--timestamps"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			factoryOverrides: func(f *cmdutil.Factory) {
+				// Ensure tty is overridden
+				var err error
+				_, f.In, err = pty.Open()
+				require.NoError(t, err)
+
+				f.GetLogsFunc = func(ctx context.Context, opts logstreamer.Options) (io.ReadCloser, error) {
+					if !opts.PodLogOptions.Timestamps {
+						return nil, errors.New("timestamps not requested")
+					}
+					return ioutil.NopCloser(bytes.NewBufferString("2021-01-01T00:00:00Z fake logs")), nil
+				}
+			},
+			assertions: func(o *launcherOptions) {
+				// With timestamps, launcher should stream logs not attach
+				assert.Equal(t, "2021-01-01T00:00:00Z fake logs", o.Out.(*bytes.Buffer).String())
+			},
+		},
+		{
+			name: "plan in JSON",
+			args: []string{"--json"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.ErrOut = new(bytes.Buffer)
+				f.GetLogsFunc = func(ctx context.Context, opts logstreamer.Options) (io.ReadCloser, error) {
+					return ioutil.NopCloser(bytes.NewBufferString("fake logs\n--- etok:json-plan:begin ---\n{\"format_version\":\"0.1\"}\n--- etok:json-plan:end ---\n")), nil
+				}
+			},
+			assertions: func(o *launcherOptions) {
+				assert.Equal(t, "{\"format_version\":\"0.1\"}\n", o.Out.(*bytes.Buffer).String())
+				assert.Equal(t, "fake logs\n", o.ErrOut.(*bytes.Buffer).String())
+
+				// Get run
+				run, err := o.RunsClient(o.namespace).Get(context.Background(), o.runName, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.True(t, run.JSONPlan)
+			},
+		},
+		{
+			name: "plan in JSON to file",
+			args: []string{"--json-file", "plan.json"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.GetLogsFunc = func(ctx context.Context, opts logstreamer.Options) (io.ReadCloser, error) {
+					return ioutil.NopCloser(bytes.NewBufferString("fake logs\n--- etok:json-plan:begin ---\n{\"format_version\":\"0.1\"}\n--- etok:json-plan:end ---\n")), nil
+				}
+			},
+			assertions: func(o *launcherOptions) {
+				assert.Equal(t, "fake logs\n", o.Out.(*bytes.Buffer).String())
+
+				data, err := ioutil.ReadFile("plan.json")
+				require.NoError(t, err)
+				assert.Equal(t, "{\"format_version\":\"0.1\"}\n", string(data))
+			},
+		},
 		{
 			name: "pod completed with no tty",
 			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithCombinedQueue("run-12345"))},
@@ -314,7 +787,7 @@ func TestLauncher(t *testing.T) {
 				command = tt.cmd
 			}
 
-			opts := &launcherOptions{command: command, runName: "run-12345"}
+			opts := &launcherOptions{command: command, runName: "run-12345", outputFile: tt.outputFile}
 
 			// Mock the workspace controller by setting status up front
 			var code int
@@ -342,7 +815,7 @@ func TestLauncher(t *testing.T) {
 
 			err := cmd.ExecuteContext(context.Background())
 			if !assert.True(t, errors.Is(err, tt.err)) {
-				t.Errorf("unexpected error: %w", err)
+				t.Errorf("unexpected error: %v", err)
 			}
 
 			if tt.assertions != nil {
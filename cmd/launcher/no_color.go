@@ -0,0 +1,15 @@
+package launcher
+
+// Terraform commands that accept the -no-color flag.
+var noColorCommands = []string{
+	"apply",
+	"destroy",
+	"get",
+	"import",
+	"init",
+	"output",
+	"plan",
+	"refresh",
+	"show",
+	"validate",
+}
@@ -0,0 +1,40 @@
+package launcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	errOutputNotFound = errors.New("output not found")
+	errInvalidOutputs = errors.New("unable to parse outputs")
+)
+
+// printRawOutput parses the outputs printed by terraform output -json and
+// prints the value of the named output. A string value is printed without
+// quotes, whereas any other value is printed as JSON, making the value suitable
+// for use in scripts.
+func printRawOutput(out io.Writer, data []byte, name string) error {
+	var outputs map[string]struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &outputs); err != nil {
+		return fmt.Errorf("%w: %s", errInvalidOutputs, err.Error())
+	}
+
+	output, ok := outputs[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", errOutputNotFound, name)
+	}
+
+	var s string
+	if err := json.Unmarshal(output.Value, &s); err == nil {
+		fmt.Fprintln(out, s)
+		return nil
+	}
+
+	fmt.Fprintln(out, string(output.Value))
+	return nil
+}
@@ -0,0 +1,65 @@
+package launcher
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintRawOutput(t *testing.T) {
+	outputs := `{
+  "bucket": {"sensitive": false, "type": "string", "value": "my-bucket"},
+  "count": {"sensitive": false, "type": "number", "value": 3},
+  "zones": {"sensitive": false, "type": ["list", "string"], "value": ["a","b"]}
+}`
+
+	tests := []struct {
+		name   string
+		output string
+		data   string
+		out    string
+		err    error
+	}{
+		{
+			name:   "string",
+			output: "bucket",
+			data:   outputs,
+			out:    "my-bucket\n",
+		},
+		{
+			name:   "number",
+			output: "count",
+			data:   outputs,
+			out:    "3\n",
+		},
+		{
+			name:   "list",
+			output: "zones",
+			data:   outputs,
+			out:    "[\"a\",\"b\"]\n",
+		},
+		{
+			name:   "missing",
+			output: "missing",
+			data:   outputs,
+			err:    errOutputNotFound,
+		},
+		{
+			name:   "invalid json",
+			output: "bucket",
+			data:   "fake logs",
+			err:    errInvalidOutputs,
+		},
+	}
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			out := new(bytes.Buffer)
+			err := printRawOutput(out, []byte(tt.data), tt.output)
+			assert.True(t, errors.Is(err, tt.err))
+			assert.Equal(t, tt.out, out.String())
+		})
+	}
+}
@@ -2,7 +2,9 @@ package launcher
 
 import "github.com/leg100/etok/pkg/util/slice"
 
-// Commands that are enqueued onto a workspace queue.
+// Commands that are enqueued onto a workspace queue. These are the commands
+// that mutate state and must therefore be serialized. All other commands are
+// non-mutating and may run concurrently.
 var queueable = []string{
 	"apply",
 	"destroy",
@@ -0,0 +1,170 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/cmd/flags"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/env"
+	"github.com/leg100/etok/pkg/k8s"
+	"github.com/spf13/cobra"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	watchtools "k8s.io/client-go/tools/watch"
+	"k8s.io/client-go/util/retry"
+)
+
+const defaultRestoreTimeout = 60 * time.Second
+
+var (
+	errNoBackupBucket = errors.New("workspace has no backup bucket")
+	errRestoreFailed  = errors.New("restore failed")
+	errRestoreTimeout = errors.New("timed out waiting for state to be restored")
+	errRestoreVersion = errors.New("--version must be specified")
+	errInvalidVersion = errors.New("invalid version: must be a state serial number")
+)
+
+type stateRestoreOptions struct {
+	*cmdutil.Factory
+
+	*client.Client
+
+	path        string
+	namespace   string
+	workspace   string
+	kubeContext string
+
+	// Serial number of the backup to restore
+	version int
+
+	timeout time.Duration
+}
+
+// stateRestoreCmd restores the state of the workspace from a versioned backup.
+// Backups are only accessible to the operator, so the restore is requested via
+// an annotation on the workspace, which the operator removes once it has
+// attempted the restore.
+func stateRestoreCmd(f *cmdutil.Factory) *cobra.Command {
+	o := &stateRestoreOptions{
+		Factory:   f,
+		namespace: defaultNamespace,
+		version:   -1,
+	}
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore state from a versioned backup",
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if !flags.IsFlagPassed(cmd.Flags(), "version") {
+				return errRestoreVersion
+			}
+			if o.version < 0 {
+				return fmt.Errorf("%w: %d", errInvalidVersion, o.version)
+			}
+
+			etokenv, err := env.Read(o.path)
+			if err != nil {
+				// It's ok for envfile to not exist
+				if !os.IsNotExist(err) {
+					return err
+				}
+			} else {
+				if !flags.IsFlagPassed(cmd.Flags(), "namespace") {
+					o.namespace = etokenv.Namespace
+				}
+				if !flags.IsFlagPassed(cmd.Flags(), "workspace") {
+					o.workspace = etokenv.Workspace
+				}
+			}
+
+			o.Client, err = f.Create(o.kubeContext)
+			if err != nil {
+				return err
+			}
+
+			return o.run(cmd.Context())
+		},
+	}
+
+	flags.AddPathFlag(cmd, &o.path)
+	flags.AddNamespaceFlag(cmd, &o.namespace)
+	flags.AddWorkspaceFlag(cmd, &o.workspace)
+	flags.AddKubeContextFlag(cmd, &o.kubeContext)
+
+	cmd.Flags().IntVar(&o.version, "version", -1, "Serial number of the state to restore")
+	cmd.Flags().DurationVar(&o.timeout, "timeout", defaultRestoreTimeout, "Time to wait for the state to be restored")
+
+	return cmd
+}
+
+func (o *stateRestoreOptions) run(ctx context.Context) error {
+	// Request the restore, retrying upon conflict, i.e. the workspace was
+	// updated by someone else (or the operator) in between getting and
+	// updating it
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ws, err := o.WorkspacesClient(o.namespace).Get(ctx, o.workspace, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if ws.Spec.BackupBucket == "" {
+			return errNoBackupBucket
+		}
+
+		if ws.Annotations == nil {
+			ws.Annotations = make(map[string]string)
+		}
+		ws.Annotations[v1alpha1.RestoreVersionAnnotationKey] = strconv.Itoa(o.version)
+
+		_, err = o.WorkspacesClient(o.namespace).Update(ctx, ws, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return fmt.Errorf("%w: %s/%s", errWorkspaceNotFound, o.namespace, o.workspace)
+		}
+		return err
+	}
+
+	fmt.Fprintf(o.Out, "Restoring state #%d...\n", o.version)
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	lw := &k8s.WorkspaceListWatcher{Client: o.EtokClient, Name: o.workspace, Namespace: o.namespace}
+	_, err = watchtools.UntilWithSync(ctx, lw, &v1alpha1.Workspace{}, nil, func(event watch.Event) (bool, error) {
+		ws, ok := event.Object.(*v1alpha1.Workspace)
+		if !ok {
+			return false, nil
+		}
+
+		if _, ok := ws.Annotations[v1alpha1.RestoreVersionAnnotationKey]; ok {
+			// Restore not yet attempted
+			return false, nil
+		}
+
+		// The operator updates the status before removing the annotation,
+		// so the serial reflects the outcome of the restore
+		if ws.Status.Serial == nil || *ws.Status.Serial != o.version {
+			return false, fmt.Errorf("%w: see events for workspace %s/%s for details", errRestoreFailed, o.namespace, o.workspace)
+		}
+		return true, nil
+	})
+	if err != nil {
+		if errors.Is(err, wait.ErrWaitTimeout) {
+			return errRestoreTimeout
+		}
+		return err
+	}
+
+	fmt.Fprintf(o.Out, "Restored state #%d\n", o.version)
+	return nil
+}
@@ -0,0 +1,121 @@
+package launcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/env"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	testclient "k8s.io/client-go/testing"
+)
+
+func TestStateRestore(t *testing.T) {
+	// mockOperator mocks the operator attempting a restore by removing the
+	// restore annotation as soon as it is set
+	mockOperator := func(f *cmdutil.Factory) {
+		f.ClientCreator.(*client.FakeClientCreator).PrependReactor("update", "workspaces", func(action testclient.Action) (bool, runtime.Object, error) {
+			ws := action.(testclient.UpdateAction).GetObject().(*v1alpha1.Workspace)
+			delete(ws.Annotations, v1alpha1.RestoreVersionAnnotationKey)
+			return false, nil, nil
+		})
+	}
+
+	tests := []struct {
+		name             string
+		args             []string
+		env              *env.Env
+		objs             []runtime.Object
+		factoryOverrides func(*cmdutil.Factory)
+		out              string
+		err              error
+	}{
+		{
+			name:             "restored",
+			args:             []string{"--version", "4"},
+			objs:             []runtime.Object{testobj.Workspace("default", "default", testobj.WithBackupBucket("backup-bucket"), testobj.WithSerial(4))},
+			factoryOverrides: mockOperator,
+			out:              "Restoring state #4...\nRestored state #4\n",
+		},
+		{
+			name:             "restored workspace from environment file",
+			args:             []string{"--version", "4"},
+			env:              &env.Env{Namespace: "dev", Workspace: "networking"},
+			objs:             []runtime.Object{testobj.Workspace("dev", "networking", testobj.WithBackupBucket("backup-bucket"), testobj.WithSerial(4))},
+			factoryOverrides: mockOperator,
+			out:              "Restoring state #4...\nRestored state #4\n",
+		},
+		{
+			name:             "restore failed",
+			args:             []string{"--version", "3"},
+			objs:             []runtime.Object{testobj.Workspace("default", "default", testobj.WithBackupBucket("backup-bucket"), testobj.WithSerial(4))},
+			factoryOverrides: mockOperator,
+			err:              errRestoreFailed,
+		},
+		{
+			name: "restore timed out",
+			args: []string{"--version", "4", "--timeout", "100ms"},
+			objs: []runtime.Object{testobj.Workspace("default", "default", testobj.WithBackupBucket("backup-bucket"))},
+			err:  errRestoreTimeout,
+		},
+		{
+			name: "missing version",
+			err:  errRestoreVersion,
+		},
+		{
+			name: "invalid version",
+			args: []string{"--version", "-2"},
+			err:  errInvalidVersion,
+		},
+		{
+			name: "no backup bucket",
+			args: []string{"--version", "4"},
+			objs: []runtime.Object{testobj.Workspace("default", "default")},
+			err:  errNoBackupBucket,
+		},
+		{
+			name: "workspace not found",
+			args: []string{"--version", "4"},
+			err:  errWorkspaceNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			path := t.NewTempDir().Chdir().Root()
+
+			// Write .terraform/environment
+			if tt.env != nil {
+				require.NoError(t, tt.env.Write(path))
+			}
+
+			out := new(bytes.Buffer)
+			f := cmdutil.NewFakeFactory(out, tt.objs...)
+
+			if tt.factoryOverrides != nil {
+				tt.factoryOverrides(f)
+			}
+
+			cmd := stateRestoreCmd(f)
+			cmd.SetOut(out)
+			cmd.SetArgs(tt.args)
+
+			err := cmd.ExecuteContext(context.Background())
+			if !assert.True(t, errors.Is(err, tt.err)) {
+				t.Logf("wanted %v but got %v", tt.err, err)
+			}
+
+			if tt.out != "" {
+				assert.Equal(t, tt.out, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/leg100/etok/cmd/flags"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/controllers"
+	"github.com/leg100/etok/pkg/env"
+	"github.com/leg100/etok/pkg/globals"
+	"github.com/leg100/etok/pkg/logstreamer"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultNamespace = "default"
+	defaultWorkspace = "default"
+)
+
+var (
+	errRunNotFound       = errors.New("run not found")
+	errWorkspaceNotFound = errors.New("workspace not found")
+	errPodNotFound       = errors.New("pod not found: logs are only available until the pod is deleted")
+	errInvalidSince      = errors.New("--since must be a positive duration")
+)
+
+type LogsOptions struct {
+	*cmdutil.Factory
+
+	*client.Client
+
+	path        string
+	namespace   string
+	workspace   string
+	kubeContext string
+
+	// Name of run whose logs are to be printed. If empty, the logs of the
+	// workspace pod are printed instead.
+	run string
+
+	// Container whose logs are to be printed
+	container string
+
+	// Stream logs until the container terminates
+	follow bool
+
+	// Only print logs more recent than this duration. Zero prints the full
+	// history.
+	since time.Duration
+	// Only print this number of the most recent lines. Negative prints the
+	// full history.
+	tail int64
+}
+
+func LogsCmd(f *cmdutil.Factory) (*cobra.Command, *LogsOptions) {
+	o := &LogsOptions{
+		Factory:   f,
+		namespace: defaultNamespace,
+		workspace: defaultWorkspace,
+		tail:      -1,
+	}
+	cmd := &cobra.Command{
+		Use:   "logs [run]",
+		Short: "Print the logs of a run",
+		Long:  "Print the logs of a run's pod. If no run is specified then the logs of the workspace's pod are printed instead, i.e. the output of the terraform installation performed when the workspace was created.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) == 1 {
+				o.run = args[0]
+			}
+
+			if o.since < 0 {
+				return errInvalidSince
+			}
+
+			etokenv, err := env.Read(o.path)
+			if err != nil {
+				// It's ok for envfile to not exist
+				if !os.IsNotExist(err) {
+					return err
+				}
+			} else {
+				if !flags.IsFlagPassed(cmd.Flags(), "namespace") {
+					o.namespace = etokenv.Namespace
+				}
+				if !flags.IsFlagPassed(cmd.Flags(), "workspace") {
+					o.workspace = etokenv.Workspace
+				}
+			}
+
+			o.Client, err = f.Create(o.kubeContext)
+			if err != nil {
+				return err
+			}
+
+			return o.Run(cmd.Context())
+		},
+	}
+
+	flags.AddPathFlag(cmd, &o.path)
+	flags.AddNamespaceFlag(cmd, &o.namespace)
+	flags.AddWorkspaceFlag(cmd, &o.workspace)
+	flags.AddKubeContextFlag(cmd, &o.kubeContext)
+
+	cmd.Flags().BoolVarP(&o.follow, "follow", "f", false, "Stream logs until the container terminates")
+	cmd.Flags().DurationVar(&o.since, "since", 0, "Only print logs more recent than this duration, e.g. 10m (default full history)")
+	cmd.Flags().Int64Var(&o.tail, "tail", o.tail, "Only print this number of the most recent lines (default full history)")
+	cmd.Flags().StringVarP(&o.container, "container", "c", "", fmt.Sprintf("Container whose logs are printed (default %q for a run, %q for a workspace)", globals.RunnerContainerName, controllers.InstallerContainerName))
+
+	return cmd, o
+}
+
+func (o *LogsOptions) Run(ctx context.Context) error {
+	podName, container, err := o.pod(ctx)
+	if err != nil {
+		return err
+	}
+	if o.container != "" {
+		container = o.container
+	}
+
+	_, err = o.PodsClient(o.namespace).Get(ctx, podName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return fmt.Errorf("%w: %s/%s", errPodNotFound, o.namespace, podName)
+	} else if err != nil {
+		return err
+	}
+
+	if o.follow {
+		var streamOpts []logstreamer.StreamOption
+		if o.since > 0 {
+			streamOpts = append(streamOpts, logstreamer.WithSince(o.since))
+		}
+		if o.tail >= 0 {
+			streamOpts = append(streamOpts, logstreamer.WithTailLines(o.tail))
+		}
+		// Re-establish the stream should it be interrupted
+		return logstreamer.Stream(ctx, o.GetLogsFunc, o.Out, o.PodsClient(o.namespace), podName, container, streamOpts...)
+	}
+
+	logOpts := &corev1.PodLogOptions{Container: container}
+	if o.since > 0 {
+		// The API only accepts whole seconds, so round up to include the
+		// entire duration
+		seconds := int64(math.Ceil(o.since.Seconds()))
+		logOpts.SinceSeconds = &seconds
+	}
+	if o.tail >= 0 {
+		logOpts.TailLines = &o.tail
+	}
+
+	logs, err := o.GetLogsFunc(ctx, logstreamer.Options{
+		PodsClient:    o.PodsClient(o.namespace),
+		PodName:       podName,
+		PodLogOptions: logOpts,
+	})
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	_, err = io.Copy(o.Out, logs)
+	return err
+}
+
+// pod returns the name of the pod and its default container from which to
+// print logs, for either the run or the workspace
+func (o *LogsOptions) pod(ctx context.Context) (string, string, error) {
+	if o.run != "" {
+		run, err := o.RunsClient(o.namespace).Get(ctx, o.run, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			return "", "", fmt.Errorf("%w: %s/%s", errRunNotFound, o.namespace, o.run)
+		} else if err != nil {
+			return "", "", err
+		}
+		return run.PodName(), globals.RunnerContainerName, nil
+	}
+
+	ws, err := o.WorkspacesClient(o.namespace).Get(ctx, o.workspace, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return "", "", fmt.Errorf("%w: %s/%s", errWorkspaceNotFound, o.namespace, o.workspace)
+	} else if err != nil {
+		return "", "", err
+	}
+	return ws.PodName(), controllers.InstallerContainerName, nil
+}
@@ -0,0 +1,176 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/env"
+	"github.com/leg100/etok/pkg/logstreamer"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestLogs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		env        *env.Env
+		objs       []runtime.Object
+		err        error
+		assertions func(*testutil.T, logstreamer.Options)
+	}{
+		{
+			name: "run logs",
+			args: []string{"plan-1"},
+			objs: []runtime.Object{testobj.Run("default", "plan-1", "plan"), testobj.RunPod("default", "plan-1")},
+			assertions: func(t *testutil.T, opts logstreamer.Options) {
+				assert.Equal(t, "plan-1", opts.PodName)
+				assert.Equal(t, "runner", opts.PodLogOptions.Container)
+				assert.False(t, opts.PodLogOptions.Follow)
+			},
+		},
+		{
+			name: "follow run logs",
+			args: []string{"plan-1", "--follow"},
+			objs: []runtime.Object{testobj.Run("default", "plan-1", "plan"), testobj.RunPod("default", "plan-1")},
+			assertions: func(t *testutil.T, opts logstreamer.Options) {
+				assert.True(t, opts.PodLogOptions.Follow)
+			},
+		},
+		{
+			name: "full history by default",
+			args: []string{"plan-1"},
+			objs: []runtime.Object{testobj.Run("default", "plan-1", "plan"), testobj.RunPod("default", "plan-1")},
+			assertions: func(t *testutil.T, opts logstreamer.Options) {
+				assert.Nil(t, opts.PodLogOptions.SinceSeconds)
+				assert.Nil(t, opts.PodLogOptions.SinceTime)
+				assert.Nil(t, opts.PodLogOptions.TailLines)
+			},
+		},
+		{
+			name: "since",
+			args: []string{"plan-1", "--since", "90s"},
+			objs: []runtime.Object{testobj.Run("default", "plan-1", "plan"), testobj.RunPod("default", "plan-1")},
+			assertions: func(t *testutil.T, opts logstreamer.Options) {
+				if assert.NotNil(t, opts.PodLogOptions.SinceSeconds) {
+					assert.Equal(t, int64(90), *opts.PodLogOptions.SinceSeconds)
+				}
+			},
+		},
+		{
+			name: "since rounded up to whole seconds",
+			args: []string{"plan-1", "--since", "1500ms"},
+			objs: []runtime.Object{testobj.Run("default", "plan-1", "plan"), testobj.RunPod("default", "plan-1")},
+			assertions: func(t *testutil.T, opts logstreamer.Options) {
+				if assert.NotNil(t, opts.PodLogOptions.SinceSeconds) {
+					assert.Equal(t, int64(2), *opts.PodLogOptions.SinceSeconds)
+				}
+			},
+		},
+		{
+			name: "tail",
+			args: []string{"plan-1", "--tail", "20"},
+			objs: []runtime.Object{testobj.Run("default", "plan-1", "plan"), testobj.RunPod("default", "plan-1")},
+			assertions: func(t *testutil.T, opts logstreamer.Options) {
+				if assert.NotNil(t, opts.PodLogOptions.TailLines) {
+					assert.Equal(t, int64(20), *opts.PodLogOptions.TailLines)
+				}
+			},
+		},
+		{
+			name: "follow since and tail",
+			args: []string{"plan-1", "--follow", "--since", "10m", "--tail", "0"},
+			objs: []runtime.Object{testobj.Run("default", "plan-1", "plan"), testobj.RunPod("default", "plan-1")},
+			assertions: func(t *testutil.T, opts logstreamer.Options) {
+				assert.True(t, opts.PodLogOptions.Follow)
+				if assert.NotNil(t, opts.PodLogOptions.SinceTime) {
+					assert.WithinDuration(t, time.Now().Add(-10*time.Minute), opts.PodLogOptions.SinceTime.Time, time.Minute)
+				}
+				if assert.NotNil(t, opts.PodLogOptions.TailLines) {
+					assert.Equal(t, int64(0), *opts.PodLogOptions.TailLines)
+				}
+			},
+		},
+		{
+			name: "negative since",
+			args: []string{"plan-1", "--since", "-5m"},
+			err:  errInvalidSince,
+		},
+		{
+			name: "workspace logs",
+			env:  &env.Env{Namespace: "dev", Workspace: "networking"},
+			objs: []runtime.Object{testobj.Workspace("dev", "networking"), testobj.WorkspacePod("dev", "networking")},
+			assertions: func(t *testutil.T, opts logstreamer.Options) {
+				assert.Equal(t, "workspace-networking", opts.PodName)
+				assert.Equal(t, "installer", opts.PodLogOptions.Container)
+			},
+		},
+		{
+			name: "override container",
+			args: []string{"plan-1", "--container", "sidecar"},
+			objs: []runtime.Object{testobj.Run("default", "plan-1", "plan"), testobj.RunPod("default", "plan-1")},
+			assertions: func(t *testutil.T, opts logstreamer.Options) {
+				assert.Equal(t, "sidecar", opts.PodLogOptions.Container)
+			},
+		},
+		{
+			name: "run not found",
+			args: []string{"plan-1"},
+			err:  errRunNotFound,
+		},
+		{
+			name: "workspace not found",
+			err:  errWorkspaceNotFound,
+		},
+		{
+			name: "pod not found",
+			args: []string{"plan-1"},
+			objs: []runtime.Object{testobj.Run("default", "plan-1", "plan")},
+			err:  errPodNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			path := t.NewTempDir().Chdir().Root()
+
+			// Write .terraform/environment
+			if tt.env != nil {
+				require.NoError(t, tt.env.Write(path))
+			}
+
+			out := new(bytes.Buffer)
+			f := cmdutil.NewFakeFactory(out, tt.objs...)
+
+			// Record options with which logs are requested
+			var opts logstreamer.Options
+			f.GetLogsFunc = func(ctx context.Context, o logstreamer.Options) (io.ReadCloser, error) {
+				opts = o
+				return ioutil.NopCloser(bytes.NewBufferString("fake logs")), nil
+			}
+
+			cmd, _ := LogsCmd(f)
+			cmd.SetOut(f.Out)
+			cmd.SetArgs(tt.args)
+
+			err := cmd.ExecuteContext(context.Background())
+			if !assert.True(t, errors.Is(err, tt.err)) {
+				t.Errorf("no error in %v's chain matches %v", err, tt.err)
+			}
+
+			if tt.assertions != nil {
+				assert.Equal(t, "fake logs", out.String())
+				tt.assertions(t, opts)
+			}
+		})
+	}
+}
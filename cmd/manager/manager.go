@@ -1,11 +1,13 @@
 package manager
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"runtime"
-
-	"k8s.io/klog/v2"
+	"strings"
+	"time"
 
 	"github.com/leg100/etok/cmd/flags"
 	cmdutil "github.com/leg100/etok/cmd/util"
@@ -15,13 +17,55 @@ import (
 	"github.com/spf13/cobra"
 
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+const (
+	// Log formats
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+)
+
+var (
+	// ErrInvalidLogFormat is returned for a log format the operator does not
+	// support
+	ErrInvalidLogFormat = errors.New("invalid log format")
+
+	// LogFormats are the log formats the operator supports
+	LogFormats = []string{LogFormatJSON, LogFormatConsole}
+
+	setupLog = ctrl.Log.WithName("setup")
 )
 
 func printVersion() {
-	klog.V(0).Info(fmt.Sprintf("Operator Version: %s", version.Version))
-	klog.V(0).Info(fmt.Sprintf("Go Version: %s", runtime.Version()))
-	klog.V(0).Info(fmt.Sprintf("Go OS/Arch: %s/%s", runtime.GOOS, runtime.GOARCH))
+	setupLog.Info(fmt.Sprintf("Operator Version: %s", version.Version))
+	setupLog.Info(fmt.Sprintf("Go Version: %s", runtime.Version()))
+	setupLog.Info(fmt.Sprintf("Go OS/Arch: %s/%s", runtime.GOOS, runtime.GOARCH))
+}
+
+// ValidateLogFormat checks the operator supports the log format
+func ValidateLogFormat(format string) error {
+	for _, f := range LogFormats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s: must be one of %v", ErrInvalidLogFormat, format, LogFormats)
+}
+
+// loggerOpts returns the options for a logger that encodes log entries,
+// including their key/values, in the given format
+func loggerOpts(format string) ([]zap.Opts, error) {
+	if err := ValidateLogFormat(format); err != nil {
+		return nil, err
+	}
+	if format == LogFormatConsole {
+		return []zap.Opts{zap.UseDevMode(false), zap.ConsoleEncoder()}, nil
+	}
+	return []zap.Opts{zap.UseDevMode(false), zap.JSONEncoder()}, nil
 }
 
 type ManagerOptions struct {
@@ -34,8 +78,31 @@ type ManagerOptions struct {
 
 	// Operator metrics bind endpoint
 	MetricsAddress string
+	// Operator health probe bind endpoint
+	HealthProbeAddress string
 	// Toggle operator leader election
 	EnableLeaderElection bool
+	// Toggle backing up state before a workspace is deleted
+	BackupOnDelete bool
+	// Toggle defaults suited to GKE Autopilot clusters. Otherwise Autopilot
+	// is detected.
+	Autopilot bool
+	// Delays before reconciling a workspace again following a failed
+	// reconcile
+	RequeueBaseDelay time.Duration
+	RequeueMaxDelay  time.Duration
+	// Number of workspaces with runs waiting in their queue above which the
+	// backlog health check fails, once exceeded for longer than the backlog
+	// timeout. The check is disabled if zero.
+	BacklogThreshold int
+	BacklogTimeout   time.Duration
+	// Namespaces to watch. All namespaces are watched if empty.
+	WatchNamespaces []string
+	// Format of log entries (json|console)
+	LogFormat string
+	// Directory containing the webhook server's TLS certificate and key. The
+	// workspace validation webhook is only served if set.
+	WebhookCertDir string
 
 	args []string
 }
@@ -47,7 +114,11 @@ func ManagerCmd(f *cmdutil.Factory) *cobra.Command {
 		Short:  "Run the etok operator",
 		Hidden: true,
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
-			ctrl.SetLogger(zap.New(zap.UseDevMode(false)))
+			opts, err := loggerOpts(o.LogFormat)
+			if err != nil {
+				return err
+			}
+			ctrl.SetLogger(zap.New(opts...))
 
 			printVersion()
 
@@ -56,34 +127,89 @@ func ManagerCmd(f *cmdutil.Factory) *cobra.Command {
 				return err
 			}
 
-			mgr, err := ctrl.NewManager(client.Config, ctrl.Options{
-				Scheme:             scheme.Scheme,
-				MetricsBindAddress: o.MetricsAddress,
-				Port:               9443,
-				LeaderElection:     o.EnableLeaderElection,
-				LeaderElectionID:   "688c905b.dev",
-			})
+			mgrOpts := ctrl.Options{
+				Scheme:                 scheme.Scheme,
+				MetricsBindAddress:     o.MetricsAddress,
+				HealthProbeBindAddress: o.HealthProbeAddress,
+				Port:                   9443,
+				LeaderElection:         o.EnableLeaderElection,
+				LeaderElectionID:       "688c905b.dev",
+				CertDir:                o.WebhookCertDir,
+			}
+
+			if len(o.WatchNamespaces) > 0 {
+				// Restrict the cache, and therefore the resources reconciled,
+				// to the watched namespaces
+				setupLog.Info("Watching namespaces: " + strings.Join(o.WatchNamespaces, ","))
+				mgrOpts.NewCache = cache.MultiNamespacedCacheBuilder(o.WatchNamespaces)
+			}
+
+			mgr, err := ctrl.NewManager(client.Config, mgrOpts)
 			if err != nil {
 				return fmt.Errorf("unable to start manager: %w", err)
 			}
 
-			klog.V(0).Info("Runner image: " + o.Image)
+			if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+				return fmt.Errorf("unable to add health check: %w", err)
+			}
+
+			// Only report ready once the cache has synced, otherwise the
+			// operator is not yet able to reconcile resources
+			if err := mgr.AddReadyzCheck("cache-sync", func(req *http.Request) error {
+				if !mgr.GetCache().WaitForCacheSync(req.Context()) {
+					return errors.New("cache not synced")
+				}
+				return nil
+			}); err != nil {
+				return fmt.Errorf("unable to add readiness check: %w", err)
+			}
+
+			var backlogCheck *controllers.BacklogCheck
+			if o.BacklogThreshold > 0 {
+				backlogCheck = controllers.NewBacklogCheck(o.BacklogThreshold, o.BacklogTimeout)
+				if err := mgr.AddHealthzCheck(controllers.BacklogCheckName, backlogCheck.Check); err != nil {
+					return fmt.Errorf("unable to add backlog health check: %w", err)
+				}
+			}
+
+			setupLog.Info("Runner image: " + o.Image)
+
+			if !o.Autopilot {
+				// Use the API reader because the cache is yet to be started
+				detected, err := controllers.IsAutopilot(cmd.Context(), mgr.GetAPIReader())
+				if err != nil {
+					setupLog.Error(err, "unable to detect GKE Autopilot")
+				}
+				o.Autopilot = detected
+			}
+			if o.Autopilot {
+				setupLog.Info("Using GKE Autopilot defaults")
+			}
 
 			// Setup workspace ctrl with mgr
 			workspaceReconciler := controllers.NewWorkspaceReconciler(
 				mgr.GetClient(),
 				o.Image,
-				controllers.WithEventRecorder(mgr.GetEventRecorderFor("workspace-controller")))
+				controllers.WithEventRecorder(mgr.GetEventRecorderFor("workspace-controller")),
+				controllers.WithBackupOnDelete(o.BackupOnDelete),
+				controllers.WithAutopilot(o.Autopilot),
+				controllers.WithRequeueBackoff(o.RequeueBaseDelay, o.RequeueMaxDelay),
+				controllers.WithBacklogCheck(backlogCheck))
 			if err := workspaceReconciler.SetupWithManager(mgr); err != nil {
 				return fmt.Errorf("unable to create workspace controller: %w", err)
 			}
 
 			// Setup run ctrl with mgr
-			if err := controllers.NewRunReconciler(mgr.GetClient(), o.Image).SetupWithManager(mgr); err != nil {
+			if err := controllers.NewRunReconciler(mgr.GetClient(), o.Image, controllers.WithRunEventRecorder(mgr.GetEventRecorderFor("run-controller")), controllers.WithRunAutopilot(o.Autopilot)).SetupWithManager(mgr); err != nil {
 				return fmt.Errorf("unable to create run controller: %w", err)
 			}
 
-			klog.V(0).Info("starting manager")
+			if o.WebhookCertDir != "" {
+				mgr.GetWebhookServer().Register(controllers.WorkspaceValidationPath, &webhook.Admission{Handler: &controllers.WorkspaceValidator{}})
+				mgr.GetWebhookServer().Register(controllers.RunUserPath, &webhook.Admission{Handler: &controllers.RunUserRecorder{}})
+			}
+
+			setupLog.Info("starting manager")
 			if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 				return fmt.Errorf("problem running manager: %w", err)
 			}
@@ -97,10 +223,20 @@ func ManagerCmd(f *cmdutil.Factory) *cobra.Command {
 	flags.AddKubeContextFlag(cmd, &o.KubeContext)
 
 	cmd.Flags().StringVar(&o.MetricsAddress, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	cmd.Flags().StringVar(&o.HealthProbeAddress, "health-probe-addr", ":8081", "The address the health probe endpoints bind to.")
 	cmd.Flags().BoolVar(&o.EnableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	cmd.Flags().StringVar(&o.Image, "image", version.Image, "Docker image used for both the operator and the runner")
+	cmd.Flags().BoolVar(&o.BackupOnDelete, "backup-on-delete", false, "Backup state of workspaces with a backup bucket before they are deleted")
+	cmd.Flags().BoolVar(&o.Autopilot, "autopilot", false, "Use defaults suited to GKE Autopilot clusters (default true if Autopilot is detected)")
+	cmd.Flags().DurationVar(&o.RequeueBaseDelay, "requeue-base-delay", controllers.DefaultRequeueBaseDelay, "Delay before reconciling a workspace again following a failed reconcile, doubling with each consecutive failure")
+	cmd.Flags().DurationVar(&o.RequeueMaxDelay, "requeue-max-delay", controllers.DefaultRequeueMaxDelay, "Maximum delay before reconciling a workspace again following a failed reconcile")
+	cmd.Flags().IntVar(&o.BacklogThreshold, "backlog-threshold", 0, "Number of workspaces with queued runs above which the /healthz/backlog health check fails, once exceeded for longer than --backlog-timeout (disabled if zero)")
+	cmd.Flags().DurationVar(&o.BacklogTimeout, "backlog-timeout", controllers.DefaultBacklogTimeout, "How long the number of workspaces with queued runs may exceed --backlog-threshold before the /healthz/backlog health check fails")
+	cmd.Flags().StringVar(&o.LogFormat, "log-format", LogFormatJSON, "Format of log entries (json|console)")
+	cmd.Flags().StringVar(&o.WebhookCertDir, "webhook-cert-dir", "", "Directory containing the TLS certificate and key for the workspace validation webhook (disabled if unset)")
+	cmd.Flags().StringSliceVar(&o.WatchNamespaces, "watch-namespaces", []string{}, "Only watch these namespaces (default all namespaces)")
 
 	return cmd
 }
@@ -4,8 +4,10 @@ import (
 	"flag"
 	"strconv"
 
+	"github.com/leg100/etok/cmd/approve"
 	"github.com/leg100/etok/cmd/install"
 	"github.com/leg100/etok/cmd/launcher"
+	"github.com/leg100/etok/cmd/logs"
 	"github.com/leg100/etok/cmd/manager"
 	"github.com/leg100/etok/cmd/runner"
 	cmdutil "github.com/leg100/etok/cmd/util"
@@ -43,6 +45,12 @@ func RootCmd(f *cmdutil.Factory) *cobra.Command {
 	installCmd, _ := install.InstallCmd(f)
 	cmd.AddCommand(installCmd)
 
+	logsCmd, _ := logs.LogsCmd(f)
+	cmd.AddCommand(logsCmd)
+
+	approveCmd, _ := approve.ApproveCmd(f)
+	cmd.AddCommand(approveCmd)
+
 	// Terraform commands (and shell command)
 	launcher.AddToRoot(cmd, f)
 	// terraform fmt
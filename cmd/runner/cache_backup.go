@@ -0,0 +1,268 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/controllers"
+	"github.com/leg100/etok/pkg/globals"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// cacheProvidersDir is the directory in which terraform init installs
+	// providers
+	cacheProvidersDir = ".terraform/providers"
+
+	// cacheBackupMarker records the checksum of the lock file of the
+	// providers last backed up or restored, so that providers are only backed
+	// up when they have changed
+	cacheBackupMarker = ".terraform/etok-cache-backup"
+)
+
+// restoreCache restores providers and the lock file from the backup bucket,
+// unless providers are already installed. A lock file already present, i.e.
+// uploaded by the client, is retained.
+func (o *RunnerOptions) restoreCache(ctx context.Context, out io.Writer) error {
+	if _, err := os.Stat(cacheProvidersDir); err == nil {
+		klog.V(1).Infof("%s already exists; skipping restoring cache backup", cacheProvidersDir)
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	provider, err := o.getCacheBackupProvider(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Restoring providers from %s...\n", o.cacheBackupURL())
+	data, err := provider.Restore(ctx, o.cacheBackupBucket, o.cacheBackupKey)
+	if errors.Is(err, controllers.ErrBackupNotFound) {
+		fmt.Fprintln(out, "No providers backed up yet")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	lockFile, err := unpackCache(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Restored providers (%s)\n", formatBytes(int64(len(data))))
+
+	return ioutil.WriteFile(cacheBackupMarker, []byte(checksum(lockFile)), 0644)
+}
+
+// backupCache backs up providers and the lock file to the backup bucket,
+// unless they are unchanged since they were last backed up or restored.
+func (o *RunnerOptions) backupCache(ctx context.Context) error {
+	if _, err := os.Stat(cacheProvidersDir); os.IsNotExist(err) {
+		klog.V(1).Infof("%s not found; skipping cache backup", cacheProvidersDir)
+		return nil
+	}
+
+	lockFile, err := ioutil.ReadFile(globals.LockFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sum := checksum(lockFile)
+	if last, err := ioutil.ReadFile(cacheBackupMarker); err == nil && string(last) == sum {
+		klog.V(1).Info("providers unchanged; skipping cache backup")
+		return nil
+	}
+
+	provider, err := o.getCacheBackupProvider(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.Out, "Backing up providers to %s...\n", o.cacheBackupURL())
+
+	var buf bytes.Buffer
+	if err := packCache(&buf, lockFile); err != nil {
+		return err
+	}
+	if err := provider.Backup(ctx, o.cacheBackupBucket, o.cacheBackupKey, buf.Bytes()); err != nil {
+		return err
+	}
+	fmt.Fprintf(o.Out, "Backed up providers (%s)\n", formatBytes(int64(buf.Len())))
+
+	return ioutil.WriteFile(cacheBackupMarker, []byte(sum), 0644)
+}
+
+// getCacheBackupProvider returns the backup provider for the bucket, the same
+// as the operator uses to back up state, creating it if not yet created
+func (o *RunnerOptions) getCacheBackupProvider(ctx context.Context) (controllers.BackupProvider, error) {
+	if o.cacheBackupClient == nil {
+		provider, err := controllers.NewBackupProvider(ctx, o.cacheBackupProvider, o.cacheBackupKMSKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create %s client: %w", o.cacheBackupProvider, err)
+		}
+		o.cacheBackupClient = provider
+	}
+	return o.cacheBackupClient, nil
+}
+
+func (o *RunnerOptions) cacheBackupURL() string {
+	scheme := "gs"
+	if o.cacheBackupProvider == v1alpha1.BackupProviderS3 {
+		scheme = "s3"
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, o.cacheBackupBucket, o.cacheBackupKey)
+}
+
+// packCache writes a compressed tarball of the providers directory and the
+// lock file, if any. Providers are symlinks into terraform's plugin cache,
+// which is just as empty on a new cache, so symlinks are followed and their
+// targets archived instead.
+func packCache(w io.Writer, lockFile []byte) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	if lockFile != nil {
+		if err := tw.WriteHeader(&tar.Header{Name: globals.LockFile, Mode: 0644, Size: int64(len(lockFile)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(lockFile); err != nil {
+			return err
+		}
+	}
+
+	if err := packDir(tw, cacheProvidersDir); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// packDir recursively adds the directory to the tarball, following symlinks
+func packDir(tw *tar.Writer, dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+
+		// Follow symlinks
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if err := packDir(tw, path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := tw.WriteHeader(&tar.Header{Name: path, Mode: int64(info.Mode().Perm()), Size: info.Size(), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpackCache extracts the compressed tarball of providers into the working
+// directory, returning the contents of the archived lock file. The archived
+// lock file is only extracted should there not already be a lock file.
+func unpackCache(r io.Reader) ([]byte, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to uncompress cache backup: %w", err)
+	}
+	tr := tar.NewReader(gr)
+
+	var lockFile []byte
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return lockFile, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to extract cache backup: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		path := filepath.Clean(header.Name)
+		if path == globals.LockFile {
+			lockFile, err = ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := os.Stat(path); err == nil {
+				klog.V(1).Infof("%s already exists; not restoring it from cache backup", path)
+				continue
+			}
+			if err := ioutil.WriteFile(path, lockFile, os.FileMode(header.Mode)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Only providers are restored
+		if !strings.HasPrefix(path, cacheProvidersDir+string(filepath.Separator)) {
+			return nil, fmt.Errorf("unexpected file in cache backup: %s", header.Name)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode))
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// checksum returns the hex-encoded SHA256 checksum of data
+func checksum(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// formatBytes formats a number of bytes in human-readable form, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
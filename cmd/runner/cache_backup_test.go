@@ -0,0 +1,184 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leg100/etok/cmd/envvars"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/controllers"
+	"github.com/leg100/etok/pkg/executor"
+	"github.com/leg100/etok/pkg/globals"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fakeProvider = ".terraform/providers/registry.terraform.io/hashicorp/random/3.0.0/linux_amd64/terraform-provider-random_v3.0.0_x5"
+
+func TestCacheBackup(t *testing.T) {
+	testutil.Run(t, "back up and restore", func(t *testutil.T) {
+		store := &fakeBackupProvider{}
+
+		// Install provider as terraform does when using the plugin cache,
+		// i.e. symlinking the provider's directory to the plugin cache
+		pluginCache := t.NewTempDir().Write("registry.terraform.io/hashicorp/random/3.0.0/linux_amd64/terraform-provider-random_v3.0.0_x5", []byte("provider binary")).Root()
+		t.NewTempDir().Chdir().Write(globals.LockFile, []byte("plugin hashes"))
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Dir(fakeProvider)), 0755))
+		require.NoError(t, os.Symlink(filepath.Join(pluginCache, "registry.terraform.io/hashicorp/random/3.0.0/linux_amd64"), filepath.Dir(fakeProvider)))
+
+		out := new(bytes.Buffer)
+		o := cacheBackupOptions(out, store)
+		require.NoError(t, o.backupCache(context.Background()))
+		assert.Contains(t, out.String(), "Backing up providers to gs://backups/dev/default.terraform.tar.gz...")
+		assert.Equal(t, 1, store.backups)
+
+		// Providers unchanged, so no further backup
+		require.NoError(t, o.backupCache(context.Background()))
+		assert.Equal(t, 1, store.backups)
+
+		// Restore to a new cache
+		t.NewTempDir().Chdir()
+
+		out = new(bytes.Buffer)
+		o = cacheBackupOptions(out, store)
+		require.NoError(t, o.restoreCache(context.Background(), o.Out))
+		assert.Contains(t, out.String(), "Restoring providers from gs://backups/dev/default.terraform.tar.gz...")
+		assert.Contains(t, out.String(), "Restored providers")
+
+		// Provider restored as a regular file rather than a symlink
+		info, err := os.Lstat(fakeProvider)
+		require.NoError(t, err)
+		assert.True(t, info.Mode().IsRegular())
+		got, err := ioutil.ReadFile(fakeProvider)
+		require.NoError(t, err)
+		assert.Equal(t, "provider binary", string(got))
+
+		got, err = ioutil.ReadFile(globals.LockFile)
+		require.NoError(t, err)
+		assert.Equal(t, "plugin hashes", string(got))
+
+		// Restored providers are not backed up again
+		require.NoError(t, o.backupCache(context.Background()))
+		assert.Equal(t, 1, store.backups)
+	})
+
+	testutil.Run(t, "restore retains existing lock file", func(t *testutil.T) {
+		store := &fakeBackupProvider{}
+
+		t.NewTempDir().Chdir().Write(globals.LockFile, []byte("old hashes")).Write(fakeProvider, []byte("provider binary"))
+		require.NoError(t, cacheBackupOptions(new(bytes.Buffer), store).backupCache(context.Background()))
+
+		t.NewTempDir().Chdir().Write(globals.LockFile, []byte("new hashes"))
+		o := cacheBackupOptions(new(bytes.Buffer), store)
+		require.NoError(t, o.restoreCache(context.Background(), o.Out))
+
+		got, err := ioutil.ReadFile(globals.LockFile)
+		require.NoError(t, err)
+		assert.Equal(t, "new hashes", string(got))
+
+		// Lock file differs from that backed up, so back up again
+		require.NoError(t, o.backupCache(context.Background()))
+		assert.Equal(t, 2, store.backups)
+	})
+
+	testutil.Run(t, "restore skipped when providers installed", func(t *testutil.T) {
+		store := &fakeBackupProvider{data: []byte("not a tarball")}
+
+		t.NewTempDir().Chdir().Write(fakeProvider, []byte("provider binary"))
+
+		out := new(bytes.Buffer)
+		require.NoError(t, cacheBackupOptions(out, store).restoreCache(context.Background(), out))
+		assert.Equal(t, "", out.String())
+	})
+
+	testutil.Run(t, "nothing to restore", func(t *testutil.T) {
+		t.NewTempDir().Chdir()
+
+		out := new(bytes.Buffer)
+		require.NoError(t, cacheBackupOptions(out, &fakeBackupProvider{}).restoreCache(context.Background(), out))
+		assert.Contains(t, out.String(), "No providers backed up yet")
+	})
+
+	testutil.Run(t, "init restores providers", func(t *testutil.T) {
+		store := &fakeBackupProvider{}
+		t.NewTempDir().Chdir().Write(fakeProvider, []byte("provider binary"))
+		require.NoError(t, cacheBackupOptions(new(bytes.Buffer), store).backupCache(context.Background()))
+
+		out := new(bytes.Buffer)
+		f := cmdutil.NewFakeFactory(out, testobj.Run("dev", "run-12345", "init"))
+		cmd, o := RunnerCmd(f)
+		cmd.SetOut(out)
+		cmd.SetArgs([]string{"--", "true"})
+		o.exec = &executor.FakeExecutor{}
+		o.cacheBackupClient = store
+
+		t.NewTempDir().Chdir()
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_NAMESPACE":             "dev",
+			"ETOK_COMMAND":               "init",
+			"ETOK_RUN_NAME":              "run-12345",
+			"ETOK_CACHE_BACKUP_BUCKET":   "backups",
+			"ETOK_CACHE_BACKUP_PROVIDER": "gcs",
+			"ETOK_CACHE_BACKUP_KEY":      "dev/default.terraform.tar.gz",
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		require.NoError(t, cmd.ExecuteContext(context.Background()))
+
+		assert.FileExists(t, fakeProvider)
+		assert.Contains(t, out.String(), "Restored providers")
+	})
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "200.0 MiB", formatBytes(200*1024*1024))
+}
+
+func cacheBackupOptions(out io.Writer, provider controllers.BackupProvider) *RunnerOptions {
+	return &RunnerOptions{
+		Factory:             cmdutil.NewFakeFactory(out),
+		cacheBackupBucket:   "backups",
+		cacheBackupProvider: "gcs",
+		cacheBackupKey:      "dev/default.terraform.tar.gz",
+		cacheBackupClient:   provider,
+	}
+}
+
+// fakeBackupProvider stores a single backup in memory
+type fakeBackupProvider struct {
+	data    []byte
+	backups int
+}
+
+func (p *fakeBackupProvider) Backup(ctx context.Context, bucket, key string, data []byte) error {
+	p.data = data
+	p.backups++
+	return nil
+}
+
+func (p *fakeBackupProvider) Restore(ctx context.Context, bucket, key string) ([]byte, error) {
+	if p.data == nil {
+		return nil, controllers.ErrBackupNotFound
+	}
+	return p.data, nil
+}
+
+func (p *fakeBackupProvider) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (p *fakeBackupProvider) Delete(ctx context.Context, bucket, key string) error {
+	p.data = nil
+	return nil
+}
@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// tokenEnvVarPrefix prefixes the env vars from which terraform 1.2 onwards
+	// reads API tokens, e.g. TF_TOKEN_app_terraform_io
+	tokenEnvVarPrefix = "TF_TOKEN_"
+
+	// credentialsPath is the path, relative to the home directory, of the
+	// file from which terraform reads API tokens, as written by terraform
+	// login
+	credentialsPath = ".terraform.d/credentials.tfrc.json"
+)
+
+// writeCredentials writes API tokens found in TF_TOKEN_<hostname> env vars to
+// the credentials file in the home directory, for the benefit of terraform
+// versions prior to 1.2, which do not read tokens from the environment. An
+// existing credentials file is left untouched.
+func writeCredentials(environ []string, home string) error {
+	hosts := make(map[string]interface{})
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, tokenEnvVarPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(kv, tokenEnvVarPrefix), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		hosts[tokenHostname(parts[0])] = map[string]string{"token": parts[1]}
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(home, credentialsPath)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"credentials": hosts})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// tokenHostname decodes the hostname from the suffix of a TF_TOKEN_<hostname>
+// env var, in which hyphens are encoded as double underscores and periods as
+// underscores.
+func tokenHostname(suffix string) string {
+	hostname := strings.ReplaceAll(suffix, "__", "-")
+	return strings.ReplaceAll(hostname, "_", ".")
+}
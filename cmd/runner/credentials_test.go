@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		environ  []string
+		existing string
+		// Wanted contents of credentials file; empty means no file
+		want string
+	}{
+		{
+			name:    "no tokens",
+			environ: []string{"HOME=/root", "TF_VAR_foo=bar"},
+		},
+		{
+			name:    "tokens",
+			environ: []string{"TF_TOKEN_app_terraform_io=abc", "TF_TOKEN_tfe__internal_example_com=def"},
+			want:    `{"credentials":{"app.terraform.io":{"token":"abc"},"tfe-internal.example.com":{"token":"def"}}}`,
+		},
+		{
+			name:    "empty token",
+			environ: []string{"TF_TOKEN_app_terraform_io="},
+		},
+		{
+			name:     "existing credentials file",
+			environ:  []string{"TF_TOKEN_app_terraform_io=abc"},
+			existing: `{"credentials":{"app.terraform.io":{"token":"xyz"}}}`,
+			want:     `{"credentials":{"app.terraform.io":{"token":"xyz"}}}`,
+		},
+	}
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			home := t.NewTempDir()
+			if tt.existing != "" {
+				home.Write(credentialsPath, []byte(tt.existing))
+			}
+
+			require.NoError(t, writeCredentials(tt.environ, home.Root()))
+
+			got, err := ioutil.ReadFile(filepath.Join(home.Root(), credentialsPath))
+			if tt.want == "" {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, string(got))
+			}
+		})
+	}
+}
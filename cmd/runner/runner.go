@@ -1,12 +1,14 @@
 package runner
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/leg100/etok/api/etok.dev/v1alpha1"
@@ -15,9 +17,11 @@ import (
 	cmdutil "github.com/leg100/etok/cmd/util"
 	"github.com/leg100/etok/pkg/archive"
 	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/controllers"
 	"github.com/leg100/etok/pkg/executor"
 	"github.com/leg100/etok/pkg/globals"
 	"github.com/leg100/etok/pkg/labels"
+	"github.com/leg100/etok/pkg/logstreamer"
 	"github.com/leg100/etok/pkg/scheme"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh/terminal"
@@ -30,6 +34,10 @@ import (
 
 const (
 	defaultNamespace = "default"
+
+	// planTTL is how long a saved plan is kept in the cache before it is
+	// pruned, should it not be applied
+	planTTL = 24 * time.Hour
 )
 
 type RunnerOptions struct {
@@ -51,6 +59,30 @@ type RunnerOptions struct {
 	handshake        bool
 	handshakeTimeout time.Duration
 
+	// Terraform workspace to select prior to running command
+	tfWorkspace string
+
+	// Path to a saved plan file: plan saves its plan to the path, and apply
+	// applies the plan saved at the path
+	planFile string
+
+	// Print the saved plan in JSON, delimited from the rest of the output
+	// (plan only)
+	jsonPlan bool
+
+	// Bucket to which providers are backed up, and from which they are
+	// restored, along with the bucket's provider, the backup's object key,
+	// and the KMS key with which the backup is encrypted, if any
+	cacheBackupBucket   string
+	cacheBackupProvider string
+	cacheBackupKey      string
+	cacheBackupKMSKey   string
+	// Client for the bucket; created on demand
+	cacheBackupClient controllers.BackupProvider
+
+	// Run terraform init before the command
+	autoInit bool
+
 	args []string
 }
 
@@ -90,6 +122,14 @@ func RunnerCmd(opts *cmdutil.Factory) (*cobra.Command, *RunnerOptions) {
 	cmd.Flags().DurationVar(&o.handshakeTimeout, "handshake-timeout", v1alpha1.DefaultHandshakeTimeout, "Timeout waiting for handshake")
 	cmd.Flags().StringVar(&o.runName, "run-name", "", "Name of run resource")
 	cmd.Flags().StringVar(&o.command, "command", "", "Etok command to run")
+	cmd.Flags().StringVar(&o.tfWorkspace, "tf-workspace", "", "Terraform workspace to select before running command")
+	cmd.Flags().StringVar(&o.planFile, "plan-file", "", "Path to which plan saves its plan, or from which apply applies a saved plan")
+	cmd.Flags().BoolVar(&o.jsonPlan, "json-plan", false, "Print the saved plan in JSON after planning")
+	cmd.Flags().StringVar(&o.cacheBackupBucket, "cache-backup-bucket", "", "Bucket to which to back up, and from which to restore, providers installed by init")
+	cmd.Flags().StringVar(&o.cacheBackupProvider, "cache-backup-provider", v1alpha1.BackupProviderGCS, "Cloud storage provider of the cache backup bucket (gcs|s3)")
+	cmd.Flags().StringVar(&o.cacheBackupKey, "cache-backup-key", "", "Object key of the cache backup")
+	cmd.Flags().StringVar(&o.cacheBackupKMSKey, "cache-backup-kms-key", "", "KMS key with which to encrypt the cache backup")
+	cmd.Flags().BoolVar(&o.autoInit, "auto-init", false, "Run terraform init before the command, e.g. because .terraform is not persisted between runs")
 
 	return cmd, o
 }
@@ -110,6 +150,10 @@ func (o *RunnerOptions) validate() error {
 		return errors.New("--command cannot be empty")
 	}
 
+	if o.cacheBackupBucket != "" && o.cacheBackupKey == "" {
+		return errors.New("--cache-backup-key cannot be empty")
+	}
+
 	if launcher.UpdatesLockFile(o.command) {
 		if o.runName == "" {
 			return fmt.Errorf("%s updates lock file; --run-name cannot be empty", o.command)
@@ -151,19 +195,176 @@ func (o *RunnerOptions) Run(ctx context.Context) error {
 		return err
 	}
 
+	// Terraform prior to 1.2 only reads API tokens from its credentials file
+	if home, err := os.UserHomeDir(); err == nil {
+		if err := writeCredentials(os.Environ(), home); err != nil {
+			fmt.Fprintf(o.ErrOut, "Warning: unable to write terraform credentials: %s\n", err.Error())
+		}
+	}
+
+	// With auto init, the output of restoring providers and of init is only
+	// printed should init fail, lest it corrupt the command's machine-readable
+	// output, e.g. output -json. Stderr is no remedy: the pod's log combines
+	// stdout and stderr.
+	var initOut bytes.Buffer
+	preamble := o.Out
+	if o.autoInit {
+		preamble = &initOut
+	}
+
+	if o.cacheBackupBucket != "" && (launcher.UpdatesLockFile(o.command) || o.autoInit) {
+		// Failing to restore providers only makes init slower
+		if err := o.restoreCache(ctx, preamble); err != nil {
+			fmt.Fprintf(preamble, "Warning: unable to restore providers: %s\n", err.Error())
+		}
+	}
+
+	if o.autoInit {
+		if err := o.exec.Execute(ctx, prepareArgs("init", "-input=false"), executor.WithOutput(&initOut)); err != nil {
+			o.Out.Write(initOut.Bytes())
+			return fmt.Errorf("failed to run terraform init: %w", err)
+		}
+	}
+
+	if o.tfWorkspace != "" {
+		if err := o.selectTFWorkspace(ctx); err != nil {
+			return err
+		}
+	}
+
+	args, err := o.planFileArgs()
+	if err != nil {
+		return err
+	}
+
 	// Execute requested command
-	if err := o.exec.Execute(ctx, prepareArgs(o.command, o.args...)); err != nil {
+	err = o.exec.Execute(ctx, prepareArgs(o.command, args...))
+
+	if o.command == "plan" && o.jsonPlan {
+		// Plan saves its plan despite exiting non-zero with -detailed-exitcode.
+		// Failing to print it in JSON must not mask plan's exit code.
+		if err := o.printJSONPlan(ctx); err != nil {
+			fmt.Fprintf(o.Out, "Warning: %s\n", err.Error())
+		}
+	}
+
+	if err != nil {
 		return err
 	}
 
+	if o.command == "apply" && o.planFile != "" {
+		// A saved plan cannot be applied more than once
+		if err := os.Remove(o.planFile); err != nil {
+			klog.V(1).Infof("unable to remove applied plan file %s: %s", o.planFile, err.Error())
+		}
+	}
+
 	if launcher.UpdatesLockFile(o.command) {
 		// This is a command that updates the lock file (such as terraform init)
 		// so persist it to a configmap
 		if err := o.persistLockFile(ctx); err != nil {
 			return fmt.Errorf("failed to persist lock file to config map: %w", err)
 		}
+
+		if o.cacheBackupBucket != "" {
+			if err := o.backupCache(ctx); err != nil {
+				fmt.Fprintf(o.Out, "Warning: unable to back up providers: %s\n", err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// planFileArgs returns the command's args along with those necessary for plan
+// to save its plan to the plan file, or for apply to apply the saved plan.
+func (o *RunnerOptions) planFileArgs() ([]string, error) {
+	if o.planFile == "" {
+		return o.args, nil
+	}
+
+	args := append([]string{}, o.args...)
+	switch o.command {
+	case "plan":
+		pruneStalePlans(filepath.Dir(o.planFile), planTTL)
+		return append(args, "-out="+o.planFile), nil
+	case "apply":
+		if _, err := os.Stat(o.planFile); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("%w: %s", errPlanNotFound, filepath.Base(o.planFile))
+			}
+			return nil, err
+		}
+		// Terraform expects the plan file to be the last arg
+		return append(args, o.planFile), nil
+	}
+	return args, nil
+}
+
+// pruneStalePlans removes saved plans older than the ttl from the directory of
+// saved plans, lest plans that are never applied accumulate in the cache.
+// Failing to remove a plan is not fatal.
+func pruneStalePlans(dir string, ttl time.Duration) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		klog.V(1).Infof("unable to read saved plans in %s: %s", dir, err.Error())
+		return
+	}
+	for _, f := range files {
+		if f.IsDir() || time.Since(f.ModTime()) < ttl {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+			klog.V(1).Infof("unable to remove stale plan file %s: %s", f.Name(), err.Error())
+		}
 	}
+}
 
+// printJSONPlan prints the saved plan in JSON, delimited by markers so that
+// the client can separate it from the rest of the output. Nothing is printed
+// if the plan was not saved, i.e. the plan failed.
+func (o *RunnerOptions) printJSONPlan(ctx context.Context) error {
+	if o.planFile == "" {
+		return nil
+	}
+	if _, err := os.Stat(o.planFile); err != nil {
+		if os.IsNotExist(err) {
+			klog.V(1).Infof("plan file %s not found; not printing plan in JSON", o.planFile)
+			return nil
+		}
+		return err
+	}
+
+	// Markers must be on lines of their own, so precede each with a newline
+	// should the preceding output not have been terminated with one
+	begin, end := logstreamer.SectionMarkers(logstreamer.JSONPlanSection)
+	fmt.Fprintf(o.Out, "\n%s\n", begin)
+	err := o.exec.Execute(ctx, []string{"terraform", "show", "-json", o.planFile})
+	// Terminate the section regardless, lest subsequent output be mistaken
+	// for the plan
+	fmt.Fprintf(o.Out, "\n%s\n", end)
+	if err != nil {
+		return fmt.Errorf("unable to print plan in JSON: %w", err)
+	}
+	return nil
+}
+
+// selectTFWorkspace selects the terraform workspace, creating it if it doesn't
+// exist
+func (o *RunnerOptions) selectTFWorkspace(ctx context.Context) error {
+	// Output is only printed should the workspace be neither selected nor
+	// created, lest it corrupt the command's machine-readable output, e.g.
+	// output -json. Stderr is no remedy: the pod's log combines stdout and
+	// stderr.
+	var out bytes.Buffer
+	if err := o.exec.Execute(ctx, []string{"terraform", "workspace", "select", o.tfWorkspace}, executor.WithOutput(&out)); err != nil {
+		klog.V(1).Infof("unable to select terraform workspace %s: %s; creating it instead", o.tfWorkspace, err.Error())
+
+		if err := o.exec.Execute(ctx, []string{"terraform", "workspace", "new", o.tfWorkspace}, executor.WithOutput(&out)); err != nil {
+			o.ErrOut.Write(out.Bytes())
+			return fmt.Errorf("unable to create terraform workspace %s: %w", o.tfWorkspace, err)
+		}
+	}
 	return nil
 }
 
@@ -284,4 +485,5 @@ func (o *RunnerOptions) receiveHandshake(ctx context.Context) error {
 var (
 	errIncorrectHandshake = errors.New("incorrect handshake received")
 	errHandshakeTimeout   = errors.New("timed out awaiting handshake")
+	errPlanNotFound       = errors.New("saved plan not found; either the plan failed or it has already been applied")
 )
@@ -6,7 +6,9 @@ import (
 	"compress/gzip"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -85,6 +87,28 @@ func TestRunnerCommand(t *testing.T) {
 		assert.Equal(t, want, strings.TrimSpace(out.String()))
 	})
 
+	testutil.Run(t, "terraform plan with auto init", func(t *testutil.T) {
+		out, cmd, opts := setupRunnerCmd(t, "--", "-out", "plan.out")
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_COMMAND":   "plan",
+			"ETOK_WORKSPACE": "foo",
+			"ETOK_NAMESPACE": "foo",
+			"ETOK_AUTO_INIT": "true",
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		// Override executor with one that prints out cmd+args
+		opts.exec = &executor.FakeExecutorEchoArgs{Out: out}
+
+		require.NoError(t, cmd.ExecuteContext(context.Background()))
+
+		// Init's output is discarded unless it fails
+		want := "[terraform plan -out plan.out]"
+		assert.Equal(t, want, strings.TrimSpace(out.String()))
+	})
+
 	testutil.Run(t, "terraform plan with custom namespace", func(t *testutil.T) {
 		out, cmd, opts := setupRunnerCmd(t, "--", "-out", "plan.out")
 
@@ -125,6 +149,81 @@ func TestRunnerCommand(t *testing.T) {
 		assert.Equal(t, want, strings.TrimSpace(out.String()))
 	})
 
+	testutil.Run(t, "terraform plan with tf workspace", func(t *testutil.T) {
+		out, cmd, opts := setupRunnerCmd(t, "--", "-out", "plan.out")
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_COMMAND":      "plan",
+			"ETOK_NAMESPACE":    "dev",
+			"ETOK_TF_WORKSPACE": "staging",
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		errOut := new(bytes.Buffer)
+		opts.ErrOut = errOut
+
+		// Override executor with one that prints out cmd+args
+		opts.exec = &executor.FakeExecutorEchoArgs{Out: out}
+
+		require.NoError(t, cmd.ExecuteContext(context.Background()))
+
+		assert.Equal(t, "[terraform plan -out plan.out]", strings.TrimSpace(out.String()))
+		// Selecting the workspace succeeded so its output is discarded
+		assert.Equal(t, "", errOut.String())
+	})
+
+	testutil.Run(t, "terraform plan with new tf workspace", func(t *testutil.T) {
+		out, cmd, opts := setupRunnerCmd(t, "--", "-out", "plan.out")
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_COMMAND":      "plan",
+			"ETOK_NAMESPACE":    "dev",
+			"ETOK_TF_WORKSPACE": "staging",
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		errOut := new(bytes.Buffer)
+		opts.ErrOut = errOut
+
+		// Override executor with one that fails to select a workspace
+		opts.exec = &executor.FakeExecutorMissingWorkspace{Out: out}
+
+		require.NoError(t, cmd.ExecuteContext(context.Background()))
+
+		assert.Equal(t, "[terraform plan -out plan.out]", strings.TrimSpace(out.String()))
+		// Creating the workspace succeeded so its output is discarded
+		assert.Equal(t, "", errOut.String())
+	})
+
+	testutil.Run(t, "terraform plan failing to create tf workspace", func(t *testutil.T) {
+		out, cmd, opts := setupRunnerCmd(t, "--", "-out", "plan.out")
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_COMMAND":      "plan",
+			"ETOK_NAMESPACE":    "dev",
+			"ETOK_TF_WORKSPACE": "staging",
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		errOut := new(bytes.Buffer)
+		opts.ErrOut = errOut
+
+		// Override executor with one that fails to select and create a
+		// workspace
+		opts.exec = &executor.FakeExecutorMissingWorkspace{Out: out, NewErr: errors.New("exit status 1")}
+
+		require.Error(t, cmd.ExecuteContext(context.Background()))
+
+		// Command not run
+		assert.NotContains(t, out.String(), "[terraform plan")
+		// Output of selecting and creating the workspace is printed to help
+		// diagnose the failure
+		assert.Equal(t, "[terraform workspace select staging][terraform workspace new staging]", errOut.String())
+	})
+
 	testutil.Run(t, "terraform apply", func(t *testutil.T) {
 		out, cmd, opts := setupRunnerCmd(t, "--", "-auto-approve")
 
@@ -144,6 +243,162 @@ func TestRunnerCommand(t *testing.T) {
 		want := "[terraform apply -auto-approve]"
 		assert.Equal(t, want, strings.TrimSpace(out.String()))
 	})
+
+	testutil.Run(t, "terraform plan saving plan", func(t *testutil.T) {
+		out, cmd, opts := setupRunnerCmd(t, "--", "-input=false")
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_COMMAND":   "plan",
+			"ETOK_NAMESPACE": "dev",
+			"ETOK_PLAN_FILE": "/plans/run-12345",
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		// Override executor with one that prints out cmd+args
+		opts.exec = &executor.FakeExecutorEchoArgs{Out: out}
+
+		require.NoError(t, cmd.ExecuteContext(context.Background()))
+
+		want := "[terraform plan -input=false -out=/plans/run-12345]"
+		assert.Equal(t, want, strings.TrimSpace(out.String()))
+	})
+
+	testutil.Run(t, "terraform plan pruning stale plans", func(t *testutil.T) {
+		out, cmd, opts := setupRunnerCmd(t, "--", "-input=false")
+
+		plans := t.NewTempDir().Write("run-stale", []byte("saved plan")).Write("run-fresh", []byte("saved plan")).Root()
+		stale := time.Now().Add(-2 * planTTL)
+		require.NoError(t, os.Chtimes(filepath.Join(plans, "run-stale"), stale, stale))
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_COMMAND":   "plan",
+			"ETOK_NAMESPACE": "dev",
+			"ETOK_PLAN_FILE": filepath.Join(plans, "run-12345"),
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		opts.exec = &executor.FakeExecutorEchoArgs{Out: out}
+
+		require.NoError(t, cmd.ExecuteContext(context.Background()))
+
+		_, err := os.Stat(filepath.Join(plans, "run-stale"))
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(plans, "run-fresh"))
+		assert.NoError(t, err)
+	})
+
+	testutil.Run(t, "terraform plan in JSON", func(t *testutil.T) {
+		out, cmd, opts := setupRunnerCmd(t, "--", "-input=false")
+
+		planFile := filepath.Join(t.NewTempDir().Root(), "run-12345")
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_COMMAND":   "plan",
+			"ETOK_NAMESPACE": "dev",
+			"ETOK_PLAN_FILE": planFile,
+			"ETOK_JSON_PLAN": "true",
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		// Override executor with one that saves the plan and prints out
+		// cmd+args
+		opts.exec = &fakeSavePlanExecutor{out: out, planFile: planFile}
+
+		require.NoError(t, cmd.ExecuteContext(context.Background()))
+
+		want := "[terraform plan -input=false -out=" + planFile + "]\n--- etok:json-plan:begin ---\n[terraform show -json " + planFile + "]\n--- etok:json-plan:end ---"
+		assert.Equal(t, want, strings.TrimSpace(out.String()))
+	})
+
+	testutil.Run(t, "terraform plan in JSON failing to print plan", func(t *testutil.T) {
+		out, cmd, opts := setupRunnerCmd(t, "--", "-input=false", "-detailed-exitcode")
+
+		planFile := filepath.Join(t.NewTempDir().Root(), "run-12345")
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_COMMAND":   "plan",
+			"ETOK_NAMESPACE": "dev",
+			"ETOK_PLAN_FILE": planFile,
+			"ETOK_JSON_PLAN": "true",
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		// Plan saves its plan and exits with changes present, but show fails
+		planErr := errors.New("exit status 2")
+		opts.exec = &fakeSavePlanExecutor{out: out, planFile: planFile, planErr: planErr, showErr: errors.New("exit status 1")}
+
+		// Plan's exit code is retained
+		assert.Equal(t, planErr, errors.Unwrap(cmd.ExecuteContext(context.Background())))
+
+		assert.Contains(t, out.String(), "--- etok:json-plan:end ---")
+		assert.Contains(t, out.String(), "Warning: unable to print plan in JSON: exit status 1")
+	})
+
+	testutil.Run(t, "terraform plan in JSON without saved plan", func(t *testutil.T) {
+		out, cmd, opts := setupRunnerCmd(t, "--", "-input=false")
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_COMMAND":   "plan",
+			"ETOK_NAMESPACE": "dev",
+			"ETOK_PLAN_FILE": filepath.Join(t.NewTempDir().Root(), "run-12345"),
+			"ETOK_JSON_PLAN": "true",
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		// Echoes the args but doesn't save a plan, as if the plan failed
+		opts.exec = &executor.FakeExecutorEchoArgs{Out: out}
+
+		require.NoError(t, cmd.ExecuteContext(context.Background()))
+
+		assert.NotContains(t, out.String(), "json-plan")
+	})
+
+	testutil.Run(t, "terraform apply saved plan", func(t *testutil.T) {
+		out, cmd, opts := setupRunnerCmd(t, "--", "-input=false")
+
+		planFile := filepath.Join(t.NewTempDir().Write("run-12345", []byte("saved plan")).Root(), "run-12345")
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_COMMAND":   "apply",
+			"ETOK_NAMESPACE": "dev",
+			"ETOK_PLAN_FILE": planFile,
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		// Override executor with one that prints out cmd+args
+		opts.exec = &executor.FakeExecutorEchoArgs{Out: out}
+
+		require.NoError(t, cmd.ExecuteContext(context.Background()))
+
+		want := "[terraform apply -input=false " + planFile + "]"
+		assert.Equal(t, want, strings.TrimSpace(out.String()))
+
+		// Applied plan should be removed
+		_, err := os.Stat(planFile)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	testutil.Run(t, "terraform apply missing saved plan", func(t *testutil.T) {
+		_, cmd, opts := setupRunnerCmd(t)
+
+		// Set flag via env var since that's how runner is invoked on a pod
+		t.SetEnvs(map[string]string{
+			"ETOK_COMMAND":   "apply",
+			"ETOK_NAMESPACE": "dev",
+			"ETOK_PLAN_FILE": filepath.Join(t.NewTempDir().Root(), "run-12345"),
+		})
+		envvars.SetFlagsFromEnvVariables(cmd)
+
+		opts.exec = &executor.FakeExecutor{}
+
+		assert.True(t, errors.Is(cmd.ExecuteContext(context.Background()), errPlanNotFound))
+	})
 }
 
 func TestRunnerLockFile(t *testing.T) {
@@ -325,3 +580,27 @@ func (e *delayedReader) Read(p []byte) (int, error) {
 	time.Sleep(e.delay)
 	return len("opensesame"), nil
 }
+
+// fakeSavePlanExecutor mocks an executor that prints out cmd+args, and saves a
+// plan to the plan file when running plan. Plan and show return planErr and
+// showErr respectively.
+type fakeSavePlanExecutor struct {
+	out      io.Writer
+	planFile string
+	planErr  error
+	showErr  error
+}
+
+func (e *fakeSavePlanExecutor) Execute(ctx context.Context, args []string, opts ...executor.ExecOption) error {
+	fmt.Fprintf(e.out, "%v", args)
+	if len(args) > 1 && args[1] == "plan" {
+		if err := ioutil.WriteFile(e.planFile, []byte("saved plan"), 0644); err != nil {
+			return err
+		}
+		return e.planErr
+	}
+	if len(args) > 1 && args[1] == "show" {
+		return e.showErr
+	}
+	return nil
+}
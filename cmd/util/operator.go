@@ -0,0 +1,47 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/leg100/etok/pkg/k8s"
+	"github.com/leg100/etok/pkg/labels"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+var ErrOperatorNotReady = errors.New("etok operator not installed or not ready; run `etok install`")
+
+// CheckOperator checks the operator deployment exists and is available. The
+// deployment is looked up by its labels in all namespaces, because the operator
+// may be installed into any namespace. Ordinary users are not permitted to list
+// deployments cluster-wide, in which case the operator's status is unknown and
+// the check is silently skipped. If the deployments cannot be listed for any
+// other reason then a warning that the check could not be performed is written
+// to out, and the check is skipped.
+func CheckOperator(ctx context.Context, client kubernetes.Interface, out io.Writer) error {
+	selector := metav1.FormatLabelSelector(&metav1.LabelSelector{
+		MatchLabels: labels.MakeLabels(labels.App, labels.OperatorComponent),
+	})
+
+	deploys, err := client.AppsV1().Deployments("").List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if kerrors.IsForbidden(err) {
+		klog.V(1).Infof("not permitted to check the etok operator is installed and ready: %s", err.Error())
+		return nil
+	} else if err != nil {
+		fmt.Fprintf(out, "Warning: unable to check the etok operator is installed and ready: %s\n", err.Error())
+		return nil
+	}
+
+	for i := range deploys.Items {
+		if k8s.IsDeploymentAvailable(&deploys.Items[i]) {
+			return nil
+		}
+	}
+
+	return ErrOperatorNotReady
+}
@@ -1,16 +1,37 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/leg100/etok/cmd/flags"
 	cmdutil "github.com/leg100/etok/cmd/util"
 	"github.com/leg100/etok/pkg/version"
 	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+var errInvalidVersionOutput = errors.New("invalid output format: must be json")
+
+// versionInfo is the version information printed in JSON
+type versionInfo struct {
+	Client versionDetails `json:"clientVersion"`
+	// Nil if the server deployment is not found
+	Server *versionDetails `json:"serverVersion,omitempty"`
+	// Skew is true if the client and server versions differ
+	Skew bool `json:"skew"`
+}
+
+type versionDetails struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	// Image of the server deployment's operator container
+	Image string `json:"image,omitempty"`
+}
+
 func versionCmd(f *cmdutil.Factory) *cobra.Command {
 	// Default namespace of server installation
 	var namespace = "etok"
@@ -18,13 +39,26 @@ func versionCmd(f *cmdutil.Factory) *cobra.Command {
 	var name = "etok"
 	// k8s context
 	var kubeContext string
+	// Output format
+	var output string
 
 	cmd := &cobra.Command{
 		Use:   "version",
 		Short: "Print version information",
+		Long:  "Print the version of the client and of the server, i.e. the operator, warning if they differ.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Print client version
-			fmt.Fprintf(f.Out, "Client Version: %s\t%s\n", version.Version, version.Commit)
+			if output != "" && output != "json" {
+				return fmt.Errorf("%w: %s", errInvalidVersionOutput, output)
+			}
+
+			info := versionInfo{
+				Client: versionDetails{Version: version.Version, Commit: version.Commit},
+			}
+
+			if output == "" {
+				// Print client version
+				fmt.Fprintf(f.Out, "Client Version: %s\t%s\n", info.Client.Version, info.Client.Commit)
+			}
 
 			// Try and print server version
 			client, err := f.Create(kubeContext)
@@ -33,31 +67,41 @@ func versionCmd(f *cmdutil.Factory) *cobra.Command {
 			}
 
 			deploy, err := client.KubeClient.AppsV1().Deployments(namespace).Get(cmd.Context(), name, metav1.GetOptions{})
-			if kerrors.IsNotFound(err) {
-				fmt.Fprintf(f.Out, "Server Version: deployment %s/%s not found\n", namespace, name)
-				return nil
-			}
-			if err != nil {
+			switch {
+			case kerrors.IsNotFound(err):
+				if output == "" {
+					fmt.Fprintf(f.Out, "Server Version: deployment %s/%s not found\n", namespace, name)
+					return nil
+				}
+			case err != nil:
 				return fmt.Errorf("unable to determine server version: %w", err)
+			default:
+				info.Server, err = serverVersion(deploy)
+				if err != nil {
+					return err
+				}
+				info.Skew = info.Server.Version != info.Client.Version
 			}
 
-			lbls := deploy.GetLabels()
-			if lbls == nil {
-				return fmt.Errorf("unexpectedly found no labels on server deployment")
+			if output == "json" {
+				data, err := json.MarshalIndent(info, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(f.Out, string(data))
+				return nil
 			}
 
-			v, ok := lbls["version"]
-			if !ok {
-				return fmt.Errorf("version label missing on server deployment")
+			fmt.Fprintf(f.Out, "Server Version: %s\t%s\n", info.Server.Version, info.Server.Commit)
+			if info.Server.Image != "" {
+				fmt.Fprintf(f.Out, "Server Image: %s\n", info.Server.Image)
 			}
-
-			c, ok := lbls["commit"]
-			if !ok {
-				return fmt.Errorf("commit label missing on server deployment")
+			if info.Skew {
+				// Skew can lead to the operator reconciling resources it
+				// doesn't understand, or vice versa
+				fmt.Fprintf(f.Out, "Warning: client version %s differs from server version %s; re-run 'etok install' with this client or install client version %s\n", info.Client.Version, info.Server.Version, info.Server.Version)
 			}
 
-			fmt.Fprintf(f.Out, "Server Version: %s\t%s\n", v, c)
-
 			return nil
 		},
 	}
@@ -65,6 +109,34 @@ func versionCmd(f *cmdutil.Factory) *cobra.Command {
 	flags.AddKubeContextFlag(cmd, &kubeContext)
 	cmd.Flags().StringVarP(&namespace, "namespace", "n", namespace, "Kubernetes namespace of server installation")
 	cmd.Flags().StringVar(&name, "name", name, "Name of server deployment")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output format. One of: json")
 
 	return cmd
 }
+
+// serverVersion retrieves the server's version from the labels and image of
+// its deployment
+func serverVersion(deploy *appsv1.Deployment) (*versionDetails, error) {
+	lbls := deploy.GetLabels()
+	if lbls == nil {
+		return nil, fmt.Errorf("unexpectedly found no labels on server deployment")
+	}
+
+	v, ok := lbls["version"]
+	if !ok {
+		return nil, fmt.Errorf("version label missing on server deployment")
+	}
+
+	c, ok := lbls["commit"]
+	if !ok {
+		return nil, fmt.Errorf("commit label missing on server deployment")
+	}
+
+	details := &versionDetails{Version: v, Commit: c}
+	for _, container := range deploy.Spec.Template.Spec.Containers {
+		if container.Name == "operator" {
+			details.Image = container.Image
+		}
+	}
+	return details, nil
+}
@@ -3,11 +3,13 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"strings"
 	"testing"
 
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
@@ -15,6 +17,7 @@ import (
 	"github.com/leg100/etok/pkg/testutil"
 	"github.com/leg100/etok/pkg/version"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestVersion(t *testing.T) {
@@ -44,6 +47,52 @@ func TestVersion(t *testing.T) {
 				assert.Equal(t, "Server Version: 124\tabc", out[1])
 			},
 		},
+		{
+			name: "with matching server install",
+			objs: []runtime.Object{serverDeploy("123", "xyz", "leg100/etok:123")},
+			assertions: func(t *testutil.T, out ...string) {
+				assert.Equal(t, "Server Version: 123\txyz", out[1])
+				assert.Equal(t, "Server Image: leg100/etok:123", out[2])
+				assert.Equal(t, "", out[3])
+			},
+		},
+		{
+			name: "with skewed server install",
+			objs: []runtime.Object{serverDeploy("124", "abc", "leg100/etok:124")},
+			assertions: func(t *testutil.T, out ...string) {
+				assert.Equal(t, "Server Image: leg100/etok:124", out[2])
+				assert.Contains(t, out[3], "Warning: client version 123 differs from server version 124")
+			},
+		},
+		{
+			name: "json",
+			args: []string{"-o", "json"},
+			objs: []runtime.Object{serverDeploy("124", "abc", "leg100/etok:124")},
+			assertions: func(t *testutil.T, out ...string) {
+				var info versionInfo
+				require.NoError(t, json.Unmarshal([]byte(strings.Join(out, "\n")), &info))
+				assert.Equal(t, versionInfo{
+					Client: versionDetails{Version: "123", Commit: "xyz"},
+					Server: &versionDetails{Version: "124", Commit: "abc", Image: "leg100/etok:124"},
+					Skew:   true,
+				}, info)
+			},
+		},
+		{
+			name: "json without server install",
+			args: []string{"-o", "json"},
+			assertions: func(t *testutil.T, out ...string) {
+				var info versionInfo
+				require.NoError(t, json.Unmarshal([]byte(strings.Join(out, "\n")), &info))
+				assert.Nil(t, info.Server)
+				assert.False(t, info.Skew)
+			},
+		},
+		{
+			name: "invalid output format",
+			args: []string{"-o", "yaml"},
+			err:  errInvalidVersionOutput,
+		},
 		{
 			name: "without server install",
 			assertions: func(t *testutil.T, out ...string) {
@@ -77,3 +126,23 @@ func TestVersion(t *testing.T) {
 		})
 	}
 }
+
+func serverDeploy(version, commit, image string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etok",
+			Namespace: "etok",
+			Labels: map[string]string{
+				"version": version,
+				"commit":  commit,
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "operator", Image: image}},
+				},
+			},
+		},
+	}
+}
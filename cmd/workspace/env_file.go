@@ -0,0 +1,115 @@
+package workspace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseEnvFile parses environment variables from a file in the dotenv format,
+// i.e. a KEY=VALUE pair per line. Blank lines and comments beginning with # are
+// skipped, as is an optional 'export' preceding the key. A value may be
+// enclosed in single quotes, within which it is taken literally, or double
+// quotes, within which the escape sequences \n, \t, \" and \\ are expanded.
+// An unquoted value is trimmed of whitespace, and of any comment following
+// whitespace.
+func parseEnvFile(r io.Reader) (map[string]string, error) {
+	vars := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: line %d: expected KEY=VALUE", errInvalidEnvFile, n)
+		}
+
+		key := strings.TrimSpace(strings.TrimPrefix(parts[0], "export "))
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%w: line %d: invalid key %q", errInvalidEnvFile, n, key)
+		}
+
+		value, err := parseEnvFileValue(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %s", errInvalidEnvFile, n, err.Error())
+		}
+
+		vars[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+// parseEnvFileValue parses the value of a KEY=VALUE pair, removing any quotes
+// and trailing comment
+func parseEnvFileValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch quote := value[0]; quote {
+	case '\'':
+		end := strings.IndexByte(value[1:], quote)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		if err := checkTrailing(value[end+2:]); err != nil {
+			return "", err
+		}
+		return value[1 : end+1], nil
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			switch c := value[i]; c {
+			case '\\':
+				if i+1 == len(value) {
+					return "", fmt.Errorf("unterminated quoted value")
+				}
+				i++
+				switch value[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					// Includes \" and \\
+					b.WriteByte(value[i])
+				}
+			case '"':
+				if err := checkTrailing(value[i+1:]); err != nil {
+					return "", err
+				}
+				return b.String(), nil
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated quoted value")
+	}
+
+	// Strip comment from unquoted value
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	if i := strings.Index(value, "\t#"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// checkTrailing checks that nothing other than a comment follows a quoted value
+func checkTrailing(s string) error {
+	s = strings.TrimSpace(s)
+	if s != "" && !strings.HasPrefix(s, "#") {
+		return fmt.Errorf("unexpected characters after quoted value: %s", s)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package workspace
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		err     error
+	}{
+		{
+			name:    "empty",
+			content: "",
+			want:    map[string]string{},
+		},
+		{
+			name:    "unquoted values",
+			content: "AWS_REGION=eu-west-2\nTF_LOG=DEBUG\n",
+			want:    map[string]string{"AWS_REGION": "eu-west-2", "TF_LOG": "DEBUG"},
+		},
+		{
+			name:    "comments and blank lines",
+			content: "# provider credentials\n\nAWS_REGION=eu-west-2 # london\n  # indented comment\nCOLOR=#fff\n",
+			want:    map[string]string{"AWS_REGION": "eu-west-2", "COLOR": "#fff"},
+		},
+		{
+			name:    "export prefix",
+			content: "export AWS_REGION=eu-west-2\n",
+			want:    map[string]string{"AWS_REGION": "eu-west-2"},
+		},
+		{
+			name:    "whitespace around key and value",
+			content: "  AWS_REGION = eu-west-2  \n",
+			want:    map[string]string{"AWS_REGION": "eu-west-2"},
+		},
+		{
+			name:    "empty value",
+			content: "TF_LOG=\n",
+			want:    map[string]string{"TF_LOG": ""},
+		},
+		{
+			name:    "value containing equals sign",
+			content: "TF_CLI_ARGS_plan=-var=env=prod\n",
+			want:    map[string]string{"TF_CLI_ARGS_plan": "-var=env=prod"},
+		},
+		{
+			name:    "single quoted value",
+			content: `GREETING='hello # world \n' # comment` + "\n",
+			want:    map[string]string{"GREETING": `hello # world \n`},
+		},
+		{
+			name:    "double quoted value",
+			content: `GREETING="hello \"world\"\n\tbye\\" # comment` + "\n",
+			want:    map[string]string{"GREETING": "hello \"world\"\n\tbye\\"},
+		},
+		{
+			name:    "later entries override earlier entries",
+			content: "TF_LOG=DEBUG\nTF_LOG=TRACE\n",
+			want:    map[string]string{"TF_LOG": "TRACE"},
+		},
+		{
+			name:    "missing equals sign",
+			content: "AWS_REGION\n",
+			err:     errInvalidEnvFile,
+		},
+		{
+			name:    "missing key",
+			content: "=eu-west-2\n",
+			err:     errInvalidEnvFile,
+		},
+		{
+			name:    "key containing whitespace",
+			content: "AWS REGION=eu-west-2\n",
+			err:     errInvalidEnvFile,
+		},
+		{
+			name:    "unterminated quoted value",
+			content: `GREETING="hello` + "\n",
+			err:     errInvalidEnvFile,
+		},
+		{
+			name:    "characters after quoted value",
+			content: `GREETING="hello" world` + "\n",
+			err:     errInvalidEnvFile,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEnvFile(strings.NewReader(tt.content))
+			if !assert.True(t, errors.Is(err, tt.err)) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -23,6 +23,21 @@ func WorkspaceCmd(f *cmdutil.Factory) *cobra.Command {
 	nc, _ := newCmd(f)
 	cmd.AddCommand(nc)
 
+	ec, _ := editCmd(f)
+	cmd.AddCommand(ec)
+
+	sc, _ := statusCmd(f)
+	cmd.AddCommand(sc)
+
+	pc, _ := pauseCmd(f)
+	cmd.AddCommand(pc)
+
+	rc, _ := resumeCmd(f)
+	cmd.AddCommand(rc)
+
+	lrc, _ := listRunsCmd(f)
+	cmd.AddCommand(lrc)
+
 	cmd.AddCommand(
 		listCmd(f),
 		deleteCmd(f),
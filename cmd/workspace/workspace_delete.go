@@ -1,11 +1,16 @@
 package workspace
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/leg100/etok/cmd/flags"
 	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/controllers"
+	"github.com/leg100/etok/pkg/env"
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -13,7 +18,8 @@ import (
 )
 
 func deleteCmd(f *cmdutil.Factory) *cobra.Command {
-	var kubeContext string
+	var path, kubeContext string
+	var deleteSecret, deleteServiceAccount bool
 	var namespace = defaultNamespace
 
 	cmd := &cobra.Command{
@@ -29,31 +35,83 @@ func deleteCmd(f *cmdutil.Factory) *cobra.Command {
 			}
 
 			if err := client.WorkspacesClient(namespace).Delete(cmd.Context(), ws, metav1.DeleteOptions{}); err != nil {
-				return fmt.Errorf("failed to delete workspace: %w", err)
+				if !errors.IsNotFound(err) {
+					return fmt.Errorf("failed to delete workspace: %w", err)
+				}
+				// Already deleted, nothing to wait for
+				fmt.Fprintf(f.Out, "Workspace %s/%s not found\n", namespace, ws)
+			} else {
+				fmt.Fprintln(f.Out, "Waiting for workspace and its dependent resources to be deleted...")
+				err = wait.PollImmediate(time.Second, 60*time.Second, func() (bool, error) {
+					if _, err := client.WorkspacesClient(namespace).Get(cmd.Context(), ws, metav1.GetOptions{}); err != nil {
+						if errors.IsNotFound(err) {
+							return true, nil
+						}
+						return false, fmt.Errorf("waiting for workspace to be deleted: %w", err)
+					}
+					return false, nil
+				})
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(f.Out, "Deleted workspace %s/%s\n", namespace, ws)
 			}
 
-			fmt.Println("Waiting for workspace and its dependent resources to be deleted...")
-			err = wait.PollImmediate(time.Second, 60*time.Second, func() (bool, error) {
-				if _, err := client.WorkspacesClient(namespace).Get(cmd.Context(), ws, metav1.GetOptions{}); err != nil {
-					if errors.IsNotFound(err) {
-						return true, nil
-					}
-					return false, fmt.Errorf("waiting for workspace to be deleted: %w", err)
+			if deleteSecret {
+				if err := deleteSecretIfExists(cmd.Context(), f, client, namespace); err != nil {
+					return err
 				}
-				return false, nil
-			})
-			if err != nil {
-				return err
 			}
 
-			fmt.Printf("Deleted workspace %s/%s\n", namespace, ws)
+			if deleteServiceAccount {
+				if err := deleteServiceAccountIfExists(cmd.Context(), f, client, namespace); err != nil {
+					return err
+				}
+			}
+
+			// Warn user if they've deleted their current workspace
+			etokenv, err := env.Read(path)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					return err
+				}
+			} else if etokenv.Namespace == namespace && etokenv.Workspace == ws {
+				fmt.Fprintf(f.ErrOut, "Warning: deleted workspace %s is the current workspace; select another workspace with 'etok workspace select'\n", etokenv)
+			}
 
 			return nil
 		},
 	}
 
+	flags.AddPathFlag(cmd, &path)
 	flags.AddNamespaceFlag(cmd, &namespace)
 	flags.AddKubeContextFlag(cmd, &kubeContext)
 
+	cmd.Flags().BoolVar(&deleteSecret, "delete-secret", false, "Delete the etok secret in the namespace (shared by all workspaces in the namespace)")
+	cmd.Flags().BoolVar(&deleteServiceAccount, "delete-service-account", false, "Delete the etok service account in the namespace (shared by all workspaces in the namespace)")
+
 	return cmd
 }
+
+func deleteSecretIfExists(ctx context.Context, f *cmdutil.Factory, c *client.Client, namespace string) error {
+	if err := c.SecretsClient(namespace).Delete(ctx, "etok", metav1.DeleteOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+	fmt.Fprintf(f.Out, "Deleted secret %s/%s\n", namespace, "etok")
+	return nil
+}
+
+func deleteServiceAccountIfExists(ctx context.Context, f *cmdutil.Factory, c *client.Client, namespace string) error {
+	if err := c.ServiceAccountsClient(namespace).Delete(ctx, controllers.ServiceAccountName, metav1.DeleteOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete service account: %w", err)
+	}
+	fmt.Fprintf(f.Out, "Deleted service account %s/%s\n", namespace, controllers.ServiceAccountName)
+	return nil
+}
@@ -6,37 +6,104 @@ import (
 	"testing"
 
 	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/env"
 	"github.com/leg100/etok/pkg/testobj"
 	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
 func TestDeleteWorkspace(t *testing.T) {
 	tests := []struct {
-		name string
-		args []string
-		objs []runtime.Object
-		err  bool
+		name       string
+		args       []string
+		objs       []runtime.Object
+		env        *env.Env
+		err        bool
+		out        string
+		assertions func(*testutil.T, *cmdutil.Factory)
 	}{
 		{
 			name: "With workspace",
 			args: []string{"workspace-1"},
 			objs: []runtime.Object{testobj.Workspace("default", "workspace-1")},
+			out:  "Waiting for workspace and its dependent resources to be deleted...\nDeleted workspace default/workspace-1\n",
 		},
 		{
+			// Deleting a non-existent workspace is not an error
 			name: "Without workspace",
 			args: []string{"workspace-1"},
-			err:  true,
+			out:  "Workspace default/workspace-1 not found\n",
+		},
+		{
+			name: "Delete secret and service account",
+			args: []string{"workspace-1", "--delete-secret", "--delete-service-account"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "workspace-1"),
+				testobj.Secret("default", "etok"),
+				&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "etok"}},
+			},
+			assertions: func(t *testutil.T, f *cmdutil.Factory) {
+				assert.Contains(t, f.Out.(*bytes.Buffer).String(), "Deleted secret default/etok\n")
+				assert.Contains(t, f.Out.(*bytes.Buffer).String(), "Deleted service account default/etok\n")
+			},
+		},
+		{
+			name: "Retain secret and service account",
+			args: []string{"workspace-1"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "workspace-1"),
+				testobj.Secret("default", "etok"),
+				&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "etok"}},
+			},
+			assertions: func(t *testutil.T, f *cmdutil.Factory) {
+				assert.NotContains(t, f.Out.(*bytes.Buffer).String(), "Deleted secret")
+				assert.NotContains(t, f.Out.(*bytes.Buffer).String(), "Deleted service account")
+			},
+		},
+		{
+			name: "Delete non-existent secret and service account",
+			args: []string{"workspace-1", "--delete-secret", "--delete-service-account"},
+			objs: []runtime.Object{testobj.Workspace("default", "workspace-1")},
+		},
+		{
+			name: "Warn about current workspace",
+			args: []string{"workspace-1"},
+			objs: []runtime.Object{testobj.Workspace("default", "workspace-1")},
+			env:  &env.Env{Namespace: "default", Workspace: "workspace-1"},
+			assertions: func(t *testutil.T, f *cmdutil.Factory) {
+				assert.Contains(t, f.ErrOut.(*bytes.Buffer).String(), "Warning: deleted workspace default/workspace-1 is the current workspace")
+				assert.NotContains(t, f.Out.(*bytes.Buffer).String(), "Warning")
+			},
 		},
 	}
 	for _, tt := range tests {
 		testutil.Run(t, tt.name, func(t *testutil.T) {
+			path := t.NewTempDir().Chdir().Root()
+
+			// Write .terraform/environment
+			if tt.env != nil {
+				require.NoError(t, tt.env.Write(path))
+			}
+
 			f := cmdutil.NewFakeFactory(new(bytes.Buffer), tt.objs...)
+			f.ErrOut = new(bytes.Buffer)
 
 			cmd := deleteCmd(f)
 			cmd.SetArgs(tt.args)
 			cmd.SetOut(f.Out)
 			t.CheckError(tt.err, cmd.ExecuteContext(context.Background()))
+
+			if tt.out != "" {
+				assert.Equal(t, tt.out, f.Out.(*bytes.Buffer).String())
+			}
+
+			if tt.assertions != nil {
+				tt.assertions(t, f)
+			}
 		})
 	}
 }
@@ -0,0 +1,172 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/cmd/flags"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/tfversion"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+var errNothingToEdit = errors.New("nothing to edit: specify at least one of --terraform-version, --size, --variables")
+
+type editOptions struct {
+	*cmdutil.Factory
+
+	*client.Client
+
+	namespace   string
+	workspace   string
+	kubeContext string
+
+	terraformVersion string
+	size             string
+	variables        map[string]string
+
+	// Recall which flags were passed, so only those fields are edited
+	editTerraformVersion, editSize, editVariables bool
+}
+
+func editCmd(f *cmdutil.Factory) (*cobra.Command, *editOptions) {
+	o := &editOptions{
+		Factory:   f,
+		namespace: defaultNamespace,
+	}
+	cmd := &cobra.Command{
+		Use:   "edit <workspace>",
+		Short: "Edit an etok workspace",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			o.workspace = args[0]
+
+			o.editTerraformVersion = flags.IsFlagPassed(cmd.Flags(), "terraform-version")
+			o.editSize = flags.IsFlagPassed(cmd.Flags(), "size")
+			o.editVariables = flags.IsFlagPassed(cmd.Flags(), "variables")
+
+			if !o.editTerraformVersion && !o.editSize && !o.editVariables {
+				return errNothingToEdit
+			}
+
+			if o.editTerraformVersion {
+				if err := tfversion.Validate(o.terraformVersion); err != nil {
+					return err
+				}
+			}
+
+			if o.editSize {
+				if _, err := resource.ParseQuantity(o.size); err != nil {
+					return fmt.Errorf("invalid size: %s: %w", o.size, err)
+				}
+			}
+
+			o.Client, err = f.Create(o.kubeContext)
+			if err != nil {
+				return err
+			}
+
+			return o.run(cmd.Context())
+		},
+	}
+
+	flags.AddNamespaceFlag(cmd, &o.namespace)
+	flags.AddKubeContextFlag(cmd, &o.kubeContext)
+
+	cmd.Flags().StringVar(&o.terraformVersion, "terraform-version", "", "Override terraform version, either an exact version or a constraint, e.g. \">= 1.3, < 1.5\"")
+	cmd.Flags().StringVar(&o.size, "size", "", "Size of PersistentVolume for cache")
+	cmd.Flags().StringToStringVar(&o.variables, "variables", map[string]string{}, "Set terraform variables (existing variables not specified are retained)")
+
+	return cmd, o
+}
+
+func (o *editOptions) run(ctx context.Context) error {
+	var old, updated *v1alpha1.Workspace
+
+	// Retry upon conflict, i.e. the workspace was updated by someone else (or
+	// the operator) in between getting and updating it
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() (err error) {
+		old, err = o.WorkspacesClient(o.namespace).Get(ctx, o.workspace, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		ws := old.DeepCopy()
+		o.edit(ws)
+
+		updated, err = o.WorkspacesClient(o.namespace).Update(ctx, ws, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update workspace: %w", err)
+	}
+
+	fmt.Fprintf(o.Out, "Updated workspace %s\n", klog.KObj(updated))
+
+	// Some changes only take effect once a resource is re-created
+	if old.Spec.TerraformVersion != updated.Spec.TerraformVersion {
+		fmt.Fprintf(o.ErrOut, "Warning: terraform version is only installed when the workspace pod is created; delete pod %s/%s to apply the change\n", updated.Namespace, updated.PodName())
+	}
+	if old.Spec.Cache.Size != updated.Spec.Cache.Size {
+		fmt.Fprintf(o.ErrOut, "Warning: cache size is only set when the cache is created; re-create the workspace to apply the change\n")
+	}
+
+	return nil
+}
+
+// edit applies the flag-specified changes to the workspace. Variables take
+// effect for subsequent runs.
+func (o *editOptions) edit(ws *v1alpha1.Workspace) {
+	if o.editTerraformVersion {
+		ws.Spec.TerraformVersion = o.terraformVersion
+	}
+
+	if o.editSize {
+		ws.Spec.Cache.Size = o.size
+	}
+
+	if o.editVariables {
+		// Sort keys to ensure new variables are appended in a consistent order
+		keys := make([]string, 0, len(o.variables))
+		for k := range o.variables {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			setVariable(ws, k, o.variables[k])
+		}
+	}
+}
+
+// setVariable sets the value of an existing terraform variable or otherwise
+// adds a new terraform variable
+func setVariable(ws *v1alpha1.Workspace, key, value string) {
+	for _, v := range ws.Spec.Variables {
+		if v.Key == key && !v.EnvironmentVariable {
+			v.Value = value
+			return
+		}
+	}
+	ws.Spec.Variables = append(ws.Spec.Variables, &v1alpha1.Variable{Key: key, Value: value})
+}
+
+// setEnvironmentVariable sets the value of an existing environment variable or
+// otherwise adds a new environment variable
+func setEnvironmentVariable(ws *v1alpha1.Workspace, key, value string) {
+	for _, v := range ws.Spec.Variables {
+		if v.Key == key && v.EnvironmentVariable {
+			v.Value = value
+			return
+		}
+	}
+	ws.Spec.Variables = append(ws.Spec.Variables, &v1alpha1.Variable{Key: key, Value: value, EnvironmentVariable: true})
+}
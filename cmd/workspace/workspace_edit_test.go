@@ -0,0 +1,152 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	testclient "k8s.io/client-go/testing"
+)
+
+func TestEditWorkspace(t *testing.T) {
+	tests := []struct {
+		name             string
+		args             []string
+		objs             []runtime.Object
+		err              bool
+		out              string
+		errOut           string
+		factoryOverrides func(*cmdutil.Factory)
+		assertions       func(*testutil.T, *v1alpha1.Workspace)
+	}{
+		{
+			name: "missing workspace name",
+			args: []string{"--size", "2Gi"},
+			err:  true,
+		},
+		{
+			name: "nothing to edit",
+			args: []string{"foo"},
+			objs: []runtime.Object{testobj.Workspace("default", "foo")},
+			err:  true,
+		},
+		{
+			name: "workspace not found",
+			args: []string{"foo", "--size", "2Gi"},
+			err:  true,
+		},
+		{
+			name: "invalid size",
+			args: []string{"foo", "--size", "lots"},
+			objs: []runtime.Object{testobj.Workspace("default", "foo")},
+			err:  true,
+		},
+		{
+			name:   "edit terraform version",
+			args:   []string{"foo", "--terraform-version", "0.14.3"},
+			objs:   []runtime.Object{testobj.Workspace("default", "foo", testobj.WithTerraformVersion("0.13.5"))},
+			out:    "Updated workspace default/foo\n",
+			errOut: "Warning: terraform version is only installed when the workspace pod is created; delete pod default/workspace-foo to apply the change\n",
+			assertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, "0.14.3", ws.Spec.TerraformVersion)
+			},
+		},
+		{
+			name: "invalid terraform version constraint",
+			args: []string{"foo", "--terraform-version", "latest"},
+			objs: []runtime.Object{testobj.Workspace("default", "foo")},
+			err:  true,
+		},
+		{
+			name:   "edit cache size",
+			args:   []string{"foo", "--size", "2Gi"},
+			objs:   []runtime.Object{testobj.Workspace("default", "foo")},
+			out:    "Updated workspace default/foo\n",
+			errOut: "Warning: cache size is only set when the cache is created; re-create the workspace to apply the change\n",
+			assertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, "2Gi", ws.Spec.Cache.Size)
+			},
+		},
+		{
+			name: "edit variables",
+			args: []string{"foo", "--variables", "region=europe-west2,zone=europe-west2-a"},
+			objs: []runtime.Object{testobj.Workspace("default", "foo", testobj.WithVariables("region", "us-central1"))},
+			out:  "Updated workspace default/foo\n",
+			assertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, []*v1alpha1.Variable{
+					{Key: "region", Value: "europe-west2"},
+					{Key: "zone", Value: "europe-west2-a"},
+				}, ws.Spec.Variables)
+			},
+		},
+		{
+			name: "edit workspace in non-default namespace",
+			args: []string{"foo", "--namespace", "bar", "--variables", "region=europe-west2"},
+			objs: []runtime.Object{testobj.Workspace("bar", "foo")},
+			out:  "Updated workspace bar/foo\n",
+		},
+		{
+			name:   "retry upon conflict",
+			args:   []string{"foo", "--size", "2Gi"},
+			objs:   []runtime.Object{testobj.Workspace("default", "foo")},
+			out:    "Updated workspace default/foo\n",
+			errOut: "Warning: cache size is only set when the cache is created; re-create the workspace to apply the change\n",
+			factoryOverrides: func(f *cmdutil.Factory) {
+				// Fail first update with a conflict
+				var conflicted bool
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("update", "workspaces", func(action testclient.Action) (bool, runtime.Object, error) {
+					if conflicted {
+						return false, nil, nil
+					}
+					conflicted = true
+					return true, nil, kerrors.NewConflict(schema.GroupResource{Group: "etok.dev", Resource: "workspaces"}, "foo", nil)
+				})
+			},
+			assertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, "2Gi", ws.Spec.Cache.Size)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			out := new(bytes.Buffer)
+			errOut := new(bytes.Buffer)
+			f := cmdutil.NewFakeFactory(out, tt.objs...)
+			f.ErrOut = errOut
+
+			if tt.factoryOverrides != nil {
+				tt.factoryOverrides(f)
+			}
+
+			cmd, opts := editCmd(f)
+			cmd.SetOut(out)
+			cmd.SetArgs(tt.args)
+
+			t.CheckError(tt.err, cmd.ExecuteContext(context.Background()))
+
+			if tt.out != "" {
+				assert.Equal(t, tt.out, out.String())
+			}
+			assert.Equal(t, tt.errOut, errOut.String())
+
+			if tt.assertions != nil {
+				ws, err := opts.WorkspacesClient(opts.namespace).Get(context.Background(), opts.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				tt.assertions(t, ws)
+			}
+		})
+	}
+}
@@ -1,25 +1,34 @@
 package workspace
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
 	"github.com/leg100/etok/cmd/flags"
 	cmdutil "github.com/leg100/etok/cmd/util"
 	"github.com/leg100/etok/pkg/env"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
 )
 
 func listCmd(f *cmdutil.Factory) *cobra.Command {
-	var path, kubeContext string
+	var path, kubeContext, output string
+	var allNamespaces bool
 	var namespace = defaultNamespace
-	var workspace = defaultWorkspace
+	var current = &env.Env{Namespace: defaultNamespace, Workspace: defaultWorkspace}
 
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all workspaces",
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err := validateOutputFormat(output); err != nil {
+				return err
+			}
+
 			client, err := f.Create(kubeContext)
 			if err != nil {
 				return err
@@ -32,24 +41,38 @@ func listCmd(f *cmdutil.Factory) *cobra.Command {
 				}
 			} else {
 				// Override defaults
-				namespace = etokenv.Namespace
-				workspace = etokenv.Workspace
+				current = etokenv
+				if !flags.IsFlagPassed(cmd.Flags(), "namespace") {
+					namespace = etokenv.Namespace
+				}
+			}
+
+			listNamespace := namespace
+			if allNamespaces {
+				// List across all namespaces
+				listNamespace = ""
 			}
 
-			// List across all namespaces
-			workspaces, err := client.WorkspacesClient("").List(cmd.Context(), metav1.ListOptions{})
+			workspaces, err := client.WorkspacesClient(listNamespace).List(cmd.Context(), metav1.ListOptions{})
 			if err != nil {
 				return err
 			}
 
+			switch output {
+			case "json":
+				return printJSON(f, workspaces)
+			case "yaml":
+				return printYAML(f, workspaces)
+			}
+
 			var prefix string
 			for _, ws := range workspaces.Items {
-				if ws.Namespace == namespace && ws.Name == workspace {
+				if ws.Namespace == current.Namespace && ws.Name == current.Workspace {
 					prefix = "*"
 				} else {
 					prefix = ""
 				}
-				fmt.Fprintf(f.Out, "%s\t%s\n", prefix, &env.Env{Namespace: ws.Namespace, Workspace: ws.Name})
+				fmt.Fprintf(f.Out, "%s\t%s\t%d\t%s\n", prefix, &env.Env{Namespace: ws.Namespace, Workspace: ws.Name}, len(ws.Status.Queue), readyStatus(&ws))
 			}
 
 			return nil
@@ -57,7 +80,45 @@ func listCmd(f *cmdutil.Factory) *cobra.Command {
 	}
 
 	flags.AddPathFlag(cmd, &path)
+	flags.AddNamespaceFlag(cmd, &namespace)
 	flags.AddKubeContextFlag(cmd, &kubeContext)
 
+	cmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "List workspaces across all namespaces")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output format. One of: json|yaml")
+
 	return cmd
 }
+
+// readyStatus returns the status of the workspace's ready condition
+func readyStatus(ws *v1alpha1.Workspace) string {
+	ready := meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition)
+	if ready == nil {
+		return string(metav1.ConditionUnknown)
+	}
+	return string(ready.Status)
+}
+
+func validateOutputFormat(output string) error {
+	if output != "" && output != "json" && output != "yaml" {
+		return fmt.Errorf("invalid output format: %s: must be one of json, yaml", output)
+	}
+	return nil
+}
+
+func printJSON(f *cmdutil.Factory, obj interface{}) error {
+	data, err := json.MarshalIndent(obj, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(f.Out, string(data))
+	return nil
+}
+
+func printYAML(f *cmdutil.Factory, obj interface{}) error {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(f.Out, string(data))
+	return nil
+}
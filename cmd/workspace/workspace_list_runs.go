@@ -0,0 +1,115 @@
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/cmd/flags"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/env"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type listRunsOptions struct {
+	*cmdutil.Factory
+
+	*client.Client
+
+	path        string
+	namespace   string
+	workspace   string
+	kubeContext string
+
+	// Only list runs created by this user
+	user string
+
+	output string
+}
+
+func listRunsCmd(f *cmdutil.Factory) (*cobra.Command, *listRunsOptions) {
+	o := &listRunsOptions{
+		Factory:   f,
+		namespace: defaultNamespace,
+	}
+	cmd := &cobra.Command{
+		Use:   "list-runs [<workspace>]",
+		Short: "List the runs of a workspace",
+		Long:  "List the runs of a workspace, defaulting to the current workspace, along with the user that created each run. With --user, only the runs created by that user are listed.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err := validateOutputFormat(o.output); err != nil {
+				return err
+			}
+
+			if len(args) > 0 {
+				o.workspace = args[0]
+			} else {
+				etokenv, err := env.Read(o.path)
+				if err != nil {
+					if !os.IsNotExist(err) {
+						return fmt.Errorf("failed reading contents of %s: %w", o.path, err)
+					}
+					// no .terraform/environment, so use defaults
+					etokenv = &env.Env{Namespace: defaultNamespace, Workspace: defaultWorkspace}
+				}
+				if !flags.IsFlagPassed(cmd.Flags(), "namespace") {
+					o.namespace = etokenv.Namespace
+				}
+				o.workspace = etokenv.Workspace
+			}
+
+			o.Client, err = f.Create(o.kubeContext)
+			if err != nil {
+				return err
+			}
+
+			return o.run(cmd.Context())
+		},
+	}
+
+	flags.AddPathFlag(cmd, &o.path)
+	flags.AddNamespaceFlag(cmd, &o.namespace)
+	flags.AddKubeContextFlag(cmd, &o.kubeContext)
+
+	cmd.Flags().StringVar(&o.user, "user", "", "Only list runs created by this user")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format. One of: json|yaml")
+
+	return cmd, o
+}
+
+func (o *listRunsOptions) run(ctx context.Context) error {
+	runs, err := o.RunsClient(o.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	// The user is recorded in an annotation, which cannot be selected on, so
+	// filter client-side
+	filtered := &v1alpha1.RunList{TypeMeta: runs.TypeMeta, ListMeta: runs.ListMeta}
+	for _, run := range runs.Items {
+		if run.Workspace != o.workspace {
+			continue
+		}
+		if o.user != "" && run.User() != o.user {
+			continue
+		}
+		filtered.Items = append(filtered.Items, run)
+	}
+
+	switch o.output {
+	case "json":
+		return printJSON(o.Factory, filtered)
+	case "yaml":
+		return printYAML(o.Factory, filtered)
+	}
+
+	for _, run := range filtered.Items {
+		fmt.Fprintf(o.Out, "%s\t%s\t%s\t%s\n", run.Name, run.Command, run.Phase, run.User())
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/env"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestListRuns(t *testing.T) {
+	runs := []runtime.Object{
+		testobj.Run("default", "run-1", "plan", testobj.WithWorkspace("foo"), testobj.WithRunPhase(v1alpha1.RunPhaseCompleted), testobj.WithUser("alice")),
+		testobj.Run("default", "run-2", "apply", testobj.WithWorkspace("foo"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning), testobj.WithUser("bob")),
+		testobj.Run("default", "run-3", "plan", testobj.WithWorkspace("bar"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning), testobj.WithUser("alice")),
+		testobj.Run("dev", "run-4", "plan", testobj.WithWorkspace("foo"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning), testobj.WithUser("alice")),
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		env  *env.Env
+		objs []runtime.Object
+		err  bool
+		out  string
+		// Assertions on output, in lieu of out
+		assertions func(*testutil.T, string)
+	}{
+		{
+			name: "named workspace",
+			args: []string{"foo"},
+			objs: runs,
+			out:  "run-1\tplan\tcompleted\talice\nrun-2\tapply\trunning\tbob\n",
+		},
+		{
+			name: "current workspace",
+			env:  &env.Env{Namespace: "dev", Workspace: "foo"},
+			objs: runs,
+			out:  "run-4\tplan\trunning\talice\n",
+		},
+		{
+			name: "current workspace in namespace flag",
+			args: []string{"--namespace", "default"},
+			env:  &env.Env{Namespace: "dev", Workspace: "foo"},
+			objs: runs,
+			out:  "run-1\tplan\tcompleted\talice\nrun-2\tapply\trunning\tbob\n",
+		},
+		{
+			name: "filter by user",
+			args: []string{"foo", "--user", "alice"},
+			objs: runs,
+			out:  "run-1\tplan\tcompleted\talice\n",
+		},
+		{
+			name: "no runs by user",
+			args: []string{"foo", "--user", "carol"},
+			objs: runs,
+			out:  "",
+		},
+		{
+			name: "run without user",
+			args: []string{"foo"},
+			objs: []runtime.Object{testobj.Run("default", "run-1", "plan", testobj.WithWorkspace("foo"), testobj.WithRunPhase(v1alpha1.RunPhaseCompleted))},
+			out:  "run-1\tplan\tcompleted\t\n",
+		},
+		{
+			name: "JSON output",
+			args: []string{"foo", "--user", "bob", "-o", "json"},
+			objs: runs,
+			assertions: func(t *testutil.T, out string) {
+				var list v1alpha1.RunList
+				require.NoError(t, json.Unmarshal([]byte(out), &list))
+				if assert.Equal(t, 1, len(list.Items)) {
+					assert.Equal(t, "run-2", list.Items[0].Name)
+				}
+			},
+		},
+		{
+			name: "Invalid output format",
+			args: []string{"foo", "-o", "xml"},
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			path := t.NewTempDir().Chdir().Root()
+
+			// Write .terraform/environment
+			if tt.env != nil {
+				require.NoError(t, tt.env.Write(path))
+			}
+
+			out := new(bytes.Buffer)
+			cmd, _ := listRunsCmd(cmdutil.NewFakeFactory(out, tt.objs...))
+			cmd.SetOut(out)
+			// Leave reporting errors to the test
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			cmd.SetArgs(tt.args)
+
+			t.CheckError(tt.err, cmd.ExecuteContext(context.Background()))
+
+			if tt.assertions != nil {
+				tt.assertions(t, out.String())
+			} else {
+				assert.Equal(t, tt.out, out.String())
+			}
+		})
+	}
+}
@@ -3,8 +3,10 @@ package workspace
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"testing"
 
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
 	cmdutil "github.com/leg100/etok/cmd/util"
 	"github.com/leg100/etok/pkg/env"
 	"github.com/leg100/etok/pkg/testobj"
@@ -12,6 +14,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
 )
 
 func TestListWorkspaces(t *testing.T) {
@@ -22,6 +25,8 @@ func TestListWorkspaces(t *testing.T) {
 		env  *env.Env
 		err  bool
 		out  string
+		// Assertions on output, in lieu of out
+		assertions func(*testutil.T, string)
 	}{
 		{
 			name: "WithEnvironmentFile",
@@ -29,9 +34,9 @@ func TestListWorkspaces(t *testing.T) {
 				testobj.Workspace("default", "workspace-1"),
 				testobj.Workspace("dev", "workspace-2"),
 			},
-			args: []string{},
+			args: []string{"--all-namespaces"},
 			env:  &env.Env{Namespace: "default", Workspace: "workspace-1"},
-			out:  "*\tdefault/workspace-1\n\tdev/workspace-2\n",
+			out:  "*\tdefault/workspace-1\t0\tTrue\n\tdev/workspace-2\t0\tTrue\n",
 		},
 		{
 			name: "WithoutEnvironmentFile",
@@ -39,8 +44,68 @@ func TestListWorkspaces(t *testing.T) {
 				testobj.Workspace("default", "workspace-1"),
 				testobj.Workspace("dev", "workspace-2"),
 			},
+			args: []string{"--all-namespaces"},
+			out:  "\tdefault/workspace-1\t0\tTrue\n\tdev/workspace-2\t0\tTrue\n",
+		},
+		{
+			name: "Namespace from environment file",
+			objs: []runtime.Object{
+				testobj.Workspace("default", "workspace-1"),
+				testobj.Workspace("dev", "workspace-2"),
+			},
 			args: []string{},
-			out:  "\tdefault/workspace-1\n\tdev/workspace-2\n",
+			env:  &env.Env{Namespace: "dev", Workspace: "workspace-2"},
+			out:  "*\tdev/workspace-2\t0\tTrue\n",
+		},
+		{
+			name: "Namespace flag",
+			objs: []runtime.Object{
+				testobj.Workspace("default", "workspace-1"),
+				testobj.Workspace("dev", "workspace-2"),
+			},
+			args: []string{"--namespace", "dev"},
+			out:  "\tdev/workspace-2\t0\tTrue\n",
+		},
+		{
+			name: "Queue length",
+			objs: []runtime.Object{
+				testobj.Workspace("default", "workspace-1", testobj.WithCombinedQueue("plan-1", "plan-2", "plan-3")),
+			},
+			args: []string{},
+			out:  "\tdefault/workspace-1\t2\tTrue\n",
+		},
+		{
+			name: "JSON output",
+			objs: []runtime.Object{
+				testobj.Workspace("default", "workspace-1"),
+			},
+			args: []string{"-o", "json"},
+			assertions: func(t *testutil.T, out string) {
+				var list v1alpha1.WorkspaceList
+				require.NoError(t, json.Unmarshal([]byte(out), &list))
+				if assert.Equal(t, 1, len(list.Items)) {
+					assert.Equal(t, "workspace-1", list.Items[0].Name)
+				}
+			},
+		},
+		{
+			name: "YAML output",
+			objs: []runtime.Object{
+				testobj.Workspace("default", "workspace-1"),
+			},
+			args: []string{"-o", "yaml"},
+			assertions: func(t *testutil.T, out string) {
+				var list v1alpha1.WorkspaceList
+				require.NoError(t, yaml.Unmarshal([]byte(out), &list))
+				if assert.Equal(t, 1, len(list.Items)) {
+					assert.Equal(t, "workspace-1", list.Items[0].Name)
+				}
+			},
+		},
+		{
+			name: "Invalid output format",
+			args: []string{"-o", "xml"},
+			err:  true,
 		},
 	}
 	for _, tt := range tests {
@@ -59,10 +124,17 @@ func TestListWorkspaces(t *testing.T) {
 			cmd := listCmd(f)
 			cmd.SetArgs(tt.args)
 			cmd.SetOut(f.Out)
+			// Leave reporting errors to the test
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
 
 			t.CheckError(tt.err, cmd.ExecuteContext(context.Background()))
 
-			assert.Equal(t, tt.out, out.String())
+			if tt.assertions != nil {
+				tt.assertions(t, out.String())
+			} else {
+				assert.Equal(t, tt.out, out.String())
+			}
 		})
 	}
 }
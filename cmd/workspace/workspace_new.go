@@ -1,9 +1,17 @@
 package workspace
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/leg100/etok/api/etok.dev/v1alpha1"
@@ -11,36 +19,76 @@ import (
 	cmdutil "github.com/leg100/etok/cmd/util"
 	"github.com/leg100/etok/pkg/client"
 	"github.com/leg100/etok/pkg/controllers"
-	"github.com/leg100/etok/pkg/handlers"
-	"github.com/leg100/etok/pkg/k8s"
 	"github.com/leg100/etok/pkg/labels"
-	"github.com/leg100/etok/pkg/monitors"
+	"github.com/leg100/etok/pkg/tfversion"
+	"github.com/leg100/etok/pkg/util/slice"
+	etokworkspace "github.com/leg100/etok/pkg/workspace"
 	"github.com/spf13/cobra"
-	"golang.org/x/sync/errgroup"
+	"github.com/spf13/pflag"
 
 	"github.com/leg100/etok/pkg/env"
-	"github.com/leg100/etok/pkg/logstreamer"
 	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/wait"
-	watchtools "k8s.io/client-go/tools/watch"
 	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util/term"
+	"sigs.k8s.io/yaml"
 )
 
 const (
-	defaultReconcileTimeout = 10 * time.Second
-	defaultPodTimeout       = 60 * time.Second
-	defaultReadyTimeout     = 60 * time.Second
-	defaultCacheSize        = "1Gi"
+	defaultCacheSize      = "1Gi"
+	defaultCleanupTimeout = 10 * time.Second
+	defaultReplaceTimeout = 60 * time.Second
+
+	// Name of the secret containing credentials made available to terraform
+	// as environment variables
+	secretName = "etok"
 )
 
 var (
-	errPodTimeout       = errors.New("timed out waiting for pod to be ready")
-	errReconcileTimeout = errors.New("timed out waiting for workspace to be reconciled")
-	errReadyTimeout     = errors.New("timed out waiting for workspace to be ready")
-	errWorkspaceNameArg = errors.New("expected single argument providing the workspace name")
+	errWorkspaceNameArg  = errors.New("expected single argument providing the workspace name")
+	errInvalidName       = errors.New("invalid workspace name")
+	errInvalidDuration   = errors.New("invalid duration")
+	errInvalidToleration = errors.New("invalid toleration")
+	errInvalidAffinity   = errors.New("invalid node affinity: --node-affinity-values requires --node-affinity-key")
+	errInvalidTFLog      = errors.New("invalid terraform log level")
+
+	errInvalidBackupRetention   = errors.New("invalid backup retention: must be zero or more")
+	errInvalidMaxConcurrentRuns = errors.New("invalid max concurrent runs: must be zero or more")
+	errInvalidGracePeriod       = errors.New("invalid termination grace period: must be zero or more")
+	errInvalidSpecFile          = errors.New("invalid workspace spec file")
+	errInvalidAccessMode        = errors.New("invalid access mode")
+	errInvalidImagePullPolicy   = errors.New("invalid image pull policy")
+	errInvalidEnvFile           = errors.New("invalid env file")
+	errInvalidSecretEnv         = errors.New("invalid secret env: must be in the format KEY=VALUE or KEY=@FILE")
+	errInheritBackendNotFound   = errors.New("unable to inherit backend: workspace not found")
+	errBackendPrefixUnsupported = errors.New("backend prefix not supported by backend type")
+	errInvalidBackendFile       = errors.New("invalid backend file")
+	errReplaceApproval          = errors.New("replace requires approval: either run with a TTY or pass --auto-approve")
+	errReplaceNotConfirmed      = errors.New("replace not confirmed")
+	errReplaceDiscardsState     = errors.New("replacing the workspace would delete its state, which is neither stored in a remote backend nor backed up: pass --discard-state to replace it regardless")
+	errInvalidWaitFor           = errors.New("invalid wait for")
+	errCacheBackupBucket        = errors.New("--cache-backup requires --backup-bucket")
+	errRestoreGeneration        = errors.New("--restore-generation requires --backup-bucket with the gcs backup provider")
+	errInvalidRestoreGeneration = errors.New("invalid restore generation: must be greater than zero")
+	errRestoreGenerationBackend = errors.New("--restore-generation requires the kubernetes backend: state is only backed up from the kubernetes backend")
+	errInvalidVariablesFrom     = errors.New("invalid variables source: must be in the format configmap/NAME or secret/NAME")
+	errInvalidRegistryToken     = errors.New("invalid registry token: must be in the format HOSTNAME=SECRET:KEY")
 )
 
+// backendPrefixKeys maps backend types to the backend config key that
+// determines where state is stored
+var backendPrefixKeys = map[string]string{
+	v1alpha1.BackendS3:      "key",
+	v1alpha1.BackendAzureRM: "key",
+	v1alpha1.BackendRemote:  "prefix",
+	v1alpha1.BackendConsul:  "path",
+}
+
 type newOptions struct {
 	*cmdutil.Factory
 
@@ -67,9 +115,35 @@ type newOptions struct {
 	// Disable default behaviour of deleting resources upon error
 	disableResourceCleanup bool
 
+	// Timeout for deleting resources upon error
+	cleanupTimeout time.Duration
+
+	// Toggle waiting for workspace to be ready
+	wait bool
+	// Stage of the workspace's creation to wait for
+	waitFor string
+
+	// Print resources in YAML format rather than creating them
+	dryRun bool
+
+	// Suppress progress messages, leaving only the output of the workspace
+	// pod
+	quiet bool
+
+	// Delete and recreate the workspace if it already exists
+	replace bool
+	// Skip confirmation before replacing an existing workspace
+	autoApprove bool
+	// Permit an approved replace to delete state that is neither stored in a
+	// remote backend nor backed up
+	discardState bool
+	// Timeout for an existing workspace to be deleted when replacing it
+	replaceTimeout time.Duration
+
 	// Recall if resources are created so that if error occurs they can be
 	// cleaned up
 	createdWorkspace bool
+	createdSecret    bool
 
 	// For testing purposes set workspace status
 	status *v1alpha1.WorkspaceStatus
@@ -77,16 +151,78 @@ type newOptions struct {
 	variables            map[string]string
 	environmentVariables map[string]string
 
+	// Path to dotenv file containing environment variables
+	envFile string
+
+	// Config maps and secrets from which to source terraform variables, in
+	// the format configmap/NAME or secret/NAME
+	variablesFrom []string
+
+	// Registry tokens, each referencing a secret key in the format SECRET:KEY,
+	// keyed by registry hostname
+	registryTokens map[string]string
+
 	// backupBucket is the bucket to which the state file will backed up to
 	backupBucket string
 
+	// Compute resource requests and limits for terraform containers
+	cpu, memory, cpuLimit, memoryLimit string
+
+	// Tolerations in the format key[=value][:effect]
+	tolerations []string
+
+	// Node label key and values to which workspace and run pods are pinned
+	nodeAffinityKey    string
+	nodeAffinityValues []string
+
+	// UID with which to run workspace and run pods
+	runAsUser int64
+	// Toggle read-only root filesystem for workspace and run pods
+	readOnlyRoot bool
+
+	// Seconds given to workspace and run pods to exit upon deletion
+	terminationGracePeriod int64
+
+	// Access modes for the cache's persistent volume claim
+	accessModes []string
+
+	// Pull policy for the image of the workspace and run pods
+	imagePullPolicy string
+
+	// GCS object generation of the backup from which to restore state, rather
+	// than the latest generation
+	restoreGeneration int64
+
+	// Path to YAML file containing workspace spec
+	specFile string
+
+	// Paths to terraform variable files
+	varFiles []string
+	// Contents of terraform variable files, keyed by config map key
+	varFilesData map[string]string
+
+	// Keys to populate the etok secret with, in the format KEY=VALUE or
+	// KEY=@FILE
+	secretEnv []string
+	// Parsed secret keys and their values
+	secretData map[string][]byte
+
+	// Name of workspace from which to inherit backend configuration
+	inheritBackend string
+	// Overrides the prefix/key of the backend configuration
+	backendPrefix string
+	// Path to file declaring the backend, used verbatim
+	backendFile string
+
 	etokenv *env.Env
 }
 
 func newCmd(f *cmdutil.Factory) (*cobra.Command, *newOptions) {
 	o := &newOptions{
-		Factory:   f,
-		namespace: defaultNamespace,
+		Factory:        f,
+		namespace:      defaultNamespace,
+		cleanupTimeout: defaultCleanupTimeout,
+		replaceTimeout: defaultReplaceTimeout,
 	}
 	cmd := &cobra.Command{
 		Use:   "new <workspace>",
@@ -98,6 +234,22 @@ func newCmd(f *cmdutil.Factory) (*cobra.Command, *newOptions) {
 
 			o.workspace = args[0]
 
+			// Reject an invalid name before creating any resources
+			if err := validateWorkspaceName(o.workspace); err != nil {
+				return err
+			}
+
+			// Timeouts can be set via env vars, but flags take precedence
+			if err := durationFromEnv(cmd.Flags(), "reconcile-timeout", "ETOK_RECONCILE_TIMEOUT", &o.reconcileTimeout); err != nil {
+				return err
+			}
+			if err := durationFromEnv(cmd.Flags(), "pod-timeout", "ETOK_POD_TIMEOUT", &o.podTimeout); err != nil {
+				return err
+			}
+			if err := durationFromEnv(cmd.Flags(), "restore-timeout", "ETOK_RESTORE_TIMEOUT", &o.restoreTimeout); err != nil {
+				return err
+			}
+
 			o.etokenv, err = env.New(o.namespace, o.workspace)
 			if err != nil {
 				return err
@@ -109,15 +261,158 @@ func newCmd(f *cmdutil.Factory) (*cobra.Command, *newOptions) {
 				o.workspaceSpec.Cache.StorageClass = nil
 			}
 
-			o.Client, err = f.Create(o.kubeContext)
-			if err != nil {
+			for _, s := range o.variablesFrom {
+				src, err := parseVariablesSource(s)
+				if err != nil {
+					return err
+				}
+				o.workspaceSpec.VariablesFrom = append(o.workspaceSpec.VariablesFrom, src)
+			}
+
+			for host, ref := range o.registryTokens {
+				selector, err := parseRegistryToken(host, ref)
+				if err != nil {
+					return err
+				}
+				if o.workspaceSpec.RegistryTokens == nil {
+					o.workspaceSpec.RegistryTokens = make(map[string]corev1.SecretKeySelector)
+				}
+				o.workspaceSpec.RegistryTokens[host] = selector
+			}
+
+			if o.specFile != "" {
+				if err := o.loadSpecFile(cmd.Flags()); err != nil {
+					return err
+				}
+			}
+
+			if o.workspaceSpec.TFLog != "" {
+				o.workspaceSpec.TFLog = strings.ToUpper(o.workspaceSpec.TFLog)
+				if !slice.ContainsString(v1alpha1.TFLogLevels, o.workspaceSpec.TFLog) {
+					return fmt.Errorf("%w: %s: must be one of %s", errInvalidTFLog, o.workspaceSpec.TFLog, strings.Join(v1alpha1.TFLogLevels, ", "))
+				}
+			}
+
+			if o.workspaceSpec.TerraformVersion != "" {
+				if err := tfversion.Validate(o.workspaceSpec.TerraformVersion); err != nil {
+					return err
+				}
+			}
+
+			if !slice.ContainsString(etokworkspace.WaitForStages, o.waitFor) {
+				return fmt.Errorf("%w: %s: must be one of %s", errInvalidWaitFor, o.waitFor, strings.Join(etokworkspace.WaitForStages, ", "))
+			}
+
+			if o.workspaceSpec.BackupRetention < 0 {
+				return errInvalidBackupRetention
+			}
+
+			if o.workspaceSpec.CacheBackup && o.workspaceSpec.BackupBucket == "" {
+				return errCacheBackupBucket
+			}
+
+			if flags.IsFlagPassed(cmd.Flags(), "restore-generation") {
+				if o.restoreGeneration <= 0 {
+					return errInvalidRestoreGeneration
+				}
+				if o.workspaceSpec.BackupBucket == "" || o.workspaceSpec.BackupProvider != v1alpha1.BackupProviderGCS {
+					return errRestoreGeneration
+				}
+			}
+
+			if o.workspaceSpec.MaxConcurrentRuns < 0 {
+				return errInvalidMaxConcurrentRuns
+			}
+
+			if flags.IsFlagPassed(cmd.Flags(), "termination-grace-period") {
+				if o.terminationGracePeriod < 0 {
+					return errInvalidGracePeriod
+				}
+				o.workspaceSpec.TerminationGracePeriodSeconds = &o.terminationGracePeriod
+			}
+
+			if err := o.setResources(); err != nil {
 				return err
 			}
 
+			if flags.IsFlagPassed(cmd.Flags(), "access-mode") {
+				if err := o.setAccessModes(); err != nil {
+					return err
+				}
+			}
+
+			if flags.IsFlagPassed(cmd.Flags(), "image-pull-policy") {
+				if err := o.setImagePullPolicy(); err != nil {
+					return err
+				}
+			}
+
+			o.setSecurityContext(cmd.Flags())
+
+			for _, t := range o.tolerations {
+				toleration, err := parseToleration(t)
+				if err != nil {
+					return err
+				}
+				o.workspaceSpec.Tolerations = append(o.workspaceSpec.Tolerations, toleration)
+			}
+
+			if err := o.setNodeAffinity(); err != nil {
+				return err
+			}
+
+			if err := o.readVarFiles(); err != nil {
+				return err
+			}
+
+			if err := o.readSecretEnv(); err != nil {
+				return err
+			}
+
+			if err := o.readEnvFile(); err != nil {
+				return err
+			}
+
+			// Dry run only requires a client when inheriting the backend
+			if !o.dryRun || o.inheritBackend != "" {
+				o.Client, err = f.Create(o.kubeContext)
+				if err != nil {
+					return err
+				}
+			}
+
+			if o.inheritBackend != "" {
+				if err := o.inheritBackendFrom(cmd.Context(), cmd.Flags()); err != nil {
+					return err
+				}
+			}
+
+			if o.backendFile != "" {
+				if err := o.readBackendFile(cmd.Flags()); err != nil {
+					return err
+				}
+			}
+
+			if o.backendPrefix != "" {
+				if err := o.setBackendPrefix(); err != nil {
+					return err
+				}
+			}
+
+			if err := o.checkRestoreGenerationBackend(); err != nil {
+				return err
+			}
+
+			if o.dryRun {
+				return o.printResources()
+			}
+
 			err = o.run(cmd.Context())
 			if err != nil {
 				if !o.disableResourceCleanup {
-					o.cleanup()
+					if err := o.cleanup(); err != nil {
+						fmt.Fprintf(o.Out, "Warning: unable to clean up resources: %s\n", err.Error())
+					}
 				}
 			}
 			return err
@@ -129,89 +424,684 @@ func newCmd(f *cmdutil.Factory) (*cobra.Command, *newOptions) {
 	flags.AddKubeContextFlag(cmd, &o.kubeContext)
 	flags.AddDisableResourceCleanupFlag(cmd, &o.disableResourceCleanup)
 
+	cmd.Flags().StringVar(&o.specFile, "from-file", "", "Read workspace spec from YAML file (flags override values in the file)")
+
 	cmd.Flags().StringVar(&o.workspaceSpec.Cache.Size, "size", defaultCacheSize, "Size of PersistentVolume for cache")
-	cmd.Flags().StringVar(&o.workspaceSpec.TerraformVersion, "terraform-version", "", "Override terraform version")
-	cmd.Flags().StringVar(&o.workspaceSpec.BackupBucket, "backup-bucket", "", "Backup state to GCS bucket")
+	cmd.Flags().BoolVar(&o.workspaceSpec.Cache.Ephemeral, "ephemeral-cache", false, "Use an empty dir for cache instead of a PersistentVolume, e.g. for short-lived CI workspaces (each run installs terraform and runs init afresh)")
+	cmd.Flags().StringSliceVar(&o.accessModes, "access-mode", []string{}, "Set access modes of PersistentVolume for cache (ReadWriteOnce|ReadOnlyMany|ReadWriteMany) (default ReadWriteOnce)")
+	cmd.Flags().StringVar(&o.workspaceSpec.TerraformVersion, "terraform-version", "", "Override terraform version, either an exact version or a constraint, e.g. \">= 1.3, < 1.5\"")
+	cmd.Flags().StringVar(&o.workspaceSpec.TFLog, "tf-log", "", "Set terraform log level (TRACE|DEBUG|INFO|WARN|ERROR)")
+	cmd.Flags().StringVar(&o.workspaceSpec.BackupBucket, "backup-bucket", "", "Backup state to bucket")
+	cmd.Flags().StringVar(&o.workspaceSpec.BackupKMSKey, "backup-kms-key", "", "Encrypt backups with KMS key (GCP Cloud KMS key resource name, or AWS KMS key ID, ARN or alias)")
+	cmd.Flags().StringVar(&o.workspaceSpec.BackupProvider, "backup-provider", v1alpha1.BackupProviderGCS, "Cloud storage provider of backup bucket (gcs|s3)")
+	cmd.Flags().IntVar(&o.workspaceSpec.BackupRetention, "backup-retention", 0, "Number of versions of state to retain in backup bucket (0 retains all versions)")
+	cmd.Flags().Int64Var(&o.restoreGeneration, "restore-generation", 0, "Restore state from this GCS object generation of the backup rather than the latest (requires object versioning on the backup bucket)")
+	cmd.Flags().BoolVar(&o.workspaceSpec.CacheBackup, "cache-backup", false, "Also backup providers installed by terraform init to backup bucket, restoring them to a new cache")
+
+	cmd.Flags().StringVar(&o.workspaceSpec.Backend.Type, "backend-type", v1alpha1.BackendKubernetes, "Set terraform backend type")
+	cmd.Flags().StringToStringVar(&o.workspaceSpec.Backend.Config, "backend-config", map[string]string{}, "Set terraform backend configuration")
+	cmd.Flags().StringVar(&o.backendFile, "backend-tf-file", "", "Path to file declaring the terraform backend, used verbatim (for backends not otherwise supported)")
+	cmd.Flags().StringVar(&o.inheritBackend, "inherit-backend", "", "Copy terraform backend configuration from another workspace (backend flags override inherited values)")
+	cmd.Flags().StringVar(&o.backendPrefix, "backend-prefix", "", "Override prefix/key/path of terraform backend configuration (s3|azurerm|remote|consul)")
 
 	// We want nil to be the default but it doesn't seem like pflags supports
 	// that so use empty string and override later (see above)
 	o.workspaceSpec.Cache.StorageClass = cmd.Flags().String("storage-class", "", "StorageClass of PersistentVolume for cache")
 
-	cmd.Flags().DurationVar(&o.reconcileTimeout, "reconcile-timeout", defaultReconcileTimeout, "timeout for resource to be reconciled")
-	cmd.Flags().DurationVar(&o.podTimeout, "pod-timeout", defaultPodTimeout, "timeout for pod to be ready")
-	cmd.Flags().DurationVar(&o.restoreTimeout, "restore-timeout", defaultReadyTimeout, "timeout for restore condition to report back")
+	cmd.Flags().BoolVar(&o.wait, "wait", true, "Toggle waiting for workspace to be ready")
+	cmd.Flags().StringVar(&o.waitFor, "wait-for", etokworkspace.WaitForPod, fmt.Sprintf("Stage to wait for: one of %s. Each stage includes those preceding it", strings.Join(etokworkspace.WaitForStages, ", ")))
+	cmd.Flags().BoolVar(&o.dryRun, "dry-run", false, "Don't create resources just print them out in YAML format")
+	cmd.Flags().BoolVarP(&o.quiet, "quiet", "q", false, "Suppress progress messages, printing only the output of the workspace pod")
+	cmd.Flags().BoolVar(&o.replace, "replace", false, "Delete and recreate the workspace if it already exists, retaining its backend and backup bucket")
+	cmd.Flags().BoolVar(&o.autoApprove, "auto-approve", false, "Skip interactive approval before replacing an existing workspace")
+	cmd.Flags().BoolVar(&o.discardState, "discard-state", false, "Permit --auto-approve to replace an existing workspace whose state is neither stored in a remote backend nor backed up, deleting the state")
+	cmd.Flags().DurationVar(&o.reconcileTimeout, "reconcile-timeout", etokworkspace.DefaultReconcileTimeout, "timeout for resource to be reconciled")
+	cmd.Flags().DurationVar(&o.podTimeout, "pod-timeout", etokworkspace.DefaultPodTimeout, "timeout for pod to be ready")
+	cmd.Flags().DurationVar(&o.restoreTimeout, "restore-timeout", etokworkspace.DefaultReadyTimeout, "timeout for restore condition to report back")
+
+	cmd.Flags().StringVar(&o.cpu, "cpu", "", "Set CPU request for terraform containers")
+	cmd.Flags().StringVar(&o.memory, "memory", "", "Set memory request for terraform containers")
+	cmd.Flags().StringVar(&o.cpuLimit, "cpu-limit", "", "Set CPU limit for terraform containers")
+	cmd.Flags().StringVar(&o.memoryLimit, "memory-limit", "", "Set memory limit for terraform containers")
+
+	cmd.Flags().StringToStringVar(&o.workspaceSpec.NodeSelector, "node-selector", map[string]string{}, "Set node selector for workspace and run pods")
+	cmd.Flags().StringToStringVar(&o.workspaceSpec.PodAnnotations, "pod-annotations", map[string]string{}, "Set annotations on workspace and run pods")
+	cmd.Flags().StringToStringVar(&o.workspaceSpec.PodLabels, "pod-labels", map[string]string{}, "Set labels on workspace and run pods")
+	cmd.Flags().Int64Var(&o.runAsUser, "run-as-user", 0, "Run workspace and run pods as this UID, which also owns the cache")
+	cmd.Flags().BoolVar(&o.readOnlyRoot, "read-only-root", false, "Run workspace and run pods with a read-only root filesystem")
+	cmd.Flags().StringVar(&o.nodeAffinityKey, "node-affinity-key", "", "Require workspace and run pods to be scheduled to nodes with this label key, e.g. topology.kubernetes.io/zone")
+	cmd.Flags().StringSliceVar(&o.nodeAffinityValues, "node-affinity-values", []string{}, "Require the node label set with --node-affinity-key to have one of these values (default any value)")
+	cmd.Flags().Int64Var(&o.terminationGracePeriod, "termination-grace-period", 0, "Set seconds given to pods to exit upon deletion (default 600 for runs that mutate state, e.g. apply, otherwise 30)")
+	cmd.Flags().StringArrayVar(&o.tolerations, "toleration", []string{}, "Add toleration for workspace and run pods, in the format key[=value][:effect] (repeatable)")
+
+	cmd.Flags().StringArrayVar(&o.secretEnv, "secret-env", []string{}, "Set key in etok secret, in the format KEY=VALUE, or KEY=@FILE to read the value from a file (repeatable)")
+	cmd.Flags().StringSliceVar(&o.workspaceSpec.SecretNames, "secrets", []string{}, "Set additional secrets whose keys are made available to terraform as environment variables")
+
+	cmd.Flags().StringVar(&o.workspaceSpec.Image, "image", "", "Override container image for workspace and run pods (must be based on the etok image)")
+	cmd.Flags().StringVar(&o.imagePullPolicy, "image-pull-policy", "", "Set pull policy for the image of workspace and run pods (Always|IfNotPresent|Never) (default IfNotPresent)")
+	cmd.Flags().StringSliceVar(&o.workspaceSpec.ImagePullSecrets, "image-pull-secrets", []string{}, "Set secrets for pulling images from a private registry for workspace and run pods")
+	cmd.Flags().StringVar(&o.workspaceSpec.NetrcSecret, "netrc-secret", "", "Set secret containing a netrc file (under the key .netrc) for authenticating to private module sources")
+	cmd.Flags().StringVar(&o.workspaceSpec.CABundleSecret, "ca-bundle-secret", "", "Set secret containing a bundle of CA certificates (under the key ca.crt) for verifying endpoints with certificates issued by a private CA")
+	cmd.Flags().StringVar(&o.workspaceSpec.TerraformRCConfigMap, "terraformrc-config-map", "", "Set config map containing a terraform CLI configuration file (under the key .terraformrc)")
+	cmd.Flags().StringVar(&o.workspaceSpec.PreRunScript, "pre-run", "", "Set shell script to run in run pods before terraform, with the same environment and secrets")
+	cmd.Flags().StringToStringVar(&o.registryTokens, "registry-tokens", map[string]string{}, "Set API tokens for private module registries from secret keys, in the format HOSTNAME=SECRET:KEY")
+
+	cmd.Flags().StringSliceVar(&o.workspaceSpec.InitArgs, "init-args", []string{}, "Set additional arguments to pass to terraform init")
+	cmd.Flags().StringSliceVar(&o.workspaceSpec.TerraformArgs, "terraform-args", []string{}, "Set default arguments to pass to the apply, destroy, import, plan and refresh commands, e.g. -lock-timeout=5m (overridden by the same flag passed to a command)")
+
+	cmd.Flags().StringArrayVar(&o.varFiles, "var-file", []string{}, "Set terraform variables from a file (repeatable; later files override earlier files)")
 
 	cmd.Flags().StringSliceVar(&o.workspaceSpec.PrivilegedCommands, "privileged-commands", []string{}, "Set privileged commands")
+	cmd.Flags().BoolVar(&o.workspaceSpec.RequireApproval, "require-approval", false, "Require apply and destroy runs to be approved with 'etok approve' before they proceed")
+	cmd.Flags().IntVar(&o.workspaceSpec.MaxConcurrentRuns, "max-concurrent-runs", 0, "Set maximum number of non-mutating runs, e.g. plan, that can run simultaneously (0 imposes no limit)")
 
 	cmd.Flags().StringToStringVar(&o.variables, "variables", map[string]string{}, "Set terraform variables")
 	cmd.Flags().StringToStringVar(&o.environmentVariables, "environment-variables", map[string]string{}, "Set environment variables")
+	cmd.Flags().StringVar(&o.envFile, "env-file", "", "Set environment variables from a dotenv file (overridden by --environment-variables)")
+	cmd.Flags().StringArrayVar(&o.variablesFrom, "variables-from", []string{}, "Set terraform variables from the keys of a config map or secret, in the format configmap/NAME or secret/NAME (repeatable; later sources override earlier sources)")
 
 	return cmd, o
 }
 
-func (o *newOptions) run(ctx context.Context) error {
-	ws, err := o.createWorkspace(ctx)
+// specFileFlags maps flags to the workspace spec fields they set, for the
+// purpose of merging flags with a spec file
+var specFileFlags = map[string]func(*v1alpha1.WorkspaceSpec) interface{}{
+	"size":                   func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.Cache.Size },
+	"storage-class":          func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.Cache.StorageClass },
+	"ephemeral-cache":        func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.Cache.Ephemeral },
+	"terraform-version":      func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.TerraformVersion },
+	"tf-log":                 func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.TFLog },
+	"backup-bucket":          func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.BackupBucket },
+	"backup-kms-key":         func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.BackupKMSKey },
+	"backup-provider":        func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.BackupProvider },
+	"backup-retention":       func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.BackupRetention },
+	"cache-backup":           func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.CacheBackup },
+	"backend-type":           func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.Backend.Type },
+	"backend-config":         func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.Backend.Config },
+	"node-selector":          func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.NodeSelector },
+	"pod-annotations":        func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.PodAnnotations },
+	"pod-labels":             func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.PodLabels },
+	"require-approval":       func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.RequireApproval },
+	"secrets":                func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.SecretNames },
+	"init-args":              func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.InitArgs },
+	"terraform-args":         func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.TerraformArgs },
+	"variables-from":         func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.VariablesFrom },
+	"privileged-commands":    func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.PrivilegedCommands },
+	"netrc-secret":           func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.NetrcSecret },
+	"ca-bundle-secret":       func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.CABundleSecret },
+	"terraformrc-config-map": func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.TerraformRCConfigMap },
+	"pre-run":                func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.PreRunScript },
+	"registry-tokens":        func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.RegistryTokens },
+	"image":                  func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.Image },
+	"image-pull-secrets":     func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.ImagePullSecrets },
+	"max-concurrent-runs":    func(spec *v1alpha1.WorkspaceSpec) interface{} { return &spec.MaxConcurrentRuns },
+}
+
+// loadSpecFile reads the workspace spec from a YAML file, which then forms the
+// basis of the workspace spec. Flags that are explicitly passed override values
+// in the file, and flag defaults are used for values absent from the file.
+// Unknown fields are rejected.
+func (o *newOptions) loadSpecFile(fs *pflag.FlagSet) error {
+	data, err := ioutil.ReadFile(o.specFile)
+	if err != nil {
+		return fmt.Errorf("unable to read workspace spec file: %w", err)
+	}
+
+	var spec v1alpha1.WorkspaceSpec
+	if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+		return fmt.Errorf("%w: %s: %s", errInvalidSpecFile, o.specFile, err.Error())
+	}
+
+	for name, field := range specFileFlags {
+		fromFile := reflect.ValueOf(field(&spec)).Elem()
+		if flags.IsFlagPassed(fs, name) || fromFile.IsZero() {
+			fromFile.Set(reflect.ValueOf(field(&o.workspaceSpec)).Elem())
+		}
+	}
+
+	o.workspaceSpec = spec
+	return nil
+}
+
+// readVarFiles reads the terraform variable files, keying each by its
+// position and filename, so that their order is retained
+func (o *newOptions) readVarFiles() error {
+	for i, path := range o.varFiles {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read var file: %w", err)
+		}
+
+		if o.varFilesData == nil {
+			o.varFilesData = make(map[string]string)
+		}
+		key := fmt.Sprintf("%d-%s", i, filepath.Base(path))
+		o.varFilesData[key] = string(data)
+		o.workspaceSpec.VarFiles = append(o.workspaceSpec.VarFiles, key)
+	}
+	return nil
+}
+
+// readSecretEnv parses the secret env flags, reading the value from a file
+// where the value is prefixed with @
+func (o *newOptions) readSecretEnv() error {
+	for _, kv := range o.secretEnv {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("%w: %s", errInvalidSecretEnv, kv)
+		}
+		key, value := parts[0], []byte(parts[1])
+
+		if strings.HasPrefix(parts[1], "@") {
+			data, err := ioutil.ReadFile(strings.TrimPrefix(parts[1], "@"))
+			if err != nil {
+				return fmt.Errorf("unable to read secret env file: %w", err)
+			}
+			value = data
+		}
+
+		if o.secretData == nil {
+			o.secretData = make(map[string][]byte)
+		}
+		o.secretData[key] = value
+	}
+	return nil
+}
+
+// readEnvFile reads environment variables from the env file, merging them with
+// those set by flag, which take precedence
+func (o *newOptions) readEnvFile() error {
+	if o.envFile == "" {
+		return nil
+	}
+
+	f, err := os.Open(o.envFile)
+	if err != nil {
+		return fmt.Errorf("unable to read env file: %w", err)
+	}
+	defer f.Close()
+
+	vars, err := parseEnvFile(f)
 	if err != nil {
 		return err
 	}
 
-	g, gctx := errgroup.WithContext(ctx)
+	if o.environmentVariables == nil {
+		o.environmentVariables = make(map[string]string)
+	}
+	for k, v := range vars {
+		if _, ok := o.environmentVariables[k]; !ok {
+			o.environmentVariables[k] = v
+		}
+	}
+	return nil
+}
 
-	// Wait for resource to have been successfully reconciled at least once
-	// within the ReconcileTimeout (If we don't do this and the operator is
-	// either not installed or malfunctioning then the user would be none the
-	// wiser until the much longer PodTimeout had expired).
-	g.Go(func() error {
-		return o.waitForReconcile(gctx, ws)
-	})
+// newSecret constructs the etok secret containing the secret env keys
+func (o *newOptions) newSecret() *corev1.Secret {
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: corev1.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: o.namespace,
+		},
+		Data: o.secretData,
+	}
 
-	// Wait for workspace to be ready
-	g.Go(func() error {
-		return o.waitForReady(gctx, ws)
-	})
+	// Set etok's common labels
+	labels.SetCommonLabels(secret)
+	// Permit filtering etok resources by component
+	labels.SetLabel(secret, labels.WorkspaceComponent)
 
-	// Monitor exit code; non-blocking
-	exit := monitors.ExitMonitor(ctx, o.KubeClient, ws.PodName(), ws.Namespace, controllers.InstallerContainerName)
+	return secret
+}
 
-	// Wait for pod to be ready and start streaming logs from its installer
-	// container
-	g.Go(func() error {
-		fmt.Fprintln(o.Out, "Waiting for workspace pod to be ready...")
-		_, err := o.waitForContainer(gctx, ws)
-		if err != nil {
+// createOrUpdateSecret populates the etok secret with the secret env keys. If
+// the secret already exists then the keys are merged into it, overwriting any
+// existing keys of the same name.
+func (o *newOptions) createOrUpdateSecret(ctx context.Context) error {
+	secret, err := o.SecretsClient(o.namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		secret = o.newSecret()
+		if _, err := o.SecretsClient(o.namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
 			return err
 		}
 
-		return logstreamer.Stream(ctx, o.GetLogsFunc, o.Out, o.PodsClient(o.namespace), ws.PodName(), controllers.InstallerContainerName)
-	})
+		o.createdSecret = true
+		o.progressf("Created secret %s\n", klog.KObj(secret))
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	for k, v := range o.secretData {
+		secret.Data[k] = v
+	}
+
+	if _, err := o.SecretsClient(o.namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+
+	o.progressf("Updated secret %s\n", klog.KObj(secret))
+	return nil
+}
+
+// newVarFilesConfigMap constructs a config map containing the terraform
+// variable files
+func (o *newOptions) newVarFilesConfigMap(ws *v1alpha1.Workspace) *corev1.ConfigMap {
+	configMap := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: corev1.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ws.VarFilesConfigMapName(),
+			Namespace: ws.Namespace,
+		},
+		Data: o.varFilesData,
+	}
+
+	// Set etok's common labels
+	labels.SetCommonLabels(configMap)
+	// Permit filtering config maps by workspace
+	labels.SetLabel(configMap, labels.Workspace(ws.Name))
+	// Permit filtering etok resources by component
+	labels.SetLabel(configMap, labels.WorkspaceComponent)
+
+	return configMap
+}
 
-	// Wait for workspace to have been reconciled and for its pod container to
-	// be ready
-	if err := g.Wait(); err != nil {
+// createVarFilesConfigMap creates a config map containing the terraform
+// variable files. The workspace is made its owner so that it is deleted along
+// with the workspace.
+func (o *newOptions) createVarFilesConfigMap(ctx context.Context, ws *v1alpha1.Workspace) error {
+	configMap := o.newVarFilesConfigMap(ws)
+	configMap.OwnerReferences = []metav1.OwnerReference{
+		*metav1.NewControllerRef(ws, v1alpha1.SchemeGroupVersion.WithKind("Workspace")),
+	}
+
+	_, err := o.ConfigMapsClient(ws.Namespace).Create(ctx, configMap, metav1.CreateOptions{})
+	return err
+}
+
+// inheritBackendFrom copies the backend configuration from another workspace in
+// the same namespace. Backend flags override the inherited configuration.
+func (o *newOptions) inheritBackendFrom(ctx context.Context, fs *pflag.FlagSet) error {
+	src, err := o.WorkspacesClient(o.namespace).Get(ctx, o.inheritBackend, metav1.GetOptions{})
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return fmt.Errorf("%w: %s/%s", errInheritBackendNotFound, o.namespace, o.inheritBackend)
+		}
 		return err
 	}
 
-	if err := o.etokenv.Write(o.path); err != nil {
+	backend := *src.Spec.Backend.DeepCopy()
+	if flags.IsFlagPassed(fs, "backend-type") {
+		backend.Type = o.workspaceSpec.Backend.Type
+	}
+	if flags.IsFlagPassed(fs, "backend-config") {
+		if backend.Config == nil {
+			backend.Config = make(map[string]string)
+		}
+		for k, v := range o.workspaceSpec.Backend.Config {
+			backend.Config[k] = v
+		}
+	}
+	o.workspaceSpec.Backend = backend
+	return nil
+}
+
+// readBackendFile reads the file declaring the backend, setting the backend
+// type to that declared in the file
+func (o *newOptions) readBackendFile(fs *pflag.FlagSet) error {
+	data, err := ioutil.ReadFile(o.backendFile)
+	if err != nil {
+		return fmt.Errorf("unable to read backend file: %w", err)
+	}
+
+	backendType, err := controllers.ParseRawBackend(string(data))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", errInvalidBackendFile, o.backendFile, err.Error())
+	}
+	if backendType == v1alpha1.BackendKubernetes {
+		return fmt.Errorf("%w: %s: the kubernetes backend is configured by etok and cannot be declared", errInvalidBackendFile, o.backendFile)
+	}
+	if flags.IsFlagPassed(fs, "backend-type") && o.workspaceSpec.Backend.Type != backendType {
+		return fmt.Errorf("%w: %s: declares %s backend but --backend-type is %s", errInvalidBackendFile, o.backendFile, backendType, o.workspaceSpec.Backend.Type)
+	}
+
+	o.workspaceSpec.Backend.Type = backendType
+	o.workspaceSpec.Backend.Raw = string(data)
+	return nil
+}
+
+// setBackendPrefix sets the backend config key determining where state is
+// stored, ensuring a workspace doesn't share state with the workspace from
+// which its backend is inherited.
+func (o *newOptions) setBackendPrefix() error {
+	key, ok := backendPrefixKeys[o.workspaceSpec.Backend.Type]
+	if !ok {
+		return fmt.Errorf("%w: %s", errBackendPrefixUnsupported, o.workspaceSpec.Backend.Type)
+	}
+	if o.workspaceSpec.Backend.Config == nil {
+		o.workspaceSpec.Backend.Config = make(map[string]string)
+	}
+	o.workspaceSpec.Backend.Config[key] = o.backendPrefix
+
+	// The remote backend permits only one of name or prefix
+	if o.workspaceSpec.Backend.Type == v1alpha1.BackendRemote {
+		delete(o.workspaceSpec.Backend.Config, "name")
+	}
+	return nil
+}
+
+// progressf prints a progress message, unless quiet is enabled
+func (o *newOptions) progressf(format string, a ...interface{}) {
+	if !o.quiet {
+		fmt.Fprintf(o.Out, format, a...)
+	}
+}
+
+// validateWorkspaceName checks the name is a valid DNS-1123 label, which is
+// required of the workspace resource and the resources named after it
+func validateWorkspaceName(name string) error {
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return fmt.Errorf("%w: %s: %s", errInvalidName, name, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// setAccessModes validates the access mode flags and sets them on the
+// workspace spec
+func (o *newOptions) setAccessModes() error {
+	valid := []string{string(corev1.ReadWriteOnce), string(corev1.ReadOnlyMany), string(corev1.ReadWriteMany)}
+
+	var modes []corev1.PersistentVolumeAccessMode
+	for _, mode := range o.accessModes {
+		if !slice.ContainsString(valid, mode) {
+			return fmt.Errorf("%w: %s: must be one of %s", errInvalidAccessMode, mode, strings.Join(valid, ", "))
+		}
+		modes = append(modes, corev1.PersistentVolumeAccessMode(mode))
+	}
+	o.workspaceSpec.Cache.AccessModes = modes
+	return nil
+}
+
+// setImagePullPolicy validates the image pull policy flag and sets it on the
+// workspace spec
+func (o *newOptions) setImagePullPolicy() error {
+	valid := []string{string(corev1.PullAlways), string(corev1.PullIfNotPresent), string(corev1.PullNever)}
+
+	if !slice.ContainsString(valid, o.imagePullPolicy) {
+		return fmt.Errorf("%w: %s: must be one of %s", errInvalidImagePullPolicy, o.imagePullPolicy, strings.Join(valid, ", "))
+	}
+	o.workspaceSpec.ImagePullPolicy = corev1.PullPolicy(o.imagePullPolicy)
+	return nil
+}
+
+// setResources parses the compute resource flags and sets them on the workspace
+// spec. Resources are only set if their respective flag is non-empty.
+func (o *newOptions) setResources() error {
+	for _, r := range []struct {
+		flag, value string
+		list        *corev1.ResourceList
+		name        corev1.ResourceName
+	}{
+		{"cpu", o.cpu, &o.workspaceSpec.Resources.Requests, corev1.ResourceCPU},
+		{"memory", o.memory, &o.workspaceSpec.Resources.Requests, corev1.ResourceMemory},
+		{"cpu-limit", o.cpuLimit, &o.workspaceSpec.Resources.Limits, corev1.ResourceCPU},
+		{"memory-limit", o.memoryLimit, &o.workspaceSpec.Resources.Limits, corev1.ResourceMemory},
+	} {
+		if r.value == "" {
+			continue
+		}
+		qty, err := resource.ParseQuantity(r.value)
+		if err != nil {
+			return fmt.Errorf("invalid value for --%s: %w", r.flag, err)
+		}
+		if *r.list == nil {
+			*r.list = corev1.ResourceList{}
+		}
+		(*r.list)[r.name] = qty
+	}
+	return nil
+}
+
+// setSecurityContext sets the security context flags on the workspace spec,
+// merging them into any security contexts read from the spec file
+func (o *newOptions) setSecurityContext(fs *pflag.FlagSet) {
+	if flags.IsFlagPassed(fs, "run-as-user") {
+		if o.workspaceSpec.PodSecurityContext == nil {
+			o.workspaceSpec.PodSecurityContext = &corev1.PodSecurityContext{}
+		}
+		uid, nonRoot := o.runAsUser, o.runAsUser != 0
+		o.workspaceSpec.PodSecurityContext.RunAsUser = &uid
+		o.workspaceSpec.PodSecurityContext.RunAsNonRoot = &nonRoot
+		// Ensure the user can write to the cache
+		o.workspaceSpec.PodSecurityContext.FSGroup = &uid
+	}
+
+	if o.readOnlyRoot {
+		if o.workspaceSpec.SecurityContext == nil {
+			o.workspaceSpec.SecurityContext = &corev1.SecurityContext{}
+		}
+		readOnly := true
+		o.workspaceSpec.SecurityContext.ReadOnlyRootFilesystem = &readOnly
+	}
+}
+
+// setNodeAffinity requires workspace and run pods to be scheduled to nodes with
+// the node affinity key and, if specified, one of the node affinity values. It
+// replaces any node affinity set in a spec file but retains any pod
+// (anti-)affinity.
+func (o *newOptions) setNodeAffinity() error {
+	if o.nodeAffinityKey == "" {
+		if len(o.nodeAffinityValues) > 0 {
+			return errInvalidAffinity
+		}
+		return nil
+	}
+
+	requirement := corev1.NodeSelectorRequirement{
+		Key:      o.nodeAffinityKey,
+		Operator: corev1.NodeSelectorOpExists,
+	}
+	if len(o.nodeAffinityValues) > 0 {
+		requirement.Operator = corev1.NodeSelectorOpIn
+		requirement.Values = o.nodeAffinityValues
+	}
+
+	if o.workspaceSpec.Affinity == nil {
+		o.workspaceSpec.Affinity = &corev1.Affinity{}
+	}
+	o.workspaceSpec.Affinity.NodeAffinity = &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{
+					MatchExpressions: []corev1.NodeSelectorRequirement{requirement},
+				},
+			},
+		},
+	}
+	return nil
+}
+
+// parseVariablesSource parses a variables source in the format configmap/NAME
+// or secret/NAME
+func parseVariablesSource(s string) (v1alpha1.VariablesSource, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return v1alpha1.VariablesSource{}, fmt.Errorf("%w: %s", errInvalidVariablesFrom, s)
+	}
+
+	switch strings.ToLower(parts[0]) {
+	case "configmap", "cm":
+		return v1alpha1.VariablesSource{Kind: v1alpha1.VariablesSourceConfigMap, Name: parts[1]}, nil
+	case "secret":
+		return v1alpha1.VariablesSource{Kind: v1alpha1.VariablesSourceSecret, Name: parts[1]}, nil
+	default:
+		return v1alpha1.VariablesSource{}, fmt.Errorf("%w: %s", errInvalidVariablesFrom, s)
+	}
+}
+
+// parseRegistryToken parses a reference to the secret key containing the
+// registry's token, in the format SECRET:KEY
+func parseRegistryToken(host, ref string) (corev1.SecretKeySelector, error) {
+	parts := strings.SplitN(ref, ":", 2)
+	if host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return corev1.SecretKeySelector{}, fmt.Errorf("%w: %s=%s", errInvalidRegistryToken, host, ref)
+	}
+	return corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: parts[0]},
+		Key:                  parts[1],
+	}, nil
+}
+
+// parseToleration parses a toleration in the format key[=value][:effect]. The
+// operator is Equal if a value is specified, otherwise Exists.
+func parseToleration(s string) (corev1.Toleration, error) {
+	var toleration corev1.Toleration
+
+	keyValue := s
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		keyValue = s[:i]
+		toleration.Effect = corev1.TaintEffect(s[i+1:])
+
+		switch toleration.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return toleration, fmt.Errorf("%w: %s: invalid effect: %s", errInvalidToleration, s, toleration.Effect)
+		}
+	}
+
+	if i := strings.Index(keyValue, "="); i >= 0 {
+		toleration.Key = keyValue[:i]
+		toleration.Value = keyValue[i+1:]
+		toleration.Operator = corev1.TolerationOpEqual
+	} else {
+		toleration.Key = keyValue
+		toleration.Operator = corev1.TolerationOpExists
+	}
+
+	if toleration.Key == "" {
+		return toleration, fmt.Errorf("%w: %s: missing key", errInvalidToleration, s)
+	}
+
+	return toleration, nil
+}
+
+// durationFromEnv sets the duration from the env var if the flag has not been
+// passed and the env var is set
+func durationFromEnv(fs *pflag.FlagSet, flag, envVar string, d *time.Duration) error {
+	if flags.IsFlagPassed(fs, flag) {
+		return nil
+	}
+	val, ok := os.LookupEnv(envVar)
+	if !ok {
+		return nil
+	}
+	parsed, err := time.ParseDuration(val)
+	if err != nil {
+		return fmt.Errorf("%w: %s=%s", errInvalidDuration, envVar, val)
+	}
+	*d = parsed
+	return nil
+}
+
+func (o *newOptions) run(ctx context.Context) error {
+	// Fail fast rather than waiting for reconcile to time out if the operator
+	// is missing
+	if err := cmdutil.CheckOperator(ctx, o.KubeClient, o.ErrOut); err != nil {
 		return err
 	}
 
-	// Return container's exit code
-	select {
-	case <-time.After(10 * time.Second):
-		return fmt.Errorf("timed out waiting for exit code")
-	case code := <-exit:
-		return code
+	if o.replace {
+		if err := o.replaceWorkspace(ctx); err != nil {
+			return err
+		}
+	}
+
+	ws, err := etokworkspace.Create(ctx, o.Client, o.newWorkspace(), etokworkspace.CreateOptions{
+		Wait:             o.wait,
+		WaitFor:          o.waitFor,
+		ReconcileTimeout: o.reconcileTimeout,
+		PodTimeout:       o.podTimeout,
+		ReadyTimeout:     o.restoreTimeout,
+		Out:              o.Out,
+		GetLogsFunc:      o.GetLogsFunc,
+		OnCreate:         o.onCreate,
+	})
+	if ws != nil {
+		o.createdWorkspace = true
+	}
+	if err != nil {
+		return err
 	}
+
+	return o.etokenv.Write(o.path)
 }
 
-func (o *newOptions) cleanup() {
+// printResources prints out the resources that would otherwise be created, in
+// YAML format
+func (o *newOptions) printResources() error {
+	ws := o.newWorkspace()
+
+	resources := []interface{}{ws}
+	if len(o.secretData) > 0 {
+		resources = append(resources, o.newSecret())
+	}
+	if len(o.varFilesData) > 0 {
+		resources = append(resources, o.newVarFilesConfigMap(ws))
+	}
+
+	var docs []string
+	for _, r := range resources {
+		data, err := yaml.Marshal(r)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, string(data))
+	}
+	fmt.Fprint(o.Out, strings.Join(docs, "---\n"))
+
+	return nil
+}
+
+// cleanup deletes the resources created thus far. An attempt is made to delete
+// each resource regardless of whether deleting another fails, and any errors
+// are aggregated. The workspace is deleted last, once its dependents are gone,
+// so that its finalizers can run.
+func (o *newOptions) cleanup() error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.cleanupTimeout)
+	defer cancel()
+
+	var errs []error
+	if o.createdSecret {
+		if err := o.SecretsClient(o.namespace).Delete(ctx, secretName, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("deleting secret %s/%s: %w", o.namespace, secretName, err))
+		}
+	}
 	if o.createdWorkspace {
-		o.WorkspacesClient(o.namespace).Delete(context.Background(), o.workspace, metav1.DeleteOptions{})
+		if err := o.WorkspacesClient(o.namespace).Delete(ctx, o.workspace, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("deleting workspace %s/%s: %w", o.namespace, o.workspace, err))
+		}
 	}
+	return utilerrors.NewAggregate(errs)
 }
 
-func (o *newOptions) createWorkspace(ctx context.Context) (*v1alpha1.Workspace, error) {
+// newWorkspace constructs the workspace resource from the options
+func (o *newOptions) newWorkspace() *v1alpha1.Workspace {
 	ws := &v1alpha1.Workspace{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Workspace",
+			APIVersion: v1alpha1.SchemeGroupVersion.String(),
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      o.workspace,
 			Namespace: o.namespace,
@@ -228,81 +1118,192 @@ func (o *newOptions) createWorkspace(ctx context.Context) (*v1alpha1.Workspace,
 
 	ws.Spec.Verbosity = o.Verbosity
 
+	if o.restoreGeneration > 0 {
+		// Request the operator restore the generation rather than the latest
+		// backup
+		ws.Annotations = map[string]string{
+			v1alpha1.RestoreGenerationAnnotationKey: strconv.FormatInt(o.restoreGeneration, 10),
+		}
+	}
+
 	if o.status != nil {
 		// For testing purposes seed workspace status
 		ws.Status = *o.status
 	}
 
+	// Variables set via flags override those set in a spec file
 	for k, v := range o.variables {
-		ws.Spec.Variables = append(ws.Spec.Variables, &v1alpha1.Variable{Key: k, Value: v})
+		setVariable(ws, k, v)
 	}
 
 	for k, v := range o.environmentVariables {
-		ws.Spec.Variables = append(ws.Spec.Variables, &v1alpha1.Variable{Key: k, Value: v, EnvironmentVariable: true})
+		setEnvironmentVariable(ws, k, v)
 	}
 
-	ws, err := o.WorkspacesClient(o.namespace).Create(ctx, ws, metav1.CreateOptions{})
+	return ws
+}
+
+// replaceWorkspace deletes the workspace if it already exists, waiting for it
+// and its dependent resources to be garbage collected, so that it can be
+// recreated. Foreground deletion keeps the workspace around until its pod and
+// PVC are gone, but they are checked for too, lest the new workspace's pod
+// and PVC clash with them. The existing workspace's backend and backup bucket are retained
+// unless the new spec sets them, so that its state isn't lost.
+func (o *newOptions) replaceWorkspace(ctx context.Context) error {
+	existing, err := o.WorkspacesClient(o.namespace).Get(ctx, o.workspace, metav1.GetOptions{})
 	if err != nil {
-		return nil, err
+		if kerrors.IsNotFound(err) {
+			// Nothing to replace
+			return nil
+		}
+		return err
 	}
 
-	o.createdWorkspace = true
-	fmt.Fprintf(o.Out, "Created workspace %s\n", klog.KObj(ws))
-
-	return ws, nil
-}
+	if !o.autoApprove {
+		if err := o.confirmReplace(existing); err != nil {
+			return err
+		}
+	} else if discardsState(existing) && !o.discardState {
+		// Without a prompt the user has not been warned the state is lost
+		return errReplaceDiscardsState
+	}
 
-// waitForContainer returns true once the installer container can be streamed
-// from
-func (o *newOptions) waitForContainer(ctx context.Context, ws *v1alpha1.Workspace) (*corev1.Pod, error) {
-	lw := &k8s.PodListWatcher{Client: o.KubeClient, Name: ws.PodName(), Namespace: ws.Namespace}
-	hdlr := handlers.ContainerReady(ws.PodName(), controllers.InstallerContainerName, true, false)
+	if o.workspaceSpec.BackupBucket == "" {
+		o.workspaceSpec.BackupBucket = existing.Spec.BackupBucket
+		o.workspaceSpec.BackupProvider = existing.Spec.BackupProvider
+		o.workspaceSpec.BackupKMSKey = existing.Spec.BackupKMSKey
+		o.workspaceSpec.BackupRetention = existing.Spec.BackupRetention
+	}
+	if isDefaultBackend(o.workspaceSpec.Backend) {
+		o.workspaceSpec.Backend = *existing.Spec.Backend.DeepCopy()
+	}
+	if err := o.checkRestoreGenerationBackend(); err != nil {
+		return err
+	}
 
-	ctx, cancel := context.WithTimeout(ctx, o.podTimeout)
-	defer cancel()
+	foreground := metav1.DeletePropagationForeground
+	if err := o.WorkspacesClient(o.namespace).Delete(ctx, o.workspace, metav1.DeleteOptions{PropagationPolicy: &foreground}); err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete workspace: %w", err)
+	}
 
-	event, err := watchtools.UntilWithSync(ctx, lw, &corev1.Pod{}, nil, hdlr)
+	o.progressf("Waiting for workspace %s and its dependent resources to be deleted...\n", klog.KObj(existing))
+	err = wait.PollImmediate(time.Second, o.replaceTimeout, func() (bool, error) {
+		if _, err := o.WorkspacesClient(o.namespace).Get(ctx, o.workspace, metav1.GetOptions{}); err != nil {
+			if kerrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, fmt.Errorf("waiting for workspace to be deleted: %w", err)
+		}
+		return false, nil
+	})
 	if err != nil {
-		if errors.Is(err, wait.ErrWaitTimeout) {
-			return nil, errPodTimeout
+		return err
+	}
+
+	err = wait.PollImmediate(time.Second, o.replaceTimeout, func() (bool, error) {
+		pod, err := o.PodsClient(o.namespace).Get(ctx, existing.PodName(), metav1.GetOptions{})
+		if err == nil && metav1.IsControlledBy(pod, existing) {
+			return false, nil
+		} else if err != nil && !kerrors.IsNotFound(err) {
+			return false, fmt.Errorf("waiting for workspace pod to be deleted: %w", err)
+		}
+
+		pvc, err := o.PersistentVolumeClaimsClient(o.namespace).Get(ctx, existing.PVCName(), metav1.GetOptions{})
+		if err == nil && metav1.IsControlledBy(pvc, existing) {
+			return false, nil
+		} else if err != nil && !kerrors.IsNotFound(err) {
+			return false, fmt.Errorf("waiting for workspace cache to be deleted: %w", err)
 		}
-		return nil, err
+		return true, nil
+	})
+	if err != nil {
+		return err
 	}
-	return event.Object.(*corev1.Pod), nil
+
+	o.progressf("Deleted workspace %s\n", klog.KObj(existing))
+	return nil
 }
 
-// waitForReconcile waits for the workspace resource to be reconciled.
-func (o *newOptions) waitForReconcile(ctx context.Context, ws *v1alpha1.Workspace) error {
-	lw := &k8s.WorkspaceListWatcher{Client: o.EtokClient, Name: ws.Name, Namespace: ws.Namespace}
-	hdlr := handlers.Reconciled(ws)
+// checkRestoreGenerationBackend checks that the workspace uses the kubernetes
+// backend should a backup generation be restored, because the state of other
+// backends is neither backed up nor restored.
+func (o *newOptions) checkRestoreGenerationBackend() error {
+	if o.restoreGeneration == 0 {
+		return nil
+	}
+	if ws := (v1alpha1.Workspace{Spec: o.workspaceSpec}); ws.BackendType() != v1alpha1.BackendKubernetes {
+		return fmt.Errorf("%w: backend type is %s", errRestoreGenerationBackend, ws.BackendType())
+	}
+	return nil
+}
 
-	ctx, cancel := context.WithTimeout(ctx, o.reconcileTimeout)
-	defer cancel()
+// confirmReplace prompts the user to confirm the replacement of an existing
+// workspace. A TTY is required to do so.
+func (o *newOptions) confirmReplace(existing *v1alpha1.Workspace) error {
+	if !term.IsTerminal(o.In) {
+		return errReplaceApproval
+	}
 
-	_, err := watchtools.UntilWithSync(ctx, lw, &v1alpha1.Workspace{}, nil, hdlr)
-	if err != nil {
-		if errors.Is(err, wait.ErrWaitTimeout) {
-			return errReconcileTimeout
-		}
+	fmt.Fprintf(o.Out, "Workspace %s already exists. Its pod and cache will be deleted", klog.KObj(existing))
+	if discardsState(existing) {
+		fmt.Fprint(o.Out, ", along with its state (it is neither stored in a remote backend nor backed up)")
+	}
+	fmt.Fprint(o.Out, ". Replace it? [y/N] ")
+
+	answer, err := bufio.NewReader(o.In).ReadString('\n')
+	if err != nil && err != io.EOF {
 		return err
 	}
-	return nil
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errReplaceNotConfirmed
+	}
 }
 
-// waitForReady waits for the ready condition to indicate it is ready.
-func (o *newOptions) waitForReady(ctx context.Context, ws *v1alpha1.Workspace) error {
-	lw := &k8s.WorkspaceListWatcher{Client: o.EtokClient, Name: ws.Name, Namespace: ws.Namespace}
-	hdlr := handlers.WorkspaceReady()
+// discardsState determines whether deleting the workspace deletes its state,
+// i.e. it is neither stored in a remote backend nor backed up
+func discardsState(ws *v1alpha1.Workspace) bool {
+	return isDefaultBackend(ws.Spec.Backend) && ws.Spec.BackupBucket == ""
+}
 
-	ctx, cancel := context.WithTimeout(ctx, o.restoreTimeout)
-	defer cancel()
+// isDefaultBackend determines whether the backend is the default kubernetes
+// backend, configured by etok
+func isDefaultBackend(backend v1alpha1.BackendSpec) bool {
+	return (backend.Type == "" || backend.Type == v1alpha1.BackendKubernetes) && len(backend.Config) == 0 && backend.Raw == ""
+}
 
-	_, err := watchtools.UntilWithSync(ctx, lw, &v1alpha1.Workspace{}, nil, hdlr)
-	if err != nil {
-		if errors.Is(err, wait.ErrWaitTimeout) {
-			return errReadyTimeout
+// onCreate creates the resources the workspace depends upon once the
+// workspace itself is created
+func (o *newOptions) onCreate(ctx context.Context, ws *v1alpha1.Workspace) error {
+	o.progressf("Created workspace %s\n", klog.KObj(ws))
+
+	if len(o.varFilesData) > 0 {
+		if err := o.createVarFilesConfigMap(ctx, ws); err != nil {
+			return err
 		}
-		return err
 	}
+
+	if len(o.secretData) > 0 {
+		if err := o.createOrUpdateSecret(ctx); err != nil {
+			return err
+		}
+	}
+
+	if o.wait {
+		switch o.waitFor {
+		case etokworkspace.WaitForReconcile:
+			o.progressf("Waiting for workspace to be reconciled...\n")
+		case etokworkspace.WaitForRestore:
+			o.progressf("Waiting for workspace to be ready...\n")
+		default:
+			o.progressf("Waiting for workspace pod to be ready...\n")
+		}
+	}
+
 	return nil
 }
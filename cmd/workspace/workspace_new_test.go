@@ -5,30 +5,44 @@ import (
 	"context"
 	"errors"
 	"io"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/leg100/etok/api/etok.dev/v1alpha1"
 	etokerrors "github.com/leg100/etok/pkg/errors"
 	"github.com/leg100/etok/pkg/handlers"
 
 	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
 	"github.com/leg100/etok/pkg/env"
 	"github.com/leg100/etok/pkg/logstreamer"
 	"github.com/leg100/etok/pkg/testobj"
 	"github.com/leg100/etok/pkg/testutil"
+	"github.com/leg100/etok/pkg/tfversion"
+	etokworkspace "github.com/leg100/etok/pkg/workspace"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	testclient "k8s.io/client-go/testing"
 )
 
 func TestNewWorkspace(t *testing.T) {
 	var fakeError = errors.New("fake error")
 
+	// Number of times the replaced workspace's PVC is polled for
+	var pvcPolls int
+
 	tests := []struct {
 		name             string
 		args             []string
+		envs             map[string]string
+		files            map[string][]byte
 		err              error
 		overrideStatus   func(*v1alpha1.WorkspaceStatus)
 		objs             []runtime.Object
@@ -40,6 +54,16 @@ func TestNewWorkspace(t *testing.T) {
 			args: []string{},
 			err:  errWorkspaceNameArg,
 		},
+		{
+			name: "uppercase workspace name",
+			args: []string{"Foo"},
+			err:  errInvalidName,
+		},
+		{
+			name: "workspace name with underscore",
+			args: []string{"foo_bar"},
+			err:  errInvalidName,
+		},
 		{
 			name: "create workspace",
 			args: []string{"foo"},
@@ -79,6 +103,28 @@ func TestNewWorkspace(t *testing.T) {
 				assert.True(t, kerrors.IsNotFound(err))
 			},
 		},
+		{
+			name: "report errors cleaning up resources",
+			args: []string{"foo", "--secret-env", "AWS_ACCESS_KEY_ID=abc"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			err:  fakeError,
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.GetLogsFunc = func(ctx context.Context, opts logstreamer.Options) (io.ReadCloser, error) {
+					return nil, fakeError
+				}
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("delete", "secrets", func(action testclient.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("fake delete error")
+				})
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should still be deleted despite failing to delete
+				// secret
+				_, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				assert.True(t, kerrors.IsNotFound(err))
+
+				assert.Contains(t, o.Out.(*bytes.Buffer).String(), "Warning: unable to clean up resources: deleting secret default/etok: fake delete error\n")
+			},
+		},
 		{
 			name: "do not cleanup resources upon error",
 			args: []string{"foo", "--no-cleanup"},
@@ -139,6 +185,120 @@ func TestNewWorkspace(t *testing.T) {
 				assert.Equal(t, "oz-cluster", o.kubeContext)
 			},
 		},
+		{
+			name: "quiet",
+			args: []string{"foo", "--quiet"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Only the pod's output is printed
+				assert.Equal(t, "fake logs", o.Out.(*bytes.Buffer).String())
+			},
+		},
+		{
+			name: "replace existing workspace",
+			args: []string{"foo", "--replace", "--auto-approve", "--tf-log", "debug"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "foo", testobj.WithBackupBucket("my-bucket"), testobj.WithBackend("s3", "bucket", "my-state", "key", "foo/terraform.tfstate")),
+				testobj.WorkspacePod("default", "foo"),
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				// New spec applied
+				assert.Equal(t, "DEBUG", ws.Spec.TFLog)
+				// Backend and backup bucket retained
+				assert.Equal(t, "my-bucket", ws.Spec.BackupBucket)
+				assert.Equal(t, "s3", ws.Spec.Backend.Type)
+				assert.Equal(t, map[string]string{"bucket": "my-state", "key": "foo/terraform.tfstate"}, ws.Spec.Backend.Config)
+			},
+		},
+		{
+			name: "replace workspace with lingering dependents",
+			args: []string{"foo", "--replace", "--auto-approve", "--tf-log", "debug"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "foo", testobj.WithBackupBucket("my-bucket")),
+				testobj.WorkspacePod("default", "foo"),
+			},
+			factoryOverrides: func(f *cmdutil.Factory) {
+				// The old workspace's PVC lingers for the first poll
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("get", "persistentvolumeclaims", func(action testclient.Action) (bool, runtime.Object, error) {
+					pvcPolls++
+					if pvcPolls > 1 {
+						return false, nil, nil
+					}
+					pvc := &corev1.PersistentVolumeClaim{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "foo",
+							Namespace: "default",
+						},
+					}
+					controller := true
+					pvc.OwnerReferences = []metav1.OwnerReference{{Kind: "Workspace", Name: "foo", Controller: &controller}}
+					return true, pvc, nil
+				})
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Waited for the PVC to be deleted
+				assert.Equal(t, 2, pvcPolls)
+
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, "DEBUG", ws.Spec.TFLog)
+			},
+		},
+		{
+			name: "replace workspace without backup",
+			args: []string{"foo", "--replace", "--auto-approve", "--tf-log", "debug"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "foo"),
+				testobj.WorkspacePod("default", "foo"),
+			},
+			err: errReplaceDiscardsState,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Existing workspace left untouched
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, "", ws.Spec.TFLog)
+			},
+		},
+		{
+			name: "replace workspace without backup discarding state",
+			args: []string{"foo", "--replace", "--auto-approve", "--discard-state", "--tf-log", "debug"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "foo"),
+				testobj.WorkspacePod("default", "foo"),
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, "DEBUG", ws.Spec.TFLog)
+			},
+		},
+		{
+			name: "replace non-existent workspace",
+			args: []string{"foo", "--replace"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				_, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				assert.NoError(t, err)
+			},
+		},
+		{
+			name: "replace without approval",
+			args: []string{"foo", "--replace", "--tf-log", "debug"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "foo"),
+				testobj.WorkspacePod("default", "foo"),
+			},
+			err: errReplaceApproval,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Existing workspace left untouched
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, "", ws.Spec.TFLog)
+			},
+		},
 		{
 			name: "log stream output",
 			args: []string{"foo"},
@@ -165,6 +325,22 @@ func TestNewWorkspace(t *testing.T) {
 				assert.Equal(t, "0.12.17", ws.Spec.TerraformVersion)
 			},
 		},
+		{
+			name: "set terraform version constraint",
+			args: []string{"foo", "--terraform-version", ">= 1.3, < 1.5"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, ">= 1.3, < 1.5", ws.Spec.TerraformVersion)
+			},
+		},
+		{
+			name: "invalid terraform version constraint",
+			args: []string{"foo", "--terraform-version", "latest"},
+			err:  tfversion.ErrInvalidConstraint,
+		},
 		{
 			name: "set terraform variables",
 			args: []string{"foo", "--variables", "foo=bar,baz=haj"},
@@ -191,6 +367,35 @@ func TestNewWorkspace(t *testing.T) {
 				assert.Contains(t, ws.Spec.Variables, &v1alpha1.Variable{Key: "baz", Value: "haj", EnvironmentVariable: true})
 			},
 		},
+		{
+			name: "set environment variables from env file",
+			args: []string{"foo", "--env-file", ".env", "--environment-variables", "baz=override"},
+			files: map[string][]byte{
+				".env": []byte("# provider credentials\nfoo=bar\nexport baz=\"haj\"\n"),
+			},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Contains(t, ws.Spec.Variables, &v1alpha1.Variable{Key: "foo", Value: "bar", EnvironmentVariable: true})
+				// Flag overrides file
+				assert.Contains(t, ws.Spec.Variables, &v1alpha1.Variable{Key: "baz", Value: "override", EnvironmentVariable: true})
+			},
+		},
+		{
+			name: "invalid env file",
+			args: []string{"foo", "--env-file", ".env"},
+			files: map[string][]byte{
+				".env": []byte("foo\n"),
+			},
+			err: errInvalidEnvFile,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
 		{
 			name: "set privileged commands",
 			args: []string{"foo", "--privileged-commands", "apply,destroy,sh"},
@@ -204,53 +409,984 @@ func TestNewWorkspace(t *testing.T) {
 			},
 		},
 		{
-			// Mock a absent/misbehaving operator
-			name: "reconcile timeout exceeded",
-			args: []string{"foo", "--reconcile-timeout", "10ms"},
-			overrideStatus: func(status *v1alpha1.WorkspaceStatus) {
-				// Unset conditions, which should trigger timeout
-				status.Conditions = []metav1.Condition{}
+			name: "set backend",
+			args: []string{"foo", "--backend-type", "s3", "--backend-config", "bucket=my-bucket,key=terraform.tfstate,region=eu-west-2"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "s3", ws.Spec.Backend.Type)
+				assert.Equal(t, map[string]string{"bucket": "my-bucket", "key": "terraform.tfstate", "region": "eu-west-2"}, ws.Spec.Backend.Config)
 			},
-			err: errReconcileTimeout,
 		},
 		{
-			name: "pod timeout exceeded",
-			args: []string{"foo", "--pod-timeout", "10ms"},
-			// Deliberately omit pod
-			objs: []runtime.Object{},
-			err:  errPodTimeout,
+			name: "backend file",
+			args: []string{"foo", "--backend-tf-file", "backend.tf"},
+			files: map[string][]byte{
+				"backend.tf": []byte("terraform {\n  backend \"consul\" {\n    path = \"foo\"\n  }\n}\n"),
+			},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "consul", ws.Spec.Backend.Type)
+				assert.Equal(t, "terraform {\n  backend \"consul\" {\n    path = \"foo\"\n  }\n}\n", ws.Spec.Backend.Raw)
+			},
 		},
 		{
-			name: "workspace failure",
+			name: "invalid backend file",
+			args: []string{"foo", "--backend-tf-file", "backend.tf"},
+			files: map[string][]byte{
+				"backend.tf": []byte(`resource "null_resource" "foo" {}`),
+			},
+			err: errInvalidBackendFile,
+		},
+		{
+			name: "backend file type mismatch",
+			args: []string{"foo", "--backend-tf-file", "backend.tf", "--backend-type", "s3"},
+			files: map[string][]byte{
+				"backend.tf": []byte("terraform {\n  backend \"consul\" {}\n}\n"),
+			},
+			err: errInvalidBackendFile,
+		},
+		{
+			name: "inherit backend",
+			args: []string{"foo", "--inherit-backend", "bar", "--backend-prefix", "foo/terraform.tfstate"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "bar", testobj.WithBackend("s3", "bucket", "my-bucket", "key", "bar/terraform.tfstate", "region", "eu-west-2")),
+				testobj.WorkspacePod("default", "foo"),
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "s3", ws.Spec.Backend.Type)
+				assert.Equal(t, map[string]string{"bucket": "my-bucket", "key": "foo/terraform.tfstate", "region": "eu-west-2"}, ws.Spec.Backend.Config)
+			},
+		},
+		{
+			name: "inherit backend with config override",
+			args: []string{"foo", "--inherit-backend", "bar", "--backend-config", "region=us-east-1"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "bar", testobj.WithBackend("s3", "bucket", "my-bucket", "key", "bar/terraform.tfstate", "region", "eu-west-2")),
+				testobj.WorkspacePod("default", "foo"),
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, map[string]string{"bucket": "my-bucket", "key": "bar/terraform.tfstate", "region": "us-east-1"}, ws.Spec.Backend.Config)
+			},
+		},
+		{
+			name: "inherit remote backend",
+			args: []string{"foo", "--inherit-backend", "bar", "--backend-prefix", "networking-"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "bar", testobj.WithBackend("remote", "organization", "acme", "name", "bar")),
+				testobj.WorkspacePod("default", "foo"),
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, map[string]string{"organization": "acme", "prefix": "networking-"}, ws.Spec.Backend.Config)
+			},
+		},
+		{
+			name: "inherit consul backend",
+			args: []string{"foo", "--inherit-backend", "bar", "--backend-prefix", "etok/foo"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "bar", testobj.WithBackend("consul", "address", "consul.example.com:8500", "path", "etok/bar")),
+				testobj.WorkspacePod("default", "foo"),
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, map[string]string{"address": "consul.example.com:8500", "path": "etok/foo"}, ws.Spec.Backend.Config)
+			},
+		},
+		{
+			name: "inherit backend from non-existent workspace",
+			args: []string{"foo", "--inherit-backend", "bar"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			err:  errInheritBackendNotFound,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "backend prefix unsupported by backend type",
+			args: []string{"foo", "--backend-prefix", "foo/terraform.tfstate"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			err:  errBackendPrefixUnsupported,
+		},
+		{
+			name: "set resources",
+			args: []string{"foo", "--cpu", "500m", "--memory", "512Mi", "--cpu-limit", "1", "--memory-limit", "1Gi"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "500m", ws.Spec.Resources.Requests.Cpu().String())
+				assert.Equal(t, "512Mi", ws.Spec.Resources.Requests.Memory().String())
+				assert.Equal(t, "1", ws.Spec.Resources.Limits.Cpu().String())
+				assert.Equal(t, "1Gi", ws.Spec.Resources.Limits.Memory().String())
+			},
+		},
+		{
+			name: "default resources",
 			args: []string{"foo"},
 			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
-			overrideStatus: func(status *v1alpha1.WorkspaceStatus) {
-				status.Conditions = []metav1.Condition{
-					{
-						Type:    v1alpha1.WorkspaceReadyCondition,
-						Status:  metav1.ConditionFalse,
-						Reason:  v1alpha1.FailureReason,
-						Message: "mock failure",
-					},
-				}
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Nil(t, ws.Spec.Resources.Requests)
+				assert.Nil(t, ws.Spec.Resources.Limits)
 			},
-			err: handlers.ErrWorkspaceFailed,
 		},
 		{
-			name: "restore timeout exceeded",
-			args: []string{"foo", "--backup-bucket", "my-bucket", "--restore-timeout", "100ms"},
+			name: "set scheduling",
+			args: []string{"foo", "--node-selector", "pool=terraform", "--toleration", "dedicated=terraform:NoSchedule", "--toleration", "spot"},
 			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
-			overrideStatus: func(status *v1alpha1.WorkspaceStatus) {
-				// Mock operator failing to provide restoreFailure condition
-				// status
-				status.Conditions = nil
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, map[string]string{"pool": "terraform"}, ws.Spec.NodeSelector)
+				assert.Equal(t, []corev1.Toleration{
+					{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "terraform", Effect: corev1.TaintEffectNoSchedule},
+					{Key: "spot", Operator: corev1.TolerationOpExists},
+				}, ws.Spec.Tolerations)
 			},
-			err: errReadyTimeout,
 		},
-	}
+		{
+			name: "set node affinity",
+			args: []string{"foo", "--node-affinity-key", "topology.kubernetes.io/zone", "--node-affinity-values", "europe-west2-a,europe-west2-b"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				terms := ws.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+				assert.Equal(t, []corev1.NodeSelectorRequirement{
+					{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"europe-west2-a", "europe-west2-b"}},
+				}, terms[0].MatchExpressions)
+			},
+		},
+		{
+			name: "set node affinity key only",
+			args: []string{"foo", "--node-affinity-key", "dedicated"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				terms := ws.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+				assert.Equal(t, []corev1.NodeSelectorRequirement{
+					{Key: "dedicated", Operator: corev1.NodeSelectorOpExists},
+				}, terms[0].MatchExpressions)
+			},
+		},
+		{
+			name: "invalid node affinity without key",
+			args: []string{"foo", "--node-affinity-values", "europe-west2-a"},
+			err:  errInvalidAffinity,
+		},
+		{
+			name: "invalid toleration effect",
+			args: []string{"foo", "--toleration", "dedicated=terraform:Sometimes"},
+			err:  errInvalidToleration,
+		},
+		{
+			name: "invalid toleration missing key",
+			args: []string{"foo", "--toleration", "=terraform"},
+			err:  errInvalidToleration,
+		},
+		{
+			name: "set pod annotations and labels",
+			args: []string{"foo", "--pod-annotations", "sidecar.istio.io/inject=false", "--pod-labels", "team=infra"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, map[string]string{"sidecar.istio.io/inject": "false"}, ws.Spec.PodAnnotations)
+				assert.Equal(t, map[string]string{"team": "infra"}, ws.Spec.PodLabels)
+			},
+		},
+		{
+			name: "set terraform log level",
+			args: []string{"foo", "--tf-log", "debug"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "DEBUG", ws.Spec.TFLog)
+			},
+		},
+		{
+			name: "set backup retention",
+			args: []string{"foo", "--backup-bucket", "my-bucket", "--backup-retention", "5"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, 5, ws.Spec.BackupRetention)
+			},
+		},
+		{
+			name: "enable cache backup",
+			args: []string{"foo", "--backup-bucket", "my-bucket", "--cache-backup"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.True(t, ws.Spec.CacheBackup)
+			},
+		},
+		{
+			name: "ephemeral cache",
+			args: []string{"foo", "--ephemeral-cache"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.True(t, ws.Spec.Cache.Ephemeral)
+			},
+		},
+		{
+			name: "set cache access mode",
+			args: []string{"foo", "--access-mode", "ReadWriteMany"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, ws.Spec.Cache.AccessModes)
+			},
+		},
+		{
+			name: "invalid cache access mode",
+			args: []string{"foo", "--access-mode", "ReadWriteSometimes"},
+			err:  errInvalidAccessMode,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "set image pull policy",
+			args: []string{"foo", "--image-pull-policy", "Always"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, corev1.PullAlways, ws.Spec.ImagePullPolicy)
+			},
+		},
+		{
+			name: "invalid image pull policy",
+			args: []string{"foo", "--image-pull-policy", "Sometimes"},
+			err:  errInvalidImagePullPolicy,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "set backup kms key",
+			args: []string{"foo", "--backup-bucket", "my-bucket", "--backup-provider", "s3", "--backup-kms-key", "alias/etok"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "alias/etok", ws.Spec.BackupKMSKey)
+			},
+		},
+		{
+			name: "set image",
+			args: []string{"foo", "--image", "acme/etok-terraform:1.4.6"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "acme/etok-terraform:1.4.6", ws.Spec.Image)
+			},
+		},
+		{
+			name: "set security context",
+			args: []string{"foo", "--run-as-user", "1000", "--read-only-root"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				if assert.NotNil(t, ws.Spec.PodSecurityContext) {
+					assert.Equal(t, int64(1000), *ws.Spec.PodSecurityContext.RunAsUser)
+					assert.True(t, *ws.Spec.PodSecurityContext.RunAsNonRoot)
+					assert.Equal(t, int64(1000), *ws.Spec.PodSecurityContext.FSGroup)
+				}
+				if assert.NotNil(t, ws.Spec.SecurityContext) {
+					assert.True(t, *ws.Spec.SecurityContext.ReadOnlyRootFilesystem)
+				}
+			},
+		},
+		{
+			name: "default security context",
+			args: []string{"foo"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Nil(t, ws.Spec.PodSecurityContext)
+				assert.Nil(t, ws.Spec.SecurityContext)
+			},
+		},
+		{
+			name: "set image pull secrets",
+			args: []string{"foo", "--image-pull-secrets", "registry-creds"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, []string{"registry-creds"}, ws.Spec.ImagePullSecrets)
+			},
+		},
+		{
+			name: "set max concurrent runs",
+			args: []string{"foo", "--max-concurrent-runs", "3"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, 3, ws.Spec.MaxConcurrentRuns)
+			},
+		},
+		{
+			name: "require approval",
+			args: []string{"foo", "--require-approval"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.True(t, ws.Spec.RequireApproval)
+			},
+		},
+		{
+			name: "invalid max concurrent runs",
+			args: []string{"foo", "--max-concurrent-runs", "-1"},
+			err:  errInvalidMaxConcurrentRuns,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "set termination grace period",
+			args: []string{"foo", "--termination-grace-period", "900"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				if assert.NotNil(t, ws.Spec.TerminationGracePeriodSeconds) {
+					assert.Equal(t, int64(900), *ws.Spec.TerminationGracePeriodSeconds)
+				}
+			},
+		},
+		{
+			name: "default termination grace period",
+			args: []string{"foo"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				// Left to the operator to default
+				assert.Nil(t, ws.Spec.TerminationGracePeriodSeconds)
+			},
+		},
+		{
+			name: "invalid termination grace period",
+			args: []string{"foo", "--termination-grace-period", "-1"},
+			err:  errInvalidGracePeriod,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "invalid backup retention",
+			args: []string{"foo", "--backup-bucket", "my-bucket", "--backup-retention", "-1"},
+			err:  errInvalidBackupRetention,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "cache backup without backup bucket",
+			args: []string{"foo", "--cache-backup"},
+			err:  errCacheBackupBucket,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "restore generation",
+			args: []string{"foo", "--backup-bucket", "my-bucket", "--restore-generation", "1617191435237187"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "1617191435237187", ws.Annotations[v1alpha1.RestoreGenerationAnnotationKey])
+			},
+		},
+		{
+			name: "restore generation without backup bucket",
+			args: []string{"foo", "--restore-generation", "1617191435237187"},
+			err:  errRestoreGeneration,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "restore generation with s3 backup provider",
+			args: []string{"foo", "--backup-bucket", "my-bucket", "--backup-provider", "s3", "--restore-generation", "1617191435237187"},
+			err:  errRestoreGeneration,
+		},
+		{
+			name: "restore generation with s3 backend",
+			args: []string{"foo", "--backup-bucket", "my-bucket", "--restore-generation", "1617191435237187", "--backend-type", "s3", "--backend-config", "bucket=my-state,key=foo/terraform.tfstate,region=eu-west-2"},
+			err:  errRestoreGenerationBackend,
+		},
+		{
+			name: "replace workspace with s3 backend restoring generation",
+			args: []string{"foo", "--replace", "--auto-approve", "--backup-bucket", "my-bucket", "--restore-generation", "1617191435237187"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "foo", testobj.WithBackupBucket("my-bucket"), testobj.WithBackend("s3", "bucket", "my-state", "key", "foo/terraform.tfstate")),
+				testobj.WorkspacePod("default", "foo"),
+			},
+			err: errRestoreGenerationBackend,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Existing workspace left untouched
+				_, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "invalid restore generation",
+			args: []string{"foo", "--backup-bucket", "my-bucket", "--restore-generation", "0"},
+			err:  errInvalidRestoreGeneration,
+		},
+		{
+			name: "invalid terraform log level",
+			args: []string{"foo", "--tf-log", "verbose"},
+			err:  errInvalidTFLog,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "set additional secrets",
+			args: []string{"foo", "--secrets", "gcp-creds,registry-token"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, []string{"gcp-creds", "registry-token"}, ws.Spec.SecretNames)
+			},
+		},
+		{
+			name: "set variables sources",
+			args: []string{"foo", "--variables-from", "configmap/tfvars", "--variables-from", "secret/tfvars-sensitive"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, []v1alpha1.VariablesSource{
+					{Kind: "ConfigMap", Name: "tfvars"},
+					{Kind: "Secret", Name: "tfvars-sensitive"},
+				}, ws.Spec.VariablesFrom)
+			},
+		},
+		{
+			name: "invalid variables source",
+			args: []string{"foo", "--variables-from", "deployment/tfvars"},
+			err:  errInvalidVariablesFrom,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "set private registry credentials",
+			args: []string{"foo", "--netrc-secret", "netrc", "--registry-tokens", "app.terraform.io=registry-tokens:tfc"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "netrc", ws.Spec.NetrcSecret)
+				assert.Equal(t, map[string]corev1.SecretKeySelector{
+					"app.terraform.io": {
+						LocalObjectReference: corev1.LocalObjectReference{Name: "registry-tokens"},
+						Key:                  "tfc",
+					},
+				}, ws.Spec.RegistryTokens)
+			},
+		},
+		{
+			name: "invalid registry token",
+			args: []string{"foo", "--registry-tokens", "app.terraform.io=secret-token"},
+			err:  errInvalidRegistryToken,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "set CA bundle",
+			args: []string{"foo", "--ca-bundle-secret", "ca-bundle"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "ca-bundle", ws.Spec.CABundleSecret)
+			},
+		},
+		{
+			name: "set terraform CLI config",
+			args: []string{"foo", "--terraformrc-config-map", "terraformrc"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "terraformrc", ws.Spec.TerraformRCConfigMap)
+			},
+		},
+		{
+			name: "set pre-run script",
+			args: []string{"foo", "--pre-run", "./fetch-creds.sh"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "./fetch-creds.sh", ws.Spec.PreRunScript)
+			},
+		},
+		{
+			name: "set init args",
+			args: []string{"foo", "--init-args=-upgrade,-reconfigure"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, []string{"-upgrade", "-reconfigure"}, ws.Spec.InitArgs)
+			},
+		},
+		{
+			name: "set terraform args",
+			args: []string{"foo", "--terraform-args=-lock-timeout=5m,-parallelism=20"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, []string{"-lock-timeout=5m", "-parallelism=20"}, ws.Spec.TerraformArgs)
+			},
+		},
+		{
+			name: "set var files",
+			args: []string{"foo", "--var-file", "common.tfvars", "--var-file", "vars/prod.tfvars"},
+			files: map[string][]byte{
+				"common.tfvars":    []byte("region = \"europe-west2\"\n"),
+				"vars/prod.tfvars": []byte("environment = \"prod\"\n"),
+			},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, []string{"0-common.tfvars", "1-prod.tfvars"}, ws.Spec.VarFiles)
+
+				// Get var files config map
+				varFiles, err := o.ConfigMapsClient(o.namespace).Get(context.Background(), ws.VarFilesConfigMapName(), metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "region = \"europe-west2\"\n", varFiles.Data["0-common.tfvars"])
+				assert.Equal(t, "environment = \"prod\"\n", varFiles.Data["1-prod.tfvars"])
+			},
+		},
+		{
+			name: "spec from file",
+			args: []string{"foo", "--from-file", "spec.yaml"},
+			files: map[string][]byte{
+				"spec.yaml": []byte(`
+terraformVersion: 0.13.5
+backupBucket: my-bucket
+cache:
+  size: 5Gi
+privilegedCommands:
+- apply
+variables:
+- key: foo
+  value: bar
+- key: TF_LOG
+  value: DEBUG
+  environmentVariable: true
+`),
+			},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "0.13.5", ws.Spec.TerraformVersion)
+				assert.Equal(t, "my-bucket", ws.Spec.BackupBucket)
+				assert.Equal(t, "5Gi", ws.Spec.Cache.Size)
+				assert.Equal(t, []string{"apply"}, ws.Spec.PrivilegedCommands)
+				assert.Equal(t, []*v1alpha1.Variable{
+					{Key: "foo", Value: "bar"},
+					{Key: "TF_LOG", Value: "DEBUG", EnvironmentVariable: true},
+				}, ws.Spec.Variables)
+
+				// Values absent from the file take flag defaults
+				assert.Equal(t, v1alpha1.BackendKubernetes, ws.Spec.Backend.Type)
+				assert.Equal(t, v1alpha1.BackupProviderGCS, ws.Spec.BackupProvider)
+				assert.Nil(t, ws.Spec.Cache.StorageClass)
+			},
+		},
+		{
+			name: "flags override spec file",
+			args: []string{"foo", "--from-file", "spec.yaml", "--terraform-version", "0.14.0", "--variables", "foo=baz"},
+			files: map[string][]byte{
+				"spec.yaml": []byte(`
+terraformVersion: 0.13.5
+cache:
+  size: 5Gi
+variables:
+- key: foo
+  value: bar
+`),
+			},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Get workspace
+				ws, err := o.WorkspacesClient(o.namespace).Get(context.Background(), o.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, "0.14.0", ws.Spec.TerraformVersion)
+				assert.Equal(t, "5Gi", ws.Spec.Cache.Size)
+				assert.Equal(t, []*v1alpha1.Variable{{Key: "foo", Value: "baz"}}, ws.Spec.Variables)
+			},
+		},
+		{
+			name: "spec file with unknown field",
+			args: []string{"foo", "--from-file", "spec.yaml"},
+			files: map[string][]byte{
+				"spec.yaml": []byte("terraformVersoin: 0.13.5\n"),
+			},
+			err: errInvalidSpecFile,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "set secret env",
+			args: []string{"foo", "--secret-env", "AWS_ACCESS_KEY_ID=abc", "--secret-env", "AWS_SECRET_ACCESS_KEY=@secret.txt"},
+			files: map[string][]byte{
+				"secret.txt": []byte("xyz"),
+			},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				secret, err := o.SecretsClient(o.namespace).Get(context.Background(), "etok", metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, map[string][]byte{
+					"AWS_ACCESS_KEY_ID":     []byte("abc"),
+					"AWS_SECRET_ACCESS_KEY": []byte("xyz"),
+				}, secret.Data)
+				assert.True(t, o.createdSecret)
+			},
+		},
+		{
+			name: "merge secret env into existing secret",
+			args: []string{"foo", "--secret-env", "AWS_ACCESS_KEY_ID=abc"},
+			objs: []runtime.Object{
+				testobj.WorkspacePod("default", "foo"),
+				testobj.Secret("default", "etok", testobj.WithData("AWS_ACCESS_KEY_ID", "old"), testobj.WithData("GOOGLE_CREDENTIALS", "def")),
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				secret, err := o.SecretsClient(o.namespace).Get(context.Background(), "etok", metav1.GetOptions{})
+				require.NoError(t, err)
+
+				assert.Equal(t, map[string][]byte{
+					"AWS_ACCESS_KEY_ID":  []byte("abc"),
+					"GOOGLE_CREDENTIALS": []byte("def"),
+				}, secret.Data)
+				// Existing secret should not be deleted upon error
+				assert.False(t, o.createdSecret)
+			},
+		},
+		{
+			name: "invalid secret env",
+			args: []string{"foo", "--secret-env", "AWS_ACCESS_KEY_ID"},
+			err:  errInvalidSecretEnv,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "non-existent secret env file",
+			args: []string{"foo", "--secret-env", "AWS_SECRET_ACCESS_KEY=@does-not-exist.txt"},
+			err:  os.ErrNotExist,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			name: "dry run",
+			args: []string{"foo", "--dry-run", "--terraform-version", "0.13.5", "--secret-env", "AWS_ACCESS_KEY_ID=abc", "--var-file", "common.tfvars"},
+			files: map[string][]byte{
+				"common.tfvars": []byte("region = \"europe-west2\"\n"),
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Nothing should have been created
+				assert.False(t, o.createdWorkspace)
+				assert.False(t, o.createdSecret)
+
+				docs := strings.Split(o.Out.(*bytes.Buffer).String(), "---\n")
+				if assert.Equal(t, 3, len(docs)) {
+					assert.Contains(t, docs[0], "kind: Workspace\n")
+					assert.Contains(t, docs[0], "terraformVersion: 0.13.5\n")
+					assert.Contains(t, docs[1], "kind: Secret\n")
+					assert.Contains(t, docs[2], "kind: ConfigMap\n")
+				}
+			},
+		},
+		{
+			name: "non-existent var file",
+			args: []string{"foo", "--var-file", "does-not-exist.tfvars"},
+			err:  os.ErrNotExist,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+		{
+			// Mock a absent/misbehaving operator
+			name: "reconcile timeout exceeded",
+			args: []string{"foo", "--reconcile-timeout", "10ms"},
+			overrideStatus: func(status *v1alpha1.WorkspaceStatus) {
+				// Unset conditions, which should trigger timeout
+				status.Conditions = []metav1.Condition{}
+			},
+			err: etokworkspace.ErrReconcileTimeout,
+		},
+		{
+			name: "pod timeout exceeded",
+			args: []string{"foo", "--pod-timeout", "10ms"},
+			// Deliberately omit pod
+			objs: []runtime.Object{},
+			err:  etokworkspace.ErrPodTimeout,
+		},
+		{
+			name: "workspace failure",
+			args: []string{"foo"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			overrideStatus: func(status *v1alpha1.WorkspaceStatus) {
+				status.Conditions = []metav1.Condition{
+					{
+						Type:    v1alpha1.WorkspaceReadyCondition,
+						Status:  metav1.ConditionFalse,
+						Reason:  v1alpha1.FailureReason,
+						Message: "mock failure",
+					},
+				}
+			},
+			err: handlers.ErrWorkspaceFailed,
+		},
+		{
+			name: "no wait",
+			args: []string{"foo", "--wait=false"},
+			overrideStatus: func(status *v1alpha1.WorkspaceStatus) {
+				// Mock operator yet to reconcile workspace
+				status.Conditions = nil
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Confirm workspace resource has been created
+				_, err := o.WorkspacesClient("default").Get(context.Background(), "foo", metav1.GetOptions{})
+				require.NoError(t, err)
+
+				// Confirm env file has been written
+				etokenv, err := env.Read(o.path)
+				require.NoError(t, err)
+				assert.Equal(t, "default/foo", etokenv.String())
+			},
+		},
+		{
+			name: "wait for reconcile",
+			args: []string{"foo", "--wait-for", "reconcile"},
+			overrideStatus: func(status *v1alpha1.WorkspaceStatus) {
+				// Mock operator having reconciled workspace but with its pod
+				// yet to be scheduled
+				status.Conditions = []metav1.Condition{
+					{
+						Type:    v1alpha1.WorkspaceReadyCondition,
+						Status:  metav1.ConditionFalse,
+						Reason:  v1alpha1.PendingReason,
+						Message: "mock pending",
+					},
+				}
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				assert.Contains(t, o.Out.(*bytes.Buffer).String(), "Waiting for workspace to be reconciled...")
+			},
+		},
+		{
+			name: "invalid wait for",
+			args: []string{"foo", "--wait-for", "install"},
+			err:  errInvalidWaitFor,
+		},
+		{
+			name: "operator not installed",
+			args: []string{"foo"},
+			err:  cmdutil.ErrOperatorNotReady,
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("list", "deployments", func(action testclient.Action) (bool, runtime.Object, error) {
+					return true, &appsv1.DeploymentList{}, nil
+				})
+			},
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				_, err := o.WorkspacesClient("default").Get(context.Background(), "foo", metav1.GetOptions{})
+				assert.True(t, kerrors.IsNotFound(err))
+			},
+		},
+		{
+			name: "restore timeout exceeded",
+			args: []string{"foo", "--backup-bucket", "my-bucket", "--restore-timeout", "100ms"},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			overrideStatus: func(status *v1alpha1.WorkspaceStatus) {
+				// Mock operator failing to provide restoreFailure condition
+				// status
+				status.Conditions = nil
+			},
+			err: etokworkspace.ErrReadyTimeout,
+		},
+		{
+			name: "pod timeout set via env var",
+			args: []string{"foo"},
+			envs: map[string]string{"ETOK_POD_TIMEOUT": "10ms"},
+			// Deliberately omit pod
+			objs: []runtime.Object{},
+			err:  etokworkspace.ErrPodTimeout,
+		},
+		{
+			name: "timeout flags take precedence over env vars",
+			args: []string{"foo", "--reconcile-timeout", "5s", "--pod-timeout", "6s", "--restore-timeout", "7s"},
+			envs: map[string]string{
+				"ETOK_RECONCILE_TIMEOUT": "1s",
+				"ETOK_POD_TIMEOUT":       "2s",
+				"ETOK_RESTORE_TIMEOUT":   "3s",
+			},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				assert.Equal(t, 5*time.Second, o.reconcileTimeout)
+				assert.Equal(t, 6*time.Second, o.podTimeout)
+				assert.Equal(t, 7*time.Second, o.restoreTimeout)
+			},
+		},
+		{
+			name: "timeouts set via env vars",
+			args: []string{"foo"},
+			envs: map[string]string{
+				"ETOK_RECONCILE_TIMEOUT": "1s",
+				"ETOK_POD_TIMEOUT":       "2s",
+				"ETOK_RESTORE_TIMEOUT":   "3s",
+			},
+			objs: []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			assertions: func(t *testutil.T, o *newOptions) {
+				assert.Equal(t, 1*time.Second, o.reconcileTimeout)
+				assert.Equal(t, 2*time.Second, o.podTimeout)
+				assert.Equal(t, 3*time.Second, o.restoreTimeout)
+			},
+		},
+		{
+			name: "invalid timeout env var",
+			args: []string{"foo"},
+			envs: map[string]string{"ETOK_RESTORE_TIMEOUT": "forever"},
+			err:  errInvalidDuration,
+			assertions: func(t *testutil.T, o *newOptions) {
+				// Workspace should not have been created
+				assert.False(t, o.createdWorkspace)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			t.SetEnvs(tt.envs)
 
-	for _, tt := range tests {
-		testutil.Run(t, tt.name, func(t *testutil.T) {
 			out := new(bytes.Buffer)
 			f := cmdutil.NewFakeFactory(out, tt.objs...)
 
@@ -263,7 +1399,7 @@ func TestNewWorkspace(t *testing.T) {
 			cmd.SetArgs(tt.args)
 
 			// Override path
-			path := t.NewTempDir().Chdir().Root()
+			path := t.NewTempDir().Chdir().WriteFiles(tt.files).Root()
 			opts.path = path
 
 			// Mock the workspace controller by setting status up front
@@ -0,0 +1,158 @@
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/cmd/flags"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+// Interval between polling runs whilst draining a workspace
+var drainInterval = time.Second
+
+type pauseOptions struct {
+	*cmdutil.Factory
+
+	*client.Client
+
+	namespace   string
+	workspace   string
+	kubeContext string
+
+	// Pause (true) or resume (false) the workspace
+	paused bool
+
+	// Wait for runs that have already started to finish
+	drain bool
+	// Timeout for runs to finish when draining
+	drainTimeout time.Duration
+}
+
+func pauseCmd(f *cmdutil.Factory) (*cobra.Command, *pauseOptions) {
+	o := &pauseOptions{
+		Factory:   f,
+		namespace: defaultNamespace,
+		paused:    true,
+	}
+	cmd := &cobra.Command{
+		Use:   "pause <workspace>",
+		Short: "Pause an etok workspace, holding new runs without starting them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			o.workspace = args[0]
+
+			o.Client, err = f.Create(o.kubeContext)
+			if err != nil {
+				return err
+			}
+
+			return o.run(cmd.Context())
+		},
+	}
+
+	flags.AddNamespaceFlag(cmd, &o.namespace)
+	flags.AddKubeContextFlag(cmd, &o.kubeContext)
+
+	cmd.Flags().BoolVar(&o.drain, "drain", false, "Wait for runs that have already started to finish")
+	cmd.Flags().DurationVar(&o.drainTimeout, "drain-timeout", time.Hour, "Timeout for runs to finish when draining")
+
+	return cmd, o
+}
+
+func resumeCmd(f *cmdutil.Factory) (*cobra.Command, *pauseOptions) {
+	o := &pauseOptions{
+		Factory:   f,
+		namespace: defaultNamespace,
+	}
+	cmd := &cobra.Command{
+		Use:   "resume <workspace>",
+		Short: "Resume a paused etok workspace, starting its held runs",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			o.workspace = args[0]
+
+			o.Client, err = f.Create(o.kubeContext)
+			if err != nil {
+				return err
+			}
+
+			return o.run(cmd.Context())
+		},
+	}
+
+	flags.AddNamespaceFlag(cmd, &o.namespace)
+	flags.AddKubeContextFlag(cmd, &o.kubeContext)
+
+	return cmd, o
+}
+
+func (o *pauseOptions) run(ctx context.Context) error {
+	var ws *v1alpha1.Workspace
+
+	// Retry upon conflict, i.e. the workspace was updated by someone else (or
+	// the operator) in between getting and updating it
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() (err error) {
+		ws, err = o.WorkspacesClient(o.namespace).Get(ctx, o.workspace, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if ws.Spec.Paused == o.paused {
+			return nil
+		}
+
+		ws.Spec.Paused = o.paused
+
+		ws, err = o.WorkspacesClient(o.namespace).Update(ctx, ws, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update workspace: %w", err)
+	}
+
+	if !o.paused {
+		fmt.Fprintf(o.Out, "Resumed workspace %s\n", klog.KObj(ws))
+		return nil
+	}
+
+	fmt.Fprintf(o.Out, "Paused workspace %s\n", klog.KObj(ws))
+
+	if o.drain {
+		fmt.Fprintln(o.Out, "Waiting for started runs to finish...")
+		if err := o.waitForDrain(ctx); err != nil {
+			return fmt.Errorf("failed to drain workspace: %w", err)
+		}
+		fmt.Fprintf(o.Out, "Drained workspace %s\n", klog.KObj(ws))
+	}
+
+	return nil
+}
+
+// waitForDrain waits until none of the workspace's runs have a pod that is
+// being provisioned or is running
+func (o *pauseOptions) waitForDrain(ctx context.Context) error {
+	return wait.PollImmediate(drainInterval, o.drainTimeout, func() (bool, error) {
+		runs, err := o.RunsClient(o.namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, run := range runs.Items {
+			if run.Workspace != o.workspace {
+				continue
+			}
+			switch run.Phase {
+			case v1alpha1.RunPhaseProvisioning, v1alpha1.RunPhaseRunning:
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
@@ -0,0 +1,126 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestPauseWorkspace(t *testing.T) {
+	tests := []struct {
+		name       string
+		cmd        func(*cmdutil.Factory) (*cobra.Command, *pauseOptions)
+		args       []string
+		objs       []runtime.Object
+		err        bool
+		out        string
+		assertions func(*testutil.T, *v1alpha1.Workspace)
+	}{
+		{
+			name: "pause",
+			cmd:  pauseCmd,
+			args: []string{"foo"},
+			objs: []runtime.Object{testobj.Workspace("default", "foo")},
+			out:  "Paused workspace default/foo\n",
+			assertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.True(t, ws.Spec.Paused)
+			},
+		},
+		{
+			name: "pause already paused workspace",
+			cmd:  pauseCmd,
+			args: []string{"foo"},
+			objs: []runtime.Object{testobj.Workspace("default", "foo", testobj.WithPaused())},
+			out:  "Paused workspace default/foo\n",
+			assertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.True(t, ws.Spec.Paused)
+			},
+		},
+		{
+			name: "pause workspace in non-default namespace",
+			cmd:  pauseCmd,
+			args: []string{"foo", "--namespace", "bar"},
+			objs: []runtime.Object{testobj.Workspace("bar", "foo")},
+			out:  "Paused workspace bar/foo\n",
+		},
+		{
+			name: "pause workspace not found",
+			cmd:  pauseCmd,
+			args: []string{"foo"},
+			err:  true,
+		},
+		{
+			name: "pause and drain",
+			cmd:  pauseCmd,
+			args: []string{"foo", "--drain"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "foo"),
+				testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("foo"), testobj.WithRunPhase(v1alpha1.RunPhaseCompleted)),
+				testobj.Run("default", "plan-2", "plan", testobj.WithWorkspace("foo"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting)),
+				testobj.Run("default", "plan-3", "plan", testobj.WithWorkspace("bar"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			},
+			out: "Paused workspace default/foo\nWaiting for started runs to finish...\nDrained workspace default/foo\n",
+		},
+		{
+			name: "pause and drain with run still running",
+			cmd:  pauseCmd,
+			args: []string{"foo", "--drain", "--drain-timeout", "50ms"},
+			objs: []runtime.Object{
+				testobj.Workspace("default", "foo"),
+				testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("foo"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			},
+			err: true,
+			assertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				// Workspace remains paused
+				assert.True(t, ws.Spec.Paused)
+			},
+		},
+		{
+			name: "resume",
+			cmd:  resumeCmd,
+			args: []string{"foo"},
+			objs: []runtime.Object{testobj.Workspace("default", "foo", testobj.WithPaused())},
+			out:  "Resumed workspace default/foo\n",
+			assertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.False(t, ws.Spec.Paused)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			t.Override(&drainInterval, 10*time.Millisecond)
+
+			out := new(bytes.Buffer)
+			f := cmdutil.NewFakeFactory(out, tt.objs...)
+
+			cmd, opts := tt.cmd(f)
+			cmd.SetOut(out)
+			cmd.SetArgs(tt.args)
+
+			t.CheckError(tt.err, cmd.ExecuteContext(context.Background()))
+
+			if tt.out != "" {
+				assert.Equal(t, tt.out, out.String())
+			}
+
+			if tt.assertions != nil {
+				ws, err := opts.WorkspacesClient(opts.namespace).Get(context.Background(), opts.workspace, metav1.GetOptions{})
+				require.NoError(t, err)
+
+				tt.assertions(t, ws)
+			}
+		})
+	}
+}
@@ -8,31 +8,75 @@ import (
 	cmdutil "github.com/leg100/etok/cmd/util"
 	"github.com/leg100/etok/pkg/env"
 	"github.com/spf13/cobra"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
 )
 
+// localWorkspace is printed in lieu of the workspace resource when the cluster
+// cannot be reached
+type localWorkspace struct {
+	Namespace string `json:"namespace"`
+	Workspace string `json:"workspace"`
+}
+
 func showCmd(f *cmdutil.Factory) *cobra.Command {
-	var path string
+	var path, kubeContext, output string
 
 	cmd := &cobra.Command{
 		Use:   "show",
 		Short: "Show current workspace",
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err := validateOutputFormat(output); err != nil {
+				return err
+			}
+
 			etokenv, err := env.Read(path)
 			if err != nil {
-				if os.IsNotExist(err) {
-					// no .terraform/environment, so show defaults
-					fmt.Fprintf(f.Out, "%s/%s\n", defaultNamespace, defaultWorkspace)
-					return nil
+				if !os.IsNotExist(err) {
+					return fmt.Errorf("failed reading contents of %s: %w", path, err)
 				}
-				return fmt.Errorf("failed reading contents of %s: %w", path, err)
+				// no .terraform/environment, so show defaults
+				etokenv = &env.Env{Namespace: defaultNamespace, Workspace: defaultWorkspace}
 			}
 
-			fmt.Fprintln(f.Out, etokenv)
-			return nil
+			if output == "" {
+				fmt.Fprintln(f.Out, etokenv)
+				return nil
+			}
+
+			var obj interface{} = &localWorkspace{Namespace: etokenv.Namespace, Workspace: etokenv.Workspace}
+
+			// Fetch live workspace, falling back to local info if cluster is
+			// unreachable
+			client, err := f.Create(kubeContext)
+			if err != nil {
+				klog.V(1).Infof("unable to create client; showing local workspace info only: %s", err.Error())
+			} else {
+				ws, err := client.WorkspacesClient(etokenv.Namespace).Get(cmd.Context(), etokenv.Workspace, metav1.GetOptions{})
+				switch {
+				case kerrors.IsNotFound(err):
+					return fmt.Errorf("workspace %s not found", etokenv)
+				case err != nil:
+					klog.V(1).Infof("unable to retrieve workspace; showing local workspace info only: %s", err.Error())
+				default:
+					obj = ws
+				}
+			}
+
+			switch output {
+			case "json":
+				return printJSON(f, obj)
+			default:
+				return printYAML(f, obj)
+			}
 		},
 	}
 
 	flags.AddPathFlag(cmd, &path)
+	flags.AddKubeContextFlag(cmd, &kubeContext)
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output format, including the workspace's status. One of: json|yaml")
 
 	return cmd
 }
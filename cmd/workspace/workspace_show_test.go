@@ -3,22 +3,33 @@ package workspace
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"testing"
 
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
 	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
 	"github.com/leg100/etok/pkg/env"
+	"github.com/leg100/etok/pkg/testobj"
 	"github.com/leg100/etok/pkg/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	testclient "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
 )
 
 func TestWorkspaceShow(t *testing.T) {
 	tests := []struct {
-		name string
-		args []string
-		env  *env.Env
-		out  string
-		err  bool
+		name             string
+		args             []string
+		env              *env.Env
+		objs             []runtime.Object
+		factoryOverrides func(*cmdutil.Factory)
+		out              string
+		err              bool
+		assertions       func(*testutil.T, *bytes.Buffer)
 	}{
 		{
 			name: "WithEnvironmentFile",
@@ -31,6 +42,56 @@ func TestWorkspaceShow(t *testing.T) {
 			args: []string{"show"},
 			out:  "default/default\n",
 		},
+		{
+			name: "InvalidOutputFormat",
+			args: []string{"show", "-o", "xml"},
+			err:  true,
+		},
+		{
+			name: "JSON",
+			args: []string{"show", "-o", "json"},
+			env:  &env.Env{Namespace: "default", Workspace: "workspace-1"},
+			objs: []runtime.Object{testobj.Workspace("default", "workspace-1", testobj.WithCombinedQueue("apply-1", "apply-2"), testobj.WithBackend("s3"))},
+			assertions: func(t *testutil.T, out *bytes.Buffer) {
+				var ws v1alpha1.Workspace
+				require.NoError(t, json.Unmarshal(out.Bytes(), &ws))
+
+				assert.Equal(t, "apply-1", ws.Status.Active)
+				assert.Equal(t, []string{"apply-2"}, ws.Status.Queue)
+				assert.Equal(t, v1alpha1.WorkspaceReadyCondition, ws.Status.Conditions[0].Type)
+				assert.Equal(t, "s3", ws.Spec.Backend.Type)
+				assert.Equal(t, "1Gi", ws.Spec.Cache.Size)
+			},
+		},
+		{
+			name: "YAMLWithoutEnvironmentFile",
+			args: []string{"show", "-o", "yaml"},
+			objs: []runtime.Object{testobj.Workspace("default", "default")},
+			assertions: func(t *testutil.T, out *bytes.Buffer) {
+				var ws v1alpha1.Workspace
+				require.NoError(t, yaml.Unmarshal(out.Bytes(), &ws))
+
+				assert.Equal(t, "default", ws.Namespace)
+				assert.Equal(t, "default", ws.Name)
+			},
+		},
+		{
+			name: "WorkspaceNotFound",
+			args: []string{"show", "-o", "json"},
+			env:  &env.Env{Namespace: "default", Workspace: "workspace-1"},
+			err:  true,
+		},
+		{
+			name: "ClusterUnreachable",
+			args: []string{"show", "-o", "json"},
+			env:  &env.Env{Namespace: "default", Workspace: "workspace-1"},
+			factoryOverrides: func(f *cmdutil.Factory) {
+				f.ClientCreator.(*client.FakeClientCreator).PrependReactor("get", "workspaces", func(action testclient.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("connection refused")
+				})
+			},
+			out: "{\n    \"namespace\": \"default\",\n    \"workspace\": \"workspace-1\"\n}\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -44,7 +105,11 @@ func TestWorkspaceShow(t *testing.T) {
 
 			out := new(bytes.Buffer)
 
-			f := cmdutil.NewFakeFactory(out)
+			f := cmdutil.NewFakeFactory(out, tt.objs...)
+
+			if tt.factoryOverrides != nil {
+				tt.factoryOverrides(f)
+			}
 
 			cmd := showCmd(f)
 			cmd.SetOut(f.Out)
@@ -52,7 +117,13 @@ func TestWorkspaceShow(t *testing.T) {
 
 			t.CheckError(tt.err, cmd.ExecuteContext(context.Background()))
 
-			assert.Equal(t, tt.out, out.String())
+			if tt.out != "" {
+				assert.Equal(t, tt.out, out.String())
+			}
+
+			if tt.assertions != nil {
+				tt.assertions(t, out)
+			}
 		})
 	}
 }
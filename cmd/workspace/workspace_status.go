@@ -0,0 +1,103 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/cmd/flags"
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/env"
+	"github.com/leg100/etok/pkg/handlers"
+	"github.com/leg100/etok/pkg/k8s"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+type statusOptions struct {
+	*cmdutil.Factory
+
+	*client.Client
+
+	path        string
+	namespace   string
+	workspace   string
+	kubeContext string
+
+	// Print changes to the workspace's status until interrupted
+	watch bool
+}
+
+func statusCmd(f *cmdutil.Factory) (*cobra.Command, *statusOptions) {
+	o := &statusOptions{
+		Factory:   f,
+		namespace: defaultNamespace,
+	}
+	cmd := &cobra.Command{
+		Use:   "status [<workspace>]",
+		Short: "Show the queue and conditions of a workspace",
+		Long:  "Show the queue and conditions of a workspace, defaulting to the current workspace. With --watch, changes are printed as they happen until interrupted.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) > 0 {
+				o.workspace = args[0]
+			} else {
+				etokenv, err := env.Read(o.path)
+				if err != nil {
+					if !os.IsNotExist(err) {
+						return fmt.Errorf("failed reading contents of %s: %w", o.path, err)
+					}
+					// no .terraform/environment, so use defaults
+					etokenv = &env.Env{Namespace: defaultNamespace, Workspace: defaultWorkspace}
+				}
+				if !flags.IsFlagPassed(cmd.Flags(), "namespace") {
+					o.namespace = etokenv.Namespace
+				}
+				o.workspace = etokenv.Workspace
+			}
+
+			o.Client, err = f.Create(o.kubeContext)
+			if err != nil {
+				return err
+			}
+
+			return o.run(cmd.Context())
+		},
+	}
+
+	flags.AddPathFlag(cmd, &o.path)
+	flags.AddNamespaceFlag(cmd, &o.namespace)
+	flags.AddKubeContextFlag(cmd, &o.kubeContext)
+
+	cmd.Flags().BoolVarP(&o.watch, "watch", "w", false, "Watch for changes to the queue and conditions until interrupted")
+
+	return cmd, o
+}
+
+func (o *statusOptions) run(ctx context.Context) error {
+	ws, err := o.WorkspacesClient(o.namespace).Get(ctx, o.workspace, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	hdlr := handlers.LogWorkspaceStatus(o.Out)
+
+	if !o.watch {
+		_, err := hdlr(watch.Event{Type: watch.Added, Object: ws})
+		return err
+	}
+
+	lw := &k8s.WorkspaceListWatcher{Client: o.EtokClient, Name: o.workspace, Namespace: o.namespace}
+	_, err = watchtools.UntilWithSync(ctx, lw, &v1alpha1.Workspace{}, nil, hdlr)
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		// Interrupted by the user
+		return nil
+	}
+	return err
+}
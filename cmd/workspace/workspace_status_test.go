@@ -0,0 +1,92 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	cmdutil "github.com/leg100/etok/cmd/util"
+	"github.com/leg100/etok/pkg/env"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestWorkspaceStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  *env.Env
+		objs []runtime.Object
+		out  string
+		err  bool
+	}{
+		{
+			name: "named workspace",
+			args: []string{"foo"},
+			objs: []runtime.Object{testobj.Workspace("default", "foo", testobj.WithCombinedQueue("apply-1", "apply-2"))},
+			out:  "Active run: apply-1, queue: [apply-2]\nCondition Ready: True\n",
+		},
+		{
+			name: "current workspace",
+			env:  &env.Env{Namespace: "dev", Workspace: "foo"},
+			objs: []runtime.Object{testobj.Workspace("dev", "foo")},
+			out:  "Active run: none, queue: []\nCondition Ready: True\n",
+		},
+		{
+			name: "current workspace in namespace flag",
+			args: []string{"--namespace", "prod"},
+			env:  &env.Env{Namespace: "dev", Workspace: "foo"},
+			objs: []runtime.Object{testobj.Workspace("dev", "foo"), testobj.Workspace("prod", "foo", testobj.WithCombinedQueue("apply-1"))},
+			out:  "Active run: apply-1, queue: []\nCondition Ready: True\n",
+		},
+		{
+			name: "named workspace in namespace flag",
+			args: []string{"bar", "--namespace", "prod"},
+			env:  &env.Env{Namespace: "dev", Workspace: "foo"},
+			objs: []runtime.Object{testobj.Workspace("dev", "foo"), testobj.Workspace("prod", "bar", testobj.WithCombinedQueue("apply-1"))},
+			out:  "Active run: apply-1, queue: []\nCondition Ready: True\n",
+		},
+		{
+			name: "watch until interrupted",
+			args: []string{"foo", "--watch"},
+			objs: []runtime.Object{testobj.Workspace("default", "foo", testobj.WithCombinedQueue("apply-1"))},
+			out:  "Active run: apply-1, queue: []\nCondition Ready: True\n",
+		},
+		{
+			name: "workspace not found",
+			args: []string{"foo"},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			path := t.NewTempDir().Chdir().Root()
+
+			// Write .terraform/environment
+			if tt.env != nil {
+				require.NoError(t, tt.env.Write(path))
+			}
+
+			out := new(bytes.Buffer)
+			cmd, _ := statusCmd(cmdutil.NewFakeFactory(out, tt.objs...))
+			cmd.SetOut(out)
+			// Leave reporting errors to the test
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			cmd.SetArgs(tt.args)
+
+			// Mimic the user interrupting the watch
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			t.CheckError(tt.err, cmd.ExecuteContext(ctx))
+
+			assert.Equal(t, tt.out, out.String())
+		})
+	}
+}
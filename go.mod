@@ -4,17 +4,22 @@ go 1.15
 
 require (
 	cloud.google.com/go/storage v1.12.0
+	github.com/aws/aws-sdk-go v1.36.19
 	github.com/creack/pty v1.1.9
 	github.com/docker/spdystream v0.0.0-20181023171402-6480d4af844c // indirect
 	github.com/fatih/color v1.7.0
 	github.com/fsouza/fake-gcs-server v1.22.0
 	github.com/google/go-cmp v0.5.4
 	github.com/google/goexpect v0.0.0-20200816234442-b5b77125c2c5
+	github.com/hashicorp/go-version v1.2.1
+	github.com/hashicorp/hcl/v2 v2.0.0
 	github.com/hashicorp/terraform-config-inspect v0.0.0-20201102131242-0c45ba392e51
 	github.com/kr/text v0.2.0 // indirect
 	github.com/mattn/go-colorable v0.1.4 // indirect
 	github.com/mattn/go-isatty v0.0.12 // indirect
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
+	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/client_model v0.2.0
 	github.com/sergi/go-diff v1.1.0 // indirect
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5
@@ -40,12 +40,11 @@ func run(args []string, out, errout io.Writer, in io.Reader) error {
 }
 
 // Print error message unless the error originated from executing a program (which would have
-// printed its own message)
+// printed its own message). Returns the exit code (see pkg/errors for the mapping).
 func handleError(err error, out io.Writer) int {
 	var exit etokerrors.ExitError
-	if errors.As(err, &exit) {
-		return exit.ExitCode()
+	if !errors.As(err, &exit) {
+		fmt.Fprintf(out, "%s %s\n", color.HiRedString("Error:"), err.Error())
 	}
-	fmt.Fprintf(out, "%s %s\n", color.HiRedString("Error:"), err.Error())
-	return 1
+	return etokerrors.ExitCode(err)
 }
@@ -40,6 +40,10 @@ func (c *Client) ConfigMapsClient(namespace string) typedv1.ConfigMapInterface {
 	return c.KubeClient.CoreV1().ConfigMaps(namespace)
 }
 
+func (c *Client) PersistentVolumeClaimsClient(namespace string) typedv1.PersistentVolumeClaimInterface {
+	return c.KubeClient.CoreV1().PersistentVolumeClaims(namespace)
+}
+
 func (c *Client) WorkspacesClient(namespace string) etoktyped.WorkspaceInterface {
 	return c.EtokClient.EtokV1alpha1().Workspaces(namespace)
 }
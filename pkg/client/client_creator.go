@@ -5,7 +5,6 @@ import (
 
 	"github.com/leg100/etok/pkg/k8s/etokclient"
 	"k8s.io/client-go/kubernetes"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
 // ClientCreator impls are objs for deferred creation of kubernetes clients
@@ -21,7 +20,19 @@ func NewClientCreator() ClientCreator {
 }
 
 func (cc *clientCreator) Create(kubeCtx string) (*Client, error) {
-	cfg, err := config.GetConfigWithContext(kubeCtx)
+	// Check context exists before proceeding, otherwise an invalid context
+	// only surfaces later as an opaque connection error
+	if kubeCtx != "" {
+		kubeconfig, err := loadKubeconfig()
+		if err != nil {
+			return nil, fmt.Errorf("loading kubeconfig: %w", err)
+		}
+		if err := validateContext(kubeCtx, kubeconfig); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg, err := restConfig(kubeCtx)
 	if err != nil {
 		return nil, fmt.Errorf("getting kubernetes client config: %w", err)
 	}
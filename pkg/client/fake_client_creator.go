@@ -1,8 +1,15 @@
 package client
 
 import (
+	"time"
+
 	"github.com/leg100/etok/api/etok.dev/v1alpha1"
 	sfake "github.com/leg100/etok/pkg/k8s/etokclient/fake"
+	"github.com/leg100/etok/pkg/labels"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	kfake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
@@ -31,19 +38,60 @@ func (f *FakeClientCreator) Create(kubeCtx string) (*Client, error) {
 		}
 	}
 
+	KubeClient := kfake.NewSimpleClientset(kubeObjs...)
+	// Permit everything by default
+	KubeClient.PrependReactor("create", "selfsubjectaccessreviews", allowAccessReview)
+	// Operator is installed and available by default
+	KubeClient.PrependReactor("list", "deployments", availableOperator)
+
 	EtokClient := sfake.NewSimpleClientset(etokObjs...)
 	for _, r := range f.reactors {
-		EtokClient.PrependReactor(r.Verb, r.Resource, r.Reaction)
+		// Attach the reactor only to the client serving the resource
+		switch r.Resource {
+		case "runs", "workspaces":
+			EtokClient.PrependReactor(r.Verb, r.Resource, r.Reaction)
+		default:
+			KubeClient.PrependReactor(r.Verb, r.Resource, r.Reaction)
+		}
 	}
 
 	return &Client{
 		Config:     &rest.Config{},
 		EtokClient: EtokClient,
-		KubeClient: kfake.NewSimpleClientset(kubeObjs...),
+		KubeClient: KubeClient,
+	}, nil
+}
+
+func allowAccessReview(action testing.Action) (bool, runtime.Object, error) {
+	review := action.(testing.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+	review.Status.Allowed = true
+	return true, review, nil
+}
+
+func availableOperator(action testing.Action) (bool, runtime.Object, error) {
+	return true, &appsv1.DeploymentList{
+		Items: []appsv1.Deployment{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "etok",
+					Name:      "etok",
+					Labels:    labels.MakeLabels(labels.App, labels.OperatorComponent),
+				},
+				Status: appsv1.DeploymentStatus{
+					Conditions: []appsv1.DeploymentCondition{
+						{
+							Type:               appsv1.DeploymentAvailable,
+							Status:             corev1.ConditionTrue,
+							LastTransitionTime: metav1.Time{Time: time.Now().Add(-time.Minute)},
+						},
+					},
+				},
+			},
+		},
 	}, nil
 }
 
 // Add a reactor to the list of reactors to be prepended.
 func (f *FakeClientCreator) PrependReactor(verb, resource string, reaction testing.ReactionFunc) {
-	f.reactors = append(f.reactors, testing.SimpleReactor{verb, resource, reaction})
+	f.reactors = append(f.reactors, testing.SimpleReactor{Verb: verb, Resource: resource, Reaction: reaction})
 }
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// restConfig builds a kubernetes client config using the default loading
+// rules, i.e. from the colon-separated list of files in $KUBECONFIG, otherwise
+// from ~/.kube/config, falling back to the in-cluster config if neither exist.
+// Any exec credential plugin (e.g. an OIDC plugin) configured for the user is
+// retained on the config, and is invoked non-interactively upon the first
+// request.
+func restConfig(kubeCtx string) (*rest.Config, error) {
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: kubeCtx},
+	).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the same client-side rate limits as controller-runtime
+	if cfg.QPS == 0.0 {
+		cfg.QPS = 20.0
+		cfg.Burst = 30
+	}
+
+	return cfg, nil
+}
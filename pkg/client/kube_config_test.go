@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeExecPlugin is a credential plugin that prints a static token
+const fakeExecPlugin = `#!/bin/sh
+echo '{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential","status":{"token":"fake-token"}}'
+`
+
+func TestRestConfigExecPlugin(t *testing.T) {
+	testutil.Run(t, "exec plugin", func(t *testutil.T) {
+		// Record authorization header sent to fake API server. Client-go only
+		// sends credentials over TLS.
+		var authorization string
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"major":"1","minor":"19","gitVersion":"v1.19.2"}`))
+		}))
+		defer srv.Close()
+
+		// Split kubeconfig across two files to check both files in $KUBECONFIG
+		// are loaded
+		tmpdir := t.NewTempDir()
+		tmpdir.Write("plugin.sh", []byte(fakeExecPlugin))
+		tmpdir.Write("cluster.yaml", []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: fake
+  cluster:
+    server: %s
+    insecure-skip-tls-verify: true
+contexts:
+- name: fake
+  context:
+    cluster: fake
+    user: oidc
+current-context: fake
+`, srv.URL)))
+		tmpdir.Write("user.yaml", []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+users:
+- name: oidc
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: %s
+`, tmpdir.Path("plugin.sh"))))
+
+		t.SetEnvs(map[string]string{
+			"KUBECONFIG": tmpdir.Path("cluster.yaml") + string(os.PathListSeparator) + tmpdir.Path("user.yaml"),
+		})
+
+		cl, err := NewClientCreator().Create("")
+		require.NoError(t, err)
+		require.NotNil(t, cl.Config.ExecProvider)
+
+		_, err = cl.KubeClient.Discovery().ServerVersion()
+		require.NoError(t, err)
+
+		assert.Equal(t, "Bearer fake-token", authorization)
+	})
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+var ErrContextNotFound = errors.New("context not found in kubeconfig")
+
+// validateContext checks the kube context exists in the kubeconfig, returning
+// an error listing the valid contexts if not. An empty context refers to the
+// current context and is always valid.
+func validateContext(kubeCtx string, kubeconfig *clientcmdapi.Config) error {
+	if kubeCtx == "" {
+		return nil
+	}
+
+	if _, ok := kubeconfig.Contexts[kubeCtx]; ok {
+		return nil
+	}
+
+	var valid []string
+	for name := range kubeconfig.Contexts {
+		valid = append(valid, name)
+	}
+	sort.Strings(valid)
+
+	if len(valid) == 0 {
+		return fmt.Errorf("%w: %s: no contexts found", ErrContextNotFound, kubeCtx)
+	}
+	return fmt.Errorf("%w: %s: valid contexts are: %s", ErrContextNotFound, kubeCtx, strings.Join(valid, ", "))
+}
+
+// loadKubeconfig loads the kubeconfig using the default loading rules, i.e.
+// from the files in $KUBECONFIG or from ~/.kube/config
+func loadKubeconfig() (*clientcmdapi.Config, error) {
+	return clientcmd.NewDefaultClientConfigLoadingRules().Load()
+}
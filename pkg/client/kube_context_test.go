@@ -0,0 +1,56 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestValidateContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		context  string
+		contexts []string
+		err      string
+	}{
+		{
+			name:     "current context",
+			context:  "",
+			contexts: []string{"kind-kind"},
+		},
+		{
+			name:     "valid context",
+			context:  "gke",
+			contexts: []string{"kind-kind", "gke"},
+		},
+		{
+			name:     "invalid context",
+			context:  "eks",
+			contexts: []string{"kind-kind", "gke"},
+			err:      "context not found in kubeconfig: eks: valid contexts are: gke, kind-kind",
+		},
+		{
+			name:    "no contexts",
+			context: "eks",
+			err:     "context not found in kubeconfig: eks: no contexts found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubeconfig := clientcmdapi.NewConfig()
+			for _, name := range tt.contexts {
+				kubeconfig.Contexts[name] = clientcmdapi.NewContext()
+			}
+
+			err := validateContext(tt.context, kubeconfig)
+			if tt.err != "" {
+				assert.True(t, errors.Is(err, ErrContextNotFound))
+				assert.EqualError(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -5,7 +5,6 @@ import (
 
 	"github.com/leg100/etok/pkg/scheme"
 	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
 // RuntimeClientCreator impls are objs for deferred creation of kubernetes clients
@@ -21,7 +20,7 @@ func NewRuntimeClientCreator() RuntimeClientCreator {
 }
 
 func (cc *runtimeClientCreator) CreateRuntimeClient(kubeCtx string) (*Client, error) {
-	cfg, err := config.GetConfigWithContext(kubeCtx)
+	cfg, err := restConfig(kubeCtx)
 	if err != nil {
 		return nil, fmt.Errorf("getting kubernetes client config: %w", err)
 	}
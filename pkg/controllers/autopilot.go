@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/leg100/etok/pkg/labels"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// Label present on the nodes of a GKE Autopilot cluster
+	autopilotNodeLabel = "cloud.google.com/gke-autopilot"
+
+	// Storage class provided by GKE Autopilot, backed by the persistent disk
+	// CSI driver
+	autopilotStorageClass = "standard-rwo"
+)
+
+// Requests set on the containers of pods on GKE Autopilot, where they are not
+// already set. Autopilot would otherwise default each container to far larger
+// requests, 500m CPU and 2Gi of memory, including the workspace pod's idler.
+var autopilotRequests = corev1.ResourceList{
+	corev1.ResourceCPU:    resource.MustParse("250m"),
+	corev1.ResourceMemory: resource.MustParse("512Mi"),
+}
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=list
+
+// IsAutopilot detects whether the cluster is a GKE Autopilot cluster by
+// checking for nodes bearing the Autopilot label. Should the operator not be
+// permitted to list nodes, e.g. when installed without cluster-wide
+// permissions, then the cluster is deemed not to be Autopilot.
+func IsAutopilot(ctx context.Context, c client.Reader) (bool, error) {
+	var nodes corev1.NodeList
+	if err := c.List(ctx, &nodes, client.HasLabels{autopilotNodeLabel}, client.Limit(1)); err != nil {
+		if kerrors.IsForbidden(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(nodes.Items) > 0, nil
+}
+
+// setAutopilotResources sets default requests on containers without requests,
+// and sets limits equal to requests on containers without limits, as required
+// by Autopilot, which would otherwise adjust them itself. A container with
+// limits but no requests has its requests derived from its limits instead,
+// falling back to the defaults for those resources without a limit, lest its
+// requests exceed its limits.
+func setAutopilotResources(spec *corev1.PodSpec) {
+	containers := []*corev1.Container{}
+	for i := range spec.InitContainers {
+		containers = append(containers, &spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		containers = append(containers, &spec.Containers[i])
+	}
+
+	for _, c := range containers {
+		if len(c.Resources.Requests) == 0 {
+			c.Resources.Requests = autopilotRequests.DeepCopy()
+			for name, limit := range c.Resources.Limits {
+				c.Resources.Requests[name] = limit.DeepCopy()
+			}
+		}
+		if len(c.Resources.Limits) == 0 {
+			c.Resources.Limits = c.Resources.Requests.DeepCopy()
+		}
+	}
+}
+
+// setAutopilotAffinity requires a run pod to be scheduled to the same node as
+// its workspace pod, unless the workspace already specifies pod affinity.
+// Autopilot is otherwise liable to provision a new node for the run pod, to
+// which the workspace's ReadWriteOnce cache cannot be attached.
+func setAutopilotAffinity(spec *corev1.PodSpec, workspace string) {
+	if spec.Affinity == nil {
+		spec.Affinity = &corev1.Affinity{}
+	}
+	if spec.Affinity.PodAffinity != nil {
+		return
+	}
+	spec.Affinity.PodAffinity = &corev1.PodAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+			{
+				LabelSelector: &metav1.LabelSelector{
+					MatchLabels: labels.MakeLabels(labels.Workspace(workspace), labels.WorkspaceComponent),
+				},
+				TopologyKey: corev1.LabelHostname,
+			},
+		},
+	}
+}
+
+// setAutopilotStorageClass sets the storage class of a PVC to one provided by
+// Autopilot, unless a storage class is already specified.
+func setAutopilotStorageClass(pvc *corev1.PersistentVolumeClaim) {
+	if pvc.Spec.StorageClassName != nil {
+		return
+	}
+	class := autopilotStorageClass
+	pvc.Spec.StorageClassName = &class
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/leg100/etok/pkg/scheme"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIsAutopilot(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []runtime.Object
+		want  bool
+	}{
+		{
+			name: "autopilot",
+			nodes: []runtime.Object{
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gk3-node", Labels: map[string]string{autopilotNodeLabel: "true"}}},
+			},
+			want: true,
+		},
+		{
+			name: "not autopilot",
+			nodes: []runtime.Object{
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gke-node"}},
+			},
+		},
+		{
+			name: "no nodes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := fake.NewFakeClientWithScheme(scheme.Scheme, tt.nodes...)
+
+			got, err := IsAutopilot(context.Background(), cl)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// forbiddenReader is a client.Reader that is not permitted to read anything
+type forbiddenReader struct {
+	client.Reader
+}
+
+func (r *forbiddenReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return kerrors.NewForbidden(corev1.Resource("nodes"), "", errors.New("fake forbidden"))
+}
+
+func TestIsAutopilotForbidden(t *testing.T) {
+	got, err := IsAutopilot(context.Background(), &forbiddenReader{})
+	require.NoError(t, err)
+	assert.False(t, got)
+}
+
+func TestSetAutopilotResources(t *testing.T) {
+	custom := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("1"),
+		corev1.ResourceMemory: resource.MustParse("4Gi"),
+	}
+	low := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("100m"),
+		corev1.ResourceMemory: resource.MustParse("128Mi"),
+	}
+
+	tests := []struct {
+		name      string
+		resources corev1.ResourceRequirements
+		want      corev1.ResourceRequirements
+	}{
+		{
+			name: "defaults",
+			want: corev1.ResourceRequirements{Requests: autopilotRequests, Limits: autopilotRequests},
+		},
+		{
+			name:      "limits set to custom requests",
+			resources: corev1.ResourceRequirements{Requests: custom},
+			want:      corev1.ResourceRequirements{Requests: custom, Limits: custom},
+		},
+		{
+			name:      "requests derived from limits",
+			resources: corev1.ResourceRequirements{Limits: low},
+			want:      corev1.ResourceRequirements{Requests: low, Limits: low},
+		},
+		{
+			name:      "requests derived from partial limits",
+			resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")}},
+			want: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("250m"),
+					corev1.ResourceMemory: resource.MustParse("128Mi"),
+				},
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+			},
+		},
+		{
+			name:      "custom requests and limits",
+			resources: corev1.ResourceRequirements{Requests: autopilotRequests, Limits: custom},
+			want:      corev1.ResourceRequirements{Requests: autopilotRequests, Limits: custom},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := testobj.Workspace("default", "foo", testobj.WithResources(tt.resources.Requests, tt.resources.Limits))
+
+			pod, err := workspacePod(ws, "etok:latest")
+			require.NoError(t, err)
+
+			setAutopilotResources(&pod.Spec)
+
+			// Terraform installer
+			assert.Equal(t, tt.want, pod.Spec.InitContainers[0].Resources)
+			// Idler is always given the defaults
+			assert.Equal(t, corev1.ResourceRequirements{Requests: autopilotRequests, Limits: autopilotRequests}, pod.Spec.Containers[0].Resources)
+		})
+	}
+}
+
+func TestSetAutopilotAffinity(t *testing.T) {
+	t.Run("co-located with workspace pod", func(t *testing.T) {
+		spec := &corev1.PodSpec{}
+
+		setAutopilotAffinity(spec, "foo")
+
+		if assert.NotNil(t, spec.Affinity.PodAffinity) {
+			term := spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0]
+			assert.Equal(t, map[string]string{"workspace": "foo", "component": "workspace"}, term.LabelSelector.MatchLabels)
+			assert.Equal(t, "kubernetes.io/hostname", term.TopologyKey)
+		}
+	})
+
+	t.Run("existing pod affinity retained", func(t *testing.T) {
+		existing := &corev1.PodAffinity{}
+		spec := &corev1.PodSpec{Affinity: &corev1.Affinity{PodAffinity: existing}}
+
+		setAutopilotAffinity(spec, "foo")
+
+		assert.Same(t, existing, spec.Affinity.PodAffinity)
+	})
+}
+
+func TestSetAutopilotStorageClass(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		pvc := newPVCForWS(testobj.Workspace("default", "foo"))
+
+		setAutopilotStorageClass(pvc)
+
+		if assert.NotNil(t, pvc.Spec.StorageClassName) {
+			assert.Equal(t, "standard-rwo", *pvc.Spec.StorageClassName)
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		class := "premium-rwo"
+		pvc := newPVCForWS(testobj.Workspace("default", "foo", testobj.WithStorageClass(&class)))
+
+		setAutopilotStorageClass(pvc)
+
+		assert.Equal(t, "premium-rwo", *pvc.Spec.StorageClassName)
+	})
+}
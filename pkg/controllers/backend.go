@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ErrInvalidRawBackend is returned when a raw backend is not a terraform block
+// declaring a backend
+var ErrInvalidRawBackend = errors.New("invalid raw backend: must be a terraform block containing only a backend block")
+
+// requiredBackendConfig maps a backend type to the config keys that must be
+// set for that backend.
+var requiredBackendConfig = map[string][]string{
+	v1alpha1.BackendKubernetes: {},
+	v1alpha1.BackendS3:         {"bucket", "key", "region"},
+	v1alpha1.BackendAzureRM:    {"resource_group_name", "storage_account_name", "container_name", "key"},
+	v1alpha1.BackendRemote:     {"organization"},
+	v1alpha1.BackendHTTP:       {"address"},
+	v1alpha1.BackendConsul:     {"path"},
+}
+
+// remoteWorkspacesKeys are the remote backend config keys that belong in its
+// nested workspaces block
+var remoteWorkspacesKeys = []string{"name", "prefix"}
+
+// Default hostname of the remote backend (Terraform Cloud)
+const defaultRemoteHostname = "app.terraform.io"
+
+// backendCredentials maps a backend type to environment variables from which
+// the backend reads its credentials. Each is populated from the key of the
+// same name in the etok secret, if present.
+var backendCredentials = map[string][]string{
+	v1alpha1.BackendAzureRM: {"ARM_ACCESS_KEY"},
+	v1alpha1.BackendHTTP:    {"TF_HTTP_USERNAME", "TF_HTTP_PASSWORD"},
+	v1alpha1.BackendConsul:  {"CONSUL_HTTP_TOKEN"},
+}
+
+// validateBackend checks the backend type is supported and all its required
+// config keys are set.
+func validateBackend(ws *v1alpha1.Workspace) error {
+	if ws.Spec.Backend.Raw != "" {
+		return validateRawBackend(ws)
+	}
+
+	required, ok := requiredBackendConfig[ws.BackendType()]
+	if !ok {
+		return fmt.Errorf("unsupported backend type: %s", ws.BackendType())
+	}
+
+	for _, k := range required {
+		if ws.Spec.Backend.Config[k] == "" {
+			return fmt.Errorf("%s backend requires config key: %s", ws.BackendType(), k)
+		}
+	}
+
+	if ws.BackendType() == v1alpha1.BackendRemote {
+		name, prefix := ws.Spec.Backend.Config["name"], ws.Spec.Backend.Config["prefix"]
+		if (name == "") == (prefix == "") {
+			return fmt.Errorf("remote backend requires exactly one of config keys: name, prefix")
+		}
+	}
+	return nil
+}
+
+// validateRawBackend checks the raw backend declares a backend of the
+// workspace's backend type. The kubernetes backend is configured by etok and
+// cannot be declared.
+func validateRawBackend(ws *v1alpha1.Workspace) error {
+	declared, err := ParseRawBackend(ws.Spec.Backend.Raw)
+	if err != nil {
+		return err
+	}
+	if declared == v1alpha1.BackendKubernetes {
+		return fmt.Errorf("raw backend cannot declare the kubernetes backend")
+	}
+	if declared != ws.BackendType() {
+		return fmt.Errorf("raw backend declares %s backend but backend type is %s", declared, ws.BackendType())
+	}
+	return nil
+}
+
+// ParseRawBackend checks raw is a terraform block containing only a backend
+// block, returning the type of the backend
+func ParseRawBackend(raw string) (string, error) {
+	f, diags := hclparse.NewParser().ParseHCL([]byte(raw), backendPath)
+	if diags.HasErrors() {
+		return "", fmt.Errorf("%w: %s", ErrInvalidRawBackend, diags.Error())
+	}
+
+	content, diags := f.Body.Content(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "terraform"}},
+	})
+	if diags.HasErrors() {
+		return "", fmt.Errorf("%w: %s", ErrInvalidRawBackend, diags.Error())
+	}
+	if len(content.Blocks) != 1 {
+		return "", ErrInvalidRawBackend
+	}
+
+	content, diags = content.Blocks[0].Body.Content(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "backend", LabelNames: []string{"type"}}},
+	})
+	if diags.HasErrors() {
+		return "", fmt.Errorf("%w: %s", ErrInvalidRawBackend, diags.Error())
+	}
+	if len(content.Blocks) != 1 {
+		return "", ErrInvalidRawBackend
+	}
+	return content.Blocks[0].Labels[0], nil
+}
+
+// backendDeclaration returns terraform configuration declaring a backend of
+// the given type. The backend's configuration is left empty; it is populated
+// when terraform init is invoked.
+func backendDeclaration(backendType string) string {
+	return fmt.Sprintf(`
+terraform {
+  backend "%s" {}
+}
+`, backendType)
+}
+
+// backendConfig renders backend configuration in the format expected by
+// terraform init's -backend-config flag. Keys with empty values are omitted.
+// The remote backend's workspace keys are rendered within a workspaces block.
+// Keys are rendered in alphabetical order.
+func backendConfig(backendType string, config map[string]string) string {
+	// Sort keys to render config deterministically, otherwise the config map
+	// would differ from one reconcile to the next
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := new(strings.Builder)
+	workspaces := new(strings.Builder)
+	for _, k := range keys {
+		v := config[k]
+		if v == "" {
+			continue
+		}
+		if backendType == v1alpha1.BackendRemote && isRemoteWorkspacesKey(k) {
+			fmt.Fprintf(workspaces, "  %s = %q\n", k, v)
+			continue
+		}
+		fmt.Fprintf(b, "%s = %q\n", k, v)
+	}
+	if workspaces.Len() > 0 {
+		fmt.Fprintf(b, "workspaces {\n%s}\n", workspaces.String())
+	}
+	return b.String()
+}
+
+func isRemoteWorkspacesKey(key string) bool {
+	for _, k := range remoteWorkspacesKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// backendInitArg returns the arg to be passed to terraform init to configure
+// the workspace's backend
+func backendInitArg(ws *v1alpha1.Workspace) string {
+	if ws.BackendType() == v1alpha1.BackendKubernetes {
+		return "-backend-config=secret_suffix=" + ws.Name
+	}
+	return "-backend-config=" + backendConfigPath
+}
+
+// backendCredentialsEnv returns environment variables sourcing the backend's
+// credentials from the etok secret. The secret keys are optional: credentials
+// may be provided by other means, e.g. workload identity.
+func backendCredentialsEnv(ws *v1alpha1.Workspace) (env []corev1.EnvVar) {
+	for _, name := range backendCredentials[ws.BackendType()] {
+		env = append(env, secretKeyEnvVar(name, name))
+	}
+
+	if ws.BackendType() == v1alpha1.BackendRemote {
+		// Terraform reads the API token for a host from an env var named after
+		// the host
+		hostname := ws.Spec.Backend.Config["hostname"]
+		if hostname == "" {
+			hostname = defaultRemoteHostname
+		}
+		env = append(env, secretKeyEnvVar(remoteTokenEnvVar(hostname), "TF_TOKEN"))
+	}
+	return env
+}
+
+// remoteTokenEnvVar returns the name of the env var from which terraform reads
+// the API token for the given host. Periods are encoded as underscores and
+// hyphens as double underscores.
+func remoteTokenEnvVar(hostname string) string {
+	hostname = strings.ReplaceAll(hostname, "-", "__")
+	hostname = strings.ReplaceAll(hostname, ".", "_")
+	return "TF_TOKEN_" + hostname
+}
+
+// secretKeyEnvVar returns an env var populated from an optional key in the etok
+// secret
+func secretKeyEnvVar(name, key string) corev1.EnvVar {
+	optional := true
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: "etok",
+				},
+				Key:      key,
+				Optional: &optional,
+			},
+		},
+	}
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		backendType string
+		config      map[string]string
+		want        string
+	}{
+		{
+			name:        "no config",
+			backendType: "s3",
+			want:        "",
+		},
+		{
+			name:        "keys in alphabetical order",
+			backendType: "s3",
+			config: map[string]string{
+				"region":               "eu-west-2",
+				"key":                  "terraform.tfstate",
+				"bucket":               "my-bucket",
+				"dynamodb_table":       "locks",
+				"encrypt":              "true",
+				"acl":                  "private",
+				"profile":              "default",
+				"role_arn":             "arn:aws:iam::123456789012:role/etok",
+				"kms_key_id":           "alias/etok",
+				"workspace_key_prefix": "env",
+			},
+			want: `acl = "private"
+bucket = "my-bucket"
+dynamodb_table = "locks"
+encrypt = "true"
+key = "terraform.tfstate"
+kms_key_id = "alias/etok"
+profile = "default"
+region = "eu-west-2"
+role_arn = "arn:aws:iam::123456789012:role/etok"
+workspace_key_prefix = "env"
+`,
+		},
+		{
+			name:        "empty values omitted",
+			backendType: "s3",
+			config:      map[string]string{"bucket": "my-bucket", "profile": ""},
+			want:        "bucket = \"my-bucket\"\n",
+		},
+		{
+			name:        "remote workspaces block",
+			backendType: "remote",
+			config:      map[string]string{"organization": "acme", "name": "networking", "hostname": "tfe.example.com"},
+			want:        "hostname = \"tfe.example.com\"\norganization = \"acme\"\nworkspaces {\n  name = \"networking\"\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Render several times to catch any non-determinism arising from
+			// map iteration order
+			for i := 0; i < 10; i++ {
+				assert.Equal(t, tt.want, backendConfig(tt.backendType, tt.config))
+			}
+		})
+	}
+}
+
+func TestParseRawBackend(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+		err  error
+	}{
+		{
+			name: "backend with config",
+			raw: `
+terraform {
+  backend "pg" {
+    conn_str = "postgres://localhost/terraform_backend"
+  }
+}
+`,
+			want: "pg",
+		},
+		{
+			name: "empty backend",
+			raw:  "terraform {\n  backend \"consul\" {}\n}\n",
+			want: "consul",
+		},
+		{
+			name: "not hcl",
+			raw:  `terraform {`,
+			err:  ErrInvalidRawBackend,
+		},
+		{
+			name: "no terraform block",
+			raw:  `backend "consul" {}`,
+			err:  ErrInvalidRawBackend,
+		},
+		{
+			name: "no backend block",
+			raw:  `terraform {}`,
+			err:  ErrInvalidRawBackend,
+		},
+		{
+			name: "additional resource",
+			raw: `
+terraform {
+  backend "consul" {}
+}
+resource "null_resource" "foo" {}
+`,
+			err: ErrInvalidRawBackend,
+		},
+		{
+			name: "additional terraform settings",
+			raw: `
+terraform {
+  required_version = ">= 1.0"
+  backend "consul" {}
+}
+`,
+			err: ErrInvalidRawBackend,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRawBackend(tt.raw)
+			if !assert.True(t, errors.Is(err, tt.err)) {
+				t.Errorf("no error in %v's chain matches %v", err, tt.err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// BacklogCheckName is the name of the health check reporting the
+	// reconcile backlog, served at /healthz/backlog
+	BacklogCheckName = "backlog"
+
+	// DefaultBacklogTimeout is how long the backlog may exceed its threshold
+	// before the backlog check fails
+	DefaultBacklogTimeout = 10 * time.Minute
+)
+
+// BacklogCheck is a health check that fails when the number of workspaces with
+// runs waiting in their queue has exceeded a threshold for longer than a
+// timeout, which suggests the operator is stuck and not working through the
+// queues.
+type BacklogCheck struct {
+	threshold int
+	timeout   time.Duration
+
+	mu sync.Mutex
+	// Workspaces with runs waiting in their queue
+	queued map[types.NamespacedName]struct{}
+	// Time at which the backlog first exceeded the threshold; zero if it does
+	// not currently exceed it
+	exceededSince time.Time
+
+	// Current time; overridden in tests
+	now func() time.Time
+}
+
+func NewBacklogCheck(threshold int, timeout time.Duration) *BacklogCheck {
+	return &BacklogCheck{
+		threshold: threshold,
+		timeout:   timeout,
+		queued:    make(map[types.NamespacedName]struct{}),
+		now:       time.Now,
+	}
+}
+
+// Update records the number of runs waiting in a workspace's queue. A deleted
+// workspace should be recorded as having no runs waiting.
+func (b *BacklogCheck) Update(workspace types.NamespacedName, depth int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if depth > 0 {
+		b.queued[workspace] = struct{}{}
+	} else {
+		delete(b.queued, workspace)
+	}
+
+	if len(b.queued) <= b.threshold {
+		b.exceededSince = time.Time{}
+	} else if b.exceededSince.IsZero() {
+		b.exceededSince = b.now()
+	}
+}
+
+// Check implements healthz.Checker
+func (b *BacklogCheck) Check(_ *http.Request) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.exceededSince.IsZero() {
+		return nil
+	}
+	if exceeded := b.now().Sub(b.exceededSince); exceeded > b.timeout {
+		return fmt.Errorf("%d workspaces have runs waiting in their queue, exceeding the threshold of %d for %s", len(b.queued), b.threshold, exceeded.Round(time.Second))
+	}
+	return nil
+}
+
+// updateBacklog informs the backlog check, if any, of the number of runs
+// waiting in a workspace's queue
+func (r *WorkspaceReconciler) updateBacklog(workspace types.NamespacedName, depth int) {
+	if r.BacklogCheck != nil {
+		r.BacklogCheck.Update(workspace, depth)
+	}
+}
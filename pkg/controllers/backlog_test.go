@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+func TestBacklogCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		// Queue depths of workspaces, updated in turn
+		depths []int
+		// Time elapsed since the last update
+		elapsed time.Duration
+		code    int
+	}{
+		{
+			name: "no backlog",
+			code: http.StatusOK,
+		},
+		{
+			name:    "below threshold",
+			depths:  []int{1, 3},
+			elapsed: time.Hour,
+			code:    http.StatusOK,
+		},
+		{
+			name:    "exceeds threshold briefly",
+			depths:  []int{1, 3, 2},
+			elapsed: time.Minute,
+			code:    http.StatusOK,
+		},
+		{
+			name:    "exceeds threshold for too long",
+			depths:  []int{1, 3, 2},
+			elapsed: time.Hour,
+			code:    http.StatusInternalServerError,
+		},
+		{
+			name:    "empty queues are not counted",
+			depths:  []int{1, 0, 2},
+			elapsed: time.Hour,
+			code:    http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+
+			check := NewBacklogCheck(2, DefaultBacklogTimeout)
+			check.now = func() time.Time { return now }
+
+			for i, depth := range tt.depths {
+				check.Update(types.NamespacedName{Namespace: "default", Name: string(rune('a' + i))}, depth)
+			}
+
+			now = now.Add(tt.elapsed)
+
+			// The manager serves each health check at /healthz/<name>, via a
+			// handler with the /healthz prefix stripped
+			handler := &healthz.Handler{Checks: map[string]healthz.Checker{BacklogCheckName: check.Check}}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, httptest.NewRequest("GET", "/"+BacklogCheckName, nil))
+
+			assert.Equal(t, tt.code, resp.Code)
+		})
+	}
+}
+
+func TestBacklogCheckRecovers(t *testing.T) {
+	now := time.Now()
+
+	check := NewBacklogCheck(0, DefaultBacklogTimeout)
+	check.now = func() time.Time { return now }
+
+	workspace := types.NamespacedName{Namespace: "default", Name: "workspace-1"}
+
+	check.Update(workspace, 1)
+	now = now.Add(time.Hour)
+	assert.Error(t, check.Check(nil))
+
+	// Queue emptied
+	check.Update(workspace, 0)
+	assert.NoError(t, check.Check(nil))
+
+	// The timeout restarts when the threshold is next exceeded
+	check.Update(workspace, 1)
+	now = now.Add(time.Minute)
+	assert.NoError(t, check.Check(nil))
+}
@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	cloudkms "google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	// errBucketNotFound is returned by a backup provider when the backup
+	// bucket does not exist
+	errBucketNotFound = errors.New("bucket does not exist")
+
+	// ErrBackupNotFound is returned by a backup provider when there is no
+	// backup to restore
+	ErrBackupNotFound = errors.New("backup does not exist")
+)
+
+// BackupProvider persists and retrieves backups to and from a cloud
+// storage bucket
+type BackupProvider interface {
+	// Backup writes data to the object key in the bucket
+	Backup(ctx context.Context, bucket, key string, data []byte) error
+	// Restore reads data from the object key in the bucket
+	Restore(ctx context.Context, bucket, key string) ([]byte, error)
+	// List returns the keys of objects in the bucket with the given prefix
+	List(ctx context.Context, bucket, prefix string) ([]string, error)
+	// Delete removes the object key from the bucket
+	Delete(ctx context.Context, bucket, key string) error
+}
+
+// NewBackupProvider returns a backup provider for the cloud storage provider,
+// authenticating with the environment's credentials, for use outside of the
+// operator, e.g. by run pods. As with the operator's backups, backups are
+// verified against checksums, and encrypted with the KMS key unless it is
+// empty.
+func NewBackupProvider(ctx context.Context, provider, kmsKey string) (BackupProvider, error) {
+	var (
+		storageProvider BackupProvider
+		newKeyManager   func(context.Context) (keyManager, error)
+	)
+
+	switch provider {
+	case v1alpha1.BackupProviderS3:
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		storageProvider = &s3Provider{client: s3.New(sess)}
+		newKeyManager = func(context.Context) (keyManager, error) {
+			return &awsKeyManager{client: kms.New(sess)}, nil
+		}
+	default:
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		storageProvider = &gcsProvider{client: client}
+		newKeyManager = func(ctx context.Context) (keyManager, error) {
+			service, err := cloudkms.NewService(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return &gcpKeyManager{service: service}, nil
+		}
+	}
+
+	// Checksums are computed on the uploaded, i.e. encrypted, backup
+	return &encryptingProvider{
+		BackupProvider: &checksummingProvider{BackupProvider: storageProvider},
+		kmsKey:         kmsKey,
+		keyManager:     newKeyManager,
+	}, nil
+}
+
+// gcsProvider is a backup provider for Google Cloud Storage
+type gcsProvider struct {
+	client *storage.Client
+
+	// Restore this generation of an object rather than its live version.
+	// Requires the bucket to have object versioning enabled.
+	generation int64
+}
+
+func (p *gcsProvider) Backup(ctx context.Context, bucket, key string, data []byte) error {
+	bh := p.client.Bucket(bucket)
+	if _, err := bh.Attrs(ctx); err != nil {
+		return gcsError(err)
+	}
+
+	owriter := bh.Object(key).NewWriter(ctx)
+	if _, err := io.Copy(owriter, bytes.NewBuffer(data)); err != nil {
+		return err
+	}
+	return owriter.Close()
+}
+
+func (p *gcsProvider) Restore(ctx context.Context, bucket, key string) ([]byte, error) {
+	bh := p.client.Bucket(bucket)
+	if _, err := bh.Attrs(ctx); err != nil {
+		return nil, gcsError(err)
+	}
+
+	oh := bh.Object(key)
+	if p.generation != 0 {
+		oh = oh.Generation(p.generation)
+	}
+	if _, err := oh.Attrs(ctx); err != nil {
+		return nil, gcsError(err)
+	}
+
+	oreader, err := oh.NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer oreader.Close()
+
+	return ioutil.ReadAll(oreader)
+}
+
+func (p *gcsProvider) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+	it := p.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, gcsError(err)
+		}
+		keys = append(keys, attrs.Name)
+	}
+}
+
+func (p *gcsProvider) Delete(ctx context.Context, bucket, key string) error {
+	return gcsError(p.client.Bucket(bucket).Object(key).Delete(ctx))
+}
+
+// gcsError translates GCS client errors into backup provider errors
+func gcsError(err error) error {
+	switch err {
+	case storage.ErrBucketNotExist:
+		return errBucketNotFound
+	case storage.ErrObjectNotExist:
+		return ErrBackupNotFound
+	default:
+		return err
+	}
+}
+
+// s3Provider is a backup provider for AWS S3
+type s3Provider struct {
+	client s3iface.S3API
+}
+
+func (p *s3Provider) Backup(ctx context.Context, bucket, key string, data []byte) error {
+	if _, err := p.client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		return s3Error(err)
+	}
+
+	_, err := p.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	return s3Error(err)
+}
+
+func (p *s3Provider) Restore(ctx context.Context, bucket, key string) ([]byte, error) {
+	if _, err := p.client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		return nil, s3Error(err)
+	}
+
+	out, err := p.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	defer out.Body.Close()
+
+	return ioutil.ReadAll(out.Body)
+}
+
+func (p *s3Provider) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	for {
+		out, err := p.client.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return nil, s3Error(err)
+		}
+		for _, obj := range out.Contents {
+			keys = append(keys, *obj.Key)
+		}
+		if !aws.BoolValue(out.IsTruncated) {
+			return keys, nil
+		}
+		input.ContinuationToken = out.NextContinuationToken
+	}
+}
+
+func (p *s3Provider) Delete(ctx context.Context, bucket, key string) error {
+	_, err := p.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return s3Error(err)
+}
+
+// s3Error translates S3 client errors into backup provider errors
+func s3Error(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchBucket:
+			return errBucketNotFound
+		case s3.ErrCodeNoSuchKey:
+			return ErrBackupNotFound
+		case "NotFound":
+			// HeadBucket returns a generic not found error code
+			return errBucketNotFound
+		}
+	}
+	return err
+}
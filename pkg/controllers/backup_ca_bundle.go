@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// errInvalidCABundle is returned when a CA bundle contains no PEM-encoded
+// certificates
+var errInvalidCABundle = errors.New("no certificates found in CA bundle")
+
+// caBundleProvider is a backup provider whose client trusts a workspace's CA
+// bundle, cached along with the version of the bundle from which it was built
+type caBundleProvider struct {
+	BackupProvider
+
+	// Name and resource version of the CA bundle secret
+	secret          string
+	resourceVersion string
+	// Backup provider type
+	providerType string
+
+	transport *http.Transport
+	// Closes the provider's client, if it needs closing
+	close func() error
+}
+
+// release closes the provider's client along with its idle connections
+func (p *caBundleProvider) release() {
+	if p.close != nil {
+		p.close()
+	}
+	p.transport.CloseIdleConnections()
+}
+
+// caBundleStorageProvider returns a backup provider whose client trusts the
+// workspace's CA bundle in addition to the system's CA certificates, for
+// backing up to an object store with a certificate issued by a private CA.
+// Unlike the operator's default clients, the client is specific to the
+// workspace. It is re-used until the workspace's CA bundle or backup provider
+// changes, whereupon it is replaced.
+func (r *WorkspaceReconciler) caBundleStorageProvider(ctx context.Context, ws *v1alpha1.Workspace) (BackupProvider, error) {
+	var secret corev1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: ws.Namespace, Name: ws.Spec.CABundleSecret}, &secret); err != nil {
+		return nil, fmt.Errorf("unable to retrieve CA bundle: %w", err)
+	}
+
+	r.caBundleMu.Lock()
+	defer r.caBundleMu.Unlock()
+
+	key := types.NamespacedName{Namespace: ws.Namespace, Name: ws.Name}
+	if cached, ok := r.caBundleProviders[key]; ok {
+		if cached.secret == secret.Name && cached.resourceVersion == secret.ResourceVersion && cached.providerType == ws.BackupProviderType() {
+			return cached.BackupProvider, nil
+		}
+		cached.release()
+		delete(r.caBundleProviders, key)
+	}
+
+	provider, err := newCABundleProvider(ctx, ws.BackupProviderType(), secret.Data[caBundleKey], r.caBundleS3Config)
+	if err != nil {
+		return nil, err
+	}
+	provider.secret = secret.Name
+	provider.resourceVersion = secret.ResourceVersion
+
+	if r.caBundleProviders == nil {
+		r.caBundleProviders = make(map[types.NamespacedName]*caBundleProvider)
+	}
+	r.caBundleProviders[key] = provider
+
+	return provider.BackupProvider, nil
+}
+
+// releaseCABundleProvider releases the cached CA bundle provider of a
+// workspace, should there be one
+func (r *WorkspaceReconciler) releaseCABundleProvider(key types.NamespacedName) {
+	r.caBundleMu.Lock()
+	defer r.caBundleMu.Unlock()
+
+	if cached, ok := r.caBundleProviders[key]; ok {
+		cached.release()
+		delete(r.caBundleProviders, key)
+	}
+}
+
+// newCABundleProvider builds a backup provider of the given type whose client
+// trusts the CA bundle. The S3 client's configuration is merged with s3Config,
+// should it be given.
+func newCABundleProvider(ctx context.Context, providerType string, bundle []byte, s3Config *aws.Config) (*caBundleProvider, error) {
+	transport, err := caBundleTransport(bundle)
+	if err != nil {
+		return nil, err
+	}
+	provider := &caBundleProvider{providerType: providerType, transport: transport}
+
+	switch providerType {
+	case v1alpha1.BackupProviderS3:
+		cfg := aws.NewConfig().WithHTTPClient(&http.Client{Transport: transport})
+		if s3Config != nil {
+			cfg.MergeIn(s3Config)
+		}
+		sess, err := session.NewSession(cfg)
+		if err != nil {
+			return nil, err
+		}
+		// The SDK replaces the transport's CA certificates with those of the
+		// bundle set via AWS_CA_BUNDLE, should it be set, so trust the
+		// workspace's bundle in addition to them
+		transport.TLSClientConfig.RootCAs.AppendCertsFromPEM(bundle)
+		provider.BackupProvider = &s3Provider{client: s3.New(sess)}
+	default:
+		// Authenticate requests made via the transport
+		authenticated, err := htransport.NewTransport(ctx, transport, option.WithScopes(storage.ScopeFullControl))
+		if err != nil {
+			return nil, err
+		}
+		client, err := storage.NewClient(ctx, option.WithHTTPClient(&http.Client{Transport: authenticated}))
+		if err != nil {
+			return nil, err
+		}
+		provider.BackupProvider = &gcsProvider{client: client}
+		provider.close = client.Close
+	}
+	return provider, nil
+}
+
+// caBundleTransport returns an HTTP transport that trusts the PEM-encoded
+// certificates in the bundle in addition to the system's CA certificates
+func caBundleTransport(bundle []byte) (*http.Transport, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, errInvalidCABundle
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return transport, nil
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/scheme"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCABundleTransport(t *testing.T) {
+	// Server with a self-signed certificate, standing in for an object store
+	// with a certificate issued by a private CA
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	t.Run("trusts bundle", func(t *testing.T) {
+		bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+		transport, err := caBundleTransport(bundle)
+		require.NoError(t, err)
+
+		resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	t.Run("system CAs alone", func(t *testing.T) {
+		_, err := http.Get(srv.URL)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid bundle", func(t *testing.T) {
+		_, err := caBundleTransport([]byte("not a certificate"))
+		assert.True(t, errors.Is(err, errInvalidCABundle))
+	})
+}
+
+// tlsS3 is a minimal S3 API served over TLS, standing in for an object store
+// with a certificate issued by a private CA
+type tlsS3 struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	// Number of connections opened by clients
+	conns int
+}
+
+func newTLSS3() *tlsS3 {
+	s := &tlsS3{objects: make(map[string][]byte)}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serve))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+		}
+	}
+	s.StartTLS()
+	return s
+}
+
+func (s *tlsS3) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodHead:
+		// Bucket exists
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		s.objects[r.URL.Path] = data
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *tlsS3) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func TestReconcileWorkspaceCABundleBackup(t *testing.T) {
+	srv := newTLSS3()
+	defer srv.Close()
+
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	ws := testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithCABundleSecret("ca-bundle"))
+	state := testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithData("tfstate", string(stateWithSerial(t, 5))))
+	ca := testobj.Secret("default", "ca-bundle", testobj.WithData("ca.crt", string(bundle)))
+	cl := fake.NewFakeClientWithScheme(scheme.Scheme, ws, state, ca)
+
+	r := NewWorkspaceReconciler(cl, "", WithEventRecorder(record.NewFakeRecorder(100)))
+	r.now = func() time.Time { return backupTime }
+	r.caBundleS3Config = &aws.Config{
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("eu-west-2"),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	}
+	req := requestFromObject(ws)
+
+	// backup takes the state with the serial, checking it is backed up over
+	// TLS
+	backup := func(serial int) {
+		var secret corev1.Secret
+		require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: ws.StateSecretName()}, &secret))
+		secret.Data["tfstate"] = stateWithSerial(t, serial)
+		require.NoError(t, r.Update(context.Background(), &secret))
+
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+
+		var got v1alpha1.Workspace
+		require.NoError(t, r.Get(context.Background(), req.NamespacedName, &got))
+		require.NotNil(t, got.Status.BackupSerial)
+		assert.Equal(t, serial, *got.Status.BackupSerial)
+
+		srv.mu.Lock()
+		assert.Contains(t, srv.objects, "/backup-bucket/"+ws.BackupVersionObjectName(serial, backupTime))
+		srv.mu.Unlock()
+	}
+
+	backup(5)
+	backup(6)
+	// Both backups re-use the client and its connection
+	assert.Equal(t, 1, srv.connections())
+
+	// Update the CA bundle, replacing the client
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "ca-bundle"}, ca))
+	ca.Data["ca.crt"] = append(ca.Data["ca.crt"], '\n')
+	require.NoError(t, r.Update(context.Background(), ca))
+
+	backup(7)
+	assert.Equal(t, 2, srv.connections())
+
+	// Delete the workspace, releasing its client
+	require.NoError(t, r.Delete(context.Background(), ws))
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.NotContains(t, r.caBundleProviders, req.NamespacedName)
+}
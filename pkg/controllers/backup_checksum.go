@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// checksumSuffix is appended to a backup's object key to give the key of the
+// object containing its checksum
+const checksumSuffix = ".sha256"
+
+// errChecksumMismatch is returned when a backup does not match the checksum
+// recorded when it was uploaded, e.g. because the upload was truncated
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// checksummingProvider is a backup provider that records the SHA256 checksum
+// of each backup alongside it, and verifies the backup against the checksum
+// upon restore. Backups without a checksum, e.g. those made before checksums
+// were recorded, are restored as-is.
+type checksummingProvider struct {
+	BackupProvider
+}
+
+func (p *checksummingProvider) Backup(ctx context.Context, bucket, key string, data []byte) error {
+	if err := p.BackupProvider.Backup(ctx, bucket, key, data); err != nil {
+		return err
+	}
+	// Upload the checksum only once the backup has been uploaded successfully
+	return p.BackupProvider.Backup(ctx, bucket, key+checksumSuffix, []byte(checksum(data)))
+}
+
+func (p *checksummingProvider) Restore(ctx context.Context, bucket, key string) ([]byte, error) {
+	data, err := p.BackupProvider.Restore(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	want, err := p.BackupProvider.Restore(ctx, bucket, key+checksumSuffix)
+	if err == ErrBackupNotFound {
+		return data, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if got := checksum(data); got != strings.TrimSpace(string(want)) {
+		return nil, fmt.Errorf("%w: expected sha256 %s but got %s", errChecksumMismatch, strings.TrimSpace(string(want)), got)
+	}
+	return data, nil
+}
+
+// List omits checksum objects
+func (p *checksummingProvider) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	keys, err := p.BackupProvider.List(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, k := range keys {
+		if !strings.HasSuffix(k, checksumSuffix) {
+			backups = append(backups, k)
+		}
+	}
+	return backups, nil
+}
+
+// Delete removes the backup along with its checksum, if it has one
+func (p *checksummingProvider) Delete(ctx context.Context, bucket, key string) error {
+	if err := p.BackupProvider.Delete(ctx, bucket, key); err != nil {
+		return err
+	}
+	if err := p.BackupProvider.Delete(ctx, bucket, key+checksumSuffix); err != nil && err != ErrBackupNotFound {
+		return err
+	}
+	return nil
+}
+
+// checksum returns the hex-encoded SHA256 checksum of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupChecksum(t *testing.T) {
+	tests := []struct {
+		name string
+		// Tamper with the bucket's objects after backing up
+		tamper     func(objects map[string][]byte)
+		err        error
+		assertions func(t *testing.T, p BackupProvider, objects map[string][]byte)
+	}{
+		{
+			name: "round trip",
+			assertions: func(t *testing.T, p BackupProvider, objects map[string][]byte) {
+				assert.Equal(t, checksum([]byte("my state")), string(objects["default/foo.yaml.sha256"]))
+			},
+		},
+		{
+			name: "truncated upload",
+			tamper: func(objects map[string][]byte) {
+				objects["default/foo.yaml"] = objects["default/foo.yaml"][:2]
+			},
+			err: errChecksumMismatch,
+		},
+		{
+			name: "no checksum",
+			tamper: func(objects map[string][]byte) {
+				delete(objects, "default/foo.yaml.sha256")
+			},
+		},
+		{
+			name: "list omits checksums",
+			assertions: func(t *testing.T, p BackupProvider, objects map[string][]byte) {
+				keys, err := p.List(context.Background(), "backup-bucket", "default/")
+				require.NoError(t, err)
+				assert.Equal(t, []string{"default/foo.yaml"}, keys)
+			},
+		},
+		{
+			name: "delete removes checksum",
+			assertions: func(t *testing.T, p BackupProvider, objects map[string][]byte) {
+				require.NoError(t, p.Delete(context.Background(), "backup-bucket", "default/foo.yaml"))
+				assert.Empty(t, objects)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := make(map[string][]byte)
+			p := &checksummingProvider{
+				BackupProvider: &s3Provider{client: &fakeS3{buckets: map[string]map[string][]byte{"backup-bucket": objects}}},
+			}
+
+			require.NoError(t, p.Backup(context.Background(), "backup-bucket", "default/foo.yaml", []byte("my state")))
+
+			if tt.tamper != nil {
+				tt.tamper(objects)
+			}
+
+			data, err := p.Restore(context.Background(), "backup-bucket", "default/foo.yaml")
+			if !assert.True(t, errors.Is(err, tt.err)) {
+				t.Errorf("no error in %v's chain matches %v", err, tt.err)
+			}
+			if tt.err == nil {
+				assert.Equal(t, "my state", string(data))
+			}
+
+			if tt.assertions != nil {
+				tt.assertions(t, p, objects)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	cloudkms "google.golang.org/api/cloudkms/v1"
+)
+
+// errDecryptionFailed is returned when an encrypted backup cannot be decrypted,
+// e.g. the operator is denied use of the KMS key, or the backup has been
+// tampered with
+var errDecryptionFailed = errors.New("unable to decrypt backup")
+
+// keyManager encrypts and decrypts data encryption keys with a KMS key
+type keyManager interface {
+	Encrypt(ctx context.Context, kmsKey string, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, kmsKey string, ciphertext []byte) ([]byte, error)
+}
+
+// encryptedBackup is an envelope-encrypted backup: the backup is encrypted with
+// a random data key, which in turn is encrypted with the KMS key. KMS services
+// limit the size of data they encrypt directly, hence the data key.
+type encryptedBackup struct {
+	// KMS key with which the data key is encrypted
+	KMSKey string `json:"kmsKey"`
+	// Data key, encrypted with the KMS key
+	EncryptedKey []byte `json:"encryptedKey"`
+	// Nonce used to encrypt the backup
+	Nonce []byte `json:"nonce"`
+	// Backup, encrypted with the data key using AES-256-GCM
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// encryptBackup encrypts data with a new data key, encrypting the data key with
+// the KMS key
+func encryptBackup(ctx context.Context, km keyManager, kmsKey string, data []byte) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := km.Encrypt(ctx, kmsKey, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&encryptedBackup{
+		KMSKey:       kmsKey,
+		EncryptedKey: encryptedKey,
+		Nonce:        nonce,
+		Ciphertext:   gcm.Seal(nil, nonce, data, nil),
+	})
+}
+
+// isEncryptedBackup determines whether data is an encrypted backup, returning
+// the parsed envelope if so. Unencrypted backups are YAML and are not parsed.
+func isEncryptedBackup(data []byte) (*encryptedBackup, bool) {
+	var eb encryptedBackup
+	if err := json.Unmarshal(data, &eb); err != nil {
+		return nil, false
+	}
+	if eb.KMSKey == "" || eb.Ciphertext == nil {
+		return nil, false
+	}
+	return &eb, true
+}
+
+// decryptBackup decrypts the data key with the KMS key with which it was
+// encrypted, and then decrypts the backup
+func decryptBackup(ctx context.Context, km keyManager, eb *encryptedBackup) ([]byte, error) {
+	dataKey, err := km.Decrypt(ctx, eb.KMSKey, eb.EncryptedKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errDecryptionFailed, err.Error())
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errDecryptionFailed, err.Error())
+	}
+
+	data, err := gcm.Open(nil, eb.Nonce, eb.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errDecryptionFailed, err.Error())
+	}
+	return data, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptingProvider is a backup provider that encrypts backups with a KMS key
+// before they are uploaded, and decrypts encrypted backups upon restore.
+// Unencrypted backups, e.g. those made before encryption was enabled, are
+// restored as-is.
+type encryptingProvider struct {
+	BackupProvider
+
+	// KMS key with which to encrypt backups. If empty, backups are not
+	// encrypted.
+	kmsKey string
+
+	// keyManager returns the key manager, which is only constructed when
+	// needed
+	keyManager func(context.Context) (keyManager, error)
+}
+
+func (p *encryptingProvider) Backup(ctx context.Context, bucket, key string, data []byte) error {
+	if p.kmsKey == "" {
+		return p.BackupProvider.Backup(ctx, bucket, key, data)
+	}
+
+	km, err := p.keyManager(ctx)
+	if err != nil {
+		return err
+	}
+	encrypted, err := encryptBackup(ctx, km, p.kmsKey, data)
+	if err != nil {
+		return err
+	}
+	return p.BackupProvider.Backup(ctx, bucket, key, encrypted)
+}
+
+func (p *encryptingProvider) Restore(ctx context.Context, bucket, key string) ([]byte, error) {
+	data, err := p.BackupProvider.Restore(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	eb, ok := isEncryptedBackup(data)
+	if !ok {
+		return data, nil
+	}
+
+	km, err := p.keyManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return decryptBackup(ctx, km, eb)
+}
+
+// gcpKeyManager encrypts and decrypts with Google Cloud KMS
+type gcpKeyManager struct {
+	service *cloudkms.Service
+}
+
+func (m *gcpKeyManager) Encrypt(ctx context.Context, kmsKey string, plaintext []byte) ([]byte, error) {
+	resp, err := m.service.Projects.Locations.KeyRings.CryptoKeys.Encrypt(kmsKey, &cloudkms.EncryptRequest{
+		Plaintext: base64.StdEncoding.EncodeToString(plaintext),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
+}
+
+func (m *gcpKeyManager) Decrypt(ctx context.Context, kmsKey string, ciphertext []byte) ([]byte, error) {
+	resp, err := m.service.Projects.Locations.KeyRings.CryptoKeys.Decrypt(kmsKey, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
+
+// awsKeyManager encrypts and decrypts with AWS KMS
+type awsKeyManager struct {
+	client kmsiface.KMSAPI
+}
+
+func (m *awsKeyManager) Encrypt(ctx context.Context, kmsKey string, plaintext []byte) ([]byte, error) {
+	out, err := m.client.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(kmsKey),
+		Plaintext: plaintext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (m *awsKeyManager) Decrypt(ctx context.Context, kmsKey string, ciphertext []byte) ([]byte, error) {
+	out, err := m.client.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(kmsKey),
+		CiphertextBlob: ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupEncryption(t *testing.T) {
+	tests := []struct {
+		name       string
+		kms        *fakeKMS
+		tamper     func(*encryptedBackup)
+		err        error
+		assertions func(t *testing.T, encrypted []byte)
+	}{
+		{
+			name: "round trip",
+			kms:  &fakeKMS{},
+			assertions: func(t *testing.T, encrypted []byte) {
+				assert.NotContains(t, string(encrypted), "my state")
+
+				eb, ok := isEncryptedBackup(encrypted)
+				require.True(t, ok)
+				assert.Equal(t, "alias/etok", eb.KMSKey)
+			},
+		},
+		{
+			name: "kms access denied",
+			kms:  &fakeKMS{denyDecrypt: true},
+			err:  errDecryptionFailed,
+		},
+		{
+			name: "tampered ciphertext",
+			kms:  &fakeKMS{},
+			tamper: func(eb *encryptedBackup) {
+				eb.Ciphertext[0] ^= 0xff
+			},
+			err: errDecryptionFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			km := &awsKeyManager{client: tt.kms}
+
+			encrypted, err := encryptBackup(context.Background(), km, "alias/etok", []byte("my state"))
+			require.NoError(t, err)
+
+			if tt.assertions != nil {
+				tt.assertions(t, encrypted)
+			}
+
+			eb, ok := isEncryptedBackup(encrypted)
+			require.True(t, ok)
+			if tt.tamper != nil {
+				tt.tamper(eb)
+			}
+
+			decrypted, err := decryptBackup(context.Background(), km, eb)
+			if !assert.True(t, errors.Is(err, tt.err)) {
+				t.Errorf("no error in %v's chain matches %v", err, tt.err)
+			}
+			if tt.err == nil {
+				assert.Equal(t, "my state", string(decrypted))
+			}
+		})
+	}
+}
+
+func TestIsEncryptedBackupUnencrypted(t *testing.T) {
+	_, ok := isEncryptedBackup(readFile("testdata/tfstate.yaml"))
+	assert.False(t, ok)
+}
+
+// fakeKMS is an implementation of the AWS KMS API methods used for encrypting
+// backups. Ciphertext is the plaintext prefixed with the key ID.
+type fakeKMS struct {
+	kmsiface.KMSAPI
+
+	// denyDecrypt causes decrypt requests to be refused, as if the operator
+	// lacked permission to use the key
+	denyDecrypt bool
+}
+
+func (f *fakeKMS) EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+	return &kms.EncryptOutput{
+		CiphertextBlob: append([]byte(*input.KeyId+":"), input.Plaintext...),
+		KeyId:          input.KeyId,
+	}, nil
+}
+
+func (f *fakeKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	if f.denyDecrypt {
+		return nil, awserr.NewRequestFailure(awserr.New("AccessDeniedException", "access denied", nil), 400, "")
+	}
+	prefix := []byte(*input.KeyId + ":")
+	if !bytes.HasPrefix(input.CiphertextBlob, prefix) {
+		return nil, awserr.NewRequestFailure(awserr.New("IncorrectKeyException", fmt.Sprintf("ciphertext not encrypted with %s", *input.KeyId), nil), 400, "")
+	}
+	return &kms.DecryptOutput{
+		Plaintext: bytes.TrimPrefix(input.CiphertextBlob, prefix),
+		KeyId:     input.KeyId,
+	}, nil
+}
+
+// encryptFile reads a file and encrypts it as a backup using the fake KMS
+func encryptFile(path, kmsKey string) []byte {
+	encrypted, err := encryptBackup(context.Background(), &awsKeyManager{client: &fakeKMS{}}, kmsKey, readFile(path))
+	if err != nil {
+		panic(err.Error())
+	}
+	return encrypted
+}
@@ -32,6 +32,23 @@ func workspaceUnknown(message string) *metav1.Condition {
 	}
 }
 
+// pausedCondition reports whether the workspace is holding new runs
+func pausedCondition(ws *v1alpha1.Workspace) metav1.Condition {
+	if ws.Spec.Paused {
+		return metav1.Condition{
+			Type:    v1alpha1.WorkspacePausedCondition,
+			Status:  metav1.ConditionTrue,
+			Reason:  v1alpha1.PausedReason,
+			Message: "New runs are held until the workspace is resumed",
+		}
+	}
+	return metav1.Condition{
+		Type:   v1alpha1.WorkspacePausedCondition,
+		Status: metav1.ConditionFalse,
+		Reason: v1alpha1.ResumedReason,
+	}
+}
+
 func runFailed(reason, message string) *metav1.Condition {
 	return &metav1.Condition{
 		Type:    v1alpha1.RunFailedCondition,
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// workspaceQueueDepth is the number of runs waiting in each workspace's
+	// queue, excluding the active run
+	workspaceQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "etok_workspace_queue_depth",
+			Help: "Number of runs waiting in the workspace queue",
+		},
+		[]string{"namespace", "workspace"},
+	)
+
+	// workspaceReconcileDuration is the time taken to reconcile a workspace
+	workspaceReconcileDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "etok_workspace_reconcile_duration_seconds",
+			Help:    "Time taken to reconcile a workspace",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+)
+
+func init() {
+	// Register custom metrics with the global registry, which is served
+	// alongside the controller-runtime metrics on the manager's metrics
+	// endpoint
+	metrics.Registry.MustRegister(workspaceQueueDepth, workspaceReconcileDuration)
+}
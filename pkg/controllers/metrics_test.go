@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/leg100/etok/pkg/scheme"
+	"github.com/leg100/etok/pkg/testobj"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestWorkspaceMetrics(t *testing.T) {
+	ws := testobj.Workspace("default", "workspace-1")
+	cl := fake.NewFakeClientWithScheme(scheme.Scheme,
+		ws,
+		testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
+		testobj.Run("default", "apply-2", "apply", testobj.WithWorkspace("workspace-1")),
+		testobj.Run("default", "apply-3", "apply", testobj.WithWorkspace("workspace-1")),
+		testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+	)
+	r := NewWorkspaceReconciler(cl, "", WithEventRecorder(record.NewFakeRecorder(100)))
+
+	before := reconcileDurationCount(t)
+
+	_, _ = r.Reconcile(context.Background(), requestFromObject(ws))
+
+	// One apply is active and the other two are queued. The plan is not
+	// queueable.
+	assert.Equal(t, float64(2), promtestutil.ToFloat64(workspaceQueueDepth.WithLabelValues("default", "workspace-1")))
+	assert.Equal(t, before+1, reconcileDurationCount(t))
+
+	// Reconciling a deleted workspace stops reporting its queue depth
+	workspaceQueueDepth.WithLabelValues("default", "workspace-2").Set(1)
+	series := promtestutil.CollectAndCount(workspaceQueueDepth)
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "workspace-2"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, series-1, promtestutil.CollectAndCount(workspaceQueueDepth))
+	assert.Equal(t, before+2, reconcileDurationCount(t))
+}
+
+// reconcileDurationCount returns the number of reconcile durations observed
+func reconcileDurationCount(t *testing.T) uint64 {
+	var m dto.Metric
+	require.NoError(t, workspaceReconcileDuration.Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
@@ -12,6 +12,12 @@ const (
 	// pluginMountPath
 	pluginSubPath = "plugin-cache/"
 
+	// planMountPath is container path to saved plan files, each named after
+	// the plan run that saved it
+	planMountPath = "/plans"
+	// planSubPath is path within persistent volume to mount on planMountPath
+	planSubPath = "plans/"
+
 	// dotTerraformSubPath is path within persistent volume to mount on
 	// <WorkingDir>/.terraform
 	dotTerraformSubPath = ".terraform/"
@@ -27,4 +33,51 @@ const (
 	// backendPath is the filename in <WorkingDir> containing declaration of
 	// backend configuration.
 	backendPath = "_etok_backend.tf"
+
+	// backendConfigPath is the filename in <WorkingDir> containing the
+	// key-value pairs passed to terraform init via -backend-config. Only
+	// used for backends other than the kubernetes backend.
+	backendConfigPath = "_etok_backend.ini"
+
+	// varFilesMountPath is the container path to which terraform variable
+	// files are mounted
+	varFilesMountPath = "/varfiles"
+
+	// tmpMountPath is the container path for temporary files. A writable
+	// volume is mounted on it if the root filesystem is read-only.
+	tmpMountPath = "/tmp"
+
+	// homeMountPath is the container path of a writable home directory,
+	// which, unlike /root, is accessible whichever user the container runs
+	// as. HOME is set to it should the netrc or terraformrc file be mounted.
+	homeMountPath = "/home/etok"
+	// netrcMountPath is the container path to which the netrc file is
+	// mounted
+	netrcMountPath = "/home/etok/.netrc"
+	// netrcKey is the key in the netrc secret containing the netrc file
+	netrcKey = ".netrc"
+
+	// caBundleMountPath is the container path to which the CA bundle secret
+	// is mounted
+	caBundleMountPath = "/etc/ssl/etok-ca"
+	// caBundleKey is the key in the CA bundle secret containing the CA bundle
+	caBundleKey = "ca.crt"
+	// caCertsMountPath is the container path of a directory containing the
+	// system's CA certificates combined with the CA bundle
+	caCertsMountPath = "/etc/ssl/etok"
+	// caCertsFile is the filename in caCertsMountPath of the combined CA
+	// certificates
+	caCertsFile = "ca-certificates.crt"
+
+	// terraformRCMountPath is the container path to which the terraform CLI
+	// configuration file is mounted
+	terraformRCMountPath = "/home/etok/.terraformrc"
+	// terraformRCKey is the key in the terraformrc config map containing the
+	// terraform CLI configuration file
+	terraformRCKey = ".terraformrc"
+
+	// preRunMountPath is the container path of a directory shared by the
+	// pre-run container and the runner, for handing off files such as
+	// credentials
+	preRunMountPath = "/pre-run"
 )
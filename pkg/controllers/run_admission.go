@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/cmd/launcher"
+)
+
+// admitRun determines whether a run may proceed to creating its pod, given the
+// list of runs in the namespace and the maximum number of concurrent
+// non-mutating runs permitted for its workspace (zero imposes no limit).
+// Mutating runs are always admitted because they are serialized by the
+// workspace queue instead. Non-mutating runs are admitted in the order in
+// which they were created, for as long as there is a free slot.
+func admitRun(run *v1alpha1.Run, runs []v1alpha1.Run, max int) bool {
+	if max == 0 || launcher.IsQueueable(run.Command) {
+		return true
+	}
+
+	// Run already admitted
+	if isAdmitted(run) {
+		return true
+	}
+
+	var occupied int
+	for _, other := range runs {
+		// Filter out the run itself
+		if other.Name == run.Name {
+			continue
+		}
+
+		// Filter out runs belonging to other workspaces
+		if other.Workspace != run.Workspace {
+			continue
+		}
+
+		// Filter out completed runs
+		if other.IsDone() {
+			continue
+		}
+
+		// Filter out mutating runs
+		if launcher.IsQueueable(other.Command) {
+			continue
+		}
+
+		// Count runs already admitted, along with runs waiting for admission
+		// that were created earlier and therefore take precedence
+		if isAdmitted(&other) || createdBefore(&other, run) {
+			occupied++
+		}
+	}
+
+	return occupied < max
+}
+
+// isAdmitted determines whether a run has been admitted, i.e. it has proceeded
+// to provisioning or running a pod
+func isAdmitted(run *v1alpha1.Run) bool {
+	return run.Phase == v1alpha1.RunPhaseProvisioning || run.Phase == v1alpha1.RunPhaseRunning
+}
+
+// createdBefore determines whether run a was created before run b, falling
+// back to name for runs created at the same time
+func createdBefore(a, b *v1alpha1.Run) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Name < b.Name
+}
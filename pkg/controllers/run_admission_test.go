@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmitRun(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		run  *v1alpha1.Run
+		runs []v1alpha1.Run
+		max  int
+		want bool
+	}{
+		{
+			name: "No limit",
+			run:  testobj.Run("default", "plan-2", "plan", testobj.WithWorkspace("workspace-1")),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			},
+			max:  0,
+			want: true,
+		},
+		{
+			name: "Free slot",
+			run:  testobj.Run("default", "plan-2", "plan", testobj.WithWorkspace("workspace-1")),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			},
+			max:  2,
+			want: true,
+		},
+		{
+			name: "No free slot",
+			run:  testobj.Run("default", "plan-3", "plan", testobj.WithWorkspace("workspace-1")),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+				*testobj.Run("default", "plan-2", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseProvisioning)),
+			},
+			max:  2,
+			want: false,
+		},
+		{
+			name: "Already admitted",
+			run:  testobj.Run("default", "plan-3", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+				*testobj.Run("default", "plan-2", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			},
+			max:  2,
+			want: true,
+		},
+		{
+			name: "Mutating run always admitted",
+			run:  testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			},
+			max:  1,
+			want: true,
+		},
+		{
+			name: "Mutating runs do not occupy slots",
+			run:  testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			},
+			max:  1,
+			want: true,
+		},
+		{
+			name: "Completed runs do not occupy slots",
+			run:  testobj.Run("default", "plan-2", "plan", testobj.WithWorkspace("workspace-1")),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning), testobj.WithCondition(v1alpha1.RunCompleteCondition)),
+			},
+			max:  1,
+			want: true,
+		},
+		{
+			name: "Runs of other workspaces do not occupy slots",
+			run:  testobj.Run("default", "plan-2", "plan", testobj.WithWorkspace("workspace-1")),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-2"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			},
+			max:  1,
+			want: true,
+		},
+		{
+			name: "Earlier waiting run takes precedence",
+			run:  testobj.Run("default", "plan-2", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(now)),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting), testobj.WithCreationTimestamp(now.Add(-time.Second))),
+			},
+			max:  1,
+			want: false,
+		},
+		{
+			name: "Later waiting run does not take precedence",
+			run:  testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(now.Add(-time.Second))),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "plan-2", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting), testobj.WithCreationTimestamp(now)),
+			},
+			max:  1,
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, admitRun(tt.run, tt.runs, tt.max))
+		})
+	}
+}
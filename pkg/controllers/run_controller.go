@@ -3,6 +3,8 @@ package controllers
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
@@ -17,6 +19,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -36,27 +39,57 @@ var (
 	// runPodPendingTimeout is the maximum time a pod can remain in the pending
 	// phase
 	runPodPendingTimeout = 60 * time.Second
+	// runApprovalTimeout is the maximum time a run can remain waiting to be
+	// approved, measured from its creation
+	runApprovalTimeout = 60 * time.Minute
 )
 
 type runUpdater func(context.Context, *v1alpha1.Run, v1alpha1.Workspace) (*metav1.Condition, error)
 
 type RunReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Image  string
+	Scheme   *runtime.Scheme
+	Image    string
+	recorder record.EventRecorder
+
+	// Toggle defaults suited to GKE Autopilot clusters
+	Autopilot bool
+}
+
+type RunReconcilerOption func(r *RunReconciler)
+
+func WithRunEventRecorder(recorder record.EventRecorder) RunReconcilerOption {
+	return func(r *RunReconciler) {
+		r.recorder = recorder
+	}
+}
+
+func WithRunAutopilot(enabled bool) RunReconcilerOption {
+	return func(r *RunReconciler) {
+		r.Autopilot = enabled
+	}
 }
 
-func NewRunReconciler(c client.Client, image string) *RunReconciler {
+func NewRunReconciler(c client.Client, image string, opts ...RunReconcilerOption) *RunReconciler {
 	r := &RunReconciler{
 		Client: c,
 		Scheme: scheme.Scheme,
 		Image:  image,
+		// Discard events unless a recorder is provided
+		recorder: &record.FakeRecorder{},
+	}
+
+	for _, o := range opts {
+		o(r)
 	}
 
 	// Build chain of status updaters, to be called one after the other in a
 	// reconcile
 	runReconcileStatusChain = []runUpdater{}
+	runReconcileStatusChain = append(runReconcileStatusChain, r.manageApproval)
 	runReconcileStatusChain = append(runReconcileStatusChain, r.manageQueue)
+	runReconcileStatusChain = append(runReconcileStatusChain, r.manageConcurrency)
+	runReconcileStatusChain = append(runReconcileStatusChain, r.managePause)
 	runReconcileStatusChain = append(runReconcileStatusChain, r.managePod)
 
 	return r
@@ -81,9 +114,10 @@ func (r *RunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// Don't reconcile failed or completed runs
+	// Don't reconcile failed or completed runs, other than to kill the pod of
+	// a run that timed out
 	if run.IsDone() {
-		return ctrl.Result{}, nil
+		return ctrl.Result{}, r.deleteTimedOutPod(ctx, &run)
 	}
 
 	// Fetch its Workspace object
@@ -133,11 +167,50 @@ func (r *RunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		if err := r.updateStatus(ctx, req, run.RunStatus); err != nil {
 			return ctrl.Result{}, err
 		}
+
+		if condition.Reason == v1alpha1.RunTimeoutReason {
+			// Only now that the run's failure is persisted is its pod killed,
+			// lest the pod be recreated should the update fail
+			return ctrl.Result{}, r.deleteTimedOutPod(ctx, &run)
+		}
+
+		if condition.Reason == v1alpha1.PendingApprovalReason {
+			// Reconcile again once the approval timeout has expired
+			return ctrl.Result{RequeueAfter: time.Until(run.CreationTimestamp.Add(runApprovalTimeout))}, nil
+		}
+
+		if condition.Reason == v1alpha1.PodRunningReason {
+			// Reconcile again once the run timeout, if any, has expired
+			var pod corev1.Pod
+			if err := r.Get(ctx, requestFromObject(&run).NamespacedName, &pod); err != nil {
+				return ctrl.Result{}, client.IgnoreNotFound(err)
+			}
+			if remaining, ok := runTimeRemaining(&run, &pod); ok {
+				return ctrl.Result{RequeueAfter: remaining}, nil
+			}
+		}
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// deleteTimedOutPod deletes the pod of a run that has failed because it timed
+// out. The pod is deleted only once the failure is persisted, so that it is
+// not recreated.
+func (r *RunReconciler) deleteTimedOutPod(ctx context.Context, run *v1alpha1.Run) error {
+	failed := meta.FindStatusCondition(run.Conditions, v1alpha1.RunFailedCondition)
+	if failed == nil || failed.Status != metav1.ConditionTrue || failed.Reason != v1alpha1.RunTimeoutReason {
+		return nil
+	}
+
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: run.Namespace, Name: run.PodName()}}
+	if err := r.Delete(ctx, &pod); client.IgnoreNotFound(err) != nil {
+		log.FromContext(ctx).Error(err, "unable to delete pod")
+		return err
+	}
+	return nil
+}
+
 func (r *RunReconciler) updateStatus(ctx context.Context, req ctrl.Request, newStatus v1alpha1.RunStatus) error {
 	var run v1alpha1.Run
 	if err := r.Get(ctx, req.NamespacedName, &run); err != nil {
@@ -183,6 +256,9 @@ func processRunReconcileStatusChain(ctx context.Context, run *v1alpha1.Run, ws v
 					}
 					// Do not proceed to creating pod
 					return condition, nil
+				case v1alpha1.RunThrottledReason, v1alpha1.PendingApprovalReason, v1alpha1.RunPausedReason:
+					// Do not proceed to creating pod
+					return condition, nil
 				case v1alpha1.PodPendingReason:
 					if condition.LastTransitionTime.Add(runPodPendingTimeout).After(time.Now()) {
 						return runFailed(v1alpha1.RunPendingTimeoutReason, "Timed out waiting for pod in pending phase"), nil
@@ -207,8 +283,10 @@ func setRunPhase(condition metav1.Condition) v1alpha1.RunPhase {
 			return v1alpha1.RunPhaseCompleted
 		case metav1.ConditionFalse:
 			switch condition.Reason {
-			case v1alpha1.RunUnqueuedReason:
+			case v1alpha1.RunUnqueuedReason, v1alpha1.RunThrottledReason, v1alpha1.RunPausedReason:
 				return v1alpha1.RunPhaseWaiting
+			case v1alpha1.PendingApprovalReason:
+				return v1alpha1.RunPhasePendingApproval
 			case v1alpha1.RunQueuedReason:
 				return v1alpha1.RunPhaseQueued
 			case v1alpha1.PodCreatedReason, v1alpha1.PodPendingReason:
@@ -222,6 +300,19 @@ func setRunPhase(condition metav1.Condition) v1alpha1.RunPhase {
 	return v1alpha1.RunPhaseUnknown
 }
 
+// Hold back a run requiring approval from the queue until it is approved,
+// failing the run should it not be approved in time
+func (r *RunReconciler) manageApproval(ctx context.Context, run *v1alpha1.Run, ws v1alpha1.Workspace) (*metav1.Condition, error) {
+	if !ws.RequiresApproval(run.Command) || ws.IsRunReviewed(run) {
+		return nil, nil
+	}
+
+	if time.Since(run.CreationTimestamp.Time) > runApprovalTimeout {
+		return runFailed(v1alpha1.ApprovalTimeoutReason, "Timed out waiting for approval"), nil
+	}
+	return runIncomplete(v1alpha1.PendingApprovalReason, fmt.Sprintf("Run waiting to be approved with: etok approve %s", run.Name)), nil
+}
+
 func (r *RunReconciler) manageQueue(ctx context.Context, run *v1alpha1.Run, ws v1alpha1.Workspace) (*metav1.Condition, error) {
 	if !launcher.IsQueueable(run.Command) {
 		return nil, nil
@@ -238,6 +329,40 @@ func (r *RunReconciler) manageQueue(ctx context.Context, run *v1alpha1.Run, ws v
 	}
 }
 
+// Limit the number of non-mutating runs running simultaneously on the
+// workspace
+func (r *RunReconciler) manageConcurrency(ctx context.Context, run *v1alpha1.Run, ws v1alpha1.Workspace) (*metav1.Condition, error) {
+	if ws.Spec.MaxConcurrentRuns == 0 || launcher.IsQueueable(run.Command) {
+		return nil, nil
+	}
+
+	runlist := &v1alpha1.RunList{}
+	if err := r.List(ctx, runlist, client.InNamespace(run.Namespace)); err != nil {
+		return nil, err
+	}
+
+	if admitRun(run, runlist.Items, ws.Spec.MaxConcurrentRuns) {
+		return nil, nil
+	}
+	return runIncomplete(v1alpha1.RunThrottledReason, fmt.Sprintf("Run waiting for one of %d concurrent runs to finish", ws.Spec.MaxConcurrentRuns)), nil
+}
+
+// Hold back a run whilst its workspace is paused, unless its pod has already
+// been created, in which case it is left to finish.
+func (r *RunReconciler) managePause(ctx context.Context, run *v1alpha1.Run, ws v1alpha1.Workspace) (*metav1.Condition, error) {
+	if !ws.Spec.Paused {
+		return nil, nil
+	}
+
+	err := r.Get(ctx, requestFromObject(run).NamespacedName, &corev1.Pod{})
+	if err == nil {
+		return nil, nil
+	} else if !kerrors.IsNotFound(err) {
+		return nil, err
+	}
+	return runIncomplete(v1alpha1.RunPausedReason, "Run waiting for workspace to be resumed"), nil
+}
+
 // Manage run's pod. Update run status to reflect pod status.
 func (r *RunReconciler) managePod(ctx context.Context, run *v1alpha1.Run, ws v1alpha1.Workspace) (*metav1.Condition, error) {
 	log := log.FromContext(ctx)
@@ -263,7 +388,34 @@ func (r *RunReconciler) managePod(ctx context.Context, run *v1alpha1.Run, ws v1a
 	var pod corev1.Pod
 	err = r.Get(ctx, requestFromObject(run).NamespacedName, &pod)
 	if kerrors.IsNotFound(err) {
-		pod = *runPod(run, &ws, secretFound, serviceAccountFound, r.Image)
+		// Check additional secrets are available before creating pod
+		for _, name := range ws.Spec.SecretNames {
+			err := r.Get(ctx, types.NamespacedName{Namespace: run.Namespace, Name: name}, &corev1.Secret{})
+			if kerrors.IsNotFound(err) {
+				return runFailed(v1alpha1.SecretNotFoundReason, fmt.Sprintf("Secret %s not found", name)), nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		// Check variables sources are available before creating pod
+		for _, src := range ws.Spec.VariablesFrom {
+			cond, err := r.checkVariablesSource(ctx, run.Namespace, src)
+			if err != nil || cond != nil {
+				return cond, err
+			}
+		}
+
+		newPod, err := runPod(run, &ws, secretFound, serviceAccountFound, podImage(&ws, r.Image))
+		if err != nil {
+			return nil, err
+		}
+		pod = *newPod
+
+		if r.Autopilot {
+			setAutopilotResources(&pod.Spec)
+			setAutopilotAffinity(&pod.Spec, ws.Name)
+		}
 
 		// Make run owner of pod
 		if err := controllerutil.SetControllerReference(run, &pod, r.Scheme); err != nil {
@@ -281,6 +433,22 @@ func (r *RunReconciler) managePod(ctx context.Context, run *v1alpha1.Run, ws v1a
 
 	var isCompleted = metav1.ConditionFalse
 
+	if pod.Status.Phase == corev1.PodRunning {
+		// Kill a pod that has been running for longer than permitted
+		if remaining, ok := runTimeRemaining(run, &pod); ok && remaining <= 0 {
+			msg := fmt.Sprintf("Timed out after running for %s", run.Timeout)
+			r.recorder.Event(run, "Warning", v1alpha1.RunTimeoutReason, msg)
+			return runFailed(v1alpha1.RunTimeoutReason, msg), nil
+		}
+	}
+
+	if pod.Status.Phase == corev1.PodFailed {
+		// The runner never runs if the pre-run script fails
+		if msg, failed := preRunFailed(&pod); failed {
+			return runFailed(v1alpha1.PreRunFailedReason, msg), nil
+		}
+	}
+
 	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
 		// Record exit code in run status
 		code, err := getExitCode(&pod)
@@ -292,10 +460,22 @@ func (r *RunReconciler) managePod(ctx context.Context, run *v1alpha1.Run, ws v1a
 		isCompleted = metav1.ConditionTrue
 	}
 
+	var msg string
+	if pod.Status.Phase == corev1.PodFailed {
+		// Report who holds the state lock, should terraform have failed to
+		// acquire it, so that the user can decide whether to release it
+		if info := stateLockInfo(&pod); info != nil {
+			run.RunStatus.LockInfo = info
+			msg = fmt.Sprintf("State locked by %s since %s (lock ID: %s); if the lock is stale, release it with: etok force-unlock %s", info.Who, info.Created, info.ID, run.Name)
+			r.recorder.Event(run, "Warning", v1alpha1.StateLockedReason, msg)
+		}
+	}
+
 	return &metav1.Condition{
-		Type:   v1alpha1.RunCompleteCondition,
-		Status: isCompleted,
-		Reason: getReasonFromPodPhase(pod.Status.Phase),
+		Type:    v1alpha1.RunCompleteCondition,
+		Status:  isCompleted,
+		Reason:  getReasonFromPodPhase(pod.Status.Phase),
+		Message: msg,
 	}, nil
 }
 
@@ -323,6 +503,63 @@ func getExitCode(pod *corev1.Pod) (int, error) {
 	return int(status.State.Terminated.ExitCode), nil
 }
 
+// runTimeRemaining returns the time remaining before the run's timeout expires,
+// measured from when its pod started. False is returned if the run has no
+// timeout or the pod is yet to start.
+func runTimeRemaining(run *v1alpha1.Run, pod *corev1.Pod) (time.Duration, bool) {
+	if run.Timeout == "" || pod.Status.StartTime == nil {
+		return 0, false
+	}
+	timeout, err := time.ParseDuration(run.Timeout)
+	if err != nil {
+		return 0, false
+	}
+	return time.Until(pod.Status.StartTime.Add(timeout)), true
+}
+
+// preRunFailed determines whether the pod's pre-run container, if it has one,
+// exited non-zero, returning a message describing the failure along with the
+// container's termination message, i.e. the tail of its logs
+func preRunFailed(pod *corev1.Pod) (string, bool) {
+	status := k8s.ContainerStatusByName(pod, PreRunContainerName)
+	if status == nil || status.State.Terminated == nil || status.State.Terminated.ExitCode == 0 {
+		return "", false
+	}
+	msg := fmt.Sprintf("Pre-run script failed with exit code %d", status.State.Terminated.ExitCode)
+	if tail := strings.TrimSpace(status.State.Terminated.Message); tail != "" {
+		msg += ": " + tail
+	}
+	return msg, true
+}
+
+// stateLockInfo retrieves the details of the state lock from the runner
+// container's termination message, i.e. the tail of its logs, should terraform
+// have failed to acquire the lock
+func stateLockInfo(pod *corev1.Pod) *v1alpha1.LockInfo {
+	status := k8s.ContainerStatusByName(pod, globals.RunnerContainerName)
+	if status == nil || status.State.Terminated == nil {
+		return nil
+	}
+	return parseLockInfo(status.State.Terminated.Message)
+}
+
+// checkVariablesSource fails the run if the config map or secret from which
+// its variables are sourced is not found
+func (r *RunReconciler) checkVariablesSource(ctx context.Context, namespace string, src v1alpha1.VariablesSource) (*metav1.Condition, error) {
+	var obj client.Object = &corev1.ConfigMap{}
+	reason := v1alpha1.ConfigMapNotFoundReason
+	if src.Kind == v1alpha1.VariablesSourceSecret {
+		obj = &corev1.Secret{}
+		reason = v1alpha1.SecretNotFoundReason
+	}
+
+	err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: src.Name}, obj)
+	if kerrors.IsNotFound(err) {
+		return runFailed(reason, fmt.Sprintf("%s %s not found", src.Kind, src.Name)), nil
+	}
+	return nil, err
+}
+
 func (r *RunReconciler) setOwnerOfArchive(ctx context.Context, run *v1alpha1.Run) error {
 	log := log.FromContext(ctx)
 
@@ -389,5 +626,28 @@ func (r *RunReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return
 	}))
 
+	// Watch for changes to runs and requeue waiting runs of the same workspace,
+	// which may now be admitted should the run have finished
+	blder = blder.Watches(&source.Kind{Type: &v1alpha1.Run{}}, handler.EnqueueRequestsFromMapFunc(func(o client.Object) (requests []ctrl.Request) {
+		runlist := &v1alpha1.RunList{}
+		_ = r.List(context.TODO(), runlist, client.InNamespace(o.GetNamespace()), client.MatchingFields{
+			"spec.workspace": o.(*v1alpha1.Run).Workspace,
+		})
+		for _, run := range runlist.Items {
+			// Skip the run that triggered the event
+			if run.Name == o.GetName() {
+				continue
+			}
+
+			// Only trigger reconcile of runs awaiting admission
+			if run.Phase != v1alpha1.RunPhaseWaiting {
+				continue
+			}
+
+			requests = append(requests, requestFromObject(&run))
+		}
+		return
+	}))
+
 	return blder.Complete(r)
 }
@@ -3,16 +3,142 @@ package controllers
 import (
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/cmd/launcher"
 	"github.com/leg100/etok/pkg/globals"
 	"github.com/leg100/etok/pkg/labels"
+	"github.com/leg100/etok/pkg/util/slice"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func runPod(run *v1alpha1.Run, ws *v1alpha1.Workspace, secretFound, serviceAccountFound bool, image string) *corev1.Pod {
+const (
+	// PreRunContainerName is the name of the init container that runs the
+	// workspace's pre-run script
+	PreRunContainerName = "pre-run"
+
+	// defaultMutatingGracePeriod is the default termination grace period, in
+	// seconds, for run pods with commands that mutate state
+	defaultMutatingGracePeriod int64 = 600
+)
+
+// Terraform commands that accept the -var-file flag
+var varFileCommands = []string{"apply", "console", "destroy", "import", "plan", "refresh"}
+
+// Terraform commands that are passed the workspace's default terraform args.
+// Only commands that lock state and plan changes are included, for they accept
+// the same flags, e.g. -lock-timeout and -parallelism, whereas other commands
+// such as output and show would reject them.
+var terraformArgsCommands = []string{"apply", "destroy", "import", "plan", "refresh"}
+
+// Terraform flags that may be passed more than once, their values
+// accumulating. Defaults passing them are merged with the run's args rather
+// than overridden.
+var repeatableFlags = []string{"replace", "target", "var-file"}
+
+// varFileArgs returns -var-file flags for each var file, preserving their order
+// so that later files override earlier files
+func varFileArgs(varFiles []string) string {
+	var args []string
+	for _, f := range varFiles {
+		args = append(args, "-var-file="+filepath.Join(varFilesMountPath, f))
+	}
+	return strings.Join(args, " ")
+}
+
+// initArgs returns the args to be passed to terraform init. The backend config
+// arg is placed last so that it takes precedence over any user-provided backend
+// config.
+func initArgs(ws *v1alpha1.Workspace) string {
+	args := append([]string{}, ws.Spec.InitArgs...)
+	args = append(args, backendInitArg(ws))
+	return strings.Join(args, " ")
+}
+
+// terraformArgs merges the workspace's default terraform args with the run's
+// args. Defaults precede the run's args, keeping any positional args last, and
+// a default is dropped should the run pass the same flag, so that the run's
+// args take precedence. A -var default is only dropped should the run set the
+// same variable, and repeatable flags such as -target are merged rather than
+// dropped. Only terraformArgsCommands are passed the defaults, and not an apply
+// of a saved plan, which terraform refuses to be passed planning options.
+func terraformArgs(run *v1alpha1.Run, ws *v1alpha1.Workspace) []string {
+	if !slice.ContainsString(terraformArgsCommands, run.Command) {
+		return run.Args
+	}
+	if run.Command == "apply" && run.Plan != "" {
+		return run.Args
+	}
+
+	passed := make(map[string]bool)
+	for i := range run.Args {
+		if key := overrideKey(run.Args, i); key != "" {
+			passed[key] = true
+		}
+	}
+
+	var args []string
+	defaults := ws.Spec.TerraformArgs
+	for i := 0; i < len(defaults); i++ {
+		if !passed[overrideKey(defaults, i)] {
+			args = append(args, defaults[i])
+			continue
+		}
+		// Also drop the overridden flag's value, should it be passed as a
+		// separate arg, e.g. -lock-timeout 5m
+		if !strings.Contains(defaults[i], "=") && i+1 < len(defaults) && flagName(defaults[i+1]) == "" {
+			i++
+		}
+	}
+	return append(args, run.Args...)
+}
+
+// overrideKey returns the key by which the flag arg at index i of args is
+// overridden: the flag's name, or for -var its name along with that of the
+// variable, e.g. var:region for -var=region=eu-west-2. An empty key is
+// returned for an arg that is not a flag or for a repeatable flag, which is
+// never overridden.
+func overrideKey(args []string, i int) string {
+	name := flagName(args[i])
+	if name == "" || slice.ContainsString(repeatableFlags, name) {
+		return ""
+	}
+	if name != "var" {
+		return name
+	}
+
+	// The variable is set either in the same arg, e.g. -var=region=eu-west-2,
+	// or in the next arg, e.g. -var region=eu-west-2
+	var value string
+	if j := strings.Index(args[i], "="); j >= 0 {
+		value = args[i][j+1:]
+	} else if i+1 < len(args) {
+		value = args[i+1]
+	}
+	if j := strings.Index(value, "="); j >= 0 {
+		value = value[:j]
+	}
+	return name + ":" + value
+}
+
+// flagName returns the name of a flag arg, e.g. lock-timeout for
+// -lock-timeout=5m, or an empty string if the arg is not a flag
+func flagName(arg string) string {
+	if !strings.HasPrefix(arg, "-") {
+		return ""
+	}
+	name := strings.TrimLeft(arg, "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+func runPod(run *v1alpha1.Run, ws *v1alpha1.Workspace, secretFound, serviceAccountFound bool, image string) (*corev1.Pod, error) {
 	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      run.PodName(),
@@ -22,7 +148,7 @@ func runPod(run *v1alpha1.Run, ws *v1alpha1.Workspace, secretFound, serviceAccou
 			Containers: []corev1.Container{
 				{
 					Command: []string{"etok", "runner"},
-					Args:    append([]string{"--"}, run.Args...),
+					Args:    append([]string{"--"}, terraformArgs(run, ws)...),
 					Env: []corev1.EnvVar{
 						{
 							Name:  "ETOK_HANDSHAKE",
@@ -70,12 +196,16 @@ func runPod(run *v1alpha1.Run, ws *v1alpha1.Workspace, secretFound, serviceAccou
 						},
 						{
 							Name:  "TF_CLI_ARGS_init",
-							Value: "-backend-config=secret_suffix=" + ws.Name,
+							Value: initArgs(ws),
 						},
 						{
 							Name:  "ETOK_RUN_NAME",
 							Value: run.Name,
 						},
+						{
+							Name:  "ETOK_TF_WORKSPACE",
+							Value: run.TFWorkspace,
+						},
 						{
 							Name:  "TF_VAR_namespace",
 							Value: ws.Namespace,
@@ -86,8 +216,9 @@ func runPod(run *v1alpha1.Run, ws *v1alpha1.Workspace, secretFound, serviceAccou
 						},
 					},
 					Image:                    image,
-					ImagePullPolicy:          corev1.PullIfNotPresent,
+					ImagePullPolicy:          podImagePullPolicy(ws),
 					Name:                     globals.RunnerContainerName,
+					Resources:                ws.Spec.Resources,
 					Stdin:                    run.Handshake,
 					TTY:                      run.Handshake,
 					TerminationMessagePolicy: "FallbackToLogsOnError",
@@ -131,14 +262,7 @@ func runPod(run *v1alpha1.Run, ws *v1alpha1.Workspace, secretFound, serviceAccou
 			},
 			RestartPolicy: corev1.RestartPolicyNever,
 			Volumes: []corev1.Volume{
-				{
-					Name: "cache",
-					VolumeSource: corev1.VolumeSource{
-						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-							ClaimName: ws.PVCName(),
-						},
-					},
-				},
+				cacheVolume(ws),
 				{
 					Name: "tarball",
 					VolumeSource: corev1.VolumeSource{
@@ -163,6 +287,32 @@ func runPod(run *v1alpha1.Run, ws *v1alpha1.Workspace, secretFound, serviceAccou
 		},
 	}
 
+	if ws.Spec.Cache.Ephemeral {
+		// Nothing is shared with the workspace pod, so install terraform and,
+		// unless the run is itself an init, run terraform init beforehand
+		installer, err := installerContainer(ws, image)
+		if err != nil {
+			return nil, err
+		}
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, installer)
+
+		if run.Command != "init" && run.Command != "sh" {
+			pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{
+				Name:  "ETOK_AUTO_INIT",
+				Value: "true",
+			})
+		}
+	}
+
+	setScheduling(&pod.Spec, ws)
+	setImagePullSecrets(&pod.Spec, ws)
+	pod.Spec.TerminationGracePeriodSeconds = runTerminationGracePeriod(ws, run.Command)
+	// The tarball is extracted to the workspace dir
+	setSecurityContext(&pod.Spec, ws, workspaceDir)
+	// Set before the pre-run container is added, which copies the runner
+	setCABundle(&pod.Spec, ws)
+	setPodMetadata(pod, ws)
+
 	// Set etok's common labels
 	labels.SetCommonLabels(pod)
 	// Permit filtering pods by workspace
@@ -172,6 +322,85 @@ func runPod(run *v1alpha1.Run, ws *v1alpha1.Workspace, secretFound, serviceAccou
 	// Permit filtering pods by the run command
 	labels.SetLabel(pod, labels.Command(run.Command))
 
+	if ws.BackendType() != v1alpha1.BackendKubernetes {
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name: "builtins",
+			// <WorkingDir>/_etok_backend.ini
+			MountPath: filepath.Join(workspaceDir, run.ConfigMapPath, backendConfigPath),
+			SubPath:   backendConfigPath,
+		})
+	}
+
+	if len(ws.Spec.VarFiles) > 0 {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "varfiles",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: ws.VarFilesConfigMapName(),
+					},
+				},
+			},
+		})
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "varfiles",
+			MountPath: varFilesMountPath,
+		})
+
+		// Pass var files to those commands that accept them
+		args := varFileArgs(ws.Spec.VarFiles)
+		for _, cmd := range varFileCommands {
+			if cmd == "apply" && run.Plan != "" {
+				// Terraform refuses to set variables when applying a saved
+				// plan; they're already recorded in the plan
+				continue
+			}
+			pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{
+				Name:  "TF_CLI_ARGS_" + cmd,
+				Value: args,
+			})
+		}
+	}
+
+	// Save plans to the cache so that a subsequent apply can apply the exact
+	// plan
+	switch {
+	case run.Command == "plan":
+		setPlanFile(pod, run.Name)
+		if run.JSONPlan {
+			pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{
+				Name:  "ETOK_JSON_PLAN",
+				Value: "true",
+			})
+		}
+	case run.Command == "apply" && run.Plan != "":
+		setPlanFile(pod, run.Plan)
+	}
+
+	// Commands that install providers back them up to, and restore them from,
+	// the backup bucket, as do runs on an ephemeral cache, which run init
+	// beforehand
+	if ws.Spec.CacheBackup && ws.Spec.BackupBucket != "" && (launcher.UpdatesLockFile(run.Command) || ws.Spec.Cache.Ephemeral) {
+		pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env,
+			corev1.EnvVar{Name: "ETOK_CACHE_BACKUP_BUCKET", Value: ws.Spec.BackupBucket},
+			corev1.EnvVar{Name: "ETOK_CACHE_BACKUP_PROVIDER", Value: ws.BackupProviderType()},
+			corev1.EnvVar{Name: "ETOK_CACHE_BACKUP_KEY", Value: ws.CacheBackupObjectName()},
+		)
+		if ws.Spec.BackupKMSKey != "" {
+			pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "ETOK_CACHE_BACKUP_KMS_KEY", Value: ws.Spec.BackupKMSKey})
+		}
+	}
+
+	if ws.Spec.TFLog != "" {
+		pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "TF_LOG",
+			Value: ws.Spec.TFLog,
+		})
+	}
+
+	// Set backend credentials
+	pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, backendCredentialsEnv(ws)...)
+
 	if serviceAccountFound {
 		pod.Spec.ServiceAccountName = "etok"
 	}
@@ -186,6 +415,90 @@ func runPod(run *v1alpha1.Run, ws *v1alpha1.Workspace, secretFound, serviceAccou
 		})
 	}
 
+	// Additional secrets follow the etok secret so that they take precedence
+	for _, name := range ws.Spec.SecretNames {
+		pod.Spec.Containers[0].EnvFrom = append(pod.Spec.Containers[0].EnvFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: name,
+				},
+			},
+		})
+	}
+
+	// Project the keys of variables sources as terraform variables. Variables
+	// set on the workspace are set as env vars, which take precedence.
+	for _, src := range ws.Spec.VariablesFrom {
+		pod.Spec.Containers[0].EnvFrom = append(pod.Spec.Containers[0].EnvFrom, variablesEnvFrom(src))
+	}
+
+	if ws.Spec.NetrcSecret != "" {
+		// Git and curl read the netrc file from the home directory, and
+		// terraform reads it from NETRC
+		setHomeDirectory(pod)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "netrc",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: ws.Spec.NetrcSecret,
+				},
+			},
+		})
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "netrc",
+			MountPath: netrcMountPath,
+			SubPath:   netrcKey,
+			ReadOnly:  true,
+		})
+		pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "NETRC",
+			Value: netrcMountPath,
+		})
+	}
+
+	if ws.Spec.TerraformRCConfigMap != "" {
+		setHomeDirectory(pod)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "terraformrc",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: ws.Spec.TerraformRCConfigMap,
+					},
+				},
+			},
+		})
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "terraformrc",
+			MountPath: terraformRCMountPath,
+			SubPath:   terraformRCKey,
+			ReadOnly:  true,
+		})
+		// Terraform reads ~/.terraformrc by default but be explicit in case
+		// HOME is overridden, e.g. by the workspace's environment variables
+		pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "TF_CLI_CONFIG_FILE",
+			Value: terraformRCMountPath,
+		})
+	}
+
+	// Set registry tokens from their secrets, sorted by hostname so that the
+	// pod spec is stable
+	hosts := make([]string, 0, len(ws.Spec.RegistryTokens))
+	for host := range ws.Spec.RegistryTokens {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		ref := ws.Spec.RegistryTokens[host]
+		pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{
+			Name: remoteTokenEnvVar(host),
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &ref,
+			},
+		})
+	}
+
 	// Set workspace variables
 	for _, v := range ws.Spec.Variables {
 		var ev corev1.EnvVar
@@ -205,5 +518,147 @@ func runPod(run *v1alpha1.Run, ws *v1alpha1.Workspace, secretFound, serviceAccou
 		pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, ev)
 	}
 
-	return pod
+	// Set run variables last so that they override workspace variables
+	setRunEnvironmentVariables(pod, run)
+
+	if ws.Spec.PreRunScript != "" {
+		setPreRunContainer(pod, ws.Spec.PreRunScript)
+	}
+
+	return pod, nil
+}
+
+// variablesEnvFrom sources TF_VAR_<key> environment variables from each key of
+// the variables source
+func variablesEnvFrom(src v1alpha1.VariablesSource) corev1.EnvFromSource {
+	ref := corev1.LocalObjectReference{Name: src.Name}
+	if src.Kind == v1alpha1.VariablesSourceSecret {
+		return corev1.EnvFromSource{
+			Prefix:    "TF_VAR_",
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: ref},
+		}
+	}
+	return corev1.EnvFromSource{
+		Prefix:       "TF_VAR_",
+		ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: ref},
+	}
+}
+
+// preRunCommand extracts the tarball, as the runner does, so that the
+// configuration is available to the pre-run script, and then runs the script,
+// which is passed as the first argument.
+const preRunCommand = `mkdir -p "$ETOK_DEST" && tar -xzf "$ETOK_TARBALL" -C "$ETOK_DEST" && exec sh -c "$1"`
+
+// setPreRunContainer adds an init container to the pod that runs the script
+// before the runner. It is a copy of the runner container, so that the script
+// has the same environment, secrets, mounts and configuration as terraform,
+// and a directory is shared between the two for handing off files.
+func setPreRunContainer(pod *corev1.Pod, script string) {
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: "pre-run",
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "pre-run",
+		MountPath: preRunMountPath,
+	})
+
+	container := pod.Spec.Containers[0].DeepCopy()
+	container.Name = PreRunContainerName
+	container.Command = []string{"sh", "-c", preRunCommand, PreRunContainerName, script}
+	container.Args = nil
+	container.Stdin = false
+	container.TTY = false
+
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, *container)
+}
+
+// runTerminationGracePeriod returns the termination grace period for a run pod.
+// Unless the workspace specifies otherwise, commands that mutate state are
+// given longer than the kubernetes default to finish writing state and
+// releasing locks.
+func runTerminationGracePeriod(ws *v1alpha1.Workspace, command string) *int64 {
+	if ws.Spec.TerminationGracePeriodSeconds != nil {
+		period := *ws.Spec.TerminationGracePeriodSeconds
+		return &period
+	}
+	if launcher.IsQueueable(command) {
+		period := defaultMutatingGracePeriod
+		return &period
+	}
+	return nil
+}
+
+// setPlanFile mounts the directory of saved plans from the cache and informs
+// the runner of the path to the plan file for the given plan run
+func setPlanFile(pod *corev1.Pod, planRun string) {
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "cache",
+		MountPath: planMountPath,
+		SubPath:   planSubPath,
+	})
+	pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{
+		Name:  "ETOK_PLAN_FILE",
+		Value: filepath.Join(planMountPath, planRun),
+	})
+}
+
+// setHomeDirectory mounts a writable home directory on the pod and sets HOME
+// to it, unless already done so. It is needed for files that tools read from
+// the home directory, because the directory of the user the image runs as,
+// e.g. /root, is not necessarily writable or even the user's.
+func setHomeDirectory(pod *corev1.Pod) {
+	for _, vol := range pod.Spec.Volumes {
+		if vol.Name == "home" {
+			return
+		}
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: "home",
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "home",
+		MountPath: homeMountPath,
+	})
+	pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{
+		Name:  "HOME",
+		Value: homeMountPath,
+	})
+}
+
+// setRunEnvironmentVariables sets the run's environment variables on its pod,
+// replacing any of the same name set by the workspace. They are sorted by name
+// so that the pod spec is stable. Reserved variables are skipped: they are
+// rejected when the run is created, but a run created before then must still
+// not override those set by etok.
+func setRunEnvironmentVariables(pod *corev1.Pod, run *v1alpha1.Run) {
+	names := make([]string, 0, len(run.EnvironmentVariables))
+	for name := range run.EnvironmentVariables {
+		if v1alpha1.IsReservedEnvironmentVariable(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	container := &pod.Spec.Containers[0]
+	for _, name := range names {
+		ev := corev1.EnvVar{Name: name, Value: run.EnvironmentVariables[name]}
+
+		replaced := false
+		for i := range container.Env {
+			if container.Env[i].Name == name {
+				container.Env[i] = ev
+				replaced = true
+			}
+		}
+		if !replaced {
+			container.Env = append(container.Env, ev)
+		}
+	}
 }
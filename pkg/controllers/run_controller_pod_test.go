@@ -6,10 +6,15 @@ import (
 	"github.com/leg100/etok/api/etok.dev/v1alpha1"
 	"github.com/leg100/etok/pkg/testobj"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 func TestRunPod(t *testing.T) {
+	uid := int64(1000)
+	readOnly := true
+
 	tests := []struct {
 		name                string
 		run                 *v1alpha1.Run
@@ -26,6 +31,28 @@ func TestRunPod(t *testing.T) {
 				assert.Equal(t, "/workspace/subdir", pod.Spec.Containers[0].WorkingDir)
 			},
 		},
+		{
+			name:      "Security context",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithPodSecurityContext(&corev1.PodSecurityContext{RunAsUser: &uid}), testobj.WithSecurityContext(&corev1.SecurityContext{Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}})),
+			assertions: func(pod *corev1.Pod) {
+				assert.Equal(t, int64(1000), *pod.Spec.SecurityContext.RunAsUser)
+				assert.Equal(t, []corev1.Capability{"ALL"}, pod.Spec.Containers[0].SecurityContext.Capabilities.Drop)
+				// Root filesystem is writable so no volumes are added
+				assert.NotContains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"})
+			},
+		},
+		{
+			name:      "Read-only root filesystem",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithSecurityContext(&corev1.SecurityContext{ReadOnlyRootFilesystem: &readOnly})),
+			assertions: func(pod *corev1.Pod) {
+				assert.True(t, *pod.Spec.Containers[0].SecurityContext.ReadOnlyRootFilesystem)
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"})
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: "workspace", MountPath: "/workspace"})
+				assert.Contains(t, pod.Spec.Volumes, corev1.Volume{Name: "workspace", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+			},
+		},
 		{
 			name:      "Terraform workspace",
 			run:       testobj.Run("default", "run-12345", "plan"),
@@ -49,6 +76,33 @@ func TestRunPod(t *testing.T) {
 				})
 			},
 		},
+		{
+			name:      "Select terraform workspace",
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithTFWorkspace("staging")),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "ETOK_TF_WORKSPACE",
+					Value: "staging",
+				})
+			},
+		},
+		{
+			name:      "Terraform plugin cache",
+			run:       testobj.Run("default", "run-12345", "init"),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "TF_PLUGIN_CACHE_DIR",
+					Value: "/plugin-cache",
+				})
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "cache",
+					MountPath: "/plugin-cache",
+					SubPath:   "plugin-cache/",
+				})
+			},
+		},
 		{
 			name:      "Terraform binary volume mount",
 			run:       testobj.Run("default", "run-12345", "plan"),
@@ -97,6 +151,261 @@ func TestRunPod(t *testing.T) {
 				})
 			},
 		},
+		{
+			name: "Runner resources",
+			run:  testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithResources(
+				corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+				corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			)),
+			assertions: func(pod *corev1.Pod) {
+				assert.Equal(t, "500m", pod.Spec.Containers[0].Resources.Requests.Cpu().String())
+				assert.Equal(t, "1", pod.Spec.Containers[0].Resources.Limits.Cpu().String())
+			},
+		},
+		{
+			name: "Scheduling",
+			run:  testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo",
+				testobj.WithNodeSelector("pool", "terraform"),
+				testobj.WithTolerations(corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpExists}),
+			),
+			assertions: func(pod *corev1.Pod) {
+				assert.Equal(t, map[string]string{"pool": "terraform"}, pod.Spec.NodeSelector)
+				assert.Equal(t, []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}}, pod.Spec.Tolerations)
+			},
+		},
+		{
+			name:      "Image pull secrets",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithImagePullSecrets("registry-creds")),
+			assertions: func(pod *corev1.Pod) {
+				assert.Equal(t, []corev1.LocalObjectReference{{Name: "registry-creds"}}, pod.Spec.ImagePullSecrets)
+			},
+		},
+		{
+			name:      "Var files",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithVarFiles("0-common.tfvars", "1-prod.tfvars")),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "varfiles",
+					MountPath: "/varfiles",
+				})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "TF_CLI_ARGS_plan",
+					Value: "-var-file=/varfiles/0-common.tfvars -var-file=/varfiles/1-prod.tfvars",
+				})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "TF_CLI_ARGS_apply",
+					Value: "-var-file=/varfiles/0-common.tfvars -var-file=/varfiles/1-prod.tfvars",
+				})
+			},
+		},
+		{
+			name:      "Var files with saved plan",
+			run:       testobj.Run("default", "run-12345", "apply", testobj.WithPlan("run-23456")),
+			workspace: testobj.Workspace("default", "foo", testobj.WithVarFiles("0-common.tfvars")),
+			assertions: func(pod *corev1.Pod) {
+				for _, ev := range pod.Spec.Containers[0].Env {
+					assert.NotEqual(t, "TF_CLI_ARGS_apply", ev.Name)
+				}
+			},
+		},
+		{
+			name:      "Save plan",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "cache",
+					MountPath: "/plans",
+					SubPath:   "plans/",
+				})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "ETOK_PLAN_FILE", Value: "/plans/run-12345"})
+			},
+		},
+		{
+			name:      "Print plan in JSON",
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithJSONPlan()),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "ETOK_JSON_PLAN", Value: "true"})
+			},
+		},
+		{
+			name:      "Apply saved plan",
+			run:       testobj.Run("default", "run-12345", "apply", testobj.WithPlan("run-23456")),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "cache",
+					MountPath: "/plans",
+					SubPath:   "plans/",
+				})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "ETOK_PLAN_FILE", Value: "/plans/run-23456"})
+			},
+		},
+		{
+			name:      "Apply without saved plan",
+			run:       testobj.Run("default", "run-12345", "apply"),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				for _, ev := range pod.Spec.Containers[0].Env {
+					assert.NotEqual(t, "ETOK_PLAN_FILE", ev.Name)
+				}
+			},
+		},
+		{
+			name:      "Without var files",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				for _, ev := range pod.Spec.Containers[0].Env {
+					assert.NotEqual(t, "TF_CLI_ARGS_plan", ev.Name)
+				}
+			},
+		},
+		{
+			name:      "Kubernetes backend init args",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "TF_CLI_ARGS_init",
+					Value: "-backend-config=secret_suffix=foo",
+				})
+			},
+		},
+		{
+			name:      "User init args precede backend init args",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithInitArgs("-upgrade", "-reconfigure")),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "TF_CLI_ARGS_init",
+					Value: "-upgrade -reconfigure -backend-config=secret_suffix=foo",
+				})
+			},
+		},
+		{
+			name:      "Workspace terraform args",
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithArgs("-refresh=false")),
+			workspace: testobj.Workspace("default", "foo", testobj.WithTerraformArgs("-lock-timeout=5m")),
+			assertions: func(pod *corev1.Pod) {
+				assert.Equal(t, []string{"--", "-lock-timeout=5m", "-refresh=false"}, pod.Spec.Containers[0].Args)
+			},
+		},
+		{
+			name:      "S3 backend config",
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithConfigMapPath("subdir")),
+			workspace: testobj.Workspace("default", "foo", testobj.WithBackend("s3", "bucket", "my-bucket")),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "TF_CLI_ARGS_init",
+					Value: "-backend-config=_etok_backend.ini",
+				})
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "builtins",
+					MountPath: "/workspace/subdir/_etok_backend.ini",
+					SubPath:   "_etok_backend.ini",
+				})
+			},
+		},
+		{
+			name:      "AzureRM backend credentials",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithBackend("azurerm", "key", "terraform.tfstate")),
+			assertions: func(pod *corev1.Pod) {
+				optional := true
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name: "ARM_ACCESS_KEY",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{
+								Name: "etok",
+							},
+							Key:      "ARM_ACCESS_KEY",
+							Optional: &optional,
+						},
+					},
+				})
+			},
+		},
+		{
+			name:      "HTTP backend credentials",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithBackend("http", "address", "https://example.com/state")),
+			assertions: func(pod *corev1.Pod) {
+				optional := true
+				for _, name := range []string{"TF_HTTP_USERNAME", "TF_HTTP_PASSWORD"} {
+					assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+						Name: name,
+						ValueFrom: &corev1.EnvVarSource{
+							SecretKeyRef: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{
+									Name: "etok",
+								},
+								Key:      name,
+								Optional: &optional,
+							},
+						},
+					})
+				}
+			},
+		},
+		{
+			name:      "Consul backend credentials",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithBackend("consul", "path", "etok/foo")),
+			assertions: func(pod *corev1.Pod) {
+				optional := true
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name: "CONSUL_HTTP_TOKEN",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{
+								Name: "etok",
+							},
+							Key:      "CONSUL_HTTP_TOKEN",
+							Optional: &optional,
+						},
+					},
+				})
+			},
+		},
+		{
+			name:      "Remote backend credentials",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithBackend("remote", "organization", "acme", "name", "foo")),
+			assertions: func(pod *corev1.Pod) {
+				optional := true
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name: "TF_TOKEN_app_terraform_io",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{
+								Name: "etok",
+							},
+							Key:      "TF_TOKEN",
+							Optional: &optional,
+						},
+					},
+				})
+			},
+		},
+		{
+			name:      "Remote backend credentials with custom hostname",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithBackend("remote", "hostname", "tfe.my-company.com", "organization", "acme", "name", "foo")),
+			assertions: func(pod *corev1.Pod) {
+				var names []string
+				for _, ev := range pod.Spec.Containers[0].Env {
+					names = append(names, ev.Name)
+				}
+				assert.Contains(t, names, "TF_TOKEN_tfe_my__company_com")
+			},
+		},
 		{
 			name:        "Set environment variables for secrets",
 			run:         testobj.Run("default", "run-12345", "plan"),
@@ -112,6 +421,309 @@ func TestRunPod(t *testing.T) {
 				})
 			},
 		},
+		{
+			name:        "Set environment variables for additional secrets",
+			run:         testobj.Run("default", "run-12345", "plan"),
+			workspace:   testobj.Workspace("default", "foo", testobj.WithSecretNames("gcp-creds", "registry-token")),
+			secretFound: true,
+			assertions: func(pod *corev1.Pod) {
+				var names []string
+				for _, src := range pod.Spec.Containers[0].EnvFrom {
+					names = append(names, src.SecretRef.Name)
+				}
+				// Additional secrets follow etok secret
+				assert.Equal(t, []string{"etok", "gcp-creds", "registry-token"}, names)
+			},
+		},
+		{
+			name:      "Mount netrc file",
+			run:       testobj.Run("default", "run-12345", "init"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithNetrcSecret("netrc")),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Volumes, corev1.Volume{
+					Name: "netrc",
+					VolumeSource: corev1.VolumeSource{
+						Secret: &corev1.SecretVolumeSource{
+							SecretName: "netrc",
+						},
+					},
+				})
+				// Mounted in a home directory accessible to non-root users
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "home",
+					MountPath: "/home/etok",
+				})
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "netrc",
+					MountPath: "/home/etok/.netrc",
+					SubPath:   ".netrc",
+					ReadOnly:  true,
+				})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "HOME", Value: "/home/etok"})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "NETRC", Value: "/home/etok/.netrc"})
+			},
+		},
+		{
+			name:      "Mount CA bundle",
+			run:       testobj.Run("default", "run-12345", "init"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithCABundleSecret("ca-bundle"), testobj.WithPreRunScript("curl https://vault.internal")),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Volumes, corev1.Volume{
+					Name: "ca-bundle",
+					VolumeSource: corev1.VolumeSource{
+						Secret: &corev1.SecretVolumeSource{
+							SecretName: "ca-bundle",
+						},
+					},
+				})
+				// The bundle is combined with the system's CA certificates first
+				combiner := pod.Spec.InitContainers[0]
+				assert.Equal(t, CABundleContainerName, combiner.Name)
+				assert.Contains(t, combiner.Command[2], "/etc/ssl/certs/ca-certificates.crt")
+				assert.Contains(t, combiner.Command[2], "> /etc/ssl/etok/ca-certificates.crt")
+				// Both the runner and the pre-run script trust the combined
+				// certificates
+				for _, c := range []corev1.Container{pod.Spec.Containers[0], pod.Spec.InitContainers[1]} {
+					assert.Contains(t, c.VolumeMounts, corev1.VolumeMount{
+						Name:      "ca-certs",
+						MountPath: "/etc/ssl/etok",
+						ReadOnly:  true,
+					})
+					assert.Contains(t, c.Env, corev1.EnvVar{Name: "SSL_CERT_FILE", Value: "/etc/ssl/etok/ca-certificates.crt"})
+					assert.Contains(t, c.Env, corev1.EnvVar{Name: "NODE_EXTRA_CA_CERTS", Value: "/etc/ssl/etok/ca-certificates.crt"})
+				}
+			},
+		},
+		{
+			name:      "Mount terraform CLI config file",
+			run:       testobj.Run("default", "run-12345", "init"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithTerraformRCConfigMap("terraformrc")),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Volumes, corev1.Volume{
+					Name: "terraformrc",
+					VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{
+								Name: "terraformrc",
+							},
+						},
+					},
+				})
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "terraformrc",
+					MountPath: "/home/etok/.terraformrc",
+					SubPath:   ".terraformrc",
+					ReadOnly:  true,
+				})
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "home",
+					MountPath: "/home/etok",
+				})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "HOME", Value: "/home/etok"})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "TF_CLI_CONFIG_FILE", Value: "/home/etok/.terraformrc"})
+			},
+		},
+		{
+			name:      "Mount netrc and terraform CLI config files",
+			run:       testobj.Run("default", "run-12345", "init"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithNetrcSecret("netrc"), testobj.WithTerraformRCConfigMap("terraformrc")),
+			assertions: func(pod *corev1.Pod) {
+				// Home directory is shared
+				var homes int
+				for _, vol := range pod.Spec.Volumes {
+					if vol.Name == "home" {
+						homes++
+					}
+				}
+				assert.Equal(t, 1, homes)
+
+				var names []string
+				for _, mnt := range pod.Spec.Containers[0].VolumeMounts {
+					names = append(names, mnt.Name)
+				}
+				assert.Subset(t, names, []string{"home", "netrc", "terraformrc"})
+			},
+		},
+		{
+			name:        "Pre-run script",
+			run:         testobj.Run("default", "run-12345", "plan", testobj.WithRunEnvironmentVariables("FOO", "bar")),
+			workspace:   testobj.Workspace("default", "foo", testobj.WithSecretNames("vault-token"), testobj.WithPreRunScript("vault read -field=token secret/ci > /pre-run/token")),
+			secretFound: true,
+			assertions: func(pod *corev1.Pod) {
+				if assert.Equal(t, 1, len(pod.Spec.InitContainers)) {
+					preRun := pod.Spec.InitContainers[0]
+					assert.Equal(t, "pre-run", preRun.Name)
+					// The configuration is extracted before the script runs
+					assert.Equal(t, []string{"sh", "-c", preRunCommand, "pre-run", "vault read -field=token secret/ci > /pre-run/token"}, preRun.Command)
+					assert.Contains(t, preRun.Command[2], `tar -xzf "$ETOK_TARBALL" -C "$ETOK_DEST"`)
+					assert.Nil(t, preRun.Args)
+					assert.False(t, preRun.TTY)
+					// Same environment, secrets and mounts as the runner
+					assert.Equal(t, pod.Spec.Containers[0].Env, preRun.Env)
+					assert.Equal(t, pod.Spec.Containers[0].EnvFrom, preRun.EnvFrom)
+					assert.Equal(t, pod.Spec.Containers[0].VolumeMounts, preRun.VolumeMounts)
+					assert.Contains(t, preRun.Env, corev1.EnvVar{Name: "FOO", Value: "bar"})
+				}
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: "pre-run", MountPath: "/pre-run"})
+			},
+		},
+		{
+			name:      "Default termination grace period for mutating command",
+			run:       testobj.Run("default", "run-12345", "apply"),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				if assert.NotNil(t, pod.Spec.TerminationGracePeriodSeconds) {
+					assert.Equal(t, int64(600), *pod.Spec.TerminationGracePeriodSeconds)
+				}
+			},
+		},
+		{
+			name:      "Default termination grace period for non-mutating command",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				assert.Nil(t, pod.Spec.TerminationGracePeriodSeconds)
+			},
+		},
+		{
+			name:      "Workspace termination grace period",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithTerminationGracePeriod(900)),
+			assertions: func(pod *corev1.Pod) {
+				if assert.NotNil(t, pod.Spec.TerminationGracePeriodSeconds) {
+					assert.Equal(t, int64(900), *pod.Spec.TerminationGracePeriodSeconds)
+				}
+			},
+		},
+		{
+			name:      "Without pre-run script",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				assert.Empty(t, pod.Spec.InitContainers)
+			},
+		},
+		{
+			name:      "Without netrc file",
+			run:       testobj.Run("default", "run-12345", "init"),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				for _, vol := range pod.Spec.Volumes {
+					assert.NotEqual(t, "netrc", vol.Name)
+				}
+			},
+		},
+		{
+			name:        "Set registry tokens",
+			run:         testobj.Run("default", "run-12345", "init"),
+			workspace:   testobj.Workspace("default", "foo", testobj.WithSecretNames("gcp-creds"), testobj.WithRegistryTokens("registry.my-company.com", "registry-tokens", "my-company", "app.terraform.io", "registry-tokens", "tfc")),
+			secretFound: true,
+			assertions: func(pod *corev1.Pod) {
+				// Tokens are referenced rather than copied into the pod spec
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name: "TF_TOKEN_registry_my__company_com",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "registry-tokens"},
+							Key:                  "my-company",
+						},
+					},
+				})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name: "TF_TOKEN_app_terraform_io",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "registry-tokens"},
+							Key:                  "tfc",
+						},
+					},
+				})
+				// Secrets are still made available
+				assert.Equal(t, 2, len(pod.Spec.Containers[0].EnvFrom))
+			},
+		},
+		{
+			name:      "Pod annotations and labels",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithPodAnnotations("prometheus.io/scrape", "true"), testobj.WithPodLabels("team", "infra", "component", "terraform")),
+			assertions: func(pod *corev1.Pod) {
+				assert.Equal(t, "true", pod.Annotations["prometheus.io/scrape"])
+				assert.Equal(t, "infra", pod.Labels["team"])
+				assert.Equal(t, "plan", pod.Labels["command"])
+				// Etok label takes precedence over user label
+				assert.Equal(t, "run", pod.Labels["component"])
+			},
+		},
+		{
+			name:      "Set terraform log level",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithTFLog("DEBUG")),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "TF_LOG",
+					Value: "DEBUG",
+				})
+			},
+		},
+		{
+			name:      "Cache backup",
+			run:       testobj.Run("default", "run-12345", "init"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithBackupBucket("backups"), testobj.WithCacheBackup()),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "ETOK_CACHE_BACKUP_BUCKET", Value: "backups"})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "ETOK_CACHE_BACKUP_PROVIDER", Value: "gcs"})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "ETOK_CACHE_BACKUP_KEY", Value: "default/foo.terraform.tar.gz"})
+			},
+		},
+		{
+			name:      "Encrypted cache backup",
+			run:       testobj.Run("default", "run-12345", "init"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithBackupBucket("backups"), testobj.WithBackupKMSKey("alias/etok"), testobj.WithCacheBackup()),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "ETOK_CACHE_BACKUP_KMS_KEY", Value: "alias/etok"})
+			},
+		},
+		{
+			name:      "No cache backup for command not installing providers",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithBackupBucket("backups"), testobj.WithCacheBackup()),
+			assertions: func(pod *corev1.Pod) {
+				for _, ev := range pod.Spec.Containers[0].Env {
+					assert.NotEqual(t, "ETOK_CACHE_BACKUP_BUCKET", ev.Name)
+				}
+			},
+		},
+		{
+			name:      "Ephemeral cache",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithEphemeralCache()),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Volumes, corev1.Volume{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+				if assert.Equal(t, 1, len(pod.Spec.InitContainers)) {
+					assert.Equal(t, InstallerContainerName, pod.Spec.InitContainers[0].Name)
+				}
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "ETOK_AUTO_INIT", Value: "true"})
+			},
+		},
+		{
+			name:      "Ephemeral cache with init",
+			run:       testobj.Run("default", "run-12345", "init"),
+			workspace: testobj.Workspace("default", "foo", testobj.WithEphemeralCache()),
+			assertions: func(pod *corev1.Pod) {
+				for _, ev := range pod.Spec.Containers[0].Env {
+					assert.NotEqual(t, "ETOK_AUTO_INIT", ev.Name)
+				}
+			},
+		},
+		{
+			name:      "Persistent cache",
+			run:       testobj.Run("default", "run-12345", "plan"),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Volumes, corev1.Volume{Name: "cache", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "foo"}}})
+				assert.Equal(t, 0, len(pod.Spec.InitContainers))
+			},
+		},
 		{
 			name:      "Set workspace terraform variables",
 			run:       testobj.Run("default", "run-12345", "plan"),
@@ -134,10 +746,147 @@ func TestRunPod(t *testing.T) {
 				})
 			},
 		},
+		{
+			name:      "Set run environment variables",
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithRunEnvironmentVariables("TF_VAR_region", "eu-west-2")),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "TF_VAR_region",
+					Value: "eu-west-2",
+				})
+			},
+		},
+		{
+			name:      "Run environment variables override workspace environment variables",
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithRunEnvironmentVariables("foo", "baz")),
+			workspace: testobj.Workspace("default", "foo", testobj.WithEnvironmentVariables("foo", "bar")),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "foo",
+					Value: "baz",
+				})
+				assert.NotContains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "foo",
+					Value: "bar",
+				})
+			},
+		},
+		{
+			name:      "Run environment variables cannot override reserved environment variables",
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithRunEnvironmentVariables("ETOK_COMMAND", "rm -rf /")),
+			workspace: testobj.Workspace("default", "foo"),
+			assertions: func(pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "ETOK_COMMAND",
+					Value: "plan",
+				})
+				assert.NotContains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{
+					Name:  "ETOK_COMMAND",
+					Value: "rm -rf /",
+				})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod, err := runPod(tt.run, tt.workspace, tt.secretFound, tt.serviceAccountFound, "etok:latest")
+			require.NoError(t, err)
+			tt.assertions(pod)
+		})
+	}
+}
+
+func TestTerraformArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  string
+		plan     string
+		defaults []string
+		args     []string
+		want     []string
+	}{
+		{
+			name:     "defaults precede run args",
+			command:  "import",
+			defaults: []string{"-lock-timeout=5m"},
+			args:     []string{"random_id.foo", "abc"},
+			want:     []string{"-lock-timeout=5m", "random_id.foo", "abc"},
+		},
+		{
+			name:     "run args override defaults",
+			command:  "plan",
+			defaults: []string{"-lock-timeout=5m", "-parallelism=20"},
+			args:     []string{"-lock-timeout=10m"},
+			want:     []string{"-parallelism=20", "-lock-timeout=10m"},
+		},
+		{
+			name:     "run args override defaults with separate values",
+			command:  "plan",
+			defaults: []string{"-lock-timeout", "5m", "-parallelism=20"},
+			args:     []string{"--lock-timeout", "10m"},
+			want:     []string{"-parallelism=20", "--lock-timeout", "10m"},
+		},
+		{
+			name:     "run args merged with repeatable defaults",
+			command:  "plan",
+			defaults: []string{"-target=random_id.foo", "-target=random_id.bar"},
+			args:     []string{"-target=random_id.baz"},
+			want:     []string{"-target=random_id.foo", "-target=random_id.bar", "-target=random_id.baz"},
+		},
+		{
+			name:     "run vars override default vars of the same name",
+			command:  "plan",
+			defaults: []string{"-var=region=eu-west-2", "-var", "zone=eu-west-2a", "-var=env=dev"},
+			args:     []string{"-var", "region=us-east-1", "-var=zone=us-east-1a"},
+			want:     []string{"-var=env=dev", "-var", "region=us-east-1", "-var=zone=us-east-1a"},
+		},
+		{
+			name:     "apply saved plan",
+			command:  "apply",
+			plan:     "plan-12345",
+			defaults: []string{"-lock-timeout=5m", "-var=region=eu-west-2"},
+			want:     nil,
+		},
+		{
+			name:     "no run args",
+			command:  "apply",
+			defaults: []string{"-lock-timeout=5m"},
+			want:     []string{"-lock-timeout=5m"},
+		},
+		{
+			name:    "no defaults",
+			command: "plan",
+			args:    []string{"-refresh=false"},
+			want:    []string{"-refresh=false"},
+		},
+		{
+			name:     "shell",
+			command:  "sh",
+			defaults: []string{"-lock-timeout=5m"},
+			args:     []string{"ls"},
+			want:     []string{"ls"},
+		},
+		{
+			name:     "output",
+			command:  "output",
+			defaults: []string{"-lock-timeout=5m"},
+			args:     []string{"-json"},
+			want:     []string{"-json"},
+		},
+		{
+			name:     "state list",
+			command:  "state list",
+			defaults: []string{"-lock-timeout=5m"},
+			want:     nil,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.assertions(runPod(tt.run, tt.workspace, tt.secretFound, tt.serviceAccountFound, "etok:latest"))
+			run := testobj.Run("default", "run-12345", tt.command, testobj.WithArgs(tt.args...), testobj.WithPlan(tt.plan))
+			ws := testobj.Workspace("default", "foo", testobj.WithTerraformArgs(tt.defaults...))
+
+			assert.Equal(t, tt.want, terraformArgs(run, ws))
 		})
 	}
 }
@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"testing"
+	"time"
 
 	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
 	"github.com/leg100/etok/pkg/scheme"
@@ -11,10 +12,12 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
@@ -27,6 +30,7 @@ func TestRunReconciler(t *testing.T) {
 		runAssertions       func(*testutil.T, *v1alpha1.Run)
 		podAssertions       func(*testutil.T, *corev1.Pod)
 		configMapAssertions func(*testutil.T, *corev1.ConfigMap)
+		podDeleted          bool
 		reconcileError      bool
 	}{
 		{
@@ -64,6 +68,85 @@ func TestRunReconciler(t *testing.T) {
 				assert.Equal(t, v1alpha1.RunPhaseProvisioning, run.Phase)
 			},
 		},
+		{
+			name: "Additional secrets found",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithSecretNames("gcp-creds")),
+				testobj.Secret("operator-test", "gcp-creds"),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseProvisioning, run.Phase)
+			},
+		},
+		{
+			name: "Additional secret not found",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithSecretNames("gcp-creds")),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				failed := meta.FindStatusCondition(run.Conditions, v1alpha1.RunFailedCondition)
+				if assert.NotNil(t, failed) {
+					assert.Equal(t, metav1.ConditionTrue, failed.Status)
+					assert.Equal(t, v1alpha1.SecretNotFoundReason, failed.Reason)
+				}
+			},
+		},
+		{
+			name: "Variables sources found",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithVariablesFrom("ConfigMap", "tfvars"), testobj.WithVariablesFrom("Secret", "tfvars-sensitive")),
+				testobj.ConfigMap("operator-test", "tfvars"),
+				testobj.Secret("operator-test", "tfvars-sensitive"),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseProvisioning, run.Phase)
+			},
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].EnvFrom, corev1.EnvFromSource{
+					Prefix: "TF_VAR_",
+					ConfigMapRef: &corev1.ConfigMapEnvSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "tfvars"},
+					},
+				})
+				assert.Contains(t, pod.Spec.Containers[0].EnvFrom, corev1.EnvFromSource{
+					Prefix: "TF_VAR_",
+					SecretRef: &corev1.SecretEnvSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "tfvars-sensitive"},
+					},
+				})
+			},
+		},
+		{
+			name: "Variables config map not found",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithVariablesFrom("ConfigMap", "tfvars")),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				failed := meta.FindStatusCondition(run.Conditions, v1alpha1.RunFailedCondition)
+				if assert.NotNil(t, failed) {
+					assert.Equal(t, metav1.ConditionTrue, failed.Status)
+					assert.Equal(t, v1alpha1.ConfigMapNotFoundReason, failed.Reason)
+				}
+			},
+		},
+		{
+			name: "Variables secret not found",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithVariablesFrom("Secret", "tfvars-sensitive")),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				failed := meta.FindStatusCondition(run.Conditions, v1alpha1.RunFailedCondition)
+				if assert.NotNil(t, failed) {
+					assert.Equal(t, metav1.ConditionTrue, failed.Status)
+					assert.Equal(t, v1alpha1.SecretNotFoundReason, failed.Reason)
+				}
+			},
+		},
 		{
 			name: "Queued",
 			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
@@ -97,6 +180,74 @@ func TestRunReconciler(t *testing.T) {
 				assert.Equal(t, v1alpha1.RunPhaseRunning, run.Phase)
 			},
 		},
+		{
+			name: "Plan throttled by concurrency limit",
+			run:  testobj.Run("operator-test", "plan-2", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithMaxConcurrentRuns(1)),
+				testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseWaiting, run.Phase)
+				assert.Equal(t, v1alpha1.RunThrottledReason, meta.FindStatusCondition(run.Conditions, v1alpha1.RunCompleteCondition).Reason)
+			},
+		},
+		{
+			name: "Plan held by paused workspace",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithPaused()),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseWaiting, run.Phase)
+				assert.Equal(t, v1alpha1.RunPausedReason, meta.FindStatusCondition(run.Conditions, v1alpha1.RunCompleteCondition).Reason)
+			},
+			podDeleted: true,
+		},
+		{
+			name: "Active apply held by paused workspace",
+			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithPaused(), testobj.WithCombinedQueue("apply-1")),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseWaiting, run.Phase)
+			},
+			podDeleted: true,
+		},
+		{
+			name: "Running plan unaffected by paused workspace",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithPaused()),
+				testobj.RunPod("operator-test", "plan-1"),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseRunning, run.Phase)
+			},
+		},
+		{
+			name: "Plan admitted within concurrency limit",
+			run:  testobj.Run("operator-test", "plan-2", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithMaxConcurrentRuns(2)),
+				testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseProvisioning, run.Phase)
+			},
+		},
+		{
+			name: "Apply not subject to concurrency limit",
+			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithMaxConcurrentRuns(1), testobj.WithCombinedQueue("apply-1")),
+				testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseRunning)),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseProvisioning, run.Phase)
+			},
+		},
 		{
 			name: "Running apply at front of queue",
 			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
@@ -119,6 +270,82 @@ func TestRunReconciler(t *testing.T) {
 				assert.True(t, meta.IsStatusConditionTrue(run.Conditions, v1alpha1.RunCompleteCondition))
 			},
 		},
+		{
+			name: "Pending approval",
+			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(time.Now())),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithRequireApproval()),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhasePendingApproval, run.Phase)
+				complete := meta.FindStatusCondition(run.Conditions, v1alpha1.RunCompleteCondition)
+				if assert.NotNil(t, complete) {
+					assert.Equal(t, v1alpha1.PendingApprovalReason, complete.Reason)
+				}
+			},
+		},
+		{
+			name: "Approval timed out",
+			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(time.Now().Add(-2*time.Hour))),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithRequireApproval()),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseFailed, run.Phase)
+				failed := meta.FindStatusCondition(run.Conditions, v1alpha1.RunFailedCondition)
+				if assert.NotNil(t, failed) {
+					assert.Equal(t, v1alpha1.ApprovalTimeoutReason, failed.Reason)
+				}
+			},
+		},
+		{
+			name: "Approved",
+			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithRequireApproval(), testobj.WithReviews("apply-1"), testobj.WithCombinedQueue("apply-1")),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseProvisioning, run.Phase)
+			},
+		},
+		{
+			name: "Plan does not require approval",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithRequireApproval()),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseProvisioning, run.Phase)
+			},
+		},
+		{
+			name: "Pre-run script failed",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithCombinedQueue("plan-1"), testobj.WithPreRunScript("exit 3")),
+				testobj.RunPod("operator-test", "plan-1", testobj.WithPhase(corev1.PodFailed), testobj.WithPreRunExitCode(3, "vault: permission denied\n")),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				failed := meta.FindStatusCondition(run.Conditions, v1alpha1.RunFailedCondition)
+				if assert.NotNil(t, failed) {
+					assert.Equal(t, metav1.ConditionTrue, failed.Status)
+					assert.Equal(t, v1alpha1.PreRunFailedReason, failed.Reason)
+					assert.Equal(t, "Pre-run script failed with exit code 3: vault: permission denied", failed.Message)
+				}
+			},
+		},
+		{
+			name: "Pre-run script succeeded",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithCombinedQueue("plan-1"), testobj.WithPreRunScript("true")),
+				testobj.RunPod("operator-test", "plan-1", testobj.WithPhase(corev1.PodFailed), testobj.WithPreRunExitCode(0, ""), testobj.WithRunnerExitCode(1)),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.False(t, meta.IsStatusConditionTrue(run.Conditions, v1alpha1.RunFailedCondition))
+				assert.Equal(t, 1, *run.ExitCode)
+			},
+		},
 		{
 			name: "Creates pod",
 			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
@@ -127,6 +354,55 @@ func TestRunReconciler(t *testing.T) {
 			},
 			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
 				assert.NotEqual(t, &corev1.Pod{}, pod)
+				assert.Equal(t, "a.b.c/d:v1", pod.Spec.Containers[0].Image)
+			},
+		},
+		{
+			name: "Mounts terraform CLI config file",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithCombinedQueue("plan-1"), testobj.WithTerraformRCConfigMap("terraformrc")),
+			},
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "terraformrc",
+					MountPath: "/home/etok/.terraformrc",
+					SubPath:   ".terraformrc",
+					ReadOnly:  true,
+				})
+				assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "TF_CLI_CONFIG_FILE", Value: "/home/etok/.terraformrc"})
+			},
+		},
+		{
+			name: "Workspace image overrides operator image",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithCombinedQueue("plan-1"), testobj.WithImage("acme/etok-terraform:1.4.6")),
+			},
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, "acme/etok-terraform:1.4.6", pod.Spec.Containers[0].Image)
+			},
+		},
+		{
+			name: "Default image pull policy",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithCombinedQueue("plan-1")),
+			},
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, corev1.PullIfNotPresent, pod.Spec.Containers[0].ImagePullPolicy)
+			},
+		},
+		{
+			name: "Workspace image pull policy",
+			run:  testobj.Run("operator-test", "plan-1", "plan", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithCombinedQueue("plan-1"), testobj.WithImagePullPolicy(corev1.PullAlways), testobj.WithPreRunScript("true")),
+			},
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, corev1.PullAlways, pod.Spec.Containers[0].ImagePullPolicy)
+				// Pre-run container
+				assert.Equal(t, corev1.PullAlways, pod.Spec.InitContainers[0].ImagePullPolicy)
 			},
 		},
 		{
@@ -227,6 +503,86 @@ func TestRunReconciler(t *testing.T) {
 				assert.Equal(t, 5, *run.RunStatus.ExitCode)
 			},
 		},
+		{
+			name: "State lock info recorded in status",
+			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithCombinedQueue("apply-1")),
+				testobj.RunPod("operator-test", "apply-1", testobj.WithPhase(corev1.PodFailed), testobj.WithRunnerExitCode(1), testobj.WithRunnerTerminationMessage(lockErrorOutput)),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				if assert.NotNil(t, run.LockInfo) {
+					assert.Equal(t, "1617191435237187", run.LockInfo.ID)
+					assert.Equal(t, "alice@laptop", run.LockInfo.Who)
+				}
+				completed := meta.FindStatusCondition(run.Conditions, v1alpha1.RunCompleteCondition)
+				if assert.NotNil(t, completed) {
+					assert.Equal(t, v1alpha1.PodFailedReason, completed.Reason)
+					assert.Equal(t, "State locked by alice@laptop since 2021-03-31 11:50:35.097548 +0000 UTC (lock ID: 1617191435237187); if the lock is stale, release it with: etok force-unlock apply-1", completed.Message)
+				}
+			},
+		},
+		{
+			name: "No state lock info for other failures",
+			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithCombinedQueue("apply-1")),
+				testobj.RunPod("operator-test", "apply-1", testobj.WithPhase(corev1.PodFailed), testobj.WithRunnerExitCode(1), testobj.WithRunnerTerminationMessage("Error: Invalid reference\n")),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Nil(t, run.LockInfo)
+			},
+		},
+		{
+			name: "Run within its timeout",
+			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithTimeout("30m")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithCombinedQueue("apply-1")),
+				testobj.RunPod("operator-test", "apply-1", testobj.WithPhase(corev1.PodRunning), testobj.WithStartTime(time.Now().Add(-10*time.Minute))),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseRunning, run.Phase)
+			},
+		},
+		{
+			name: "Run exceeding its timeout",
+			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithTimeout("30m")),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1", testobj.WithCombinedQueue("apply-1")),
+				testobj.RunPod("operator-test", "apply-1", testobj.WithPhase(corev1.PodRunning), testobj.WithStartTime(time.Now().Add(-time.Hour))),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseFailed, run.Phase)
+				failed := meta.FindStatusCondition(run.Conditions, v1alpha1.RunFailedCondition)
+				if assert.NotNil(t, failed) {
+					assert.Equal(t, v1alpha1.RunTimeoutReason, failed.Reason)
+				}
+			},
+			podDeleted: true,
+		},
+		{
+			name: "Timed out run's pod not yet deleted",
+			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithTimeout("30m"), testobj.WithFailedReason(v1alpha1.RunTimeoutReason)),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1"),
+				testobj.RunPod("operator-test", "apply-1", testobj.WithPhase(corev1.PodRunning), testobj.WithStartTime(time.Now().Add(-time.Hour))),
+			},
+			runAssertions: func(t *testutil.T, run *v1alpha1.Run) {
+				assert.Equal(t, v1alpha1.RunPhaseFailed, run.Phase)
+			},
+			podDeleted: true,
+		},
+		{
+			name: "Failed run's pod retained",
+			run:  testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithFailedReason(v1alpha1.PreRunFailedReason)),
+			objs: []runtime.Object{
+				testobj.Workspace("operator-test", "workspace-1"),
+				testobj.RunPod("operator-test", "apply-1", testobj.WithPhase(corev1.PodFailed)),
+			},
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, corev1.PodFailed, pod.Status.Phase)
+			},
+		},
 	}
 	for _, tt := range tests {
 		testutil.Run(t, tt.name, func(t *testutil.T) {
@@ -240,7 +596,7 @@ func TestRunReconciler(t *testing.T) {
 				},
 			}
 
-			_, err := NewRunReconciler(cl, "a.b.c/d:v1").Reconcile(context.Background(), req)
+			_, err := NewRunReconciler(cl, "a.b.c/d:v1", WithRunEventRecorder(record.NewFakeRecorder(100))).Reconcile(context.Background(), req)
 			t.CheckError(tt.reconcileError, err)
 
 			if tt.runAssertions != nil {
@@ -257,6 +613,11 @@ func TestRunReconciler(t *testing.T) {
 				tt.podAssertions(t, &pod)
 			}
 
+			if tt.podDeleted {
+				var pod corev1.Pod
+				assert.True(t, kerrors.IsNotFound(cl.Get(context.TODO(), req.NamespacedName, &pod)))
+			}
+
 			if tt.configMapAssertions != nil {
 				var archive corev1.ConfigMap
 				require.NoError(t, cl.Get(context.TODO(), req.NamespacedName, &archive))
@@ -266,3 +627,17 @@ func TestRunReconciler(t *testing.T) {
 		})
 	}
 }
+
+func TestRunReconcilerWithoutEventRecorder(t *testing.T) {
+	run := testobj.Run("operator-test", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithTimeout("30m"))
+	cl := fake.NewFakeClientWithScheme(scheme.Scheme,
+		run,
+		testobj.Workspace("operator-test", "workspace-1", testobj.WithCombinedQueue("apply-1")),
+		testobj.RunPod("operator-test", "apply-1", testobj.WithPhase(corev1.PodRunning), testobj.WithStartTime(time.Now().Add(-time.Hour))),
+	)
+
+	// Timing out emits an event, which is discarded
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: run.Namespace, Name: run.Name}}
+	_, err := NewRunReconciler(cl, "a.b.c/d:v1").Reconcile(context.Background(), req)
+	require.NoError(t, err)
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// RunUserPath is the path on which the operator serves the webhook recording
+// the user that created a run
+const RunUserPath = "/mutate-run-user"
+
+// RunUserRecorder is a mutating admission webhook that records the user that
+// created a run in the run's user annotation. The user is the one the API
+// server authenticated, so unlike a value set by the client it cannot be
+// forged. It also rejects runs setting environment variables reserved for
+// etok's own use.
+type RunUserRecorder struct {
+	decoder *admission.Decoder
+}
+
+// Handle sets the user annotation of a run being created to the authenticated
+// user, and restores the annotation of a run being updated to its original
+// value, overriding any value set by the client. A run setting reserved
+// environment variables is denied, unless they are unchanged by an update, so
+// that the operator can still update a run created before the webhook was
+// installed.
+func (r *RunUserRecorder) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var run v1alpha1.Run
+	if err := r.decoder.Decode(req, &run); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	user := req.UserInfo.Username
+	var old v1alpha1.Run
+	if req.Operation == admissionv1.Update {
+		if err := r.decoder.DecodeRaw(req.OldObject, &old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		user = old.User()
+	}
+
+	for name, value := range run.EnvironmentVariables {
+		if !v1alpha1.IsReservedEnvironmentVariable(name) {
+			continue
+		}
+		if prev, ok := old.EnvironmentVariables[name]; ok && prev == value {
+			continue
+		}
+		return admission.Denied(fmt.Sprintf("environment variable %s is reserved: names prefixed with %s are not permitted", name, v1alpha1.ReservedEnvironmentVariablePrefix))
+	}
+
+	if run.User() == user {
+		return admission.Allowed("")
+	}
+	if user == "" {
+		delete(run.Annotations, v1alpha1.UserAnnotationKey)
+	} else {
+		metav1.SetMetaDataAnnotation(&run.ObjectMeta, v1alpha1.UserAnnotationKey, user)
+	}
+
+	marshaled, err := json.Marshal(&run)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// InjectDecoder injects the decoder into the recorder
+func (r *RunUserRecorder) InjectDecoder(d *admission.Decoder) error {
+	r.decoder = d
+	return nil
+}
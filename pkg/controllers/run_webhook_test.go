@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/scheme"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestRunUserRecorder(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		run       *v1alpha1.Run
+		old       *v1alpha1.Run
+		// User the API server authenticated
+		user string
+		// Wanted value of the user annotation; nil if no patch is wanted
+		want interface{}
+	}{
+		{
+			name:      "create",
+			operation: admissionv1.Create,
+			run:       testobj.Run("default", "run-12345", "plan"),
+			user:      "alice",
+			want:      map[string]interface{}{v1alpha1.UserAnnotationKey: "alice"},
+		},
+		{
+			name:      "create with forged user",
+			operation: admissionv1.Create,
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithUser("bob")),
+			user:      "alice",
+			want:      "alice",
+		},
+		{
+			name:      "update without changing user",
+			operation: admissionv1.Update,
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithUser("alice")),
+			old:       testobj.Run("default", "run-12345", "plan", testobj.WithUser("alice")),
+			user:      "system:serviceaccount:etok:etok",
+		},
+		{
+			name:      "update changing user",
+			operation: admissionv1.Update,
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithUser("bob")),
+			old:       testobj.Run("default", "run-12345", "plan", testobj.WithUser("alice")),
+			user:      "bob",
+			want:      "alice",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder, err := admission.NewDecoder(scheme.Scheme)
+			require.NoError(t, err)
+			r := &RunUserRecorder{}
+			require.NoError(t, r.InjectDecoder(decoder))
+
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: tt.operation,
+				Object:    rawRun(t, tt.run),
+				UserInfo:  authenticationv1.UserInfo{Username: tt.user},
+			}}
+			if tt.old != nil {
+				req.OldObject = rawRun(t, tt.old)
+			}
+
+			resp := r.Handle(context.Background(), req)
+			require.True(t, resp.Allowed)
+			if tt.want == nil {
+				assert.Empty(t, resp.Patches)
+			} else if assert.Equal(t, 1, len(resp.Patches)) {
+				assert.Equal(t, tt.want, resp.Patches[0].Value)
+			}
+		})
+	}
+}
+
+func TestRunUserRecorderReservedEnvironmentVariables(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		run       *v1alpha1.Run
+		old       *v1alpha1.Run
+		allowed   bool
+	}{
+		{
+			name:      "create overriding command",
+			operation: admissionv1.Create,
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithRunEnvironmentVariables("ETOK_COMMAND", "destroy")),
+		},
+		{
+			name:      "update overriding command",
+			operation: admissionv1.Update,
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithRunEnvironmentVariables("ETOK_COMMAND", "destroy")),
+			old:       testobj.Run("default", "run-12345", "plan"),
+		},
+		{
+			name:      "update leaving reserved variable unchanged",
+			operation: admissionv1.Update,
+			run:       testobj.Run("default", "run-12345", "plan", testobj.WithRunEnvironmentVariables("ETOK_COMMAND", "destroy")),
+			old:       testobj.Run("default", "run-12345", "plan", testobj.WithRunEnvironmentVariables("ETOK_COMMAND", "destroy")),
+			allowed:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder, err := admission.NewDecoder(scheme.Scheme)
+			require.NoError(t, err)
+			r := &RunUserRecorder{}
+			require.NoError(t, r.InjectDecoder(decoder))
+
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: tt.operation,
+				Object:    rawRun(t, tt.run),
+				UserInfo:  authenticationv1.UserInfo{Username: "alice"},
+			}}
+			if tt.old != nil {
+				req.OldObject = rawRun(t, tt.old)
+			}
+
+			resp := r.Handle(context.Background(), req)
+			assert.Equal(t, tt.allowed, resp.Allowed)
+		})
+	}
+}
+
+func rawRun(t *testing.T, run *v1alpha1.Run) runtime.RawExtension {
+	run = run.DeepCopy()
+	run.TypeMeta = metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "Run"}
+	data, err := json.Marshal(run)
+	require.NoError(t, err)
+	return runtime.RawExtension{Raw: data}
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"bufio"
+	"regexp"
+	"strings"
+
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
+)
+
+var (
+	// Matches ANSI escape sequences, with which terraform colorizes its
+	// output
+	ansiEscapeRegex = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+	// Matches a key-value line within terraform's lock info block, e.g.
+	// 'ID:        b4a9c6f0-...'
+	lockInfoLineRegex = regexp.MustCompile(`^(ID|Path|Operation|Who|Version|Created):\s*(.*)$`)
+)
+
+// parseLockInfo parses the output of terraform for an error acquiring the
+// state lock, returning the details of the lock reported by terraform. Nil is
+// returned if the output reports no such error.
+func parseLockInfo(output string) *v1alpha1.LockInfo {
+	output = ansiEscapeRegex.ReplaceAllString(output, "")
+	if !strings.Contains(output, "Error acquiring the state lock") {
+		return nil
+	}
+
+	var info v1alpha1.LockInfo
+	var inLockInfo bool
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		// Terraform >= 0.15 draws a box around diagnostics
+		line := strings.TrimSpace(strings.TrimLeft(scanner.Text(), "│╷╵ "))
+
+		if line == "Lock Info:" {
+			inLockInfo = true
+			continue
+		}
+		if !inLockInfo {
+			continue
+		}
+
+		matches := lockInfoLineRegex.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		switch value := strings.TrimSpace(matches[2]); matches[1] {
+		case "ID":
+			info.ID = value
+		case "Path":
+			info.Path = value
+		case "Operation":
+			info.Operation = value
+		case "Who":
+			info.Who = value
+		case "Version":
+			info.Version = value
+		case "Created":
+			info.Created = value
+		}
+	}
+
+	// Without an ID the lock cannot be released, so there is nothing useful to
+	// report
+	if info.ID == "" {
+		return nil
+	}
+	return &info
+}
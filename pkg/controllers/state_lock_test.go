@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"testing"
+
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// Lock error as reported by terraform >= 0.15
+	lockErrorOutput = `
+╷
+│ Error: Error acquiring the state lock
+│
+│ Error message: writing "gs://my-bucket/default.tflock" failed: googleapi:
+│ Error 412: Precondition Failed, conditionNotMet
+│ Lock Info:
+│   ID:        1617191435237187
+│   Path:      gs://my-bucket/default.tflock
+│   Operation: OperationTypeApply
+│   Who:       alice@laptop
+│   Version:   0.15.0
+│   Created:   2021-03-31 11:50:35.097548 +0000 UTC
+│   Info:
+│
+│
+│ Terraform acquires a state lock to protect the state from being written
+│ by multiple users at the same time. Please resolve the issue above and try
+│ again. For most commands, you can disable locking with the "-lock=false"
+│ flag, but this is not recommended.
+╵
+`
+
+	// Lock error as reported by terraform < 0.15
+	legacyLockErrorOutput = "\x1b[31m\n\x1b[1m\x1b[31mError: \x1b[0m\x1b[0m\x1b[1mError locking state: Error acquiring the state lock: writing \"gs://my-bucket/default.tflock\" failed: googleapi: Error 412: Precondition Failed, conditionNotMet\nLock Info:\n  ID:        1617191435237187\n  Path:      gs://my-bucket/default.tflock\n  Operation: OperationTypePlan\n  Who:       bob@desktop\n  Version:   0.14.9\n  Created:   2021-03-31 11:50:35.097548 +0000 UTC\n  Info:      \n\x1b[0m\n"
+)
+
+func TestParseLockInfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   *v1alpha1.LockInfo
+	}{
+		{
+			name:   "lock error",
+			output: lockErrorOutput,
+			want: &v1alpha1.LockInfo{
+				ID:        "1617191435237187",
+				Path:      "gs://my-bucket/default.tflock",
+				Operation: "OperationTypeApply",
+				Who:       "alice@laptop",
+				Version:   "0.15.0",
+				Created:   "2021-03-31 11:50:35.097548 +0000 UTC",
+			},
+		},
+		{
+			name:   "legacy colorized lock error",
+			output: legacyLockErrorOutput,
+			want: &v1alpha1.LockInfo{
+				ID:        "1617191435237187",
+				Path:      "gs://my-bucket/default.tflock",
+				Operation: "OperationTypePlan",
+				Who:       "bob@desktop",
+				Version:   "0.14.9",
+				Created:   "2021-03-31 11:50:35.097548 +0000 UTC",
+			},
+		},
+		{
+			name:   "some other error",
+			output: "Error: Invalid reference\n\nA reference to a resource type must be followed by at least one attribute\naccess, specifying the resource name.\n",
+		},
+		{
+			name:   "lock error without lock info",
+			output: "Error: Error acquiring the state lock\n\nError message: context deadline exceeded\n",
+		},
+		{
+			name: "no output",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseLockInfo(tt.output))
+		})
+	}
+}
@@ -44,7 +44,7 @@ func generateScript(out io.Writer, ws *v1alpha1.Workspace) error {
 		Version string
 		BinPath string
 	}{
-		Version: ws.Spec.TerraformVersion,
+		Version: ws.ResolvedTerraformVersion(),
 		BinPath: binMountPath,
 	})
 }
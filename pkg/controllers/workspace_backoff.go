@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// DefaultRequeueBaseDelay is the delay before a workspace is reconciled
+	// again following its first failed reconcile
+	DefaultRequeueBaseDelay = time.Second
+
+	// DefaultRequeueMaxDelay is the maximum delay before a workspace is
+	// reconciled again following a failed reconcile
+	DefaultRequeueMaxDelay = 5 * time.Minute
+)
+
+// requeueAfter returns the delay before reconciling a workspace again, given
+// the number of consecutive reconciles that have failed. The delay doubles with
+// each attempt, starting at the base delay and capped at the max delay.
+func requeueAfter(attempts int, base, max time.Duration) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+
+	delay := base
+	for i := 1; i < attempts; i++ {
+		// Cap before doubling to avoid overflow
+		if delay >= max/2 {
+			return max
+		}
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// ignoreReconcileAttemptsUpdates filters out workspace updates that only
+// increment the reconcile attempt counter. Otherwise the update made after
+// each failed reconcile would trigger an immediate reconcile, defeating the
+// backoff.
+var ignoreReconcileAttemptsUpdates = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldWs, ok := e.ObjectOld.(*v1alpha1.Workspace)
+		if !ok {
+			return true
+		}
+		newWs, ok := e.ObjectNew.(*v1alpha1.Workspace)
+		if !ok {
+			return true
+		}
+		return !onlyReconcileAttemptsChanged(oldWs, newWs)
+	},
+}
+
+func onlyReconcileAttemptsChanged(oldWs, newWs *v1alpha1.Workspace) bool {
+	if oldWs.Status.ReconcileAttempts == newWs.Status.ReconcileAttempts {
+		return false
+	}
+
+	oldWs, newWs = oldWs.DeepCopy(), newWs.DeepCopy()
+	for _, ws := range []*v1alpha1.Workspace{oldWs, newWs} {
+		ws.Status.ReconcileAttempts = 0
+		ws.ResourceVersion = ""
+		ws.ManagedFields = nil
+	}
+	return equality.Semantic.DeepEqual(oldWs, newWs)
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequeueAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		base     time.Duration
+		max      time.Duration
+		want     time.Duration
+	}{
+		{
+			name:     "no attempts",
+			attempts: 0,
+			base:     time.Second,
+			max:      time.Minute,
+			want:     0,
+		},
+		{
+			name:     "first attempt",
+			attempts: 1,
+			base:     time.Second,
+			max:      time.Minute,
+			want:     time.Second,
+		},
+		{
+			name:     "second attempt",
+			attempts: 2,
+			base:     time.Second,
+			max:      time.Minute,
+			want:     2 * time.Second,
+		},
+		{
+			name:     "fifth attempt",
+			attempts: 5,
+			base:     time.Second,
+			max:      time.Minute,
+			want:     16 * time.Second,
+		},
+		{
+			name:     "capped",
+			attempts: 7,
+			base:     time.Second,
+			max:      time.Minute,
+			want:     time.Minute,
+		},
+		{
+			name:     "many attempts do not overflow",
+			attempts: 1000,
+			base:     time.Second,
+			max:      time.Minute,
+			want:     time.Minute,
+		},
+		{
+			name:     "base exceeds max",
+			attempts: 1,
+			base:     time.Hour,
+			max:      time.Minute,
+			want:     time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, requeueAfter(tt.attempts, tt.base, tt.max))
+		})
+	}
+}
+
+func TestOnlyReconcileAttemptsChanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		old    *v1alpha1.Workspace
+		update func(*v1alpha1.Workspace)
+		want   bool
+	}{
+		{
+			name: "attempts incremented",
+			old:  testobj.Workspace("default", "workspace-1"),
+			update: func(ws *v1alpha1.Workspace) {
+				ws.Status.ReconcileAttempts = 1
+				ws.ResourceVersion = "2"
+			},
+			want: true,
+		},
+		{
+			name: "attempts and phase changed",
+			old:  testobj.Workspace("default", "workspace-1"),
+			update: func(ws *v1alpha1.Workspace) {
+				ws.Status.ReconcileAttempts = 1
+				ws.Status.Phase = v1alpha1.WorkspacePhaseError
+			},
+			want: false,
+		},
+		{
+			name: "annotation added",
+			old:  testobj.Workspace("default", "workspace-1"),
+			update: func(ws *v1alpha1.Workspace) {
+				ws.Annotations = map[string]string{"foo": "bar"}
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := tt.old.DeepCopy()
+			tt.update(updated)
+			assert.Equal(t, tt.want, onlyReconcileAttemptsChanged(tt.old, updated))
+		})
+	}
+}
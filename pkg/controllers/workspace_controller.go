@@ -1,22 +1,33 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	cloudkms "google.golang.org/api/cloudkms/v1"
 	"google.golang.org/api/googleapi"
 	"sigs.k8s.io/yaml"
 
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/leg100/etok/pkg/scheme"
+	"github.com/leg100/etok/pkg/tfversion"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
@@ -26,6 +37,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -38,6 +50,10 @@ const (
 	ServiceAccountName = "etok"
 	RoleName           = "etok"
 	RoleBindingName    = "etok"
+
+	// backupFinalizer is set on a workspace and its state secret, preventing
+	// their deletion until the state has been backed up
+	backupFinalizer = "etok.dev/backup"
 )
 
 var (
@@ -52,7 +68,44 @@ type WorkspaceReconciler struct {
 	Scheme        *runtime.Scheme
 	Image         string
 	StorageClient *storage.Client
+	S3Client      s3iface.S3API
 	recorder      record.EventRecorder
+
+	// KMS clients for encrypting backups, for the gcs and s3 providers
+	// respectively
+	CloudKMSService *cloudkms.Service
+	KMSClient       kmsiface.KMSAPI
+
+	// Toggle backing up state before a workspace is deleted
+	BackupOnDelete bool
+
+	// Toggle defaults suited to GKE Autopilot clusters
+	Autopilot bool
+
+	// Lists terraform versions against which version constraints are resolved
+	TerraformVersionLister tfversion.Lister
+
+	// Delays before reconciling a workspace again following a failed
+	// reconcile: the delay starts at the base delay and doubles with each
+	// consecutive failure, up to the max delay
+	RequeueBaseDelay time.Duration
+	RequeueMaxDelay  time.Duration
+
+	// Health check informed of the workspaces with runs waiting in their
+	// queue. Optional.
+	BacklogCheck *BacklogCheck
+
+	// Current time, at which versioned backups are taken
+	now func() time.Time
+
+	// Backup providers trusting the CA bundles of workspaces, keyed by
+	// workspace
+	caBundleProviders map[types.NamespacedName]*caBundleProvider
+	caBundleMu        sync.Mutex
+
+	// Configuration merged with that of the S3 client of workspaces with a CA
+	// bundle. Only set in tests.
+	caBundleS3Config *aws.Config
 }
 
 type WorkspaceReconcilerOption func(r *WorkspaceReconciler)
@@ -63,17 +116,72 @@ func WithStorageClient(sc *storage.Client) WorkspaceReconcilerOption {
 	}
 }
 
+func WithS3Client(sc s3iface.S3API) WorkspaceReconcilerOption {
+	return func(r *WorkspaceReconciler) {
+		r.S3Client = sc
+	}
+}
+
+func WithCloudKMSService(svc *cloudkms.Service) WorkspaceReconcilerOption {
+	return func(r *WorkspaceReconciler) {
+		r.CloudKMSService = svc
+	}
+}
+
+func WithKMSClient(client kmsiface.KMSAPI) WorkspaceReconcilerOption {
+	return func(r *WorkspaceReconciler) {
+		r.KMSClient = client
+	}
+}
+
 func WithEventRecorder(recorder record.EventRecorder) WorkspaceReconcilerOption {
 	return func(r *WorkspaceReconciler) {
 		r.recorder = recorder
 	}
 }
 
+func WithBackupOnDelete(enabled bool) WorkspaceReconcilerOption {
+	return func(r *WorkspaceReconciler) {
+		r.BackupOnDelete = enabled
+	}
+}
+
+func WithTerraformVersionLister(lister tfversion.Lister) WorkspaceReconcilerOption {
+	return func(r *WorkspaceReconciler) {
+		r.TerraformVersionLister = lister
+	}
+}
+
+func WithAutopilot(enabled bool) WorkspaceReconcilerOption {
+	return func(r *WorkspaceReconciler) {
+		r.Autopilot = enabled
+	}
+}
+
+func WithRequeueBackoff(base, max time.Duration) WorkspaceReconcilerOption {
+	return func(r *WorkspaceReconciler) {
+		r.RequeueBaseDelay = base
+		r.RequeueMaxDelay = max
+	}
+}
+
+func WithBacklogCheck(check *BacklogCheck) WorkspaceReconcilerOption {
+	return func(r *WorkspaceReconciler) {
+		r.BacklogCheck = check
+	}
+}
+
 func NewWorkspaceReconciler(cl client.Client, image string, opts ...WorkspaceReconcilerOption) *WorkspaceReconciler {
 	r := &WorkspaceReconciler{
-		Client: cl,
-		Scheme: scheme.Scheme,
-		Image:  image,
+		Client:                 cl,
+		Scheme:                 scheme.Scheme,
+		Image:                  image,
+		TerraformVersionLister: tfversion.NewReleasesLister(),
+		RequeueBaseDelay:       DefaultRequeueBaseDelay,
+		RequeueMaxDelay:        DefaultRequeueMaxDelay,
+		now:                    time.Now,
+		// Discard events unless a recorder is provided
+		recorder: &record.FakeRecorder{},
 	}
 
 	for _, o := range opts {
@@ -88,6 +196,7 @@ func NewWorkspaceReconciler(cl client.Client, image string, opts ...WorkspaceRec
 	workspaceReconcileStatusChain = append(workspaceReconcileStatusChain, r.manageBuiltins)
 	workspaceReconcileStatusChain = append(workspaceReconcileStatusChain, r.manageRBACForNamespace)
 	workspaceReconcileStatusChain = append(workspaceReconcileStatusChain, r.manageState)
+	workspaceReconcileStatusChain = append(workspaceReconcileStatusChain, r.manageTerraformVersion)
 	workspaceReconcileStatusChain = append(workspaceReconcileStatusChain, r.managePVC)
 	workspaceReconcileStatusChain = append(workspaceReconcileStatusChain, r.managePod)
 
@@ -133,9 +242,21 @@ func (r *WorkspaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	log := log.FromContext(ctx)
 	log.V(0).Info("Reconciling")
 
+	start := time.Now()
+	defer func() {
+		workspaceReconcileDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	// Fetch the Workspace instance
 	var ws v1alpha1.Workspace
 	if err := r.Get(ctx, req.NamespacedName, &ws); err != nil {
+		if kerrors.IsNotFound(err) {
+			// Stop reporting metrics for deleted workspace
+			workspaceQueueDepth.DeleteLabelValues(req.Namespace, req.Name)
+			r.updateBacklog(req.NamespacedName, 0)
+			// Release client for backing up its state
+			r.releaseCABundleProvider(req.NamespacedName)
+		}
 		// we'll ignore not-found errors, since they can't be fixed by an
 		// immediate requeue (we'll need to wait for a new notification), and we
 		// can get them on deleted requests.
@@ -151,6 +272,24 @@ func (r *WorkspaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		}
 	}
 
+	// Backup state before permitting deletion of workspace
+	if ws.GetDeletionTimestamp().IsZero() {
+		if r.backupOnDelete(&ws) && !controllerutil.ContainsFinalizer(&ws, backupFinalizer) {
+			controllerutil.AddFinalizer(&ws, backupFinalizer)
+			if err := r.Update(ctx, &ws); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	} else if controllerutil.ContainsFinalizer(&ws, backupFinalizer) {
+		if err := r.finalBackup(ctx, &ws); err != nil {
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(&ws, backupFinalizer)
+		if err := r.Update(ctx, &ws); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	// Prune approval annotations
 	annotations, err := r.pruneApprovals(ctx, ws)
 	if err != nil {
@@ -164,10 +303,31 @@ func (r *WorkspaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	}
 
 	// Update status one step in the chain at a time. Returns a ready condition.
+	attempts := ws.Status.ReconcileAttempts
 	ready, backoff := processWorkspaceReconcileStatusChain(ctx, &ws)
+
+	// Report number of runs waiting in queue
+	workspaceQueueDepth.WithLabelValues(ws.Namespace, ws.Name).Set(float64(len(ws.Status.Queue)))
+	// A paused workspace holds its queued runs on purpose, so they don't
+	// indicate a stuck operator
+	if ws.Spec.Paused {
+		r.updateBacklog(req.NamespacedName, 0)
+	} else {
+		r.updateBacklog(req.NamespacedName, len(ws.Status.Queue))
+	}
+
+	// Count consecutive failed reconciles, from which the delay before the
+	// next reconcile is determined
+	if backoff != nil {
+		ws.Status.ReconcileAttempts = attempts + 1
+	} else {
+		ws.Status.ReconcileAttempts = 0
+	}
+
 	if ready != nil {
 		// Add condition to status
 		meta.SetStatusCondition(&ws.Status.Conditions, *ready)
+		meta.SetStatusCondition(&ws.Status.Conditions, pausedCondition(&ws))
 
 		// Ensure phase reflects ready condition
 		ws.Status.Phase = setPhase(ready.Reason)
@@ -175,10 +335,60 @@ func (r *WorkspaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		if err := r.updateStatus(ctx, req, ws.Status); err != nil {
 			return ctrl.Result{}, err
 		}
+	} else if ws.Status.ReconcileAttempts != attempts {
+		// Only persist the attempt counter: the status may have been only
+		// partially updated by the chain before it failed
+		if err := r.updateReconcileAttempts(ctx, req, ws.Status.ReconcileAttempts); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Remove restore version annotation once the restore has been attempted.
+	// This is done after the status is updated, so that clients waiting for
+	// the removal can rely upon the status reflecting the restored state.
+	if _, ok := ws.Annotations[v1alpha1.RestoreVersionAnnotationKey]; ok && backoff == nil {
+		patch := client.MergeFrom(ws.DeepCopy())
+		delete(ws.Annotations, v1alpha1.RestoreVersionAnnotationKey)
+		if err := r.Patch(ctx, &ws, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Likewise remove restore generation annotation, but only once the
+	// generation has been restored, so that a failed restore is not followed
+	// by a restore of the latest backup. A workspace with a backend other
+	// than kubernetes never restores a backup, so it is removed regardless.
+	if generation, ok := ws.Annotations[v1alpha1.RestoreGenerationAnnotationKey]; ok && (restoredGeneration(&ws) == generation || ws.BackendType() != v1alpha1.BackendKubernetes) {
+		patch := client.MergeFrom(ws.DeepCopy())
+		delete(ws.Annotations, v1alpha1.RestoreGenerationAnnotationKey)
+		if err := r.Patch(ctx, &ws, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if backoff != nil {
+		// Requeue after an exponentially increasing delay rather than
+		// returning the error, which would requeue according to the
+		// controller's rate limiter instead
+		delay := requeueAfter(ws.Status.ReconcileAttempts, r.RequeueBaseDelay, r.RequeueMaxDelay)
+		log.Error(backoff, "Reconcile failed", "attempts", ws.Status.ReconcileAttempts, "requeueAfter", delay)
+		return ctrl.Result{RequeueAfter: delay}, nil
 	}
 
-	// Non-nil backoff triggers an exponential backoff
-	return ctrl.Result{}, backoff
+	return ctrl.Result{}, nil
+}
+
+// updateReconcileAttempts updates the number of consecutive failed reconciles
+// in the workspace status, leaving the rest of the status untouched.
+func (r *WorkspaceReconciler) updateReconcileAttempts(ctx context.Context, req ctrl.Request, attempts int) error {
+	var ws v1alpha1.Workspace
+	if err := r.Get(ctx, req.NamespacedName, &ws); err != nil {
+		return err
+	}
+
+	ws.Status.ReconcileAttempts = attempts
+
+	return r.Status().Update(ctx, &ws)
 }
 
 // updateStatus actually calls the k8s API to update the workspace resource. To
@@ -269,6 +479,31 @@ func (r *WorkspaceReconciler) handleDeletion(ctx context.Context, ws *v1alpha1.W
 func (r *WorkspaceReconciler) manageState(ctx context.Context, ws *v1alpha1.Workspace) (*metav1.Condition, error) {
 	log := log.FromContext(ctx)
 
+	if ws.BackendType() != v1alpha1.BackendKubernetes {
+		// State is not stored in a secret, so there is nothing to report on,
+		// backup or restore. Report rather than ignore a request to restore a
+		// backup, which the annotation's removal then acknowledges.
+		_, generation := ws.Annotations[v1alpha1.RestoreGenerationAnnotationKey]
+		_, version := ws.Annotations[v1alpha1.RestoreVersionAnnotationKey]
+		if generation || version {
+			msg := fmt.Sprintf("restore requires the kubernetes backend: backend type is %s", ws.BackendType())
+			r.recorder.Event(ws, "Warning", "RestoreError", msg)
+			return workspaceFailure(fmt.Sprintf("RestoreError: %s", msg)), nil
+		}
+		return nil, nil
+	}
+
+	if generation, ok := ws.Annotations[v1alpha1.RestoreGenerationAnnotationKey]; ok {
+		// Restore the requested generation in place of the latest backup
+		return r.restoreGeneration(ctx, ws, generation)
+	}
+
+	if version, ok := ws.Annotations[v1alpha1.RestoreVersionAnnotationKey]; ok {
+		// Restore the requested version, leaving the rest of state management
+		// to the next reconcile
+		return r.restoreVersion(ctx, ws, version)
+	}
+
 	var secret corev1.Secret
 	err := r.Get(ctx, types.NamespacedName{Namespace: ws.Namespace, Name: ws.StateSecretName()}, &secret)
 	switch {
@@ -286,6 +521,11 @@ func (r *WorkspaceReconciler) manageState(ctx context.Context, ws *v1alpha1.Work
 			log.Error(err, "unable to set state secret ownership")
 			return nil, err
 		}
+		// Prevent garbage collection of state secret until it has been backed
+		// up
+		if r.backupOnDelete(ws) {
+			controllerutil.AddFinalizer(&secret, backupFinalizer)
+		}
 		if err := r.Update(ctx, &secret); err != nil {
 			return nil, err
 		}
@@ -328,8 +568,9 @@ func (r *WorkspaceReconciler) addFinalizers(ctx context.Context, ws v1alpha1.Wor
 	return ws, nil
 }
 
-// Prune invalid approval annotations. Invalid approvals are those that belong
-// to runs which are either completed or no longer exist.
+// Prune invalid approval annotations, both privileged command approvals and
+// those set by etok approve. Invalid approvals are those that belong to runs
+// which are either completed or no longer exist.
 func (r *WorkspaceReconciler) pruneApprovals(ctx context.Context, ws v1alpha1.Workspace) (map[string]string, error) {
 	if ws.Annotations == nil {
 		// Nothing to prune
@@ -339,7 +580,7 @@ func (r *WorkspaceReconciler) pruneApprovals(ctx context.Context, ws v1alpha1.Wo
 	annotations := makeCopyOfMap(ws.Annotations)
 
 	for k := range annotations {
-		if !strings.HasPrefix(k, v1alpha1.ApprovedAnnotationKeyPrefix) {
+		if !strings.HasPrefix(k, v1alpha1.ApprovedAnnotationKeyPrefix+"/") && !strings.HasPrefix(k, v1alpha1.ReviewedAnnotationKeyPrefix+"/") {
 			// Skip non-approval annotations
 			continue
 		}
@@ -363,39 +604,112 @@ func (r *WorkspaceReconciler) pruneApprovals(ctx context.Context, ws v1alpha1.Wo
 	return annotations, nil
 }
 
-func (r *WorkspaceReconciler) backup(ctx context.Context, ws *v1alpha1.Workspace, secret *corev1.Secret, sfile *state) (*metav1.Condition, error) {
-	// Re-use client or create if not yet created
-	if r.StorageClient == nil {
-		var err error
-		r.StorageClient, err = storage.NewClient(ctx)
-		if err != nil {
-			return r.handleStorageError(err, ws, "BackupError")
+// backupProvider returns the workspace's backup provider, which verifies
+// backups against checksums, and encrypts backups if the workspace specifies a
+// KMS key
+func (r *WorkspaceReconciler) backupProvider(ctx context.Context, ws *v1alpha1.Workspace) (BackupProvider, error) {
+	provider, err := r.storageProvider(ctx, ws)
+	if err != nil {
+		return nil, err
+	}
+	// Checksums are computed on the uploaded, i.e. encrypted, backup
+	return r.encryptingProvider(ws, &checksummingProvider{BackupProvider: provider}), nil
+}
+
+// encryptingProvider wraps a backup provider, encrypting backups if the
+// workspace specifies a KMS key
+func (r *WorkspaceReconciler) encryptingProvider(ws *v1alpha1.Workspace, provider BackupProvider) *encryptingProvider {
+	return &encryptingProvider{
+		BackupProvider: provider,
+		kmsKey:         ws.Spec.BackupKMSKey,
+		keyManager: func(ctx context.Context) (keyManager, error) {
+			return r.keyManager(ctx, ws)
+		},
+	}
+}
+
+// keyManager returns the KMS key manager corresponding to the workspace's
+// backup provider
+func (r *WorkspaceReconciler) keyManager(ctx context.Context, ws *v1alpha1.Workspace) (keyManager, error) {
+	switch ws.BackupProviderType() {
+	case v1alpha1.BackupProviderS3:
+		// Re-use client or create if not yet created
+		if r.KMSClient == nil {
+			sess, err := session.NewSession()
+			if err != nil {
+				return nil, err
+			}
+			r.KMSClient = kms.New(sess)
+		}
+		return &awsKeyManager{client: r.KMSClient}, nil
+	default:
+		// Re-use client or create if not yet created
+		if r.CloudKMSService == nil {
+			var err error
+			r.CloudKMSService, err = cloudkms.NewService(ctx)
+			if err != nil {
+				return nil, err
+			}
 		}
+		return &gcpKeyManager{service: r.CloudKMSService}, nil
 	}
+}
+
+// storageProvider returns the provider for the workspace's backup bucket,
+// creating the provider's client if not yet created
+func (r *WorkspaceReconciler) storageProvider(ctx context.Context, ws *v1alpha1.Workspace) (BackupProvider, error) {
+	if ws.Spec.CABundleSecret != "" {
+		return r.caBundleStorageProvider(ctx, ws)
+	}
+
+	switch ws.BackupProviderType() {
+	case v1alpha1.BackupProviderS3:
+		// Re-use client or create if not yet created
+		if r.S3Client == nil {
+			sess, err := session.NewSession()
+			if err != nil {
+				return nil, err
+			}
+			r.S3Client = s3.New(sess)
+		}
+		return &s3Provider{client: r.S3Client}, nil
+	default:
+		// Re-use client or create if not yet created
+		if r.StorageClient == nil {
+			var err error
+			r.StorageClient, err = storage.NewClient(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &gcsProvider{client: r.StorageClient}, nil
+	}
+}
 
-	bh := r.StorageClient.Bucket(ws.Spec.BackupBucket)
-	_, err := bh.Attrs(ctx)
+func (r *WorkspaceReconciler) backup(ctx context.Context, ws *v1alpha1.Workspace, secret *corev1.Secret, sfile *state) (*metav1.Condition, error) {
+	provider, err := r.backupProvider(ctx, ws)
 	if err != nil {
 		return r.handleStorageError(err, ws, "BackupError")
 	}
 
-	oh := bh.Object(ws.BackupObjectName())
-
 	// Marshal state file first to json then to yaml
 	y, err := yaml.Marshal(secret)
 	if err != nil {
 		return r.handleStorageError(err, ws, "BackupError")
 	}
 
-	// Copy state file to GCS
-	owriter := oh.NewWriter(ctx)
-	_, err = io.Copy(owriter, bytes.NewBuffer(y))
-	if err != nil {
-		return r.handleStorageError(err, ws, "BackupError")
+	// Copy state file to bucket, both as the latest backup and as a version
+	// keyed by its serial number and the time of the backup
+	for _, key := range []string{ws.BackupObjectName(), ws.BackupVersionObjectName(sfile.Serial, r.now())} {
+		if err := provider.Backup(ctx, ws.Spec.BackupBucket, key, y); err != nil {
+			return r.handleStorageError(err, ws, "BackupError")
+		}
 	}
 
-	if err := owriter.Close(); err != nil {
-		return r.handleStorageError(err, ws, "BackupError")
+	if ws.Spec.BackupRetention > 0 {
+		if err := r.pruneBackups(ctx, provider, ws); err != nil {
+			return r.handleStorageError(err, ws, "BackupError")
+		}
 	}
 
 	// Update latest backup serial
@@ -405,53 +719,323 @@ func (r *WorkspaceReconciler) backup(ctx context.Context, ws *v1alpha1.Workspace
 	return nil, nil
 }
 
-func (r *WorkspaceReconciler) restore(ctx context.Context, ws *v1alpha1.Workspace) (*metav1.Condition, error) {
+// backupOnDelete determines whether the workspace's state is to be backed up
+// before the workspace is deleted
+func (r *WorkspaceReconciler) backupOnDelete(ws *v1alpha1.Workspace) bool {
+	return r.BackupOnDelete && ws.Spec.BackupBucket != "" && ws.BackendType() == v1alpha1.BackendKubernetes
+}
+
+// finalBackup backs up the state of a workspace that is being deleted, unless
+// it has already been backed up, and then removes the finalizer from the state
+// secret, permitting its deletion. Errors that cannot be fixed by retrying are
+// reported via events rather than blocking deletion indefinitely.
+func (r *WorkspaceReconciler) finalBackup(ctx context.Context, ws *v1alpha1.Workspace) error {
 	var secret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: ws.Namespace, Name: ws.StateSecretName()}, &secret)
+	if kerrors.IsNotFound(err) {
+		// No state to backup
+		return nil
+	} else if err != nil {
+		return err
+	}
 
-	// Re-use client or create if not yet created
-	if r.StorageClient == nil {
-		var err error
-		r.StorageClient, err = storage.NewClient(ctx)
+	if ws.Spec.BackupBucket != "" {
+		state, err := readState(ctx, &secret)
 		if err != nil {
-			return nil, err
+			r.recorder.Eventf(ws, "Warning", "BackupError", "unable to read state: %s", err.Error())
+		} else if ws.Status.BackupSerial == nil || state.Serial != *ws.Status.BackupSerial {
+			if _, err := r.backup(ctx, ws, &secret, state); err != nil {
+				return err
+			}
+		}
+	}
+
+	if controllerutil.ContainsFinalizer(&secret, backupFinalizer) {
+		controllerutil.RemoveFinalizer(&secret, backupFinalizer)
+		if err := r.Update(ctx, &secret); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// backupVersion is a versioned backup of a workspace's state
+type backupVersion struct {
+	key    string
+	serial int
+	// Time at which the backup was taken. Zero for backups named after only
+	// their serial number, which predate the inclusion of the time.
+	taken time.Time
+}
 
-	bh := r.StorageClient.Bucket(ws.Spec.BackupBucket)
-	_, err := bh.Attrs(ctx)
+// listBackupVersions lists the versioned backups of the workspace's state,
+// newest first, ignoring any objects not created by etok
+func listBackupVersions(ctx context.Context, provider BackupProvider, ws *v1alpha1.Workspace) ([]backupVersion, error) {
+	keys, err := provider.List(ctx, ws.Spec.BackupBucket, ws.BackupVersionPrefix())
 	if err != nil {
-		return r.handleStorageError(err, ws, "RestoreError")
+		return nil, err
 	}
 
-	// Try to retrieve existing backup
-	oh := bh.Object(ws.BackupObjectName())
-	_, err = oh.Attrs(ctx)
-	if err == storage.ErrObjectNotExist {
-		r.recorder.Eventf(ws, "Normal", "RestoreSkipped", "There is no state to restore")
+	var versions []backupVersion
+	for _, k := range keys {
+		// Object name is either <serial>-<time>.yaml or <serial>.yaml
+		name := strings.TrimSuffix(strings.TrimPrefix(k, ws.BackupVersionPrefix()), ".yaml")
+		parts := strings.SplitN(name, "-", 2)
+
+		serial, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		version := backupVersion{key: k, serial: serial}
+		if len(parts) == 2 {
+			version.taken, err = time.Parse(v1alpha1.BackupVersionTimeFormat, parts[1])
+			if err != nil {
+				continue
+			}
+		}
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].taken.Equal(versions[j].taken) {
+			return versions[i].taken.After(versions[j].taken)
+		}
+		return versions[i].serial > versions[j].serial
+	})
+
+	return versions, nil
+}
+
+// pruneBackups deletes versioned backups, oldest first, until only the number
+// specified by the workspace's backup retention remain. Backups are aged by
+// the time they were taken rather than by their serial number, which is
+// re-used should an older version be restored.
+func (r *WorkspaceReconciler) pruneBackups(ctx context.Context, provider BackupProvider, ws *v1alpha1.Workspace) error {
+	versions, err := listBackupVersions(ctx, provider, ws)
+	if err != nil {
+		return err
+	}
+
+	if len(versions) <= ws.Spec.BackupRetention {
+		return nil
+	}
+
+	for _, v := range versions[ws.Spec.BackupRetention:] {
+		if err := provider.Delete(ctx, ws.Spec.BackupBucket, v.key); err != nil {
+			return err
+		}
+	}
+
+	r.recorder.Eventf(ws, "Normal", "BackupsPruned", "Pruned %d backup(s)", len(versions)-ws.Spec.BackupRetention)
+	return nil
+}
+
+// restoredGeneration returns the GCS object generation from which the state
+// was last restored, or an empty string if it has not been restored from a
+// generation
+func restoredGeneration(ws *v1alpha1.Workspace) string {
+	if ws.Status.RestoredGeneration == nil {
+		return ""
+	}
+	return strconv.FormatInt(*ws.Status.RestoredGeneration, 10)
+}
+
+// restoreVersion replaces the state with the versioned backup requested via
+// the restore version annotation. Errors that cannot be fixed by retrying are
+// reported via events rather than failing the workspace, because the existing
+// state is left intact.
+func (r *WorkspaceReconciler) restoreVersion(ctx context.Context, ws *v1alpha1.Workspace, version string) (*metav1.Condition, error) {
+	serial, err := strconv.Atoi(version)
+	if err != nil {
+		r.recorder.Eventf(ws, "Warning", "RestoreError", "invalid version: %s", version)
+		return nil, nil
+	}
+
+	if ws.Spec.BackupBucket == "" {
+		r.recorder.Eventf(ws, "Warning", "RestoreError", "workspace has no backup bucket")
+		return nil, nil
+	}
+
+	provider, err := r.backupProvider(ctx, ws)
+	if err != nil {
+		return nil, err
+	}
+
+	// Restore the most recent backup with the serial number
+	versions, err := listBackupVersions(ctx, provider, ws)
+	if err != nil {
+		_, err = r.handleStorageError(err, ws, "RestoreError")
+		return nil, err
+	}
+	var key string
+	for _, v := range versions {
+		if v.serial == serial {
+			key = v.key
+			break
+		}
+	}
+	if key == "" {
+		r.recorder.Eventf(ws, "Warning", "RestoreError", "backup of state #%d does not exist", serial)
+		return nil, nil
+	}
+
+	data, err := provider.Restore(ctx, ws.Spec.BackupBucket, key)
+	if err == ErrBackupNotFound {
+		r.recorder.Eventf(ws, "Warning", "RestoreError", "backup of state #%d does not exist", serial)
+		return nil, nil
+	} else if errors.Is(err, errDecryptionFailed) {
+		r.recorder.Eventf(ws, "Warning", "DecryptionError", "backup of state #%d: %s", serial, err.Error())
+		return nil, nil
+	} else if errors.Is(err, errChecksumMismatch) {
+		r.recorder.Eventf(ws, "Warning", "RestoreError", "backup of state #%d: %s", serial, err.Error())
 		return nil, nil
 	} else if err != nil {
+		// Only retry those errors deemed recoverable
+		_, err = r.handleStorageError(err, ws, "RestoreError")
 		return nil, err
 	}
 
-	oreader, err := oh.NewReader(ctx)
+	var backup corev1.Secret
+	if err := yaml.Unmarshal(data, &backup); err != nil {
+		r.recorder.Eventf(ws, "Warning", "RestoreError", "unable to parse backup of state #%d: %s", serial, err.Error())
+		return nil, nil
+	}
+	state, err := readState(ctx, &backup)
 	if err != nil {
+		r.recorder.Eventf(ws, "Warning", "RestoreError", "unable to parse backup of state #%d: %s", serial, err.Error())
+		return nil, nil
+	}
+
+	if err := r.replaceState(ctx, ws, &backup); err != nil {
+		return nil, err
+	}
+
+	ws.Status.Serial = &state.Serial
+	// The restored state is already backed up. Recording it as such ensures
+	// the state is backed up after the next apply, which re-uses the serial
+	// number following that of the restored state.
+	ws.Status.BackupSerial = &state.Serial
+
+	r.recorder.Eventf(ws, "Normal", "RestoreSuccessful", "Restored state #%d", state.Serial)
+	return nil, nil
+}
+
+// restoreGeneration replaces the state with the GCS object generation of the
+// latest backup requested via the restore generation annotation. Unlike
+// restoring a version, a generation that cannot be restored fails the
+// workspace, because the generation is typically requested when creating a
+// workspace, which would otherwise go on to restore the latest backup.
+func (r *WorkspaceReconciler) restoreGeneration(ctx context.Context, ws *v1alpha1.Workspace, annotation string) (*metav1.Condition, error) {
+	restoreError := func(format string, a ...interface{}) (*metav1.Condition, error) {
+		msg := fmt.Sprintf(format, a...)
+		r.recorder.Event(ws, "Warning", "RestoreError", msg)
+		return workspaceFailure(fmt.Sprintf("RestoreError: %s", msg)), nil
+	}
+
+	generation, err := strconv.ParseInt(annotation, 10, 64)
+	if err != nil || generation <= 0 {
+		return restoreError("invalid generation: %s", annotation)
+	}
+
+	if ws.Spec.BackupBucket == "" {
+		return restoreError("workspace has no backup bucket")
+	}
+	if ws.Spec.BackupProvider != "" && ws.Spec.BackupProvider != v1alpha1.BackupProviderGCS {
+		return restoreError("restoring a generation requires the gcs backup provider")
+	}
+
+	provider, err := r.storageProvider(ctx, ws)
+	if err != nil {
+		return nil, err
+	}
+	gcs, ok := provider.(*gcsProvider)
+	if !ok {
+		return restoreError("restoring a generation requires the gcs backup provider")
+	}
+
+	// The checksum object has generations of its own, which don't correspond
+	// to those of the backup, so the backup cannot be verified
+	key := ws.BackupObjectName()
+	data, err := r.encryptingProvider(ws, &gcsProvider{client: gcs.client, generation: generation}).Restore(ctx, ws.Spec.BackupBucket, key)
+	if err == ErrBackupNotFound {
+		return restoreError("generation %d of backup %s/%s does not exist", generation, ws.Spec.BackupBucket, key)
+	} else if errors.Is(err, errDecryptionFailed) {
+		return r.undecryptableBackup(err, ws)
+	} else if err != nil {
 		return r.handleStorageError(err, ws, "RestoreError")
 	}
 
-	// Copy state file from GCS
-	buf := new(bytes.Buffer)
-	_, err = io.Copy(buf, oreader)
+	var backup corev1.Secret
+	if err := yaml.Unmarshal(data, &backup); err != nil {
+		return restoreError("unable to parse generation %d of backup %s/%s: %s", generation, ws.Spec.BackupBucket, key, err.Error())
+	}
+	state, err := readState(ctx, &backup)
+	if err != nil {
+		return restoreError("unable to parse generation %d of backup %s/%s: %s", generation, ws.Spec.BackupBucket, key, err.Error())
+	}
+
+	if err := r.replaceState(ctx, ws, &backup); err != nil {
+		return nil, err
+	}
+
+	ws.Status.Serial = &state.Serial
+	ws.Status.RestoredGeneration = &generation
+
+	r.recorder.Eventf(ws, "Normal", "RestoreSuccessful", "Restored state #%d from generation %d", state.Serial, generation)
+	return nil, nil
+}
+
+// replaceState overwrites the existing state with the backup, or creates it if
+// it doesn't exist
+func (r *WorkspaceReconciler) replaceState(ctx context.Context, ws *v1alpha1.Workspace, backup *corev1.Secret) error {
+	var secret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: ws.Namespace, Name: ws.StateSecretName()}, &secret)
+	switch {
+	case kerrors.IsNotFound(err):
+		backup.Name = ws.StateSecretName()
+		backup.Namespace = ws.Namespace
+		backup.ResourceVersion = ""
+		backup.OwnerReferences = nil
+		return r.Create(ctx, backup)
+	case err != nil:
+		return err
+	default:
+		secret.Data = backup.Data
+		return r.Update(ctx, &secret)
+	}
+}
+
+func (r *WorkspaceReconciler) restore(ctx context.Context, ws *v1alpha1.Workspace) (*metav1.Condition, error) {
+	var secret corev1.Secret
+
+	provider, err := r.backupProvider(ctx, ws)
 	if err != nil {
+		return nil, err
+	}
+
+	// Copy state file from bucket
+	data, err := provider.Restore(ctx, ws.Spec.BackupBucket, ws.BackupObjectName())
+	if err == ErrBackupNotFound {
+		r.recorder.Eventf(ws, "Normal", "RestoreSkipped", "There is no state to restore")
+		return nil, nil
+	} else if errors.Is(err, errDecryptionFailed) {
+		return r.undecryptableBackup(err, ws)
+	} else if errors.Is(err, errChecksumMismatch) {
+		return r.mismatchedBackup(err, ws)
+	} else if err != nil {
 		return r.handleStorageError(err, ws, "RestoreError")
 	}
 
 	// Unmarshal state file into secret obj
-	if err := yaml.Unmarshal(buf.Bytes(), &secret); err != nil {
-		return r.handleStorageError(err, ws, "RestoreError")
+	if err := yaml.Unmarshal(data, &secret); err != nil {
+		return r.corruptBackup(err, ws)
 	}
 
-	if err := oreader.Close(); err != nil {
-		return r.handleStorageError(err, ws, "RestoreError")
+	// Parse state file before creating the secret, so that a corrupt backup
+	// is not restored
+	state, err := readState(ctx, &secret)
+	if err != nil {
+		return r.corruptBackup(err, ws)
 	}
 
 	// Blank out certain fields to avoid errors upon create
@@ -462,12 +1046,6 @@ func (r *WorkspaceReconciler) restore(ctx context.Context, ws *v1alpha1.Workspac
 		return r.handleStorageError(err, ws, "RestoreError")
 	}
 
-	// Parse state file
-	state, err := readState(ctx, &secret)
-	if err != nil {
-		return r.handleStorageError(err, ws, "RestoreError")
-	}
-
 	// Record in status that a backup with the given serial number exists.
 	ws.Status.BackupSerial = &state.Serial
 
@@ -476,9 +1054,35 @@ func (r *WorkspaceReconciler) restore(ctx context.Context, ws *v1alpha1.Workspac
 	return nil, nil
 }
 
-// Handle errors from the Google Cloud storage client
+// A backup that cannot be parsed is deemed unrecoverable: retrying the restore
+// won't fix it, so put the workspace into a failure state instead.
+func (r *WorkspaceReconciler) corruptBackup(err error, ws *v1alpha1.Workspace) (*metav1.Condition, error) {
+	msg := fmt.Sprintf("unable to parse backup %s/%s: %s", ws.Spec.BackupBucket, ws.BackupObjectName(), err.Error())
+	r.recorder.Eventf(ws, "Warning", "RestoreError", msg)
+	return workspaceFailure(fmt.Sprintf("RestoreError: %s", msg)), nil
+}
+
+// A backup that doesn't match its checksum has been corrupted, e.g. by a
+// truncated upload: retrying the restore won't fix it, so put the workspace
+// into a failure state instead.
+func (r *WorkspaceReconciler) mismatchedBackup(err error, ws *v1alpha1.Workspace) (*metav1.Condition, error) {
+	msg := fmt.Sprintf("%s/%s: %s", ws.Spec.BackupBucket, ws.BackupObjectName(), err.Error())
+	r.recorder.Eventf(ws, "Warning", "RestoreError", msg)
+	return workspaceFailure(fmt.Sprintf("RestoreError: %s", msg)), nil
+}
+
+// A backup that cannot be decrypted is reported distinctly from a missing or
+// corrupt backup, because it is typically caused by the operator lacking
+// permission to use the KMS key.
+func (r *WorkspaceReconciler) undecryptableBackup(err error, ws *v1alpha1.Workspace) (*metav1.Condition, error) {
+	msg := fmt.Sprintf("%s/%s: %s", ws.Spec.BackupBucket, ws.BackupObjectName(), err.Error())
+	r.recorder.Eventf(ws, "Warning", "DecryptionError", msg)
+	return workspaceFailure(fmt.Sprintf("DecryptionError: %s", msg)), nil
+}
+
+// Handle errors from the backup providers
 func (r *WorkspaceReconciler) handleStorageError(err error, ws *v1alpha1.Workspace, reason string) (*metav1.Condition, error) {
-	if err == storage.ErrBucketNotExist {
+	if err == errBucketNotFound {
 		r.recorder.Eventf(ws, "Warning", reason, "bucket does not exist")
 		return workspaceFailure(fmt.Sprintf("%s: %s", reason, "bucket does not exist")), nil
 	}
@@ -490,6 +1094,15 @@ func (r *WorkspaceReconciler) handleStorageError(err error, ws *v1alpha1.Workspa
 			return workspaceFailure(fmt.Sprintf("%s: %s", reason, gerr.Message)), nil
 		}
 	}
+
+	if aerr, ok := err.(awserr.RequestFailure); ok {
+		if aerr.StatusCode() >= 400 && aerr.StatusCode() < 500 {
+			// HTTP 40x errors are deemed unrecoverable
+			r.recorder.Eventf(ws, "Warning", reason, aerr.Message())
+			return workspaceFailure(fmt.Sprintf("%s: %s", reason, aerr.Message())), nil
+		}
+	}
+
 	r.recorder.Eventf(ws, "Warning", reason, err.Error())
 	return nil, err
 }
@@ -497,6 +1110,11 @@ func (r *WorkspaceReconciler) handleStorageError(err error, ws *v1alpha1.Workspa
 func (r *WorkspaceReconciler) manageBuiltins(ctx context.Context, ws *v1alpha1.Workspace) (*metav1.Condition, error) {
 	log := log.FromContext(ctx)
 
+	if err := validateBackend(ws); err != nil {
+		r.recorder.Eventf(ws, "Warning", "InvalidBackend", err.Error())
+		return workspaceFailure(fmt.Sprintf("Invalid backend: %s", err.Error())), nil
+	}
+
 	// Manage ConfigMap containing built-in terraform config for workspace
 	var builtins corev1.ConfigMap
 	err := r.Get(ctx, types.NamespacedName{Namespace: ws.Namespace, Name: ws.BuiltinsConfigMapName()}, &builtins)
@@ -542,12 +1160,16 @@ func (r *WorkspaceReconciler) managePod(ctx context.Context, ws *v1alpha1.Worksp
 	var pod corev1.Pod
 	err := r.Get(ctx, types.NamespacedName{Namespace: ws.Namespace, Name: ws.PodName()}, &pod)
 	if kerrors.IsNotFound(err) {
-		pod, err := workspacePod(ws, r.Image)
+		pod, err := workspacePod(ws, podImage(ws, r.Image))
 		if err != nil {
 			log.Error(err, "unable to construct pod")
 			return nil, err
 		}
 
+		if r.Autopilot {
+			setAutopilotResources(&pod.Spec)
+		}
+
 		if err := controllerutil.SetControllerReference(ws, pod, r.Scheme); err != nil {
 			log.Error(err, "unable to set pod ownership")
 			return nil, err
@@ -642,11 +1264,20 @@ func (r *WorkspaceReconciler) manageRBACForNamespace(ctx context.Context, ws *v1
 func (r *WorkspaceReconciler) managePVC(ctx context.Context, ws *v1alpha1.Workspace) (*metav1.Condition, error) {
 	log := log.FromContext(ctx)
 
+	if ws.Spec.Cache.Ephemeral {
+		// Pods use an empty dir instead
+		return nil, nil
+	}
+
 	var pvc corev1.PersistentVolumeClaim
 	err := r.Get(ctx, types.NamespacedName{Namespace: ws.Namespace, Name: ws.PVCName()}, &pvc)
 	if kerrors.IsNotFound(err) {
 		pvc := *newPVCForWS(ws)
 
+		if r.Autopilot {
+			setAutopilotStorageClass(&pvc)
+		}
+
 		if err := controllerutil.SetControllerReference(ws, &pvc, r.Scheme); err != nil {
 			log.Error(err, "unable to set PVC ownership")
 			return nil, err
@@ -680,7 +1311,7 @@ func (r *WorkspaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	blder := ctrl.NewControllerManagedBy(mgr)
 
 	// Watch for changes to primary resource Workspace
-	blder = blder.For(&v1alpha1.Workspace{})
+	blder = blder.For(&v1alpha1.Workspace{}, builder.WithPredicates(ignoreReconcileAttemptsUpdates))
 
 	// Watch for changes to secondary resource PVCs and requeue the owner Workspace
 	blder = blder.Owns(&corev1.PersistentVolumeClaim{})
@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"bytes"
+	"fmt"
+	"path/filepath"
 
 	"github.com/leg100/etok/api/etok.dev/v1alpha1"
 	"github.com/leg100/etok/pkg/labels"
@@ -11,7 +13,10 @@ import (
 
 const (
 	InstallerContainerName = "installer"
-	idlerCommand           = "trap \"exit 0\" SIGTERM; while true; do sleep 1; done"
+	// CABundleContainerName is the name of the init container that combines
+	// the system's CA certificates with the workspace's CA bundle
+	CABundleContainerName = "ca-bundle"
+	idlerCommand          = "trap \"exit 0\" SIGTERM; while true; do sleep 1; done"
 )
 
 // workspacePod returns a pod on which to setup a new etok workspace, optionally
@@ -22,8 +27,8 @@ const (
 // does mean however that a run pod can only be scheduled to the same node as
 // the workspace pod...).
 func workspacePod(ws *v1alpha1.Workspace, image string) (*corev1.Pod, error) {
-	script := new(bytes.Buffer)
-	if err := generateScript(script, ws); err != nil {
+	installer, err := installerContainer(ws, image)
+	if err != nil {
 		return nil, err
 	}
 
@@ -37,41 +42,27 @@ func workspacePod(ws *v1alpha1.Workspace, image string) (*corev1.Pod, error) {
 				{
 					Name:                     "idler",
 					Image:                    image,
-					ImagePullPolicy:          corev1.PullIfNotPresent,
+					ImagePullPolicy:          podImagePullPolicy(ws),
 					Command:                  []string{"sh", "-c", idlerCommand},
 					TerminationMessagePolicy: "FallbackToLogsOnError",
 				},
 			},
-			InitContainers: []corev1.Container{
-				{
-					Name:                     InstallerContainerName,
-					Image:                    image,
-					ImagePullPolicy:          corev1.PullIfNotPresent,
-					Command:                  []string{"sh", "-c", script.String()},
-					TerminationMessagePolicy: "FallbackToLogsOnError",
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							Name:      "cache",
-							MountPath: binMountPath,
-							SubPath:   binSubPath,
-						},
-					},
-				},
-			},
-			RestartPolicy: corev1.RestartPolicyAlways,
-			Volumes: []corev1.Volume{
-				{
-					Name: "cache",
-					VolumeSource: corev1.VolumeSource{
-						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-							ClaimName: ws.PVCName(),
-						},
-					},
-				},
-			},
+			InitContainers: []corev1.Container{installer},
+			RestartPolicy:  corev1.RestartPolicyAlways,
+			Volumes:        []corev1.Volume{cacheVolume(ws)},
 		},
 	}
 
+	setScheduling(&pod.Spec, ws)
+	setImagePullSecrets(&pod.Spec, ws)
+	setSecurityContext(&pod.Spec, ws)
+	setCABundle(&pod.Spec, ws)
+	if ws.Spec.TerminationGracePeriodSeconds != nil {
+		period := *ws.Spec.TerminationGracePeriodSeconds
+		pod.Spec.TerminationGracePeriodSeconds = &period
+	}
+	setPodMetadata(pod, ws)
+
 	// Set etok's common labels
 	labels.SetCommonLabels(pod)
 	// Permit filtering pods by workspace
@@ -81,3 +72,202 @@ func workspacePod(ws *v1alpha1.Workspace, image string) (*corev1.Pod, error) {
 
 	return pod, nil
 }
+
+// installerContainer returns a container that installs the workspace's version
+// of terraform to the cache, unless the image already provides it.
+func installerContainer(ws *v1alpha1.Workspace, image string) (corev1.Container, error) {
+	script := new(bytes.Buffer)
+	if err := generateScript(script, ws); err != nil {
+		return corev1.Container{}, err
+	}
+
+	return corev1.Container{
+		Name:                     InstallerContainerName,
+		Image:                    image,
+		ImagePullPolicy:          podImagePullPolicy(ws),
+		Command:                  []string{"sh", "-c", script.String()},
+		Resources:                ws.Spec.Resources,
+		TerminationMessagePolicy: "FallbackToLogsOnError",
+		// Download terraform to a directory writable by any user
+		WorkingDir: tmpMountPath,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "cache",
+				MountPath: binMountPath,
+				SubPath:   binSubPath,
+			},
+		},
+	}, nil
+}
+
+// setScheduling sets the workspace's scheduling constraints on a pod spec. Both
+// the workspace pod and run pods must be subject to the same constraints,
+// because a run pod is necessarily scheduled to the same node as the workspace
+// pod.
+func setScheduling(spec *corev1.PodSpec, ws *v1alpha1.Workspace) {
+	spec.NodeSelector = ws.Spec.NodeSelector
+	spec.Tolerations = ws.Spec.Tolerations
+	spec.Affinity = ws.Spec.Affinity.DeepCopy()
+}
+
+// setSecurityContext sets the workspace's security contexts on a pod spec, the
+// container security context applying to every container. If the root
+// filesystem is read-only then an empty dir volume is mounted on /tmp, along
+// with any additional paths to which the pod writes.
+func setSecurityContext(spec *corev1.PodSpec, ws *v1alpha1.Workspace, writablePaths ...string) {
+	spec.SecurityContext = ws.Spec.PodSecurityContext.DeepCopy()
+
+	sc := ws.Spec.SecurityContext
+	if sc == nil {
+		return
+	}
+	for i := range spec.InitContainers {
+		spec.InitContainers[i].SecurityContext = sc.DeepCopy()
+	}
+	for i := range spec.Containers {
+		spec.Containers[i].SecurityContext = sc.DeepCopy()
+	}
+
+	if sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+		return
+	}
+	for _, path := range append([]string{tmpMountPath}, writablePaths...) {
+		// Name volume after the path, e.g. /tmp -> tmp
+		name := filepath.Base(path)
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
+		mount := corev1.VolumeMount{Name: name, MountPath: path}
+		for i := range spec.InitContainers {
+			spec.InitContainers[i].VolumeMounts = append(spec.InitContainers[i].VolumeMounts, mount)
+		}
+		for i := range spec.Containers {
+			spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, mount)
+		}
+	}
+}
+
+// setCABundle adds the workspace's CA bundle, if any, to the system's CA
+// certificates in every container of a pod spec. An init container, added
+// ahead of the others, combines the image's CA certificates with the bundle, and
+// terraform, its providers and other tools are pointed at the combined
+// certificates via environment variables: SSL_CERT_FILE for go binaries and
+// openssl-based tools such as curl, and NODE_EXTRA_CA_CERTS for node.
+func setCABundle(spec *corev1.PodSpec, ws *v1alpha1.Workspace) {
+	if ws.Spec.CABundleSecret == "" {
+		return
+	}
+
+	spec.Volumes = append(spec.Volumes,
+		corev1.Volume{
+			Name: "ca-bundle",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: ws.Spec.CABundleSecret,
+				},
+			},
+		},
+		corev1.Volume{
+			Name: "ca-certs",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
+	)
+
+	certs := filepath.Join(caCertsMountPath, caCertsFile)
+	mount := corev1.VolumeMount{
+		Name:      "ca-certs",
+		MountPath: caCertsMountPath,
+		ReadOnly:  true,
+	}
+	env := []corev1.EnvVar{
+		{Name: "SSL_CERT_FILE", Value: certs},
+		{Name: "NODE_EXTRA_CA_CERTS", Value: certs},
+	}
+	for i := range spec.InitContainers {
+		spec.InitContainers[i].VolumeMounts = append(spec.InitContainers[i].VolumeMounts, mount)
+		spec.InitContainers[i].Env = append(spec.InitContainers[i].Env, env...)
+	}
+	for i := range spec.Containers {
+		spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, mount)
+		spec.Containers[i].Env = append(spec.Containers[i].Env, env...)
+	}
+
+	// The image's CA certificates are found at the path used by alpine and
+	// debian, or else by fedora; an image with neither only trusts the bundle
+	script := fmt.Sprintf("{ cat %s 2>/dev/null || cat %s 2>/dev/null; cat %s; } > %s",
+		"/etc/ssl/certs/ca-certificates.crt",
+		"/etc/pki/tls/certs/ca-bundle.crt",
+		filepath.Join(caBundleMountPath, caBundleKey),
+		certs)
+	spec.InitContainers = append([]corev1.Container{
+		{
+			Name:                     CABundleContainerName,
+			Image:                    spec.Containers[0].Image,
+			ImagePullPolicy:          spec.Containers[0].ImagePullPolicy,
+			Command:                  []string{"sh", "-c", script},
+			SecurityContext:          spec.Containers[0].SecurityContext.DeepCopy(),
+			TerminationMessagePolicy: "FallbackToLogsOnError",
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      "ca-bundle",
+					MountPath: caBundleMountPath,
+					ReadOnly:  true,
+				},
+				{
+					Name:      "ca-certs",
+					MountPath: caCertsMountPath,
+				},
+			},
+		},
+	}, spec.InitContainers...)
+}
+
+// podImage returns the image for the workspace's pods, defaulting to the
+// operator's configured image
+func podImage(ws *v1alpha1.Workspace, image string) string {
+	if ws.Spec.Image != "" {
+		return ws.Spec.Image
+	}
+	return image
+}
+
+// podImagePullPolicy returns the pull policy for the image of the workspace and
+// run pods, defaulting to IfNotPresent
+func podImagePullPolicy(ws *v1alpha1.Workspace) corev1.PullPolicy {
+	if ws.Spec.ImagePullPolicy != "" {
+		return ws.Spec.ImagePullPolicy
+	}
+	return corev1.PullIfNotPresent
+}
+
+// setImagePullSecrets sets the workspace's image pull secrets on a pod spec
+func setImagePullSecrets(spec *corev1.PodSpec, ws *v1alpha1.Workspace) {
+	for _, secret := range ws.Spec.ImagePullSecrets {
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, corev1.LocalObjectReference{Name: secret})
+	}
+}
+
+// setPodMetadata sets the workspace's user-specified annotations and labels on
+// a pod. It must be called before etok's own labels are set, so that they take
+// precedence.
+func setPodMetadata(pod *corev1.Pod, ws *v1alpha1.Workspace) {
+	if len(ws.Spec.PodAnnotations) > 0 {
+		annotations := pod.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		for k, v := range ws.Spec.PodAnnotations {
+			annotations[k] = v
+		}
+		pod.SetAnnotations(annotations)
+	}
+
+	for k, v := range ws.Spec.PodLabels {
+		labels.SetLabel(pod, labels.Label{Name: k, Value: v})
+	}
+}
@@ -1,10 +1,21 @@
 package controllers
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 
 	"github.com/fsouza/fake-gcs-server/fakestorage"
 	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
@@ -15,7 +26,9 @@ import (
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -25,20 +38,30 @@ import (
 
 func TestReconcileWorkspace(t *testing.T) {
 	var localPathStorageClass string = "local-path"
+	var runAsUser int64 = 1000
+	var readOnlyRoot bool = true
 
 	tests := []struct {
 		name                  string
 		workspace             *v1alpha1.Workspace
 		objs                  []runtime.Object
 		bucketObjs            []fakestorage.Object
+		s3Buckets             map[string]map[string][]byte
+		s3AccessDenied        bool
+		kmsDecryptDenied      bool
+		backupOnDelete        bool
+		terraformVersions     []string
 		workspaceAssertions   func(*testutil.T, *v1alpha1.Workspace)
 		podAssertions         func(*testutil.T, *corev1.Pod)
 		pvcAssertions         func(*testutil.T, *corev1.PersistentVolumeClaim)
+		noPVC                 bool
 		configMapAssertions   func(*testutil.T, *corev1.ConfigMap)
 		stateAssertions       func(*testutil.T, *corev1.Secret)
 		storageAssertions     func(*testutil.T, *storage.Client)
+		s3Assertions          func(*testutil.T, *fakeS3)
+		backlogAssertions     func(*testutil.T, *BacklogCheck)
 		disableRBACAssertions bool
-		wantErr               bool
+		wantRequeue           bool
 	}{
 		{
 			name:      "Queue no runs",
@@ -58,6 +81,33 @@ func TestReconcileWorkspace(t *testing.T) {
 				assert.Equal(t, "apply-1", ws.Status.Active)
 			},
 		},
+		{
+			name:      "Paused",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithPaused()),
+			objs: []runtime.Object{
+				testobj.WorkspacePod("", "workspace-1"),
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				paused := meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspacePausedCondition)
+				if assert.NotNil(t, paused) {
+					assert.Equal(t, metav1.ConditionTrue, paused.Status)
+					assert.Equal(t, v1alpha1.PausedReason, paused.Reason)
+				}
+			},
+		},
+		{
+			name:      "Not paused",
+			workspace: testobj.Workspace("", "workspace-1"),
+			objs: []runtime.Object{
+				testobj.WorkspacePod("", "workspace-1"),
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				paused := meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspacePausedCondition)
+				if assert.NotNil(t, paused) {
+					assert.Equal(t, metav1.ConditionFalse, paused.Status)
+				}
+			},
+		},
 		{
 			name:      "Queue two runs",
 			workspace: testobj.Workspace("", "workspace-1"),
@@ -71,6 +121,38 @@ func TestReconcileWorkspace(t *testing.T) {
 				assert.Equal(t, "apply-1", ws.Status.Active)
 				assert.Equal(t, []string{"apply-2"}, ws.Status.Queue)
 			},
+			backlogAssertions: func(t *testutil.T, check *BacklogCheck) {
+				assert.Error(t, check.Check(nil))
+			},
+		},
+		{
+			name:      "Paused workspace excluded from backlog",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithPaused()),
+			objs: []runtime.Object{
+				testobj.WorkspacePod("", "workspace-1"),
+				testobj.Run("", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
+				testobj.Run("", "apply-2", "apply", testobj.WithWorkspace("workspace-1")),
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, []string{"apply-2"}, ws.Status.Queue)
+			},
+			backlogAssertions: func(t *testutil.T, check *BacklogCheck) {
+				assert.NoError(t, check.Check(nil))
+			},
+		},
+		{
+			name:      "Queue runs in order of creation",
+			workspace: testobj.Workspace("", "workspace-1"),
+			objs: []runtime.Object{
+				testobj.WorkspacePod("", "workspace-1"),
+				testobj.Run("", "apply-3", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))),
+				testobj.Run("", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(time.Date(2021, 1, 1, 0, 0, 1, 0, time.UTC))),
+				testobj.Run("", "apply-2", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(time.Date(2021, 1, 1, 0, 0, 2, 0, time.UTC))),
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, "apply-3", ws.Status.Active)
+				assert.Equal(t, []string{"apply-1", "apply-2"}, ws.Status.Queue)
+			},
 		},
 		{
 			name:      "Queue with existing queue",
@@ -98,6 +180,19 @@ func TestReconcileWorkspace(t *testing.T) {
 				assert.Equal(t, want, ws.Annotations)
 			},
 		},
+		{
+			name:      "Pruned review annotation for completed run",
+			workspace: testobj.Workspace("approvals", "workspace-1", testobj.WithRequireApproval(), testobj.WithReviews("apply-1", "apply-2")),
+			objs: []runtime.Object{
+				testobj.WorkspacePod("approvals", "workspace-1"),
+				testobj.Run("approvals", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseCompleted)),
+				testobj.Run("approvals", "apply-2", "apply", testobj.WithWorkspace("workspace-1")),
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				want := map[string]string{"reviews.etok.dev/apply-2": "approved"}
+				assert.Equal(t, want, ws.Annotations)
+			},
+		},
 		{
 			name:      "Initializing phase",
 			workspace: testobj.Workspace("", "workspace-1"),
@@ -142,7 +237,7 @@ func TestReconcileWorkspace(t *testing.T) {
 			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
 				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
 			},
-			wantErr: true,
+			wantRequeue: true,
 		},
 		{
 			name:      "Pod failed",
@@ -151,7 +246,7 @@ func TestReconcileWorkspace(t *testing.T) {
 			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
 				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
 			},
-			wantErr: true,
+			wantRequeue: true,
 		},
 		{
 			name:      "Unknown phase",
@@ -160,7 +255,7 @@ func TestReconcileWorkspace(t *testing.T) {
 			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
 				assert.Equal(t, v1alpha1.WorkspacePhaseUnknown, ws.Status.Phase)
 			},
-			wantErr: true,
+			wantRequeue: true,
 		},
 		{
 			name:      "Cache: Default size",
@@ -177,6 +272,61 @@ func TestReconcileWorkspace(t *testing.T) {
 				assert.Equal(t, "local-path", *pvc.Spec.StorageClassName)
 			},
 		},
+		{
+			name:      "Cache: Default access mode",
+			workspace: testobj.Workspace("", "workspace-1"),
+			pvcAssertions: func(t *testutil.T, pvc *corev1.PersistentVolumeClaim) {
+				assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, pvc.Spec.AccessModes)
+			},
+		},
+		{
+			name:      "Cache: Custom access mode",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithAccessModes(corev1.ReadWriteMany)),
+			pvcAssertions: func(t *testutil.T, pvc *corev1.PersistentVolumeClaim) {
+				assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, pvc.Spec.AccessModes)
+			},
+		},
+		{
+			name:      "Cache: Default volume mode",
+			workspace: testobj.Workspace("", "workspace-1"),
+			pvcAssertions: func(t *testutil.T, pvc *corev1.PersistentVolumeClaim) {
+				assert.Nil(t, pvc.Spec.VolumeMode)
+			},
+		},
+		{
+			name:      "Cache: Filesystem volume mode",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithVolumeMode(corev1.PersistentVolumeFilesystem)),
+			pvcAssertions: func(t *testutil.T, pvc *corev1.PersistentVolumeClaim) {
+				if assert.NotNil(t, pvc.Spec.VolumeMode) {
+					assert.Equal(t, corev1.PersistentVolumeFilesystem, *pvc.Spec.VolumeMode)
+				}
+			},
+		},
+		{
+			name:      "Cache: Ephemeral",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithEphemeralCache()),
+			objs: []runtime.Object{
+				testobj.WorkspacePod("", "workspace-1", testobj.WithPhase(corev1.PodRunning)),
+				testobj.ConfigMap("", v1alpha1.WorkspaceBuiltinsConfigMapName("workspace-1")),
+				&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: RoleName}},
+				&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: RoleBindingName}},
+				&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: ServiceAccountName}},
+				testobj.Secret("", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			},
+			noPVC: true,
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				// Ready without a PVC
+				assert.True(t, meta.IsStatusConditionTrue(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition))
+			},
+		},
+		{
+			name:      "Cache: Ephemeral pod volume",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithEphemeralCache()),
+			noPVC:     true,
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, corev1.Volume{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}, pod.Spec.Volumes[0])
+			},
+		},
 		{
 			name:      "Ownership of dependents",
 			workspace: testobj.Workspace("", "workspace-1", testobj.WithStorageClass(&localPathStorageClass)),
@@ -208,6 +358,353 @@ func TestReconcileWorkspace(t *testing.T) {
 				assert.NotEmpty(t, vars.Data[backendPath])
 			},
 		},
+		{
+			name: "Installer resources",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithResources(
+				corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+				corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+			)),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, "512Mi", pod.Spec.InitContainers[0].Resources.Requests.Memory().String())
+				assert.Equal(t, "1Gi", pod.Spec.InitContainers[0].Resources.Limits.Memory().String())
+			},
+		},
+		{
+			name: "Read-only root filesystem",
+			workspace: testobj.Workspace("", "workspace-1",
+				testobj.WithPodSecurityContext(&corev1.PodSecurityContext{RunAsUser: &runAsUser, FSGroup: &runAsUser}),
+				testobj.WithSecurityContext(&corev1.SecurityContext{ReadOnlyRootFilesystem: &readOnlyRoot}),
+			),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, int64(1000), *pod.Spec.SecurityContext.RunAsUser)
+				assert.Equal(t, int64(1000), *pod.Spec.SecurityContext.FSGroup)
+				for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+					assert.True(t, *c.SecurityContext.ReadOnlyRootFilesystem)
+					assert.Contains(t, c.VolumeMounts, corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"})
+				}
+				// Installer downloads terraform to the writable tmp dir
+				assert.Equal(t, "/tmp", pod.Spec.InitContainers[0].WorkingDir)
+			},
+		},
+		{
+			name:              "Resolve terraform version constraint",
+			workspace:         testobj.Workspace("", "workspace-1", testobj.WithTerraformVersion(">= 1.3, < 1.5")),
+			terraformVersions: []string{"1.3.9", "1.4.6", "1.5.7"},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, "1.4.6", ws.Status.TerraformVersion)
+			},
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.InitContainers[0].Command[2], "Requested terraform version is 1.4.6")
+			},
+		},
+		{
+			name:              "Retain resolved terraform version satisfying constraint",
+			workspace:         testobj.Workspace("", "workspace-1", testobj.WithTerraformVersion(">= 1.3, < 1.5"), testobj.WithResolvedTerraformVersion("1.3.9")),
+			terraformVersions: []string{"1.3.9", "1.4.6", "1.5.7"},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, "1.3.9", ws.Status.TerraformVersion)
+			},
+		},
+		{
+			name:              "No terraform version satisfies constraint",
+			workspace:         testobj.Workspace("", "workspace-1", testobj.WithTerraformVersion(">= 1.6")),
+			terraformVersions: []string{"1.3.9", "1.4.6", "1.5.7"},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				ready := meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition)
+				if assert.NotNil(t, ready) {
+					assert.Equal(t, "no terraform release satisfies constraint: >= 1.6: nearby versions are: 1.5.7", ready.Message)
+				}
+			},
+			wantRequeue: true,
+		},
+		{
+			name:      "Exact terraform version",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithTerraformVersion("0.14.3")),
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, "0.14.3", ws.Status.TerraformVersion)
+			},
+		},
+		{
+			name:      "Default installer resources",
+			workspace: testobj.Workspace("", "workspace-1"),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, corev1.ResourceRequirements{}, pod.Spec.InitContainers[0].Resources)
+			},
+		},
+		{
+			name: "Scheduling",
+			workspace: testobj.Workspace("", "workspace-1",
+				testobj.WithNodeSelector("pool", "terraform"),
+				testobj.WithTolerations(corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "terraform", Effect: corev1.TaintEffectNoSchedule}),
+			),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, map[string]string{"pool": "terraform"}, pod.Spec.NodeSelector)
+				assert.Equal(t, []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "terraform", Effect: corev1.TaintEffectNoSchedule}}, pod.Spec.Tolerations)
+			},
+		},
+		{
+			name: "Affinity",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithAffinity(&corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							{
+								MatchExpressions: []corev1.NodeSelectorRequirement{
+									{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"europe-west2-a"}},
+								},
+							},
+						},
+					},
+				},
+			})),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				terms := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+				assert.Equal(t, "topology.kubernetes.io/zone", terms[0].MatchExpressions[0].Key)
+				assert.Equal(t, []string{"europe-west2-a"}, terms[0].MatchExpressions[0].Values)
+			},
+		},
+		{
+			name:      "Without affinity",
+			workspace: testobj.Workspace("", "workspace-1"),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Nil(t, pod.Spec.Affinity)
+			},
+		},
+		{
+			name:      "Termination grace period",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithTerminationGracePeriod(900)),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				if assert.NotNil(t, pod.Spec.TerminationGracePeriodSeconds) {
+					assert.Equal(t, int64(900), *pod.Spec.TerminationGracePeriodSeconds)
+				}
+			},
+		},
+		{
+			name:      "Image pull secrets",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithImagePullSecrets("registry-creds")),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, []corev1.LocalObjectReference{{Name: "registry-creds"}}, pod.Spec.ImagePullSecrets)
+			},
+		},
+		{
+			name:      "Workspace image",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithImage("acme/etok-terraform:1.4.6")),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, "acme/etok-terraform:1.4.6", pod.Spec.InitContainers[0].Image)
+				assert.Equal(t, "acme/etok-terraform:1.4.6", pod.Spec.Containers[0].Image)
+			},
+		},
+		{
+			name:      "Workspace image pull policy",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithImagePullPolicy(corev1.PullAlways)),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, corev1.PullAlways, pod.Spec.InitContainers[0].ImagePullPolicy)
+				assert.Equal(t, corev1.PullAlways, pod.Spec.Containers[0].ImagePullPolicy)
+			},
+		},
+		{
+			name:      "CA bundle",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithCABundleSecret("ca-bundle")),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Contains(t, pod.Spec.Volumes, corev1.Volume{
+					Name: "ca-bundle",
+					VolumeSource: corev1.VolumeSource{
+						Secret: &corev1.SecretVolumeSource{
+							SecretName: "ca-bundle",
+						},
+					},
+				})
+				// The bundle is combined with the system's CA certificates
+				// before the installer runs
+				assert.Equal(t, CABundleContainerName, pod.Spec.InitContainers[0].Name)
+				mount := corev1.VolumeMount{
+					Name:      "ca-certs",
+					MountPath: "/etc/ssl/etok",
+					ReadOnly:  true,
+				}
+				// The installer downloads terraform, so must trust the bundle
+				// too
+				for _, c := range append(pod.Spec.InitContainers[1:], pod.Spec.Containers...) {
+					assert.Contains(t, c.VolumeMounts, mount)
+					assert.Contains(t, c.Env, corev1.EnvVar{Name: "SSL_CERT_FILE", Value: "/etc/ssl/etok/ca-certificates.crt"})
+					assert.Contains(t, c.Env, corev1.EnvVar{Name: "NODE_EXTRA_CA_CERTS", Value: "/etc/ssl/etok/ca-certificates.crt"})
+				}
+			},
+		},
+		{
+			name:      "Pod annotations and labels",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithPodAnnotations("sidecar.istio.io/inject", "false"), testobj.WithPodLabels("team", "infra", "app", "terraform")),
+			podAssertions: func(t *testutil.T, pod *corev1.Pod) {
+				assert.Equal(t, "false", pod.Annotations["sidecar.istio.io/inject"])
+				// User labels
+				assert.Equal(t, "infra", pod.Labels["team"])
+				// Etok labels
+				assert.Equal(t, "workspace-1", pod.Labels["workspace"])
+				assert.Equal(t, "workspace", pod.Labels["component"])
+				// Etok label takes precedence over user label
+				assert.Equal(t, "etok", pod.Labels["app"])
+			},
+		},
+		{
+			name:      "Default kubernetes backend",
+			workspace: testobj.Workspace("", "workspace-1"),
+			configMapAssertions: func(t *testutil.T, vars *corev1.ConfigMap) {
+				assert.Contains(t, vars.Data[backendPath], `backend "kubernetes" {}`)
+				assert.NotContains(t, vars.Data, backendConfigPath)
+			},
+		},
+		{
+			name:      "S3 backend",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("s3", "bucket", "my-bucket", "key", "terraform.tfstate", "region", "eu-west-2", "dynamodb_table", "locks")),
+			configMapAssertions: func(t *testutil.T, vars *corev1.ConfigMap) {
+				assert.Contains(t, vars.Data[backendPath], `backend "s3" {}`)
+				assert.Contains(t, vars.Data[backendConfigPath], `bucket = "my-bucket"`)
+				assert.Contains(t, vars.Data[backendConfigPath], `key = "terraform.tfstate"`)
+				assert.Contains(t, vars.Data[backendConfigPath], `region = "eu-west-2"`)
+				assert.Contains(t, vars.Data[backendConfigPath], `dynamodb_table = "locks"`)
+			},
+		},
+		{
+			name:      "Raw backend",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithRawBackend("consul", "terraform {\n  backend \"consul\" {\n    path = \"etok\"\n  }\n}\n")),
+			configMapAssertions: func(t *testutil.T, vars *corev1.ConfigMap) {
+				assert.Equal(t, "terraform {\n  backend \"consul\" {\n    path = \"etok\"\n  }\n}\n", vars.Data[backendPath])
+			},
+		},
+		{
+			name:      "Raw backend type mismatch",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithRawBackend("s3", "terraform {\n  backend \"consul\" {}\n}\n")),
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				ready := meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition)
+				if assert.NotNil(t, ready) {
+					assert.Equal(t, "Invalid backend: raw backend declares consul backend but backend type is s3", ready.Message)
+				}
+			},
+			wantRequeue: true,
+			// Invalid backend fails reconcile before RBAC resources are created
+			disableRBACAssertions: true,
+		},
+		{
+			name:      "AzureRM backend",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("azurerm", "resource_group_name", "my-rg", "storage_account_name", "myaccount", "container_name", "tfstate", "key", "terraform.tfstate", "snapshot", "")),
+			configMapAssertions: func(t *testutil.T, vars *corev1.ConfigMap) {
+				assert.Contains(t, vars.Data[backendPath], `backend "azurerm" {}`)
+				assert.Contains(t, vars.Data[backendConfigPath], `resource_group_name = "my-rg"`)
+				assert.Contains(t, vars.Data[backendConfigPath], `storage_account_name = "myaccount"`)
+				assert.Contains(t, vars.Data[backendConfigPath], `container_name = "tfstate"`)
+				assert.Contains(t, vars.Data[backendConfigPath], `key = "terraform.tfstate"`)
+				// Empty values are omitted
+				assert.NotContains(t, vars.Data[backendConfigPath], "snapshot")
+			},
+		},
+		{
+			name:      "Remote backend",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("remote", "hostname", "tfe.example.com", "organization", "acme", "name", "networking")),
+			configMapAssertions: func(t *testutil.T, vars *corev1.ConfigMap) {
+				assert.Contains(t, vars.Data[backendPath], `backend "remote" {}`)
+				assert.Contains(t, vars.Data[backendConfigPath], `hostname = "tfe.example.com"`)
+				assert.Contains(t, vars.Data[backendConfigPath], `organization = "acme"`)
+				assert.Contains(t, vars.Data[backendConfigPath], "workspaces {\n  name = \"networking\"\n}\n")
+			},
+		},
+		{
+			name:      "Remote backend requires one of name or prefix",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("remote", "organization", "acme", "name", "networking", "prefix", "networking-")),
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+			},
+			wantRequeue: true,
+			// Invalid backend fails reconcile before RBAC resources are created
+			disableRBACAssertions: true,
+		},
+		{
+			// Remote backend manages its own state so there is nothing to
+			// restore, even if a backup bucket is specified
+			name:      "Remote backend skips restore",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("remote", "organization", "acme", "prefix", "networking-"), testobj.WithBackupBucket("does-not-exist")),
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.NotEqual(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				assert.Nil(t, ws.Status.BackupSerial)
+			},
+		},
+		{
+			name:      "HTTP backend",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("http", "address", "https://gitlab.com/api/v4/projects/1/terraform/state/prod", "lock_address", "https://gitlab.com/api/v4/projects/1/terraform/state/prod/lock", "lock_method", "POST")),
+			configMapAssertions: func(t *testutil.T, vars *corev1.ConfigMap) {
+				assert.Contains(t, vars.Data[backendPath], `backend "http" {}`)
+				assert.Contains(t, vars.Data[backendConfigPath], `address = "https://gitlab.com/api/v4/projects/1/terraform/state/prod"`)
+				assert.Contains(t, vars.Data[backendConfigPath], `lock_address = "https://gitlab.com/api/v4/projects/1/terraform/state/prod/lock"`)
+				assert.Contains(t, vars.Data[backendConfigPath], `lock_method = "POST"`)
+			},
+		},
+		{
+			name:      "HTTP backend skips restore",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("http", "address", "https://example.com/state"), testobj.WithBackupBucket("does-not-exist")),
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.NotEqual(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				assert.Nil(t, ws.Status.BackupSerial)
+			},
+		},
+		{
+			name:      "Consul backend",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("consul", "address", "consul.example.com:8500", "path", "etok/workspace-1", "scheme", "https", "datacenter", "dc1")),
+			configMapAssertions: func(t *testutil.T, vars *corev1.ConfigMap) {
+				assert.Contains(t, vars.Data[backendPath], `backend "consul" {}`)
+				assert.Equal(t, "address = \"consul.example.com:8500\"\ndatacenter = \"dc1\"\npath = \"etok/workspace-1\"\nscheme = \"https\"\n", vars.Data[backendConfigPath])
+			},
+		},
+		{
+			name:      "Consul backend skips restore",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("consul", "path", "etok/workspace-1"), testobj.WithBackupBucket("does-not-exist")),
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.NotEqual(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				assert.Nil(t, ws.Status.BackupSerial)
+			},
+		},
+		{
+			name:      "Consul backend missing path",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("consul", "address", "consul.example.com:8500")),
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				ready := meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition)
+				if assert.NotNil(t, ready) {
+					assert.Equal(t, "Invalid backend: consul backend requires config key: path", ready.Message)
+				}
+			},
+			wantRequeue: true,
+			// Invalid backend fails reconcile before RBAC resources are created
+			disableRBACAssertions: true,
+		},
+		{
+			name:      "HTTP backend missing address",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("http", "lock_address", "https://example.com/state/lock")),
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				ready := meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition)
+				if assert.NotNil(t, ready) {
+					assert.Equal(t, "Invalid backend: http backend requires config key: address", ready.Message)
+				}
+			},
+			wantRequeue: true,
+			// Invalid backend fails reconcile before RBAC resources are created
+			disableRBACAssertions: true,
+		},
+		{
+			name:      "S3 backend missing bucket",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackend("s3", "key", "terraform.tfstate", "region", "eu-west-2")),
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				ready := meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition)
+				if assert.NotNil(t, ready) {
+					assert.Equal(t, "Invalid backend: s3 backend requires config key: bucket", ready.Message)
+				}
+			},
+			wantRequeue: true,
+			// Invalid backend fails reconcile before RBAC resources are created
+			disableRBACAssertions: true,
+		},
 		{
 			name:      "Outputs",
 			workspace: testobj.Workspace("", "workspace-1"),
@@ -240,6 +737,13 @@ func TestReconcileWorkspace(t *testing.T) {
 				obj := client.Bucket("backup-bucket").Object("default/workspace-1.yaml")
 				_, err := obj.Attrs(context.Background())
 				require.NoError(t, err)
+
+				// Check checksum recorded alongside backup
+				backup, err := (&gcsProvider{client: client}).Restore(context.Background(), "backup-bucket", "default/workspace-1.yaml")
+				require.NoError(t, err)
+				sum, err := (&gcsProvider{client: client}).Restore(context.Background(), "backup-bucket", "default/workspace-1.yaml.sha256")
+				require.NoError(t, err)
+				assert.Equal(t, checksum(backup), string(sum))
 			},
 			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
 				assert.Equal(t, 4, *ws.Status.BackupSerial)
@@ -268,21 +772,477 @@ func TestReconcileWorkspace(t *testing.T) {
 				assert.Equal(t, 4, *ws.Status.BackupSerial)
 			}},
 		{
-			name:      "Non-existent backup bucket",
-			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackupBucket("does-not-exist")),
-			objs: []runtime.Object{
-				testobj.Secret("dev", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			name:      "Restore checksum mismatch",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket")),
+			bucketObjs: []fakestorage.Object{
+				{
+					// Mock a truncated upload
+					BucketName: "backup-bucket",
+					Name:       "default/workspace-1.yaml",
+					Content:    readFile("testdata/tfstate.yaml")[:100],
+				},
+				{
+					BucketName: "backup-bucket",
+					Name:       "default/workspace-1.yaml.sha256",
+					Content:    []byte(checksum(readFile("testdata/tfstate.yaml"))),
+				},
 			},
-			wantErr: true,
+			wantRequeue: true,
 			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
 				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				assert.True(t, strings.HasPrefix(meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition).Message, "RestoreError: backup-bucket/default/workspace-1.yaml: checksum mismatch"))
+				assert.Nil(t, ws.Status.BackupSerial)
 			},
 		},
-	}
-	for _, tt := range tests {
-		testutil.Run(t, tt.name, func(t *testutil.T) {
-			objs := append(tt.objs, runtime.Object(tt.workspace))
-			cl := fake.NewFakeClientWithScheme(scheme.Scheme, objs...)
+		{
+			name:      "Restore skipped",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket")),
+			bucketObjs: []fakestorage.Object{
+				{
+					BucketName: "backup-bucket",
+				},
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Nil(t, ws.Status.BackupSerial)
+				assert.NotEqual(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+			},
+		},
+		{
+			name:      "Restore generation",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithAnnotations(v1alpha1.RestoreGenerationAnnotationKey, "1617191435237187")),
+			bucketObjs: []fakestorage.Object{
+				{
+					BucketName: "backup-bucket",
+					Name:       "default/workspace-1.yaml",
+					Content:    readFile("testdata/tfstate.yaml"),
+					Generation: 1617191435237187,
+				},
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, 4, *ws.Status.Serial)
+				assert.Equal(t, int64(1617191435237187), *ws.Status.RestoredGeneration)
+				assert.NotContains(t, ws.Annotations, v1alpha1.RestoreGenerationAnnotationKey)
+			},
+			stateAssertions: func(t *testutil.T, secret *corev1.Secret) {
+				assert.NotEmpty(t, secret.Data["tfstate"])
+			},
+		},
+		{
+			name:      "Restore non-existent generation",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithAnnotations(v1alpha1.RestoreGenerationAnnotationKey, "1234")),
+			bucketObjs: []fakestorage.Object{
+				{
+					BucketName: "backup-bucket",
+					Name:       "default/workspace-1.yaml",
+					Content:    readFile("testdata/tfstate.yaml"),
+					Generation: 1617191435237187,
+				},
+			},
+			wantRequeue: true,
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				assert.Equal(t, "RestoreError: generation 1234 of backup backup-bucket/default/workspace-1.yaml does not exist", meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition).Message)
+				// Latest backup is not restored in its place
+				assert.Nil(t, ws.Status.Serial)
+				// Annotation is retained until the generation is restored
+				assert.Contains(t, ws.Annotations, v1alpha1.RestoreGenerationAnnotationKey)
+			},
+		},
+		{
+			name:      "Restore generation with S3 provider",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithAnnotations(v1alpha1.RestoreGenerationAnnotationKey, "1234")),
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {
+					"default/workspace-1.yaml": readFile("testdata/tfstate.yaml"),
+				},
+			},
+			wantRequeue: true,
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				assert.Equal(t, "RestoreError: restoring a generation requires the gcs backup provider", meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition).Message)
+			},
+		},
+		{
+			name:      "Restore generation with S3 backend",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackend("s3", "bucket", "my-state", "key", "terraform.tfstate", "region", "eu-west-2"), testobj.WithAnnotations(v1alpha1.RestoreGenerationAnnotationKey, "1617191435237187")),
+			bucketObjs: []fakestorage.Object{
+				{
+					BucketName: "backup-bucket",
+					Name:       "default/workspace-1.yaml",
+					Content:    readFile("testdata/tfstate.yaml"),
+					Generation: 1617191435237187,
+				},
+			},
+			wantRequeue: true,
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				assert.Equal(t, "RestoreError: restore requires the kubernetes backend: backend type is s3", meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition).Message)
+				// Annotation is removed because the restore is never possible
+				assert.NotContains(t, ws.Annotations, v1alpha1.RestoreGenerationAnnotationKey)
+			},
+		},
+		{
+			name:      "Non-existent backup bucket",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithBackupBucket("does-not-exist")),
+			objs: []runtime.Object{
+				testobj.Secret("dev", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			},
+			wantRequeue: true,
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+			},
+		},
+		{
+			name:      "S3 backup",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3")),
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {},
+			},
+			s3Assertions: func(t *testutil.T, client *fakeS3) {
+				// Check objects exist in bucket
+				assert.Contains(t, client.buckets["backup-bucket"], "default/workspace-1.yaml")
+				assert.Contains(t, client.buckets["backup-bucket"], "default/workspace-1/4-20210101T000000Z.yaml")
+
+				// Check checksums recorded alongside backups
+				for _, key := range []string{"default/workspace-1.yaml", "default/workspace-1/4-20210101T000000Z.yaml"} {
+					assert.Equal(t, checksum(client.buckets["backup-bucket"][key]), string(client.buckets["backup-bucket"][key+".sha256"]))
+				}
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, 4, *ws.Status.BackupSerial)
+			},
+		},
+		{
+			name:           "Add backup finalizers",
+			workspace:      testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3")),
+			backupOnDelete: true,
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {},
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Contains(t, ws.GetFinalizers(), backupFinalizer)
+			},
+			stateAssertions: func(t *testutil.T, state *corev1.Secret) {
+				assert.Contains(t, state.GetFinalizers(), backupFinalizer)
+			},
+		},
+		{
+			name:      "Backup finalizers not added when backup on delete disabled",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3")),
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {},
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.NotContains(t, ws.GetFinalizers(), backupFinalizer)
+			},
+			stateAssertions: func(t *testutil.T, state *corev1.Secret) {
+				assert.NotContains(t, state.GetFinalizers(), backupFinalizer)
+			},
+		},
+		{
+			name:           "S3 backup on delete",
+			workspace:      testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithDeleteTimestamp(), testobj.WithFinalizers(metav1.FinalizerDeleteDependents, backupFinalizer)),
+			backupOnDelete: true,
+			// RBAC resources won't have been created so don't check for them
+			disableRBACAssertions: true,
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json"), testobj.WithSecretFinalizers(backupFinalizer)),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {},
+			},
+			s3Assertions: func(t *testutil.T, client *fakeS3) {
+				assert.Contains(t, client.buckets["backup-bucket"], "default/workspace-1.yaml")
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.NotContains(t, ws.GetFinalizers(), backupFinalizer)
+			},
+			stateAssertions: func(t *testutil.T, state *corev1.Secret) {
+				assert.NotContains(t, state.GetFinalizers(), backupFinalizer)
+			},
+		},
+		{
+			name:           "S3 backup on delete skipped when already backed up",
+			workspace:      testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithBackupSerial(4), testobj.WithDeleteTimestamp(), testobj.WithFinalizers(metav1.FinalizerDeleteDependents, backupFinalizer)),
+			backupOnDelete: true,
+			// RBAC resources won't have been created so don't check for them
+			disableRBACAssertions: true,
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json"), testobj.WithSecretFinalizers(backupFinalizer)),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {},
+			},
+			s3Assertions: func(t *testutil.T, client *fakeS3) {
+				assert.Empty(t, client.buckets["backup-bucket"])
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.NotContains(t, ws.GetFinalizers(), backupFinalizer)
+			},
+			stateAssertions: func(t *testutil.T, state *corev1.Secret) {
+				assert.NotContains(t, state.GetFinalizers(), backupFinalizer)
+			},
+		},
+		{
+			name:      "S3 backup with retention",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithBackupRetention(2)),
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {
+					"default/workspace-1/1.yaml":  []byte("state #1"),
+					"default/workspace-1/2.yaml":  []byte("state #2"),
+					"default/workspace-1/3.yaml":  []byte("state #3"),
+					"default/workspace-10/1.yaml": []byte("another workspace"),
+				},
+			},
+			s3Assertions: func(t *testutil.T, client *fakeS3) {
+				assert.Contains(t, client.buckets["backup-bucket"], "default/workspace-1/4-20210101T000000Z.yaml")
+				assert.Contains(t, client.buckets["backup-bucket"], "default/workspace-1/3.yaml")
+				assert.NotContains(t, client.buckets["backup-bucket"], "default/workspace-1/2.yaml")
+				assert.NotContains(t, client.buckets["backup-bucket"], "default/workspace-1/1.yaml")
+				// Other workspaces' backups are left alone
+				assert.Contains(t, client.buckets["backup-bucket"], "default/workspace-10/1.yaml")
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, 4, *ws.Status.BackupSerial)
+			},
+		},
+		{
+			name:      "S3 backup re-using serial of older backup",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3")),
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {
+					"default/workspace-1/4-20200101T000000Z.yaml": []byte("state #4 before restoring an older version"),
+				},
+			},
+			s3Assertions: func(t *testutil.T, client *fakeS3) {
+				assert.Contains(t, client.buckets["backup-bucket"], "default/workspace-1/4-20210101T000000Z.yaml")
+				// Older backup with the same serial is not overwritten
+				assert.Equal(t, "state #4 before restoring an older version", string(client.buckets["backup-bucket"]["default/workspace-1/4-20200101T000000Z.yaml"]))
+			},
+		},
+		{
+			name:      "S3 backup with retention pruning oldest backups rather than lowest serials",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithBackupRetention(2)),
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {
+					"default/workspace-1/5-20200101T000000Z.yaml": []byte("state #5"),
+					"default/workspace-1/6-20200102T000000Z.yaml": []byte("state #6"),
+					"default/workspace-1/2-20200103T000000Z.yaml": []byte("state #2 restored and backed up"),
+				},
+			},
+			s3Assertions: func(t *testutil.T, client *fakeS3) {
+				assert.Contains(t, client.buckets["backup-bucket"], "default/workspace-1/4-20210101T000000Z.yaml")
+				assert.Contains(t, client.buckets["backup-bucket"], "default/workspace-1/2-20200103T000000Z.yaml")
+				assert.NotContains(t, client.buckets["backup-bucket"], "default/workspace-1/6-20200102T000000Z.yaml")
+				assert.NotContains(t, client.buckets["backup-bucket"], "default/workspace-1/5-20200101T000000Z.yaml")
+			},
+		},
+		{
+			name:      "S3 restore version",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithAnnotations(v1alpha1.RestoreVersionAnnotationKey, "4")),
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithStringData("tfstate", "out of date")),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {
+					"default/workspace-1/4.yaml": readFile("testdata/tfstate.yaml"),
+				},
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, 4, *ws.Status.Serial)
+				assert.NotContains(t, ws.Annotations, v1alpha1.RestoreVersionAnnotationKey)
+			},
+			stateAssertions: func(t *testutil.T, secret *corev1.Secret) {
+				assert.NotEqual(t, "out of date", string(secret.Data["tfstate"]))
+			},
+		},
+		{
+			name:      "S3 restore most recent backup of version",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithAnnotations(v1alpha1.RestoreVersionAnnotationKey, "4")),
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithStringData("tfstate", "out of date")),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {
+					"default/workspace-1/4-20200101T000000Z.yaml": []byte("not a secret"),
+					"default/workspace-1/4-20200102T000000Z.yaml": readFile("testdata/tfstate.yaml"),
+				},
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, 4, *ws.Status.Serial)
+			},
+			stateAssertions: func(t *testutil.T, secret *corev1.Secret) {
+				assert.NotEqual(t, "out of date", string(secret.Data["tfstate"]))
+			},
+		},
+		{
+			name:      "S3 restore non-existent version",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithAnnotations(v1alpha1.RestoreVersionAnnotationKey, "3")),
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {},
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Nil(t, ws.Status.Serial)
+				assert.NotContains(t, ws.Annotations, v1alpha1.RestoreVersionAnnotationKey)
+			},
+		},
+		{
+			name:      "S3 restore",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3")),
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {
+					"default/workspace-1.yaml": readFile("testdata/tfstate.yaml"),
+				},
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, 4, *ws.Status.BackupSerial)
+			},
+		},
+		{
+			name:      "S3 encrypted backup",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithBackupKMSKey("alias/etok")),
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			},
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {},
+			},
+			s3Assertions: func(t *testutil.T, client *fakeS3) {
+				for _, key := range []string{"default/workspace-1.yaml", "default/workspace-1/4-20210101T000000Z.yaml"} {
+					eb, ok := isEncryptedBackup(client.buckets["backup-bucket"][key])
+					if assert.True(t, ok) {
+						assert.Equal(t, "alias/etok", eb.KMSKey)
+					}
+				}
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, 4, *ws.Status.BackupSerial)
+			},
+		},
+		{
+			// Encrypted backups are restored regardless of whether encryption
+			// is still enabled
+			name:      "S3 encrypted restore",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3")),
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {
+					"default/workspace-1.yaml": encryptFile("testdata/tfstate.yaml", "alias/etok"),
+				},
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, 4, *ws.Status.BackupSerial)
+			},
+		},
+		{
+			name:      "S3 encrypted restore decryption failure",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithBackupKMSKey("alias/etok")),
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {
+					"default/workspace-1.yaml": encryptFile("testdata/tfstate.yaml", "alias/etok"),
+				},
+			},
+			kmsDecryptDenied: true,
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				ready := meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition)
+				if assert.NotNil(t, ready) {
+					assert.True(t, strings.HasPrefix(ready.Message, "DecryptionError: backup-bucket/default/workspace-1.yaml: unable to decrypt backup"))
+				}
+			},
+			wantRequeue: true,
+		},
+		{
+			name:      "S3 restore skipped",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3")),
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {},
+			},
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Nil(t, ws.Status.BackupSerial)
+			},
+		},
+		{
+			name:      "S3 restore access denied",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3")),
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {},
+			},
+			s3AccessDenied: true,
+			wantRequeue:    true,
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				assert.Equal(t, "RestoreError: Access Denied", meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition).Message)
+			},
+		},
+		{
+			name:      "S3 restore checksum mismatch",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3")),
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {
+					// Mock a truncated upload
+					"default/workspace-1.yaml":        readFile("testdata/tfstate.yaml")[:100],
+					"default/workspace-1.yaml.sha256": []byte(checksum(readFile("testdata/tfstate.yaml"))),
+				},
+			},
+			wantRequeue: true,
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				assert.True(t, strings.HasPrefix(meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition).Message, "RestoreError: backup-bucket/default/workspace-1.yaml: checksum mismatch"))
+				assert.Nil(t, ws.Status.BackupSerial)
+			},
+		},
+		{
+			name:      "S3 restore corrupt backup",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3")),
+			s3Buckets: map[string]map[string][]byte{
+				"backup-bucket": {
+					"default/workspace-1.yaml": []byte("not a secret"),
+				},
+			},
+			wantRequeue: true,
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+				assert.Contains(t, meta.FindStatusCondition(ws.Status.Conditions, v1alpha1.WorkspaceReadyCondition).Message, "RestoreError: unable to parse backup backup-bucket/default/workspace-1.yaml")
+				assert.Nil(t, ws.Status.BackupSerial)
+			},
+		},
+		{
+			name:      "S3 non-existent backup bucket",
+			workspace: testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("does-not-exist"), testobj.WithBackupProvider("s3")),
+			objs: []runtime.Object{
+				testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithCompressedDataFromFile("tfstate", "testdata/tfstate.json")),
+			},
+			wantRequeue: true,
+			workspaceAssertions: func(t *testutil.T, ws *v1alpha1.Workspace) {
+				assert.Equal(t, v1alpha1.WorkspacePhaseError, ws.Status.Phase)
+			},
+		},
+	}
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			objs := append(tt.objs, runtime.Object(tt.workspace))
+			cl := fake.NewFakeClientWithScheme(scheme.Scheme, objs...)
 
 			// Setup up new fake GCS server for each test
 			server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
@@ -294,14 +1254,20 @@ func TestReconcileWorkspace(t *testing.T) {
 			defer server.Stop()
 
 			// Reconcile
-			r := NewWorkspaceReconciler(cl, "", WithStorageClient(server.Client()), WithEventRecorder(record.NewFakeRecorder(100)))
+			// Setup up new fake S3 client for each test
+			s3client := &fakeS3{buckets: tt.s3Buckets, denied: tt.s3AccessDenied}
+
+			// Fail the backlog check as soon as any workspace has queued runs
+			backlog := NewBacklogCheck(0, 0)
+
+			r := NewWorkspaceReconciler(cl, "", WithStorageClient(server.Client()), WithS3Client(s3client), WithEventRecorder(record.NewFakeRecorder(100)), WithBackupOnDelete(tt.backupOnDelete), WithBacklogCheck(backlog), WithKMSClient(&fakeKMS{denyDecrypt: tt.kmsDecryptDenied}), WithTerraformVersionLister(fakeTerraformVersionLister(tt.terraformVersions)))
+			// Take versioned backups at a fixed time
+			r.now = func() time.Time { return backupTime }
 			req := requestFromObject(tt.workspace)
-			_, err = r.Reconcile(context.Background(), req)
-			if tt.wantErr {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-			}
+			res, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			// Failed reconciles are requeued with a backoff
+			assert.Equal(t, tt.wantRequeue, res.RequeueAfter > 0)
 
 			// Fetch fresh workspace for assertions
 			if tt.workspaceAssertions != nil {
@@ -335,10 +1301,23 @@ func TestReconcileWorkspace(t *testing.T) {
 				tt.pvcAssertions(t, &cache)
 			}
 
+			if tt.noPVC {
+				err := r.Get(context.TODO(), types.NamespacedName{Namespace: tt.workspace.Namespace, Name: tt.workspace.PVCName()}, &corev1.PersistentVolumeClaim{})
+				assert.True(t, kerrors.IsNotFound(err))
+			}
+
 			if tt.storageAssertions != nil {
 				tt.storageAssertions(t, r.StorageClient)
 			}
 
+			if tt.s3Assertions != nil {
+				tt.s3Assertions(t, s3client)
+			}
+
+			if tt.backlogAssertions != nil {
+				tt.backlogAssertions(t, backlog)
+			}
+
 			// RBAC resources should always have been created so check them
 			// unless explicitly told not to
 			if !tt.disableRBACAssertions {
@@ -354,3 +1333,172 @@ func TestReconcileWorkspace(t *testing.T) {
 		})
 	}
 }
+
+// fakeTerraformVersionLister lists a fixed set of terraform versions
+type fakeTerraformVersionLister []string
+
+func (l fakeTerraformVersionLister) List(ctx context.Context) ([]string, error) {
+	return l, nil
+}
+
+// fakeS3 is an in-memory implementation of the S3 API methods used for
+// backups, keyed by bucket and then object key
+type fakeS3 struct {
+	s3iface.S3API
+
+	buckets map[string]map[string][]byte
+
+	// denied causes all requests to be refused, as if the operator lacked
+	// permissions on the bucket
+	denied bool
+}
+
+func (f *fakeS3) HeadBucketWithContext(ctx aws.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, error) {
+	if f.denied {
+		return nil, awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "")
+	}
+	if _, ok := f.buckets[*input.Bucket]; !ok {
+		return nil, awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), 404, "")
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	objects, ok := f.buckets[*input.Bucket]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+	}
+	data, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	objects[*input.Key] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) ListObjectsV2WithContext(ctx aws.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, error) {
+	objects, ok := f.buckets[*input.Bucket]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+	}
+	out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	for k := range objects {
+		if strings.HasPrefix(k, aws.StringValue(input.Prefix)) {
+			out.Contents = append(out.Contents, &s3.Object{Key: aws.String(k)})
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeS3) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	objects, ok := f.buckets[*input.Bucket]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+	}
+	delete(objects, *input.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	objects, ok := f.buckets[*input.Bucket]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+	}
+	data, ok := objects[*input.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func TestReconcileWorkspaceBackoff(t *testing.T) {
+	// Workspace with an invalid backend, which fails every reconcile
+	ws := testobj.Workspace("", "workspace-1", testobj.WithBackend("s3", "key", "terraform.tfstate", "region", "eu-west-2"))
+	cl := fake.NewFakeClientWithScheme(scheme.Scheme, ws)
+
+	r := NewWorkspaceReconciler(cl, "", WithEventRecorder(record.NewFakeRecorder(100)), WithRequeueBackoff(time.Second, 5*time.Second))
+	req := requestFromObject(ws)
+
+	// Delay doubles with each failed reconcile, up to the max delay
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		res, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, want, res.RequeueAfter)
+
+		var got v1alpha1.Workspace
+		require.NoError(t, r.Get(context.Background(), req.NamespacedName, &got))
+		assert.Equal(t, i+1, got.Status.ReconcileAttempts)
+	}
+
+	// Fix backend and check the attempt counter is reset
+	var fixed v1alpha1.Workspace
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, &fixed))
+	fixed.Spec.Backend.Config["bucket"] = "my-bucket"
+	require.NoError(t, r.Update(context.Background(), &fixed))
+
+	res, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), res.RequeueAfter)
+
+	var got v1alpha1.Workspace
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, &got))
+	assert.Equal(t, 0, got.Status.ReconcileAttempts)
+}
+
+// backupTime is the time at which tests take versioned backups
+var backupTime = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestReconcileWorkspaceRestoreThenBackup(t *testing.T) {
+	// Workspace with state #5 backed up, restoring state #4
+	ws := testobj.Workspace("default", "workspace-1", testobj.WithBackupBucket("backup-bucket"), testobj.WithBackupProvider("s3"), testobj.WithBackupSerial(5), testobj.WithAnnotations(v1alpha1.RestoreVersionAnnotationKey, "4"))
+	secret := testobj.Secret("default", "tfstate-default-workspace-1", testobj.WithData("tfstate", string(stateWithSerial(t, 5))))
+	cl := fake.NewFakeClientWithScheme(scheme.Scheme, ws, secret)
+
+	s3client := &fakeS3{buckets: map[string]map[string][]byte{
+		"backup-bucket": {
+			"default/workspace-1/4-20200101T000000Z.yaml": readFile("testdata/tfstate.yaml"),
+			"default/workspace-1/5-20200102T000000Z.yaml": []byte("state #5 before restoring state #4"),
+		},
+	}}
+
+	r := NewWorkspaceReconciler(cl, "", WithS3Client(s3client), WithEventRecorder(record.NewFakeRecorder(100)))
+	r.now = func() time.Time { return backupTime }
+	req := requestFromObject(ws)
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	var restored v1alpha1.Workspace
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, &restored))
+	assert.Equal(t, 4, *restored.Status.Serial)
+
+	// Apply, incrementing the serial of the restored state, re-using #5
+	var state corev1.Secret
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: ws.StateSecretName()}, &state))
+	state.Data["tfstate"] = stateWithSerial(t, 5)
+	require.NoError(t, r.Update(context.Background(), &state))
+
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	var backedUp v1alpha1.Workspace
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, &backedUp))
+	assert.Equal(t, 5, *backedUp.Status.BackupSerial)
+
+	// New state #5 is backed up alongside the old state #5
+	assert.Contains(t, s3client.buckets["backup-bucket"], "default/workspace-1/5-20210101T000000Z.yaml")
+	assert.Equal(t, "state #5 before restoring state #4", string(s3client.buckets["backup-bucket"]["default/workspace-1/5-20200102T000000Z.yaml"]))
+}
+
+// stateWithSerial returns the compressed test state file with the serial
+// number replaced
+func stateWithSerial(t *testing.T, serial int) []byte {
+	data := strings.Replace(string(readFile("testdata/tfstate.json")), `"serial": 4`, fmt.Sprintf(`"serial": %d`, serial), 1)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"sort"
+
 	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
 	"github.com/leg100/etok/cmd/launcher"
 	"github.com/leg100/etok/pkg/util/slice"
@@ -9,11 +11,23 @@ import (
 // updateCombinedQueue updates a workspace's combined queue (the active run +
 // the queue) with the given list of runs.  Runs in the existing queue are
 // expunged if they meet certain criteria.  If they are not expunged they
-// mantain their position.
+// mantain their position. New runs are queued in the order in which they were
+// created.
 func updateCombinedQueue(ws *v1alpha1.Workspace, runs []v1alpha1.Run) {
 	newQ := []string{}
 	currQ := append([]string{ws.Status.Active}, ws.Status.Queue...)
 
+	// List order is not guaranteed so sort runs by creation time, falling back
+	// to name for runs created at the same time
+	runs = append([]v1alpha1.Run(nil), runs...)
+	sort.SliceStable(runs, func(i, j int) bool {
+		ti, tj := runs[i].CreationTimestamp, runs[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return runs[i].Name < runs[j].Name
+	})
+
 	// Filter run resources
 	for _, run := range runs {
 		// Filter out runs belonging to other workspaces
@@ -32,12 +46,17 @@ func updateCombinedQueue(ws *v1alpha1.Workspace, runs []v1alpha1.Run) {
 		}
 
 		// Filter out privileged commands that are yet to be approved
-		if slice.ContainsString(ws.Spec.PrivilegedCommands, run.Command) {
+		if ws.IsPrivilegedCommand(run.Command) {
 			if !ws.IsRunApproved(&run) {
 				continue
 			}
 		}
 
+		// Filter out runs that are yet to be approved with etok approve
+		if ws.RequiresApproval(run.Command) && !ws.IsRunReviewed(&run) {
+			continue
+		}
+
 		newQ = append(newQ, run.Name)
 	}
 
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"testing"
+	"time"
 
 	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
 	"github.com/leg100/etok/pkg/testobj"
@@ -9,6 +10,8 @@ import (
 )
 
 func TestUpdateCombinedQueue(t *testing.T) {
+	now := time.Now()
+
 	tests := []struct {
 		name       string
 		workspace  *v1alpha1.Workspace
@@ -71,15 +74,46 @@ func TestUpdateCombinedQueue(t *testing.T) {
 			name:      "Don't queue unqueueable runs",
 			workspace: testobj.Workspace("default", "workspace-1"),
 			runs: []v1alpha1.Run{
-				*testobj.Run("default", "output-1", "output", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting)),
-				*testobj.Run("default", "sh-1", "sh", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting)),
-				*testobj.Run("default", "state-list-1", "list", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting)),
-				*testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting)),
-				*testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting)),
+				*testobj.Run("default", "output-1", "output", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting), testobj.WithCreationTimestamp(now)),
+				*testobj.Run("default", "sh-1", "sh", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting), testobj.WithCreationTimestamp(now.Add(time.Second))),
+				*testobj.Run("default", "state-list-1", "list", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting), testobj.WithCreationTimestamp(now.Add(2*time.Second))),
+				*testobj.Run("default", "plan-1", "plan", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting), testobj.WithCreationTimestamp(now.Add(3*time.Second))),
+				*testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithRunPhase(v1alpha1.RunPhaseWaiting), testobj.WithCreationTimestamp(now.Add(4*time.Second))),
 			},
 			wantActive: "sh-1",
 			wantQueue:  []string{"apply-1"},
 		},
+		{
+			name:      "New runs queued in order of creation",
+			workspace: testobj.Workspace("default", "workspace-1"),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "apply-a", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(now.Add(2*time.Second))),
+				*testobj.Run("default", "apply-b", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(now)),
+				*testobj.Run("default", "apply-c", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(now.Add(time.Second))),
+			},
+			wantActive: "apply-b",
+			wantQueue:  []string{"apply-c", "apply-a"},
+		},
+		{
+			name:      "Runs created at same time queued in order of name",
+			workspace: testobj.Workspace("default", "workspace-1"),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "apply-2", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(now)),
+				*testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithCreationTimestamp(now)),
+			},
+			wantActive: "apply-1",
+			wantQueue:  []string{"apply-2"},
+		},
+		{
+			name:      "Runs against different terraform workspaces share queue",
+			workspace: testobj.Workspace("default", "workspace-1"),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithTFWorkspace("staging"), testobj.WithCreationTimestamp(now)),
+				*testobj.Run("default", "apply-2", "apply", testobj.WithWorkspace("workspace-1"), testobj.WithTFWorkspace("production"), testobj.WithCreationTimestamp(now.Add(time.Second))),
+			},
+			wantActive: "apply-1",
+			wantQueue:  []string{"apply-2"},
+		},
 		{
 			name:      "Unapproved privileged command",
 			workspace: testobj.Workspace("", "workspace-1", testobj.WithPrivilegedCommands("apply")),
@@ -97,6 +131,25 @@ func TestUpdateCombinedQueue(t *testing.T) {
 			wantActive: "apply-1",
 			wantQueue:  []string{},
 		},
+		{
+			name:      "Unapproved run",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithRequireApproval()),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
+				*testobj.Run("default", "init-1", "init", testobj.WithWorkspace("workspace-1")),
+			},
+			wantActive: "init-1",
+			wantQueue:  []string{},
+		},
+		{
+			name:      "Approved run",
+			workspace: testobj.Workspace("", "workspace-1", testobj.WithRequireApproval(), testobj.WithReviews("apply-1")),
+			runs: []v1alpha1.Run{
+				*testobj.Run("default", "apply-1", "apply", testobj.WithWorkspace("workspace-1")),
+			},
+			wantActive: "apply-1",
+			wantQueue:  []string{},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
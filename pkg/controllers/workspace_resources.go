@@ -13,12 +13,6 @@ const (
 	builtinVariables = `
 variable "namespace" {}
 variable "workspace" {}
-`
-
-	builtinConfig = `
-terraform {
-  backend "kubernetes" {}
-}
 `
 )
 
@@ -30,10 +24,19 @@ func newBuiltinsForWS(ws *v1alpha1.Workspace) *corev1.ConfigMap {
 		},
 		Data: map[string]string{
 			variablesPath: builtinVariables,
-			backendPath:   builtinConfig,
+			backendPath:   backendDeclaration(ws.BackendType()),
 		},
 	}
 
+	// A raw backend is used verbatim in place of etok's declaration
+	if ws.Spec.Backend.Raw != "" {
+		builtins.Data[backendPath] = ws.Spec.Backend.Raw
+	}
+
+	if ws.BackendType() != v1alpha1.BackendKubernetes {
+		builtins.Data[backendConfigPath] = backendConfig(ws.BackendType(), ws.Spec.Backend.Config)
+	}
+
 	// Set etok's common labels
 	labels.SetCommonLabels(builtins)
 	// Permit filtering etok resources by component
@@ -43,21 +46,25 @@ func newBuiltinsForWS(ws *v1alpha1.Workspace) *corev1.ConfigMap {
 }
 
 func newPVCForWS(ws *v1alpha1.Workspace) *corev1.PersistentVolumeClaim {
+	accessModes := ws.Spec.Cache.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+
 	pvc := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      ws.Name,
 			Namespace: ws.Namespace,
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{
-				corev1.ReadWriteOnce,
-			},
+			AccessModes: accessModes,
 			Resources: corev1.ResourceRequirements{
 				Requests: corev1.ResourceList{
 					corev1.ResourceStorage: resource.MustParse(ws.Spec.Cache.Size),
 				},
 			},
 			StorageClassName: ws.Spec.Cache.StorageClass,
+			VolumeMode:       ws.Spec.Cache.VolumeMode,
 		},
 	}
 
@@ -69,6 +76,20 @@ func newPVCForWS(ws *v1alpha1.Workspace) *corev1.PersistentVolumeClaim {
 	return pvc
 }
 
+// cacheVolume returns the volume for the workspace's cache, which is either
+// its persistent volume claim or, should the cache be ephemeral, an empty dir.
+func cacheVolume(ws *v1alpha1.Workspace) corev1.Volume {
+	vol := corev1.Volume{Name: "cache"}
+	if ws.Spec.Cache.Ephemeral {
+		vol.EmptyDir = &corev1.EmptyDirVolumeSource{}
+	} else {
+		vol.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+			ClaimName: ws.PVCName(),
+		}
+	}
+	return vol
+}
+
 func newRoleForNamespace(ws *v1alpha1.Workspace) *rbacv1.Role {
 	role := &rbacv1.Role{
 		ObjectMeta: metav1.ObjectMeta{
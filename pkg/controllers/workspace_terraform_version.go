@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/tfversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// manageTerraformVersion resolves the workspace's terraform version, which may
+// be a constraint, to a specific version, recording it in the workspace status.
+// A version that has already been resolved is retained for as long as it
+// satisfies the constraint, to avoid listing releases on every reconcile.
+func (r *WorkspaceReconciler) manageTerraformVersion(ctx context.Context, ws *v1alpha1.Workspace) (*metav1.Condition, error) {
+	log := log.FromContext(ctx)
+
+	requested := ws.Spec.TerraformVersion
+	if requested == "" || tfversion.IsExact(requested) {
+		ws.Status.TerraformVersion = requested
+		return nil, nil
+	}
+
+	if ws.Status.TerraformVersion != "" && tfversion.Satisfies(ws.Status.TerraformVersion, requested) {
+		return nil, nil
+	}
+
+	available, err := r.TerraformVersionLister.List(ctx)
+	if err != nil {
+		log.Error(err, "unable to list terraform versions")
+		return nil, err
+	}
+
+	resolved, err := tfversion.Resolve(requested, available)
+	if err != nil {
+		if errors.Is(err, tfversion.ErrNoMatch) || errors.Is(err, tfversion.ErrInvalidConstraint) {
+			r.recorder.Eventf(ws, "Warning", "TerraformVersionError", err.Error())
+			return workspaceFailure(err.Error()), nil
+		}
+		return nil, err
+	}
+
+	ws.Status.TerraformVersion = resolved
+	r.recorder.Eventf(ws, "Normal", "TerraformVersionResolved", "Resolved terraform version %s to %s", requested, resolved)
+
+	return nil, nil
+}
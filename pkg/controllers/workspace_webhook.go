@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/tfversion"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// WorkspaceValidationPath is the path on which the operator serves the webhook
+// validating workspaces
+const WorkspaceValidationPath = "/validate-workspace"
+
+// Access modes permitted for the cache's persistent volume claim
+var cacheAccessModes = []corev1.PersistentVolumeAccessMode{
+	corev1.ReadWriteOnce,
+	corev1.ReadOnlyMany,
+	corev1.ReadWriteMany,
+}
+
+// WorkspaceValidator is a validating admission webhook that rejects workspaces
+// with invalid specs before they are persisted, rather than leaving them to
+// fail upon reconciliation.
+type WorkspaceValidator struct {
+	decoder *admission.Decoder
+}
+
+// Handle validates the workspace being created or updated. An update that
+// leaves the spec unchanged is permitted regardless, so that the operator can
+// still update a workspace created before the webhook was installed, e.g. to
+// remove its finalizers.
+func (v *WorkspaceValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var ws v1alpha1.Workspace
+	if err := v.decoder.Decode(req, &ws); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if ws.DeletionTimestamp != nil {
+		return admission.Allowed("")
+	}
+
+	if req.Operation == admissionv1.Update {
+		var old v1alpha1.Workspace
+		if err := v.decoder.DecodeRaw(req.OldObject, &old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if equality.Semantic.DeepEqual(old.Spec, ws.Spec) {
+			return admission.Allowed("")
+		}
+	}
+
+	if errs := ValidateWorkspace(&ws); len(errs) > 0 {
+		return admission.Denied(errs.ToAggregate().Error())
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder injects the decoder into the validator
+func (v *WorkspaceValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// ValidateWorkspace checks those parts of a workspace's spec that the CRD's
+// schema cannot.
+func ValidateWorkspace(ws *v1alpha1.Workspace) field.ErrorList {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+
+	if ws.Spec.Cache.Size != "" {
+		if _, err := resource.ParseQuantity(ws.Spec.Cache.Size); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("cache", "size"), ws.Spec.Cache.Size, err.Error()))
+		}
+	}
+
+	for i, mode := range ws.Spec.Cache.AccessModes {
+		if !containsAccessMode(cacheAccessModes, mode) {
+			errs = append(errs, field.NotSupported(spec.Child("cache", "accessModes").Index(i), mode, accessModeStrings(cacheAccessModes)))
+		}
+	}
+
+	if err := validateBackend(ws); err != nil {
+		errs = append(errs, field.Invalid(spec.Child("backend"), ws.BackendType(), err.Error()))
+	}
+
+	if ws.Spec.TerraformVersion != "" {
+		if err := tfversion.Validate(ws.Spec.TerraformVersion); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("terraformVersion"), ws.Spec.TerraformVersion, err.Error()))
+		}
+	}
+
+	if ws.Spec.CacheBackup && ws.Spec.BackupBucket == "" {
+		errs = append(errs, field.Required(spec.Child("backupBucket"), "required by cacheBackup"))
+	}
+
+	return errs
+}
+
+func containsAccessMode(modes []corev1.PersistentVolumeAccessMode, mode corev1.PersistentVolumeAccessMode) bool {
+	for _, m := range modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func accessModeStrings(modes []corev1.PersistentVolumeAccessMode) (s []string) {
+	for _, m := range modes {
+		s = append(s, string(m))
+	}
+	return
+}
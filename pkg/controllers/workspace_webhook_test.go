@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	v1alpha1 "github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/scheme"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestValidateWorkspace(t *testing.T) {
+	tests := []struct {
+		name      string
+		workspace *v1alpha1.Workspace
+		// Fields expected to be invalid
+		want []string
+	}{
+		{
+			name:      "valid",
+			workspace: testobj.Workspace("default", "foo"),
+		},
+		{
+			name:      "invalid cache size",
+			workspace: testobj.Workspace("default", "foo", testobj.WithCacheSize("notaquantity")),
+			want:      []string{"spec.cache.size"},
+		},
+		{
+			name:      "invalid access mode",
+			workspace: testobj.Workspace("default", "foo", testobj.WithAccessModes("ReadWriteSometimes")),
+			want:      []string{"spec.cache.accessModes[0]"},
+		},
+		{
+			name:      "unknown backend type",
+			workspace: testobj.Workspace("default", "foo", testobj.WithBackend("consol")),
+			want:      []string{"spec.backend"},
+		},
+		{
+			name:      "backend missing required config",
+			workspace: testobj.Workspace("default", "foo", testobj.WithBackend(v1alpha1.BackendS3)),
+			want:      []string{"spec.backend"},
+		},
+		{
+			name:      "invalid terraform version",
+			workspace: testobj.Workspace("default", "foo", testobj.WithTerraformVersion("latest")),
+			want:      []string{"spec.terraformVersion"},
+		},
+		{
+			name:      "cache backup without backup bucket",
+			workspace: testobj.Workspace("default", "foo", testobj.WithCacheBackup()),
+			want:      []string{"spec.backupBucket"},
+		},
+		{
+			name:      "multiple invalid fields",
+			workspace: testobj.Workspace("default", "foo", testobj.WithCacheSize("notaquantity"), testobj.WithTerraformVersion("latest")),
+			want:      []string{"spec.cache.size", "spec.terraformVersion"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, err := range ValidateWorkspace(tt.workspace) {
+				got = append(got, err.Field)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWorkspaceValidator(t *testing.T) {
+	invalid := testobj.Workspace("default", "foo", testobj.WithCacheSize("notaquantity"))
+
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		workspace *v1alpha1.Workspace
+		old       *v1alpha1.Workspace
+		allowed   bool
+	}{
+		{
+			name:      "create valid workspace",
+			operation: admissionv1.Create,
+			workspace: testobj.Workspace("default", "foo"),
+			allowed:   true,
+		},
+		{
+			name:      "create invalid workspace",
+			operation: admissionv1.Create,
+			workspace: invalid,
+		},
+		{
+			name:      "update to invalid spec",
+			operation: admissionv1.Update,
+			workspace: invalid,
+			old:       testobj.Workspace("default", "foo"),
+		},
+		{
+			name:      "update invalid workspace without changing spec",
+			operation: admissionv1.Update,
+			workspace: testobj.Workspace("default", "foo", testobj.WithCacheSize("notaquantity"), testobj.WithFinalizers()),
+			old:       invalid,
+			allowed:   true,
+		},
+		{
+			name:      "update deleted invalid workspace",
+			operation: admissionv1.Update,
+			workspace: testobj.Workspace("default", "foo", testobj.WithCacheSize("notaquantity"), testobj.WithDeleteTimestamp()),
+			old:       testobj.Workspace("default", "foo"),
+			allowed:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder, err := admission.NewDecoder(scheme.Scheme)
+			require.NoError(t, err)
+			v := &WorkspaceValidator{}
+			require.NoError(t, v.InjectDecoder(decoder))
+
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: tt.operation,
+				Object:    rawWorkspace(t, tt.workspace),
+			}}
+			if tt.old != nil {
+				req.OldObject = rawWorkspace(t, tt.old)
+			}
+
+			resp := v.Handle(context.Background(), req)
+			assert.Equal(t, tt.allowed, resp.Allowed)
+			if !tt.allowed {
+				assert.Contains(t, string(resp.Result.Reason), "spec.cache.size")
+			}
+		})
+	}
+}
+
+func rawWorkspace(t *testing.T, ws *v1alpha1.Workspace) runtime.RawExtension {
+	ws = ws.DeepCopy()
+	ws.TypeMeta = metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "Workspace"}
+	data, err := json.Marshal(ws)
+	require.NoError(t, err)
+	return runtime.RawExtension{Raw: data}
+}
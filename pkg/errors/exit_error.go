@@ -1,5 +1,21 @@
 package errors
 
+import "errors"
+
+// Exit codes returned by etok. The exit code of a program executed by the
+// runner, i.e. terraform, is passed through unchanged, which permits CI to
+// distinguish, say, a plan with changes from a plan that failed.
+const (
+	// ExitCodeOK is returned upon success
+	ExitCodeOK = 0
+	// ExitCodeError is returned when etok itself fails, e.g. the operator is
+	// not installed or a resource fails to be created.
+	ExitCodeError = 1
+	// ExitCodePlanChanges is returned by terraform plan with the
+	// -detailed-exitcode flag when the plan contains changes.
+	ExitCodePlanChanges = 2
+)
+
 type ExitError interface {
 	error
 	ExitCode() int
@@ -30,3 +46,16 @@ func (e *exitError) Is(err error) bool {
 	exit, ok := err.(*exitError)
 	return ok && exit.code == e.code
 }
+
+// ExitCode maps an error to an exit code: the exit code of an executed program
+// is passed through, whereas any other error is an etok error.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitCodeOK
+	}
+	var exit ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode()
+	}
+	return ExitCodeError
+}
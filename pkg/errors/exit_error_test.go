@@ -0,0 +1,48 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{
+			name: "no error",
+			code: ExitCodeOK,
+		},
+		{
+			name: "etok error",
+			err:  errors.New("operator not installed"),
+			code: ExitCodeError,
+		},
+		{
+			name: "terraform error",
+			err:  NewExitError(1),
+			code: 1,
+		},
+		{
+			name: "plan with changes",
+			err:  NewExitError(ExitCodePlanChanges),
+			code: ExitCodePlanChanges,
+		},
+		{
+			name: "wrapped exit error",
+			err:  fmt.Errorf("run failed: %w", NewExitError(3)),
+			code: 3,
+		},
+	}
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			assert.Equal(t, tt.code, ExitCode(tt.err))
+		})
+	}
+}
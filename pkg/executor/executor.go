@@ -3,6 +3,8 @@ package executor
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 
 	cmdutil "github.com/leg100/etok/cmd/util"
@@ -22,7 +24,7 @@ type Exec struct {
 func (tc *Exec) Execute(ctx context.Context, args []string, opts ...ExecOption) error {
 	klog.V(1).Infof("running command %v\n", args)
 
-	exe := exec.CommandContext(ctx, args[0], args[1:]...)
+	exe := exec.Command(args[0], args[1:]...)
 	exe.Stdin = tc.In
 	exe.Stdout = tc.Out
 	exe.Stderr = tc.ErrOut
@@ -31,12 +33,39 @@ func (tc *Exec) Execute(ctx context.Context, args []string, opts ...ExecOption)
 		o(exe)
 	}
 
-	if err := exe.Run(); err != nil {
+	if err := exe.Start(); err != nil {
+		return fmt.Errorf("unable to run command %v: %w", args, err)
+	}
+
+	// Upon cancellation, interrupt rather than kill the command, and wait for
+	// it to exit: terraform responds to an interrupt by gracefully stopping,
+	// writing state and releasing locks. Should it not exit in time, the
+	// kubelet kills it once the pod's termination grace period expires.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			klog.V(1).Infof("interrupting command %v\n", args)
+			_ = exe.Process.Signal(os.Interrupt)
+		case <-done:
+		}
+	}()
+
+	if err := exe.Wait(); err != nil {
 		return fmt.Errorf("unable to run command %v: %w", args, err)
 	}
 	return nil
 }
 
+// WithOutput redirects the command's stdout and stderr to the writer
+func WithOutput(w io.Writer) ExecOption {
+	return func(cmd *exec.Cmd) {
+		cmd.Stdout = w
+		cmd.Stderr = w
+	}
+}
+
 func withPath(path string) ExecOption {
 	return func(cmd *exec.Cmd) {
 		cmd.Dir = path
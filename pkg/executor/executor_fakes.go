@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os/exec"
 )
 
 type FakeExecutor struct{}
@@ -13,26 +14,45 @@ func (fe *FakeExecutor) Execute(ctx context.Context, args []string, opts ...Exec
 	return nil
 }
 
-// Fake that prints any args to stdout
+// Fake that prints any args to stdout, or to the output set with WithOutput
 type FakeExecutorEchoArgs struct {
 	Out io.Writer
 }
 
 func (fe *FakeExecutorEchoArgs) Execute(ctx context.Context, args []string, opts ...ExecOption) error {
-	fmt.Fprintf(fe.Out, "%v", args)
+	fmt.Fprintf(fakeOutput(fe.Out, opts...), "%v", args)
 	return nil
 }
 
+// Fake that prints any args, and fails to select a terraform workspace. NewErr
+// is returned when creating a terraform workspace.
 type FakeExecutorMissingWorkspace struct {
-	Out io.Writer
+	Out    io.Writer
+	NewErr error
 }
 
 func (fe *FakeExecutorMissingWorkspace) Execute(ctx context.Context, args []string, opts ...ExecOption) error {
-	fmt.Fprintf(fe.Out, "%v", args)
+	fmt.Fprintf(fakeOutput(fe.Out, opts...), "%v", args)
 
 	if len(args) > 2 && args[0] == "terraform" && args[1] == "workspace" && args[2] == "select" {
 		return errors.New("workspace does not exist")
 	}
 
+	if len(args) > 2 && args[0] == "terraform" && args[1] == "workspace" && args[2] == "new" {
+		return fe.NewErr
+	}
+
 	return nil
 }
+
+// fakeOutput returns the output set with WithOutput, or else the default
+func fakeOutput(out io.Writer, opts ...ExecOption) io.Writer {
+	cmd := &exec.Cmd{}
+	for _, o := range opts {
+		o(cmd)
+	}
+	if cmd.Stdout != nil {
+		return cmd.Stdout
+	}
+	return out
+}
@@ -7,6 +7,7 @@ import (
 	osexec "os/exec"
 	"path/filepath"
 	"testing"
+	"time"
 
 	cmdutil "github.com/leg100/etok/cmd/util"
 	"github.com/leg100/etok/pkg/testutil"
@@ -40,6 +41,24 @@ func TestExecutor(t *testing.T) {
 		assert.FileExists(t, filepath.Join(path.Root(), "a.file"))
 	})
 
+	testutil.Run(t, "interrupted upon cancellation", func(t *testutil.T) {
+		out := new(bytes.Buffer)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		// Cancel once the trap is set
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			cancel()
+		}()
+
+		exec := &Exec{IOStreams: cmdutil.IOStreams{Out: out}}
+		err := exec.Execute(ctx, []string{"sh", "-c", "trap 'echo -n interrupted; exit 0' INT; while true; do sleep 0.01; done"})
+
+		// The command exits gracefully
+		assert.NoError(t, err)
+		assert.Equal(t, "interrupted", out.String())
+	})
+
 	testutil.Run(t, "non-zero exit", func(t *testutil.T) {
 		err := (&Exec{}).Execute(context.Background(), []string{"sh", "-c", "exit 101"})
 
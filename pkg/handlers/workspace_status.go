@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/util/slice"
+	"k8s.io/apimachinery/pkg/api/meta"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// LogWorkspaceStatus prints a workspace's queue and conditions upon the first
+// event, and thereafter only changes to them. It never returns true, i.e. it
+// logs until the watch is cancelled.
+func LogWorkspaceStatus(out io.Writer) watchtools.ConditionFunc {
+	var last *v1alpha1.WorkspaceStatus
+
+	return workspaceHandlerWrapper(func(ws *v1alpha1.Workspace) (bool, error) {
+		if last == nil || last.Active != ws.Status.Active || !slice.IdenticalStrings(last.Queue, ws.Status.Queue) {
+			active := ws.Status.Active
+			if active == "" {
+				active = "none"
+			}
+			fmt.Fprintf(out, "Active run: %s, queue: %v\n", active, ws.Status.Queue)
+		}
+
+		for _, cond := range ws.Status.Conditions {
+			if last != nil {
+				if prev := meta.FindStatusCondition(last.Conditions, cond.Type); prev != nil {
+					if prev.Status == cond.Status && prev.Reason == cond.Reason && prev.Message == cond.Message {
+						// Unchanged
+						continue
+					}
+				}
+			}
+			fmt.Fprintf(out, "Condition %s: %s", cond.Type, cond.Status)
+			if cond.Reason != "" {
+				fmt.Fprintf(out, " (%s)", cond.Reason)
+			}
+			if cond.Message != "" {
+				fmt.Fprintf(out, ": %s", cond.Message)
+			}
+			fmt.Fprintln(out)
+		}
+
+		last = ws.Status.DeepCopy()
+		return false, nil
+	})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestLogWorkspaceStatus(t *testing.T) {
+	notReady := func(ws *v1alpha1.Workspace) {
+		ws.Status.Conditions[0].Status = metav1.ConditionFalse
+		ws.Status.Conditions[0].Reason = v1alpha1.FailureReason
+		ws.Status.Conditions[0].Message = "pod failed"
+	}
+
+	tests := []struct {
+		name string
+		// Successive updates to the workspace
+		updates []*v1alpha1.Workspace
+		out     string
+	}{
+		{
+			name:    "initial status",
+			updates: []*v1alpha1.Workspace{testobj.Workspace("default", "foo", testobj.WithCombinedQueue("apply-1", "plan-1"))},
+			out:     "Active run: apply-1, queue: [plan-1]\nCondition Ready: True\n",
+		},
+		{
+			name: "queue changes",
+			updates: []*v1alpha1.Workspace{
+				testobj.Workspace("default", "foo", testobj.WithCombinedQueue("apply-1", "plan-1")),
+				testobj.Workspace("default", "foo", testobj.WithCombinedQueue("plan-1")),
+				testobj.Workspace("default", "foo"),
+			},
+			out: "Active run: apply-1, queue: [plan-1]\nCondition Ready: True\nActive run: plan-1, queue: []\nActive run: none, queue: []\n",
+		},
+		{
+			name: "condition transitions",
+			updates: []*v1alpha1.Workspace{
+				testobj.Workspace("default", "foo"),
+				testobj.Workspace("default", "foo", notReady),
+				testobj.Workspace("default", "foo", notReady),
+			},
+			out: "Active run: none, queue: []\nCondition Ready: True\nCondition Ready: False (Failure): pod failed\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			hdlr := LogWorkspaceStatus(out)
+
+			for _, ws := range tt.updates {
+				done, err := hdlr(watch.Event{Type: watch.Modified, Object: ws})
+				assert.NoError(t, err)
+				assert.False(t, done)
+			}
+
+			assert.Equal(t, tt.out, out.String())
+		})
+	}
+
+	t.Run("deleted", func(t *testing.T) {
+		_, err := LogWorkspaceStatus(new(bytes.Buffer))(watch.Event{Type: watch.Deleted, Object: testobj.Workspace("default", "foo")})
+		assert.Equal(t, ErrResourceUnexpectedlyDeleted, err)
+	})
+}
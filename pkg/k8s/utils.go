@@ -1,6 +1,11 @@
 package k8s
 
-import corev1 "k8s.io/api/core/v1"
+import (
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
 
 // ContainerStatusByName returns the ContainerStatus object for a container with
 // a given name on the pod. Includes init containers.
@@ -13,3 +18,19 @@ func ContainerStatusByName(pod *corev1.Pod, name string) *corev1.ContainerStatus
 	}
 	return nil
 }
+
+// IsDeploymentAvailable determines whether the deployment is available
+func IsDeploymentAvailable(deploy *appsv1.Deployment) bool {
+	for _, c := range deploy.Status.Conditions {
+		// Make sure that the deployment has been available for at least 10
+		// seconds. This is because the deployment can show as Ready
+		// momentarily before the pods fall into a CrashLoopBackOff. See
+		// podutils.IsPodAvailable upstream for similar logic with pods
+		if c.Type == appsv1.DeploymentAvailable && c.Status == corev1.ConditionTrue {
+			if !c.LastTransitionTime.IsZero() && c.LastTransitionTime.Add(10*time.Second).Before(time.Now()) {
+				return true
+			}
+		}
+	}
+	return false
+}
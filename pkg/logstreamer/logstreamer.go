@@ -3,13 +3,25 @@ package logstreamer
 import (
 	"context"
 	"io"
+	"io/ioutil"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 
 	typedv1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
+const (
+	// Default max number of attempts to stream logs
+	defaultMaxAttempts = 5
+
+	// Default interval to wait before first re-attempt; doubles upon each
+	// subsequent re-attempt
+	defaultRetryInterval = 500 * time.Millisecond
+)
+
 // Substitutable for testing
 type GetLogsFunc func(context.Context, Options) (io.ReadCloser, error)
 
@@ -19,19 +31,140 @@ type Options struct {
 	PodLogOptions *corev1.PodLogOptions
 }
 
-func Stream(ctx context.Context, f GetLogsFunc, out io.Writer, podsClient typedv1.PodInterface, podName, containerName string) error {
-	klog.V(1).Info("Streaming logs")
-	stream, err := f(ctx, Options{
-		PodsClient:    podsClient,
-		PodName:       podName,
-		PodLogOptions: &corev1.PodLogOptions{Follow: true, Container: containerName},
+type streamer struct {
+	maxAttempts   int
+	retryInterval time.Duration
+	timestamps    bool
+
+	// Only stream logs more recent than this duration. Zero streams the full
+	// history.
+	since time.Duration
+	// Only stream this number of the most recent lines. Nil streams the full
+	// history.
+	tailLines *int64
+
+	// Time from which logs are streamed, determined from since upon the first
+	// attempt so that each attempt streams from the same line
+	sinceTime *metav1.Time
+}
+
+type StreamOption func(*streamer)
+
+// WithMaxAttempts sets the max number of attempts to stream logs, including
+// the first attempt
+func WithMaxAttempts(attempts int) StreamOption {
+	return func(s *streamer) {
+		s.maxAttempts = attempts
+	}
+}
+
+// WithRetryInterval sets the interval to wait before the first re-attempt
+func WithRetryInterval(interval time.Duration) StreamOption {
+	return func(s *streamer) {
+		s.retryInterval = interval
+	}
+}
+
+// WithTimestamps prefixes each line of the logs with an RFC3339 timestamp.
+// Kubernetes records each line of output separately, so each line of
+// multi-line output is prefixed.
+func WithTimestamps() StreamOption {
+	return func(s *streamer) {
+		s.timestamps = true
+	}
+}
+
+// WithSince only streams logs more recent than the duration, e.g. 10m streams
+// the logs of the last ten minutes
+func WithSince(since time.Duration) StreamOption {
+	return func(s *streamer) {
+		s.since = since
+	}
+}
+
+// WithTailLines only streams the most recent number of lines of the logs, and
+// then any lines that follow. An interrupted stream is not re-established,
+// because the tail of the logs will have moved on, and the stream could not
+// resume from where it left off.
+func WithTailLines(lines int64) StreamOption {
+	return func(s *streamer) {
+		s.tailLines = &lines
+	}
+}
+
+// Stream streams logs from the container to out. Should the stream be
+// interrupted it is re-established and output resumes from where it left off.
+func Stream(ctx context.Context, f GetLogsFunc, out io.Writer, podsClient typedv1.PodInterface, podName, containerName string, opts ...StreamOption) error {
+	s := &streamer{
+		maxAttempts:   defaultMaxAttempts,
+		retryInterval: defaultRetryInterval,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+
+	if s.since > 0 {
+		sinceTime := metav1.NewTime(time.Now().Add(-s.since))
+		s.sinceTime = &sinceTime
+	}
+	if s.tailLines != nil {
+		s.maxAttempts = 1
+	}
+
+	// Bytes written to out thus far
+	var written int64
+
+	interval := s.retryInterval
+	for attempt := 1; ; attempt++ {
+		klog.V(1).Infof("Streaming logs (attempt %d)", attempt)
+
+		n, err := s.stream(ctx, f, out, podsClient, podName, containerName, written)
+		written += n
+		if err == nil {
+			return nil
+		}
+		if attempt >= s.maxAttempts {
+			return err
+		}
+
+		klog.V(1).Infof("Log stream interrupted: %s; re-attempting in %s", err.Error(), interval)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+			interval *= 2
+		}
+	}
+}
+
+// stream streams logs from the beginning, or from the since time if set,
+// skipping over the first skip bytes, and returns the number of bytes written
+// to out
+func (s *streamer) stream(ctx context.Context, f GetLogsFunc, out io.Writer, podsClient typedv1.PodInterface, podName, containerName string, skip int64) (int64, error) {
+	logs, err := f(ctx, Options{
+		PodsClient: podsClient,
+		PodName:    podName,
+		PodLogOptions: &corev1.PodLogOptions{
+			Follow:     true,
+			Container:  containerName,
+			Timestamps: s.timestamps,
+			SinceTime:  s.sinceTime,
+			TailLines:  s.tailLines,
+		},
 	})
 	if err != nil {
-		return err
+		return 0, err
+	}
+	defer logs.Close()
+
+	// Skip logs already written
+	if skip > 0 {
+		if _, err := io.CopyN(ioutil.Discard, logs, skip); err != nil {
+			return 0, err
+		}
 	}
-	defer stream.Close()
-	_, err = io.Copy(out, stream)
-	return err
+
+	return io.Copy(out, logs)
 }
 
 func GetLogs(ctx context.Context, opts Options) (io.ReadCloser, error) {
@@ -0,0 +1,144 @@
+package logstreamer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var errDisconnect = errors.New("fake disconnect")
+
+// interruptedReader returns the logs up until the cutoff and then errors
+type interruptedReader struct {
+	io.Reader
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		return n, errDisconnect
+	}
+	return n, err
+}
+
+// fakeGetLogs returns a GetLogsFunc that streams the logs, with the first
+// len(cutoffs) attempts interrupted after the corresponding number of bytes
+func fakeGetLogs(logs string, cutoffs ...int) GetLogsFunc {
+	var attempt int
+	return func(ctx context.Context, opts Options) (io.ReadCloser, error) {
+		defer func() { attempt++ }()
+		if attempt < len(cutoffs) {
+			return ioutil.NopCloser(&interruptedReader{bytes.NewBufferString(logs[:cutoffs[attempt]])}), nil
+		}
+		return ioutil.NopCloser(bytes.NewBufferString(logs)), nil
+	}
+}
+
+func TestStreamWithTimestamps(t *testing.T) {
+	var requested *corev1.PodLogOptions
+	getLogs := func(ctx context.Context, opts Options) (io.ReadCloser, error) {
+		requested = opts.PodLogOptions
+		return ioutil.NopCloser(bytes.NewBufferString("2021-01-01T00:00:00.000000000Z line 1\n")), nil
+	}
+
+	out := new(bytes.Buffer)
+	require.NoError(t, Stream(context.Background(), getLogs, out, nil, "pod-1", "container-1", WithTimestamps()))
+
+	assert.True(t, requested.Timestamps)
+	assert.Equal(t, "2021-01-01T00:00:00.000000000Z line 1\n", out.String())
+}
+
+func TestStreamSince(t *testing.T) {
+	var requested []*corev1.PodLogOptions
+	getLogs := fakeGetLogs("line 1\nline 2\n", 3)
+	recordingGetLogs := func(ctx context.Context, opts Options) (io.ReadCloser, error) {
+		requested = append(requested, opts.PodLogOptions)
+		return getLogs(ctx, opts)
+	}
+
+	out := new(bytes.Buffer)
+	require.NoError(t, Stream(context.Background(), recordingGetLogs, out, nil, "pod-1", "container-1", WithSince(time.Hour), WithRetryInterval(time.Millisecond)))
+
+	// Each attempt streams from the same time, so that the stream resumes
+	// from where it left off
+	require.Equal(t, 2, len(requested))
+	if assert.NotNil(t, requested[0].SinceTime) {
+		assert.WithinDuration(t, time.Now().Add(-time.Hour), requested[0].SinceTime.Time, time.Minute)
+	}
+	assert.Equal(t, requested[0].SinceTime, requested[1].SinceTime)
+	assert.Equal(t, "line 1\nline 2\n", out.String())
+}
+
+func TestStreamTailLines(t *testing.T) {
+	var requested *corev1.PodLogOptions
+	getLogs := fakeGetLogs("line 1\nline 2\n", 3)
+	recordingGetLogs := func(ctx context.Context, opts Options) (io.ReadCloser, error) {
+		requested = opts.PodLogOptions
+		return getLogs(ctx, opts)
+	}
+
+	out := new(bytes.Buffer)
+	err := Stream(context.Background(), recordingGetLogs, out, nil, "pod-1", "container-1", WithTailLines(10), WithRetryInterval(time.Millisecond))
+
+	if assert.NotNil(t, requested.TailLines) {
+		assert.Equal(t, int64(10), *requested.TailLines)
+	}
+	// An interrupted stream is not re-established
+	assert.True(t, errors.Is(err, errDisconnect))
+	assert.Equal(t, "lin", out.String())
+}
+
+func TestStream(t *testing.T) {
+	logs := "line 1\nline 2\nline 3\n"
+
+	tests := []struct {
+		name    string
+		getLogs GetLogsFunc
+		opts    []StreamOption
+		want    string
+		err     error
+	}{
+		{
+			name:    "uninterrupted",
+			getLogs: fakeGetLogs(logs),
+			want:    logs,
+		},
+		{
+			name:    "resume after interruption",
+			getLogs: fakeGetLogs(logs, 9),
+			want:    logs,
+		},
+		{
+			name:    "resume after several interruptions",
+			getLogs: fakeGetLogs(logs, 3, 10, 14),
+			want:    logs,
+		},
+		{
+			name:    "max attempts exceeded",
+			getLogs: fakeGetLogs(logs, 3, 10, 14),
+			opts:    []StreamOption{WithMaxAttempts(2)},
+			want:    logs[:10],
+			err:     errDisconnect,
+		},
+	}
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			out := new(bytes.Buffer)
+
+			opts := append([]StreamOption{WithRetryInterval(time.Millisecond)}, tt.opts...)
+			err := Stream(context.Background(), tt.getLogs, out, nil, "pod-1", "container-1", opts...)
+			assert.True(t, errors.Is(err, tt.err))
+
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package logstreamer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// JSONPlanSection is the name of the section of a plan run's logs containing
+// the plan in JSON
+const JSONPlanSection = "json-plan"
+
+// SectionMarkers returns the lines that delimit the named section of a log
+// stream, i.e. output that is to be separated from the rest of the logs, such
+// as machine-readable output amongst terraform's human-readable output.
+func SectionMarkers(name string) (begin, end string) {
+	return fmt.Sprintf("--- etok:%s:begin ---", name), fmt.Sprintf("--- etok:%s:end ---", name)
+}
+
+// Splitter is a writer that diverts the lines of a named section of a log
+// stream to a separate writer, and passes all other lines through. The markers
+// delimiting the section are dropped, as are blank lines within the section.
+type Splitter struct {
+	out, section io.Writer
+	begin, end   []byte
+
+	inSection bool
+	// Partial line awaiting its newline
+	buf []byte
+}
+
+func NewSplitter(out, section io.Writer, name string) *Splitter {
+	begin, end := SectionMarkers(name)
+	return &Splitter{out: out, section: section, begin: []byte(begin), end: []byte(end)}
+}
+
+func (s *Splitter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := s.buf[:i+1]
+		if err := s.writeLine(line); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[i+1:]
+	}
+}
+
+// Flush writes any remaining partial line
+func (s *Splitter) Flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	err := s.writeLine(s.buf)
+	s.buf = nil
+	return err
+}
+
+func (s *Splitter) writeLine(line []byte) error {
+	trimmed := bytes.TrimRight(line, "\r\n")
+
+	var err error
+	switch {
+	case bytes.Equal(trimmed, s.begin):
+		s.inSection = true
+	case bytes.Equal(trimmed, s.end):
+		s.inSection = false
+	case s.inSection:
+		if len(bytes.TrimSpace(trimmed)) > 0 {
+			_, err = s.section.Write(line)
+		}
+	default:
+		_, err = s.out.Write(line)
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package logstreamer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitter(t *testing.T) {
+	tests := []struct {
+		name string
+		// Logs are written in chunks to exercise the handling of partial lines
+		chunks      []string
+		wantOut     string
+		wantSection string
+	}{
+		{
+			name:    "no section",
+			chunks:  []string{"Plan: 1 to add\n", "no newline"},
+			wantOut: "Plan: 1 to add\nno newline",
+		},
+		{
+			name: "section",
+			chunks: []string{
+				"Plan: 1 to add\n",
+				"--- etok:json-plan:begin ---\n",
+				"{\"format_version\":\"0.1\"}\n",
+				"\n",
+				"--- etok:json-plan:end ---\n",
+				"done\n",
+			},
+			wantOut:     "Plan: 1 to add\ndone\n",
+			wantSection: "{\"format_version\":\"0.1\"}\n",
+		},
+		{
+			name: "markers split across writes",
+			chunks: []string{
+				"--- etok:json-pl",
+				"an:begin ---\n{\"format_",
+				"version\":\"0.1\"}\n--- etok:json-plan:end ---\n",
+			},
+			wantSection: "{\"format_version\":\"0.1\"}\n",
+		},
+		{
+			name:    "markers of other sections are passed through",
+			chunks:  []string{"--- etok:other:begin ---\n"},
+			wantOut: "--- etok:other:begin ---\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, section := new(bytes.Buffer), new(bytes.Buffer)
+
+			s := NewSplitter(out, section, JSONPlanSection)
+			for _, chunk := range tt.chunks {
+				n, err := s.Write([]byte(chunk))
+				require.NoError(t, err)
+				assert.Equal(t, len(chunk), n)
+			}
+			require.NoError(t, s.Flush())
+
+			assert.Equal(t, tt.wantOut, out.String())
+			assert.Equal(t, tt.wantSection, section.String())
+		})
+	}
+}
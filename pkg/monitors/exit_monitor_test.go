@@ -0,0 +1,46 @@
+package monitors
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	etokerrors "github.com/leg100/etok/pkg/errors"
+	"github.com/leg100/etok/pkg/globals"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExitMonitor(t *testing.T) {
+	tests := []struct {
+		name string
+		code int32
+		err  error
+	}{
+		{
+			name: "success",
+			code: 0,
+		},
+		{
+			name: "failure",
+			code: 1,
+			err:  etokerrors.NewExitError(1),
+		},
+		{
+			name: "plan with changes",
+			code: etokerrors.ExitCodePlanChanges,
+			err:  etokerrors.NewExitError(etokerrors.ExitCodePlanChanges),
+		},
+	}
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			pod := testobj.RunPod("default", "run-12345", testobj.WithRunnerExitCode(tt.code))
+			client := fake.NewSimpleClientset(pod)
+
+			err := <-ExitMonitor(context.Background(), client, "run-12345", "default", globals.RunnerContainerName)
+			assert.True(t, errors.Is(err, tt.err))
+		})
+	}
+}
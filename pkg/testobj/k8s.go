@@ -49,6 +49,12 @@ func WithPrivilegedCommands(cmds ...string) func(*v1alpha1.Workspace) {
 	}
 }
 
+func WithRequireApproval() func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.RequireApproval = true
+	}
+}
+
 func WithVariables(keyValues ...string) func(*v1alpha1.Workspace) {
 	return func(ws *v1alpha1.Workspace) {
 		for i := 0; i < len(keyValues); i += 2 {
@@ -63,12 +69,237 @@ func WithDeleteTimestamp() func(*v1alpha1.Workspace) {
 	}
 }
 
+func WithFinalizers(finalizers ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.SetFinalizers(finalizers)
+	}
+}
+
+func WithBackupSerial(serial int) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Status.BackupSerial = &serial
+	}
+}
+
 func WithBackupBucket(bucket string) func(*v1alpha1.Workspace) {
 	return func(ws *v1alpha1.Workspace) {
 		ws.Spec.BackupBucket = bucket
 	}
 }
 
+func WithRawBackend(backendType, raw string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.Backend.Type = backendType
+		ws.Spec.Backend.Raw = raw
+	}
+}
+
+func WithBackupKMSKey(key string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.BackupKMSKey = key
+	}
+}
+
+func WithBackupProvider(provider string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.BackupProvider = provider
+	}
+}
+
+func WithBackupRetention(retention int) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.BackupRetention = retention
+	}
+}
+
+func WithCacheBackup() func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.CacheBackup = true
+	}
+}
+
+func WithSerial(serial int) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Status.Serial = &serial
+	}
+}
+
+func WithBackend(backendType string, keyValues ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.Backend.Type = backendType
+		for i := 0; i < len(keyValues); i += 2 {
+			if ws.Spec.Backend.Config == nil {
+				ws.Spec.Backend.Config = make(map[string]string)
+			}
+			ws.Spec.Backend.Config[keyValues[i]] = keyValues[i+1]
+		}
+	}
+}
+
+func WithResources(requests, limits corev1.ResourceList) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.Resources.Requests = requests
+		ws.Spec.Resources.Limits = limits
+	}
+}
+
+func WithNodeSelector(keyValues ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		if ws.Spec.NodeSelector == nil {
+			ws.Spec.NodeSelector = make(map[string]string)
+		}
+		for i := 0; i < len(keyValues); i += 2 {
+			ws.Spec.NodeSelector[keyValues[i]] = keyValues[i+1]
+		}
+	}
+}
+
+func WithTolerations(tolerations ...corev1.Toleration) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.Tolerations = append(ws.Spec.Tolerations, tolerations...)
+	}
+}
+
+func WithAffinity(affinity *corev1.Affinity) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.Affinity = affinity
+	}
+}
+
+func WithTerminationGracePeriod(seconds int64) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.TerminationGracePeriodSeconds = &seconds
+	}
+}
+
+func WithPodAnnotations(keyValues ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		if ws.Spec.PodAnnotations == nil {
+			ws.Spec.PodAnnotations = make(map[string]string)
+		}
+		for i := 0; i < len(keyValues); i += 2 {
+			ws.Spec.PodAnnotations[keyValues[i]] = keyValues[i+1]
+		}
+	}
+}
+
+func WithPodLabels(keyValues ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		if ws.Spec.PodLabels == nil {
+			ws.Spec.PodLabels = make(map[string]string)
+		}
+		for i := 0; i < len(keyValues); i += 2 {
+			ws.Spec.PodLabels[keyValues[i]] = keyValues[i+1]
+		}
+	}
+}
+
+func WithTFLog(level string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.TFLog = level
+	}
+}
+
+func WithSecretNames(names ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.SecretNames = names
+	}
+}
+
+func WithVariablesFrom(kind, name string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.VariablesFrom = append(ws.Spec.VariablesFrom, v1alpha1.VariablesSource{Kind: kind, Name: name})
+	}
+}
+
+func WithPaused() func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.Paused = true
+	}
+}
+
+func WithMaxConcurrentRuns(max int) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.MaxConcurrentRuns = max
+	}
+}
+
+func WithImage(image string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.Image = image
+	}
+}
+
+func WithImagePullPolicy(policy corev1.PullPolicy) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.ImagePullPolicy = policy
+	}
+}
+
+func WithImagePullSecrets(secrets ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.ImagePullSecrets = secrets
+	}
+}
+
+func WithCABundleSecret(name string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.CABundleSecret = name
+	}
+}
+
+func WithNetrcSecret(name string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.NetrcSecret = name
+	}
+}
+
+func WithTerraformRCConfigMap(name string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.TerraformRCConfigMap = name
+	}
+}
+
+func WithPreRunScript(script string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.PreRunScript = script
+	}
+}
+
+// WithRegistryTokens sets registry tokens, each a triple of hostname, secret
+// name and secret key
+func WithRegistryTokens(triples ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		if ws.Spec.RegistryTokens == nil {
+			ws.Spec.RegistryTokens = make(map[string]corev1.SecretKeySelector)
+		}
+		for i := 0; i < len(triples)-2; i += 3 {
+			ws.Spec.RegistryTokens[triples[i]] = corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: triples[i+1]},
+				Key:                  triples[i+2],
+			}
+		}
+	}
+}
+
+func WithInitArgs(args ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.InitArgs = args
+	}
+}
+
+func WithTerraformArgs(args ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.TerraformArgs = args
+	}
+}
+
+func WithVarFiles(varFiles ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.VarFiles = varFiles
+	}
+}
+
 func WithEnvironmentVariables(keyValues ...string) func(*v1alpha1.Workspace) {
 	return func(ws *v1alpha1.Workspace) {
 		for i := 0; i < len(keyValues); i += 2 {
@@ -86,12 +317,54 @@ func WithCombinedQueue(run ...string) func(*v1alpha1.Workspace) {
 	}
 }
 
+func WithAccessModes(modes ...corev1.PersistentVolumeAccessMode) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.Cache.AccessModes = modes
+	}
+}
+
+func WithCacheSize(size string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.Cache.Size = size
+	}
+}
+
+func WithPodSecurityContext(sc *corev1.PodSecurityContext) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.PodSecurityContext = sc
+	}
+}
+
+func WithSecurityContext(sc *corev1.SecurityContext) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.SecurityContext = sc
+	}
+}
+
+func WithVolumeMode(mode corev1.PersistentVolumeMode) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.Cache.VolumeMode = &mode
+	}
+}
+
+func WithEphemeralCache() func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Spec.Cache.Ephemeral = true
+	}
+}
+
 func WithStorageClass(class *string) func(*v1alpha1.Workspace) {
 	return func(ws *v1alpha1.Workspace) {
 		ws.Spec.Cache.StorageClass = class
 	}
 }
 
+func WithResolvedTerraformVersion(version string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		ws.Status.TerraformVersion = version
+	}
+}
+
 func WithTerraformVersion(version string) func(*v1alpha1.Workspace) {
 	return func(ws *v1alpha1.Workspace) {
 		ws.Spec.TerraformVersion = version
@@ -109,6 +382,17 @@ func WithApprovals(run ...string) func(*v1alpha1.Workspace) {
 	}
 }
 
+func WithReviews(run ...string) func(*v1alpha1.Workspace) {
+	return func(ws *v1alpha1.Workspace) {
+		if ws.Annotations == nil {
+			ws.Annotations = make(map[string]string)
+		}
+		for _, r := range run {
+			ws.Annotations[v1alpha1.ReviewedAnnotationKey(r)] = "approved"
+		}
+	}
+}
+
 func WithAnnotations(keyValues ...string) func(*v1alpha1.Workspace) {
 	return func(ws *v1alpha1.Workspace) {
 		if ws.Annotations == nil {
@@ -193,6 +477,12 @@ func WithPhase(phase corev1.PodPhase) func(*corev1.Pod) {
 	}
 }
 
+func WithStartTime(t time.Time) func(*corev1.Pod) {
+	return func(pod *corev1.Pod) {
+		pod.Status.StartTime = &metav1.Time{Time: t}
+	}
+}
+
 // Set exit code in run status
 func WithRunExitCode(code int) func(*v1alpha1.Run) {
 	return func(run *v1alpha1.Run) {
@@ -206,6 +496,29 @@ func WithRunnerExitCode(code int32) func(*corev1.Pod) {
 	}
 }
 
+// Set the runner container's termination message, i.e. the tail of its logs
+func WithRunnerTerminationMessage(message string) func(*corev1.Pod) {
+	return func(pod *corev1.Pod) {
+		k8s.ContainerStatusByName(pod, globals.RunnerContainerName).State.Terminated.Message = message
+	}
+}
+
+// Add a pre-run container status that has terminated with the given exit code
+// and termination message
+func WithPreRunExitCode(code int32, message string) func(*corev1.Pod) {
+	return func(pod *corev1.Pod) {
+		pod.Status.InitContainerStatuses = append(pod.Status.InitContainerStatuses, corev1.ContainerStatus{
+			Name: "pre-run",
+			State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{
+					ExitCode: code,
+					Message:  message,
+				},
+			},
+		})
+	}
+}
+
 func WithInstallerExitCode(code int32) func(*corev1.Pod) {
 	return func(pod *corev1.Pod) {
 		k8s.ContainerStatusByName(pod, "installer").State.Terminated.ExitCode = code
@@ -241,6 +554,12 @@ func WithWorkspace(workspace string) func(*v1alpha1.Run) {
 	}
 }
 
+func WithCreationTimestamp(t time.Time) func(*v1alpha1.Run) {
+	return func(run *v1alpha1.Run) {
+		run.CreationTimestamp = metav1.NewTime(t)
+	}
+}
+
 func WithRunPhase(phase v1alpha1.RunPhase) func(*v1alpha1.Run) {
 	return func(run *v1alpha1.Run) {
 		// Only set a phase if non-empty
@@ -250,6 +569,20 @@ func WithRunPhase(phase v1alpha1.RunPhase) func(*v1alpha1.Run) {
 	}
 }
 
+// Record the state lock the run failed to acquire
+func WithLockInfo(id, who string) func(*v1alpha1.Run) {
+	return func(run *v1alpha1.Run) {
+		run.LockInfo = &v1alpha1.LockInfo{ID: id, Who: who}
+	}
+}
+
+// Record the authenticated user that created the run
+func WithUser(user string) func(*v1alpha1.Run) {
+	return func(run *v1alpha1.Run) {
+		metav1.SetMetaDataAnnotation(&run.ObjectMeta, v1alpha1.UserAnnotationKey, user)
+	}
+}
+
 func WithCondition(condition string) func(*v1alpha1.Run) {
 	return func(run *v1alpha1.Run) {
 		meta.SetStatusCondition(&run.Conditions, metav1.Condition{
@@ -259,12 +592,59 @@ func WithCondition(condition string) func(*v1alpha1.Run) {
 	}
 }
 
+// Fail the run for the given reason
+func WithFailedReason(reason string) func(*v1alpha1.Run) {
+	return func(run *v1alpha1.Run) {
+		meta.SetStatusCondition(&run.Conditions, metav1.Condition{
+			Type:   v1alpha1.RunFailedCondition,
+			Status: metav1.ConditionTrue,
+			Reason: reason,
+		})
+		run.Phase = v1alpha1.RunPhaseFailed
+	}
+}
+
 func WithArgs(args ...string) func(*v1alpha1.Run) {
 	return func(run *v1alpha1.Run) {
 		run.Args = args
 	}
 }
 
+func WithTFWorkspace(name string) func(*v1alpha1.Run) {
+	return func(run *v1alpha1.Run) {
+		run.TFWorkspace = name
+	}
+}
+
+func WithRunEnvironmentVariables(keyValues ...string) func(*v1alpha1.Run) {
+	return func(run *v1alpha1.Run) {
+		for i := 0; i < len(keyValues); i += 2 {
+			if run.EnvironmentVariables == nil {
+				run.EnvironmentVariables = make(map[string]string)
+			}
+			run.EnvironmentVariables[keyValues[i]] = keyValues[i+1]
+		}
+	}
+}
+
+func WithJSONPlan() func(*v1alpha1.Run) {
+	return func(run *v1alpha1.Run) {
+		run.JSONPlan = true
+	}
+}
+
+func WithTimeout(timeout string) func(*v1alpha1.Run) {
+	return func(run *v1alpha1.Run) {
+		run.Timeout = timeout
+	}
+}
+
+func WithPlan(plan string) func(*v1alpha1.Run) {
+	return func(run *v1alpha1.Run) {
+		run.Plan = plan
+	}
+}
+
 func WithConfigMapPath(path string) func(*v1alpha1.Run) {
 	return func(run *v1alpha1.Run) {
 		run.ConfigMapPath = path
@@ -294,6 +674,21 @@ func WithStringData(k, v string) func(*corev1.Secret) {
 	}
 }
 
+func WithSecretFinalizers(finalizers ...string) func(*corev1.Secret) {
+	return func(secret *corev1.Secret) {
+		secret.SetFinalizers(finalizers)
+	}
+}
+
+func WithData(k, v string) func(*corev1.Secret) {
+	return func(secret *corev1.Secret) {
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data[k] = []byte(v)
+	}
+}
+
 func WithDataFromFile(k, path string) func(*corev1.Secret) {
 	return func(secret *corev1.Secret) {
 		if secret.Data == nil {
@@ -0,0 +1,167 @@
+package tfversion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// ReleasesURL is the index of terraform releases published by HashiCorp
+const ReleasesURL = "https://releases.hashicorp.com/terraform/index.json"
+
+var (
+	ErrInvalidConstraint = errors.New("invalid terraform version constraint")
+	ErrNoMatch           = errors.New("no terraform release satisfies constraint")
+
+	// exactPattern matches an exact version, e.g. 0.14.3
+	exactPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+
+	// referencedPattern matches versions referenced within a constraint
+	referencedPattern = regexp.MustCompile(`[0-9]+(\.[0-9]+){0,2}`)
+)
+
+// Lister lists the available terraform versions
+type Lister interface {
+	List(context.Context) ([]string, error)
+}
+
+// IsExact determines whether v is an exact version rather than a constraint
+func IsExact(v string) bool {
+	return exactPattern.MatchString(v)
+}
+
+// Validate checks v is either an exact version or a valid constraint
+func Validate(v string) error {
+	if IsExact(v) {
+		return nil
+	}
+	if _, err := version.NewConstraint(v); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidConstraint, v)
+	}
+	return nil
+}
+
+// Satisfies determines whether the version v satisfies the constraint
+func Satisfies(v, constraint string) bool {
+	c, err := version.NewConstraint(constraint)
+	if err != nil {
+		return false
+	}
+	parsed, err := version.NewVersion(v)
+	if err != nil {
+		return false
+	}
+	return c.Check(parsed)
+}
+
+// Resolve returns the highest of the available versions that satisfies the
+// constraint. Pre-releases are ignored.
+func Resolve(constraint string, available []string) (string, error) {
+	c, err := version.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidConstraint, constraint)
+	}
+
+	releases := parseReleases(available)
+	for i := len(releases) - 1; i >= 0; i-- {
+		if c.Check(releases[i]) {
+			return releases[i].String(), nil
+		}
+	}
+
+	if nearby := nearbyReleases(constraint, releases); len(nearby) > 0 {
+		return "", fmt.Errorf("%w: %s: nearby versions are: %s", ErrNoMatch, constraint, strings.Join(nearby, ", "))
+	}
+	return "", fmt.Errorf("%w: %s", ErrNoMatch, constraint)
+}
+
+// parseReleases parses the versions, skipping pre-releases and unparseable
+// versions, and returns them in ascending order
+func parseReleases(available []string) version.Collection {
+	var releases version.Collection
+	for _, a := range available {
+		v, err := version.NewVersion(a)
+		if err != nil || v.Prerelease() != "" {
+			continue
+		}
+		releases = append(releases, v)
+	}
+	sort.Sort(releases)
+	return releases
+}
+
+// nearbyReleases returns, for each version referenced in the constraint, the
+// closest releases either side of it
+func nearbyReleases(constraint string, releases version.Collection) (nearby []string) {
+	seen := make(map[string]bool)
+	add := func(v *version.Version) {
+		if !seen[v.String()] {
+			seen[v.String()] = true
+			nearby = append(nearby, v.String())
+		}
+	}
+
+	for _, ref := range referencedPattern.FindAllString(constraint, -1) {
+		refVersion, err := version.NewVersion(ref)
+		if err != nil {
+			continue
+		}
+		i := sort.Search(len(releases), func(i int) bool {
+			return !releases[i].LessThan(refVersion)
+		})
+		if i > 0 {
+			add(releases[i-1])
+		}
+		if i < len(releases) {
+			add(releases[i])
+		}
+	}
+	return nearby
+}
+
+// ReleasesLister lists terraform versions from the releases index
+type ReleasesLister struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewReleasesLister constructs a lister for the official releases index
+func NewReleasesLister() *ReleasesLister {
+	return &ReleasesLister{URL: ReleasesURL, Client: http.DefaultClient}
+}
+
+func (l *ReleasesLister) List(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to list terraform releases: %s: %s", l.URL, resp.Status)
+	}
+
+	var index struct {
+		Versions map[string]json.RawMessage `json:"versions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, fmt.Errorf("unable to decode terraform releases: %w", err)
+	}
+
+	versions := make([]string, 0, len(index.Versions))
+	for v := range index.Versions {
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
@@ -0,0 +1,80 @@
+package tfversion
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var available = []string{"0.14.3", "1.2.9", "1.3.0", "1.3.9", "1.4.0-rc1", "1.4.6", "1.5.0", "1.5.7"}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		constraint string
+		want       string
+		err        error
+		errMsg     string
+	}{
+		{
+			name:       "range",
+			constraint: ">= 1.3, < 1.5",
+			want:       "1.4.6",
+		},
+		{
+			name:       "pessimistic",
+			constraint: "~> 1.3.0",
+			want:       "1.3.9",
+		},
+		{
+			name:       "pre-releases are ignored",
+			constraint: "< 1.4.6",
+			want:       "1.3.9",
+		},
+		{
+			name:       "no match",
+			constraint: ">= 1.3.1, < 1.3.5",
+			err:        ErrNoMatch,
+			errMsg:     "no terraform release satisfies constraint: >= 1.3.1, < 1.3.5: nearby versions are: 1.3.0, 1.3.9",
+		},
+		{
+			name:       "invalid constraint",
+			constraint: "latest",
+			err:        ErrInvalidConstraint,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(tt.constraint, available)
+			if !assert.True(t, errors.Is(err, tt.err)) {
+				t.Errorf("no error in %v's chain matches %v", err, tt.err)
+			}
+			if tt.errMsg != "" {
+				assert.EqualError(t, err, tt.errMsg)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate("0.14.3"))
+	assert.NoError(t, Validate(">= 1.3, < 1.5"))
+	assert.True(t, errors.Is(Validate("latest"), ErrInvalidConstraint))
+}
+
+func TestReleasesLister(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"terraform","versions":{"1.3.9":{"version":"1.3.9"},"1.4.6":{"version":"1.4.6"}}}`))
+	}))
+	defer srv.Close()
+
+	versions, err := (&ReleasesLister{URL: srv.URL, Client: srv.Client()}).List(context.Background())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1.3.9", "1.4.6"}, versions)
+}
@@ -0,0 +1,234 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/client"
+	"github.com/leg100/etok/pkg/controllers"
+	"github.com/leg100/etok/pkg/handlers"
+	"github.com/leg100/etok/pkg/k8s"
+	"github.com/leg100/etok/pkg/logstreamer"
+	"github.com/leg100/etok/pkg/monitors"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+const (
+	DefaultReconcileTimeout = 10 * time.Second
+	DefaultPodTimeout       = 60 * time.Second
+	DefaultReadyTimeout     = 60 * time.Second
+
+	// Timeout for the installer's exit code to be reported once its logs
+	// have been streamed
+	exitCodeTimeout = 10 * time.Second
+
+	// Stages of a workspace's creation that can be waited for, each stage
+	// including those preceding it: the workspace being reconciled, then being
+	// ready (which includes restoring state from a backup), and then its pod
+	// installing terraform.
+	WaitForReconcile = "reconcile"
+	WaitForRestore   = "restore"
+	WaitForPod       = "pod"
+)
+
+// WaitForStages lists the stages that can be waited for, in order
+var WaitForStages = []string{WaitForReconcile, WaitForRestore, WaitForPod}
+
+var (
+	ErrPodTimeout       = errors.New("timed out waiting for pod to be ready")
+	ErrReconcileTimeout = errors.New("timed out waiting for workspace to be reconciled")
+	ErrReadyTimeout     = errors.New("timed out waiting for workspace to be ready")
+)
+
+// CreateOptions configures the creation of a workspace
+type CreateOptions struct {
+	// Wait for the workspace to be reconciled and ready, and for terraform to
+	// be installed
+	Wait bool
+	// WaitFor is the stage to wait for, one of WaitForStages. Defaults to
+	// WaitForPod, i.e. waiting for every stage. Only applicable if Wait is
+	// true.
+	WaitFor string
+
+	// Timeout for the workspace to be reconciled (at least once)
+	ReconcileTimeout time.Duration
+	// Timeout for the workspace pod to be ready
+	PodTimeout time.Duration
+	// Timeout for the workspace to be ready, including restoring state from
+	// a backup
+	ReadyTimeout time.Duration
+
+	// Out receives the output of terraform's installation, streamed from the
+	// workspace pod's installer container. Defaults to discarding the output.
+	Out io.Writer
+	// GetLogsFunc retrieves the installer container's logs. Defaults to
+	// retrieving them from the cluster.
+	GetLogsFunc logstreamer.GetLogsFunc
+
+	// OnCreate, if set, is called once the workspace is created and before
+	// waiting for it, e.g. to create resources the workspace depends upon.
+	OnCreate func(context.Context, *v1alpha1.Workspace) error
+}
+
+func (o *CreateOptions) setDefaults() {
+	if o.WaitFor == "" {
+		o.WaitFor = WaitForPod
+	}
+	if o.ReconcileTimeout == 0 {
+		o.ReconcileTimeout = DefaultReconcileTimeout
+	}
+	if o.PodTimeout == 0 {
+		o.PodTimeout = DefaultPodTimeout
+	}
+	if o.ReadyTimeout == 0 {
+		o.ReadyTimeout = DefaultReadyTimeout
+	}
+	if o.Out == nil {
+		o.Out = ioutil.Discard
+	}
+	if o.GetLogsFunc == nil {
+		o.GetLogsFunc = logstreamer.GetLogs
+	}
+}
+
+// Create creates the workspace and, if opts.Wait is true, waits for it to be
+// reconciled and ready, streaming the output of terraform's installation to
+// opts.Out. A non-zero exit code of the installation is returned as an error.
+// Set opts.WaitFor to stop waiting at an earlier stage.
+//
+// If the workspace is created but a subsequent step fails, the created
+// workspace is returned along with the error, permitting the caller to clean
+// it up.
+func Create(ctx context.Context, c *client.Client, ws *v1alpha1.Workspace, opts CreateOptions) (*v1alpha1.Workspace, error) {
+	opts.setDefaults()
+
+	ws, err := c.WorkspacesClient(ws.Namespace).Create(ctx, ws, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.OnCreate != nil {
+		if err := opts.OnCreate(ctx, ws); err != nil {
+			return ws, err
+		}
+	}
+
+	if !opts.Wait {
+		// Return immediately, leaving the operator to provision the
+		// workspace
+		return ws, nil
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	// Wait for resource to have been successfully reconciled at least once
+	// within the ReconcileTimeout (If we don't do this and the operator is
+	// either not installed or malfunctioning then the user would be none the
+	// wiser until the much longer PodTimeout had expired).
+	g.Go(func() error {
+		return waitForReconcile(gctx, c, ws, opts.ReconcileTimeout)
+	})
+
+	if opts.WaitFor == WaitForReconcile {
+		return ws, g.Wait()
+	}
+
+	// Wait for workspace to be ready
+	g.Go(func() error {
+		return waitForReady(gctx, c, ws, opts.ReadyTimeout)
+	})
+
+	if opts.WaitFor == WaitForRestore {
+		return ws, g.Wait()
+	}
+
+	// Monitor exit code; non-blocking
+	exit := monitors.ExitMonitor(ctx, c.KubeClient, ws.PodName(), ws.Namespace, controllers.InstallerContainerName)
+
+	// Wait for pod to be ready and start streaming logs from its installer
+	// container
+	g.Go(func() error {
+		if err := waitForContainer(gctx, c, ws, opts.PodTimeout); err != nil {
+			return err
+		}
+
+		return logstreamer.Stream(ctx, opts.GetLogsFunc, opts.Out, c.PodsClient(ws.Namespace), ws.PodName(), controllers.InstallerContainerName)
+	})
+
+	// Wait for workspace to have been reconciled and for its pod container to
+	// be ready
+	if err := g.Wait(); err != nil {
+		return ws, err
+	}
+
+	// Return container's exit code
+	select {
+	case <-time.After(exitCodeTimeout):
+		return ws, fmt.Errorf("timed out waiting for exit code")
+	case code := <-exit:
+		return ws, code
+	}
+}
+
+// waitForContainer returns once the installer container can be streamed from
+func waitForContainer(ctx context.Context, c *client.Client, ws *v1alpha1.Workspace, timeout time.Duration) error {
+	lw := &k8s.PodListWatcher{Client: c.KubeClient, Name: ws.PodName(), Namespace: ws.Namespace}
+	hdlr := handlers.ContainerReady(ws.PodName(), controllers.InstallerContainerName, true, false)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := watchtools.UntilWithSync(ctx, lw, &corev1.Pod{}, nil, hdlr)
+	if err != nil {
+		if errors.Is(err, wait.ErrWaitTimeout) {
+			return ErrPodTimeout
+		}
+		return err
+	}
+	return nil
+}
+
+// waitForReconcile waits for the workspace resource to be reconciled.
+func waitForReconcile(ctx context.Context, c *client.Client, ws *v1alpha1.Workspace, timeout time.Duration) error {
+	lw := &k8s.WorkspaceListWatcher{Client: c.EtokClient, Name: ws.Name, Namespace: ws.Namespace}
+	hdlr := handlers.Reconciled(ws)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := watchtools.UntilWithSync(ctx, lw, &v1alpha1.Workspace{}, nil, hdlr)
+	if err != nil {
+		if errors.Is(err, wait.ErrWaitTimeout) {
+			return ErrReconcileTimeout
+		}
+		return err
+	}
+	return nil
+}
+
+// waitForReady waits for the ready condition to indicate it is ready.
+func waitForReady(ctx context.Context, c *client.Client, ws *v1alpha1.Workspace, timeout time.Duration) error {
+	lw := &k8s.WorkspaceListWatcher{Client: c.EtokClient, Name: ws.Name, Namespace: ws.Namespace}
+	hdlr := handlers.WorkspaceReady()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := watchtools.UntilWithSync(ctx, lw, &v1alpha1.Workspace{}, nil, hdlr)
+	if err != nil {
+		if errors.Is(err, wait.ErrWaitTimeout) {
+			return ErrReadyTimeout
+		}
+		return err
+	}
+	return nil
+}
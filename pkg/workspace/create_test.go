@@ -0,0 +1,150 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/leg100/etok/api/etok.dev/v1alpha1"
+	"github.com/leg100/etok/pkg/client"
+	etokerrors "github.com/leg100/etok/pkg/errors"
+	"github.com/leg100/etok/pkg/logstreamer"
+	"github.com/leg100/etok/pkg/testobj"
+	"github.com/leg100/etok/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCreate(t *testing.T) {
+	var fakeError = errors.New("fake error")
+
+	ready := []metav1.Condition{
+		{
+			Type:    v1alpha1.WorkspaceReadyCondition,
+			Status:  metav1.ConditionTrue,
+			Reason:  v1alpha1.ReadyReason,
+			Message: "mock ready",
+		},
+	}
+
+	tests := []struct {
+		name       string
+		conditions []metav1.Condition
+		objs       []runtime.Object
+		opts       CreateOptions
+		err        error
+		// Whether the workspace is expected to be created
+		created    bool
+		assertions func(*testutil.T, *bytes.Buffer)
+	}{
+		{
+			name:    "no wait",
+			created: true,
+		},
+		{
+			name:       "wait",
+			conditions: ready,
+			objs:       []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			opts:       CreateOptions{Wait: true},
+			created:    true,
+			assertions: func(t *testutil.T, out *bytes.Buffer) {
+				assert.Equal(t, "fake logs", out.String())
+			},
+		},
+		{
+			name: "wait for reconcile",
+			conditions: []metav1.Condition{
+				{
+					Type:    v1alpha1.WorkspaceReadyCondition,
+					Status:  metav1.ConditionFalse,
+					Reason:  v1alpha1.PendingReason,
+					Message: "mock pending",
+				},
+			},
+			// No pod, i.e. pod yet to be scheduled
+			opts:    CreateOptions{Wait: true, WaitFor: WaitForReconcile},
+			created: true,
+			assertions: func(t *testutil.T, out *bytes.Buffer) {
+				assert.Equal(t, "", out.String())
+			},
+		},
+		{
+			name:       "wait for restore",
+			conditions: ready,
+			opts:       CreateOptions{Wait: true, WaitFor: WaitForRestore},
+			created:    true,
+			assertions: func(t *testutil.T, out *bytes.Buffer) {
+				assert.Equal(t, "", out.String())
+			},
+		},
+		{
+			name:       "installer failure",
+			conditions: ready,
+			objs:       []runtime.Object{testobj.WorkspacePod("default", "foo", testobj.WithInstallerExitCode(5))},
+			opts:       CreateOptions{Wait: true},
+			created:    true,
+			err:        etokerrors.NewExitError(5),
+		},
+		{
+			name:    "reconcile timeout",
+			objs:    []runtime.Object{testobj.WorkspacePod("default", "foo")},
+			opts:    CreateOptions{Wait: true, ReconcileTimeout: 10 * time.Millisecond},
+			created: true,
+			err:     ErrReconcileTimeout,
+		},
+		{
+			name:       "pod timeout",
+			conditions: ready,
+			opts:       CreateOptions{Wait: true, PodTimeout: 10 * time.Millisecond},
+			created:    true,
+			err:        ErrPodTimeout,
+		},
+		{
+			name: "on create failure",
+			opts: CreateOptions{
+				Wait: true,
+				OnCreate: func(context.Context, *v1alpha1.Workspace) error {
+					return fakeError
+				},
+			},
+			created: true,
+			err:     fakeError,
+		},
+		{
+			name: "already exists",
+			objs: []runtime.Object{testobj.Workspace("default", "foo")},
+		},
+	}
+	for _, tt := range tests {
+		testutil.Run(t, tt.name, func(t *testutil.T) {
+			c, err := client.NewFakeClientCreator(tt.objs...).Create("")
+			require.NoError(t, err)
+
+			out := new(bytes.Buffer)
+			tt.opts.Out = out
+			tt.opts.GetLogsFunc = logstreamer.FakeGetLogs
+
+			// Mock the workspace controller by setting status up front
+			ws := testobj.Workspace("default", "foo")
+			ws.Status.Conditions = tt.conditions
+
+			created, err := Create(context.Background(), c, ws, tt.opts)
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err))
+			} else if tt.created {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+			assert.Equal(t, tt.created, created != nil)
+
+			if tt.assertions != nil {
+				tt.assertions(t, out)
+			}
+		})
+	}
+}